@@ -0,0 +1,248 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"strconv"
+	"time"
+)
+
+// abuseScript adds one to a key's abuse score after decaying it for the time
+// since it was last updated, and bans the key once the score reaches the
+// threshold. Time comes from the server, so every instance decays the same
+// way. It returns the new score.
+//
+// KEYS[1] = score hash, KEYS[2] = ban key
+// ARGV[1] = half-life in milliseconds, ARGV[2] = ban threshold, or empty to
+// never ban, ARGV[3] = ban duration in milliseconds
+var abuseScript = registerScript("abuse", `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local halfLife = tonumber(ARGV[1])
+
+local stored = redis.call('HMGET', KEYS[1], 'score', 'at')
+local score = 0
+if stored[1] and stored[2] then
+	score = tonumber(stored[1]) * math.pow(0.5, math.max(now - tonumber(stored[2]), 0) / halfLife)
+end
+
+score = score + 1
+redis.call('HSET', KEYS[1], 'score', string.format('%.17g', score), 'at', string.format('%d', now))
+
+-- Once the score is below 1/1024 it's as good as gone.
+local halfLives = math.ceil(math.log(score) / math.log(2)) + 10
+redis.call('PEXPIRE', KEYS[1], halfLives * halfLife)
+
+if ARGV[2] ~= '' and score >= tonumber(ARGV[2]) then
+	redis.call('SET', KEYS[2], '1', 'PX', ARGV[3])
+end
+
+return string.format('%.17g', score)
+`)
+
+// WithAbuseScore keeps a score for every key ("{<prefix>}:abuse:<key>") that
+// goes up by one whenever Consume, ConsumeSliding, ConsumeManyKeys or
+// ConsumeApprox rejects a request for it, and halves every halfLife, so keys that keep
+// getting rejected stand out from ones that only hit their limit once. See
+// AbuseScore and WithAutoBan.
+func WithAbuseScore(halfLife time.Duration) func(o *options) {
+	return func(o *options) {
+		o.abuseHalfLife = halfLife
+	}
+}
+
+// WithAutoBan bans a key for banFor once its abuse score reaches threshold. It
+// needs WithAbuseScore. Bans are only recorded; check them with Banned.
+func WithAutoBan(threshold float64, banFor time.Duration) func(o *options) {
+	return func(o *options) {
+		o.autoBanThreshold = threshold
+		o.autoBanFor = banFor
+	}
+}
+
+func (p *Provider) abuseKey(key string) string {
+	return p.companionKey("abuse", key)
+}
+
+func (p *Provider) banKey(key string) string {
+	return p.companionKey("ban", key)
+}
+
+// recordRejection adds to the abuse score of the given storage key, if abuse
+// scores are enabled.
+func (p *Provider) recordRejection(ctx context.Context, key string) error {
+	if p.abuseHalfLife <= 0 {
+		return nil
+	}
+
+	threshold := ""
+	if p.autoBanFor > 0 {
+		threshold = strconv.FormatFloat(p.autoBanThreshold, 'g', -1, 64)
+	}
+
+	keys := []string{p.abuseKey(key), p.banKey(key)}
+	return p.runScript(ctx, abuseScript, keys, p.abuseHalfLife.Milliseconds(), threshold, p.autoBanFor.Milliseconds()).Err()
+}
+
+// AbuseScore returns the current abuse score of the given key, decayed up to
+// the server's current time, which is zero for keys that were never rejected.
+// It needs WithAbuseScore.
+func (p *Provider) AbuseScore(key string) (score float64, err error) {
+	defer p.recoverPanic(&err, "abuse_score", key)
+
+	if p.abuseHalfLife <= 0 {
+		return 0, ErrAbuseScoreDisabled
+	}
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	var (
+		now    *redis.TimeCmd
+		stored *redis.SliceCmd
+	)
+
+	_, err = p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		now = pipe.Time(ctx)
+		stored = pipe.HMGet(ctx, p.abuseKey(p.storageKey(key)), "score", "at")
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := stored.Val()[0].(string)
+	at, atOK := stored.Val()[1].(string)
+	if !ok || !atOK {
+		return 0, nil
+	}
+
+	if score, err = strconv.ParseFloat(value, 64); err != nil {
+		return 0, err
+	}
+
+	updatedAt, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Val().UnixMilli() - updatedAt
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return score * math.Pow(0.5, float64(elapsed)/float64(p.abuseHalfLife.Milliseconds())), nil
+}
+
+// Ban bans the given key for d, replacing a ban that is already there. Like the
+// bans of WithAutoBan, it's only recorded; check it with Banned. With
+// WithAsyncWrites, it's queued unless Synchronous is used.
+func (p *Provider) Ban(key string, d time.Duration) error {
+	return p.BanWithOptions(key, d)
+}
+
+// BanWithOptions is Ban with options that only apply to this call.
+func (p *Provider) BanWithOptions(key string, d time.Duration, opts ...CallOption) (err error) {
+	defer p.recoverPanic(&err, "ban", key)
+
+	key = p.storageKey(key)
+	banKey := p.banKey(key)
+	banned := p.now()
+	apply := func(call *callOptions) error {
+		return p.ban(key, d, banned, call)
+	}
+
+	call := newCallOptions(opts)
+	if queued, err := p.queueWrite(call, &asyncOp{op: "ban", key: key, target: banKey, until: banned.Add(d), apply: apply}); queued || err != nil {
+		return err
+	}
+
+	return p.async.exclusive(banKey, func() error { return apply(call) })
+}
+
+// ban bans the given storage key for d from when it was banned.
+func (p *Provider) ban(key string, d time.Duration, banned time.Time, call *callOptions) error {
+	// A queued ban that's flushed after it would have ended isn't written.
+	if d > 0 {
+		if d -= p.now().Sub(banned); d <= 0 {
+			return nil
+		}
+	}
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	if err := p.cmd(ctx).Set(ctx, p.banKey(key), "1", d).Err(); err != nil {
+		return err
+	}
+
+	if err := replication.wait(ctx); err != nil {
+		return err
+	}
+
+	p.publishChange("ban", key, "", call)
+	return nil
+}
+
+// Banned returns how much longer the given key is banned by Ban or WithAutoBan,
+// or zero if it isn't.
+func (p *Provider) Banned(key string) (remaining time.Duration, err error) {
+	defer p.recoverPanic(&err, "banned", key)
+
+	if op := p.async.pending(p.banKey(p.storageKey(key))); op != nil {
+		if remaining = op.until.Sub(p.now()); remaining < 0 {
+			remaining = 0
+		}
+
+		return remaining, nil
+	}
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	remaining, err = p.cmd(ctx).PTTL(ctx, p.banKey(p.storageKey(key))).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+
+	// PTTL is negative for keys that don't exist.
+	if remaining < 0 {
+		return 0, nil
+	}
+
+	return remaining, nil
+}
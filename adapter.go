@@ -0,0 +1,96 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// consumeAdapter is the providers.Provider returned by NewConsumeAdapter.
+type consumeAdapter struct {
+	provider *Provider
+	limit    int32
+	window   time.Duration
+}
+
+var _ providers.Provider = (*consumeAdapter)(nil)
+
+// NewConsumeAdapter returns a providers.Provider for the chi-ratelimit
+// middleware whose Get counts the request in one atomic step (see Txn), which
+// starts a new window of the given limit and length when there is none or the
+// last one is over. With WithLimitCatalog, the catalog decides them instead,
+// and WithColdStartRamp can lower the limit for a while after a state loss. Its
+// Put does nothing, since Get already stored the result, and its errors are
+// *OpErrors like the Provider's own.
+//
+// This changes what the middleware's options mean: windows and limits come
+// from the adapter instead of the middleware's DefaultLimit and
+// DefaultTimeWindow, and Get never returns nil, so the middleware never creates
+// a ratelimit itself. Requests are counted even when they end up rejected, and
+// ratelimits are never global. Ratelimits store their limit as an int32, so
+// larger limits, like math.MaxInt64 for keys that are effectively unlimited,
+// are stored as math.MaxInt32, which is logged through WithLogger.
+//
+// The middleware only rejects a request when the ratelimit's Exceeded is true,
+// which in chi-ratelimit v0.0.3 means its window is over and nothing remains,
+// so requests past the limit still reach the handler with an
+// X-RateLimit-Remaining of 0. Handlers that have to reject them check that
+// header, or use Consume instead.
+func NewConsumeAdapter(p *Provider, limit int, window time.Duration) providers.Provider {
+	saturated := saturateInt32(int64(limit))
+	if int64(saturated) != int64(limit) && p.logf != nil {
+		p.logf("chi-ratelimit-redis: the consume adapter's limit %d doesn't fit into a ratelimit, so it's stored as %d", limit, saturated)
+	}
+
+	return &consumeAdapter{provider: p, limit: saturated, window: window}
+}
+
+func (a *consumeAdapter) Name() string {
+	return a.provider.Name()
+}
+
+func (a *consumeAdapter) Get(key string) (rl *types.Ratelimit, err error) {
+	defer a.provider.recoverPanic(&err, "consume", key)
+
+	counted, err := a.provider.consumeFixed(a.provider.pooledKey(key), a.limit, a.window, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if counted.fresh {
+		rl := *counted.rl
+		a.provider.rollover(key, counted.old, &rl)
+	}
+
+	return counted.rl, nil
+}
+
+// Put does nothing, since Get already stored the ratelimit.
+func (a *consumeAdapter) Put(string, *types.Ratelimit) error {
+	return nil
+}
+
+func (a *consumeAdapter) Reset(key string) (bool, error) {
+	return a.provider.Reset(key)
+}
@@ -0,0 +1,79 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	ratelimit "github.com/noelware/chi-ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsumeAdapterMiddleware(t *testing.T) {
+	p, _ := newTestProvider(t)
+	limiter := ratelimit.NewRatelimiter(
+		ratelimit.WithProvider(NewConsumeAdapter(p, 2, time.Minute)),
+		ratelimit.WithKeyFunc(func(http.ResponseWriter, *http.Request) string { return "client" }),
+	)
+
+	handled := 0
+	server := httptest.NewServer(limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		handled++
+	})))
+	defer server.Close()
+
+	// The third request is let through too, since the middleware only rejects
+	// ratelimits that Exceeded reports, which are the ones whose window is over.
+	for i, remaining := range []string{"1", "0", "0"} {
+		res, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("X-RateLimit-Remaining") != remaining || res.Header.Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("request %d = %d with %v, want 200 with %s remaining of 2", i, res.StatusCode, res.Header, remaining)
+		}
+	}
+
+	if handled != 3 {
+		t.Fatalf("the handler ran %d times, want 3", handled)
+	}
+
+	rl, err := p.Get("client")
+	if err != nil || rl == nil || rl.Remaining != 0 || rl.Limit != 2 {
+		t.Fatalf("Get after three requests = %+v, %v, want 0 of 2 remaining", rl, err)
+	}
+}
+
+func TestConsumeAdapterErrors(t *testing.T) {
+	p, server := newTestProvider(t)
+	server.Close()
+
+	_, err := NewConsumeAdapter(p, 2, time.Minute).Get("client")
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Op != "consume" || opErr.Key != "client" {
+		t.Fatalf("Get with Redis down = %v, want an *OpError for consume", err)
+	}
+}
@@ -0,0 +1,109 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// adaptiveInterval is how often the timeout of WithAdaptiveTimeout is
+	// worked out again.
+	adaptiveInterval = 5 * time.Second
+
+	// adaptiveMinSamples is how many operations have to be tracked before the
+	// timeout of WithAdaptiveTimeout moves away from its maximum.
+	adaptiveMinSamples = 64
+)
+
+// adaptiveTimeout is the timeout of WithAdaptiveTimeout.
+type adaptiveTimeout struct {
+	floor      time.Duration
+	ceiling    time.Duration
+	percentile float64
+	multiplier float64
+
+	timeout   atomic.Int64
+	updatedAt atomic.Int64
+	updating  atomic.Bool
+}
+
+// WithAdaptiveTimeout makes the timeout of every read and write follow the
+// latency that LatencyStats tracks instead of WithReadTimeout and
+// WithWriteTimeout: every few seconds, it's set to the given percentile (like
+// 0.99) of the most recent operations times the multiplier, kept between floor
+// and ceiling. Until enough operations were tracked, it's ceiling. A timeout
+// given to a single call with CallTimeout still wins. LatencyStats shows the
+// current timeout.
+func WithAdaptiveTimeout(floor, ceiling time.Duration, percentile, multiplier float64) func(o *options) {
+	return func(o *options) {
+		o.adaptiveTimeout = &adaptiveTimeout{floor: floor, ceiling: ceiling, percentile: percentile, multiplier: multiplier}
+		o.adaptiveTimeout.timeout.Store(int64(ceiling))
+	}
+}
+
+// valid returns false if the options given to WithAdaptiveTimeout can't work.
+func (a *adaptiveTimeout) valid() bool {
+	return a.floor > 0 && a.ceiling >= a.floor && a.percentile > 0 && a.percentile <= 1 && a.multiplier > 0
+}
+
+// current returns the timeout, working it out again from the tracker if it's
+// been long enough since the last time.
+func (a *adaptiveTimeout) current(t *latencyTracker, now time.Time) time.Duration {
+	if updatedAt := a.updatedAt.Load(); updatedAt == 0 || now.UnixNano()-updatedAt >= int64(adaptiveInterval) {
+		if a.updating.CompareAndSwap(false, true) {
+			a.update(t, now)
+			a.updating.Store(false)
+		}
+	}
+
+	return time.Duration(a.timeout.Load())
+}
+
+func (a *adaptiveTimeout) update(t *latencyTracker, now time.Time) {
+	sorted := t.sorted()
+	if len(sorted) < adaptiveMinSamples {
+		return
+	}
+
+	timeout := time.Duration(float64(percentile(sorted, a.percentile)) * a.multiplier)
+	switch {
+	case timeout < a.floor:
+		timeout = a.floor
+	case timeout > a.ceiling:
+		timeout = a.ceiling
+	}
+
+	a.timeout.Store(int64(timeout))
+	a.updatedAt.Store(now.UnixNano())
+}
+
+// operationTimeout returns the timeout that reads or writes get by default,
+// which is the given one unless WithAdaptiveTimeout is used.
+func (p *Provider) operationTimeout(d time.Duration) time.Duration {
+	if p.latency.adaptive == nil {
+		return d
+	}
+
+	return p.latency.adaptive.current(p.latency, p.now())
+}
@@ -0,0 +1,106 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+)
+
+// SetRemaining sets how many requests the ratelimit stored for the given key
+// has left in its current window, clamped to between zero and its limit,
+// without touching its limit or reset time. It returns false if there is no
+// ratelimit whose window hasn't reset yet.
+func (p *Provider) SetRemaining(key string, remaining int32) (ok bool, err error) {
+	defer p.recoverPanic(&err, "set_remaining", key)
+
+	return p.adjustWindow("set_remaining", key, func(rl *types.Ratelimit) {
+		rl.Remaining = clampRemaining(remaining, rl.Limit)
+	})
+}
+
+// SetLimitOverride changes the limit of the current window of the ratelimit
+// stored for the given key, like to give a customer more room until it
+// resets. The requests that were already used stay used, so the remaining ones
+// move by the difference, clamped to between zero and the new limit. The next
+// window gets the limit that Consume or Put is called with again; so does the
+// current one if WithLimitChangePolicy isn't LimitChangeNextWindow. It returns
+// false if there is no ratelimit whose window hasn't reset yet.
+func (p *Provider) SetLimitOverride(key string, limit int32) (ok bool, err error) {
+	defer p.recoverPanic(&err, "set_limit_override", key)
+
+	if limit < 0 {
+		return false, errors.New("SetLimitOverride needs a limit of at least 0")
+	}
+
+	return p.adjustWindow("set_limit_override", key, func(rl *types.Ratelimit) {
+		rl.Remaining = clampRemaining(rl.Remaining+limit-rl.Limit, limit)
+		rl.Limit = limit
+	})
+}
+
+// adjustWindow changes the ratelimit stored for the given key with fn in a
+// transaction, and publishes the change as op for WithChangeNotifications. It
+// returns false if there is no ratelimit whose window hasn't reset yet.
+func (p *Provider) adjustWindow(op, key string, fn func(rl *types.Ratelimit)) (ok bool, err error) {
+	key = p.pooledKey(key)
+	storageKey := p.storageKey(key)
+	defer p.forgetLocal(storageKey)
+
+	var adjusted types.Ratelimit
+	_, err = p.runTxn(key, false, nil, func(tx *Txn) error {
+		ok = false
+		rl, err := tx.Get()
+		if err != nil || rl == nil || !rl.ResetTime.After(p.now()) {
+			return err
+		}
+
+		adjusted = *rl
+		fn(&adjusted)
+		ok = true
+		return tx.Put(&adjusted)
+	})
+
+	if err != nil || !ok {
+		return false, err
+	}
+
+	data, err := p.encode(&adjusted)
+	if err != nil {
+		return true, nil
+	}
+
+	p.publishChange(op, storageKey, string(data), nil)
+	return true, nil
+}
+
+// clampRemaining returns remaining clamped to between zero and limit.
+func clampRemaining(remaining, limit int32) int32 {
+	switch {
+	case remaining < 0:
+		return 0
+	case remaining > limit:
+		return limit
+	default:
+		return remaining
+	}
+}
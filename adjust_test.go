@@ -0,0 +1,74 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestSetRemaining(t *testing.T) {
+	p, _ := newTestProvider(t)
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 5, ResetTime: resetAt}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	for _, tc := range []struct{ set, want int32 }{{8, 8}, {-3, 0}, {20, 10}} {
+		if ok, err := p.SetRemaining("k", tc.set); err != nil || !ok {
+			t.Fatalf("SetRemaining(%d) = %v, %v", tc.set, ok, err)
+		}
+
+		rl, err := p.Peek("k")
+		if err != nil || rl.Remaining != tc.want || rl.Limit != 10 || !rl.ResetTime.Equal(resetAt) {
+			t.Fatalf("SetRemaining(%d) left %+v, %v, want Remaining %d", tc.set, rl, err, tc.want)
+		}
+	}
+
+	if ok, err := p.SetRemaining("missing", 1); err != nil || ok {
+		t.Fatalf("SetRemaining of a missing key = %v, %v", ok, err)
+	}
+}
+
+func TestSetLimitOverride(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 4, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// The 6 used requests stay used.
+	for _, tc := range []struct{ limit, remaining int32 }{{20, 14}, {8, 2}, {3, 0}} {
+		if ok, err := p.SetLimitOverride("k", tc.limit); err != nil || !ok {
+			t.Fatalf("SetLimitOverride(%d) = %v, %v", tc.limit, ok, err)
+		}
+
+		rl, err := p.Peek("k")
+		if err != nil || rl.Limit != tc.limit || rl.Remaining != tc.remaining {
+			t.Fatalf("SetLimitOverride(%d) left %+v, %v, want Remaining %d", tc.limit, rl, err, tc.remaining)
+		}
+	}
+
+	if _, err := p.SetLimitOverride("k", -1); err == nil {
+		t.Fatal("SetLimitOverride accepted a negative limit")
+	}
+}
@@ -0,0 +1,69 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "context"
+
+// AdminClient holds the maintenance operations of a Provider, like the ones that
+// scan the whole hash. They're kept off the Provider so they can't be called
+// from a request handler by accident. It shares the client and options of the
+// Provider it came from.
+type AdminClient struct {
+	provider *Provider
+}
+
+// Admin returns the AdminClient for this Provider.
+func (p *Provider) Admin() *AdminClient {
+	return &AdminClient{provider: p}
+}
+
+// ResetByPart is a forwarder for AdminClient.ResetByPart.
+//
+// Deprecated: Use p.Admin().ResetByPart instead. This will be removed in the
+// next release.
+func (p *Provider) ResetByPart(index int, value string) (int64, error) {
+	return p.Admin().ResetByPart(index, value)
+}
+
+// ResetAll is a forwarder for AdminClient.ResetAll.
+//
+// Deprecated: Use p.Admin().ResetAll instead. This will be removed in the next
+// release.
+func (p *Provider) ResetAll(ctx context.Context, progress func(deleted int64)) (int64, error) {
+	return p.Admin().ResetAll(ctx, progress)
+}
+
+// RepairInconsistent is a forwarder for AdminClient.RepairInconsistent.
+//
+// Deprecated: Use p.Admin().RepairInconsistent instead. This will be removed in
+// the next release.
+func (p *Provider) RepairInconsistent(ctx context.Context) (*RepairReport, error) {
+	return p.Admin().RepairInconsistent(ctx)
+}
+
+// Verify is a forwarder for AdminClient.Verify.
+//
+// Deprecated: Use p.Admin().Verify instead. This will be removed in the next
+// release.
+func (p *Provider) Verify(ctx context.Context) (*VerifyReport, error) {
+	return p.Admin().Verify(ctx)
+}
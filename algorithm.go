@@ -0,0 +1,344 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// AlgorithmParams is what a single request is limited by.
+type AlgorithmParams struct {
+	Limit  int64
+	Window time.Duration
+}
+
+// Algorithm decides whether requests are let through and keeps whatever state
+// it needs for that in Redis. The ones that ship with this package are
+// FixedWindow (the default), SlidingWindow and ApproximateWindow; others can be
+// plugged in with WithAlgorithm and reuse the Provider's connection, timeouts,
+// key prefix, abuse scores and decision log through the AlgorithmStore.
+//
+// Keys are given as they're stored, so they're already cut down by
+// WithMaxKeyLength.
+type Algorithm interface {
+	// Name is what the algorithm is called, like "sliding_window".
+	Name() string
+
+	// Scripts returns the source of every Lua script the algorithm runs, by
+	// name, which AlgorithmStore.Run runs by that name.
+	Scripts() map[string]string
+
+	// Consume counts a request for the key and decides whether it's allowed.
+	Consume(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error)
+
+	// Peek returns the Decision that Consume would make right now without
+	// counting a request.
+	Peek(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error)
+
+	// Reset forgets everything about the key, returning false if there was
+	// nothing to forget.
+	Reset(ctx context.Context, store AlgorithmStore, key string) (bool, error)
+}
+
+// AlgorithmStore is what an Algorithm uses to reach Redis through its Provider.
+type AlgorithmStore struct {
+	provider *Provider
+	window   time.Duration
+}
+
+// Cmd returns the client that commands for ctx should be sent through.
+func (s AlgorithmStore) Cmd(ctx context.Context) redis.Cmdable {
+	return s.provider.cmd(ctx)
+}
+
+// Key returns a key of the given kind for key, next to the Provider's hash and
+// sharing its hash slot.
+func (s AlgorithmStore) Key(kind, key string) string {
+	return s.provider.companionKey(kind, key)
+}
+
+// Now returns the current time by the Provider's clock.
+func (s AlgorithmStore) Now() time.Time {
+	return s.provider.now()
+}
+
+// Run runs the script of the configured Algorithm with the given name, which
+// has to be one of what its Scripts returned. Like the Provider's own scripts,
+// its body is only sent when Redis doesn't know it yet, unless scripts are
+// pinned.
+func (s AlgorithmStore) Run(ctx context.Context, name string, keys []string, args ...interface{}) *redis.Cmd {
+	script, ok := s.provider.algorithmScripts[name]
+	if !ok {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(fmt.Errorf("%w: algorithm has no script %q", ErrScriptNotLoaded, name))
+		return cmd
+	}
+
+	defer s.provider.trackLatency(time.Now())
+	return s.provider.runScript(ctx, script, keys, args...)
+}
+
+// LogDecision hands a Decision to the log of WithDecisionLog if it's sampled,
+// with the SHA1 of the script that made it.
+func (s AlgorithmStore) LogDecision(key, scriptSHA string, decision Decision, now time.Time) {
+	s.provider.logDecision(key, scriptSHA, s.window, decision, now)
+}
+
+// WithAlgorithm sets the Algorithm that Consume and Inspect use, and that Reset
+// resets as well. It's FixedWindow by default.
+func WithAlgorithm(a Algorithm) func(o *options) {
+	return func(o *options) {
+		o.algorithm = a
+	}
+}
+
+// algorithmScripts returns the scripts of the given Algorithm, ready to run.
+// Scripts that are embedded in this package are the registered ones.
+func algorithmScripts(a Algorithm) map[string]*script {
+	sources := a.Scripts()
+	prepared := make(map[string]*script, len(sources))
+	for name, source := range sources {
+		if s, ok := scripts[name]; ok && s.source == source {
+			prepared[name] = s
+			continue
+		}
+
+		prepared[name] = &script{name: name, source: source, Script: redis.NewScript(source)}
+	}
+
+	return prepared
+}
+
+// Consume counts a request for the given key with the Provider's Algorithm and
+// returns its Decision. Rejected requests count towards the abuse score of
+// WithAbuseScore and the backoff of WithPenaltyBackoff.
+func (p *Provider) Consume(key string, limit int64, window time.Duration) (Decision, error) {
+	return p.consumeWith(p.currentAlgorithm(), key, AlgorithmParams{Limit: limit, Window: window})
+}
+
+// Inspect is Consume without counting a request.
+func (p *Provider) Inspect(key string, limit int64, window time.Duration) (decision Decision, err error) {
+	defer p.recoverPanic(&err, "inspect", key)
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+
+	params := AlgorithmParams{Limit: limit, Window: window}
+	decision, err = p.currentAlgorithm().Peek(ctx, AlgorithmStore{provider: p}, p.storageKey(p.pooledKey(key)), params)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision.Window = window
+	return decision, nil
+}
+
+func (p *Provider) currentAlgorithm() Algorithm {
+	if p.algorithm == nil {
+		return FixedWindow()
+	}
+
+	return p.algorithm
+}
+
+// consumeWith runs Consume of the given Algorithm. Latency is tracked by the
+// algorithms themselves, per round trip.
+func (p *Provider) consumeWith(a Algorithm, key string, params AlgorithmParams) (decision Decision, err error) {
+	defer p.recoverPanic(&err, "consume", key)
+
+	call, counted := p.sampleRoundTrips(nil)
+	defer func() { decision.RoundTrips = p.reportRoundTrips("consume", counted) }()
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+
+	name := key
+	pool, pooled := p.poolOf(key)
+	key = p.storageKey(key)
+	store := AlgorithmStore{provider: p, window: params.Window}
+	switch {
+	case !pooled:
+		decision, err = a.Consume(ctx, store, key, params)
+	case p.pool.memberLimit > 0:
+		decision, err = p.consumeMember(p.storageKey(pool), key, params, call)
+	default:
+		decision, err = a.Consume(ctx, store, p.storageKey(pool), params)
+	}
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision.Window = params.Window
+	if decision.FirstInWindow {
+		p.rollover(name, decision.previous, decisionRatelimit(decision))
+	}
+
+	if !decision.Allowed {
+		if over, err := p.consumeGrace(ctx, key, &decision); err != nil || over {
+			return decision, err
+		}
+
+		if err := p.penalize(ctx, key, params.Window, &decision); err != nil {
+			return Decision{}, err
+		}
+
+		return decision, p.recordRejection(ctx, key)
+	}
+
+	p.checkThreshold(ctx, name, key, decision)
+	return decision, nil
+}
+
+// resetAlgorithm resets the state of a configured Algorithm other than
+// FixedWindow, whose state is what Reset deletes anyway.
+func (p *Provider) resetAlgorithm(key string, call *callOptions) (bool, error) {
+	if p.algorithm == nil {
+		return false, nil
+	}
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+
+	return p.algorithm.Reset(ctx, AlgorithmStore{provider: p}, key)
+}
+
+// fixedWindow is the Algorithm of FixedWindow.
+type fixedWindow struct{}
+
+// FixedWindow is the default Algorithm: the ratelimits that Get and Put store,
+// counted in one atomic step like NewConsumeAdapter does, with a new window
+// starting when there is none or the last one is over. WithLimitCatalog and
+// WithColdStartRamp apply to it.
+func FixedWindow() Algorithm {
+	return fixedWindow{}
+}
+
+func (fixedWindow) Name() string {
+	return "fixed_window"
+}
+
+func (fixedWindow) Scripts() map[string]string {
+	return map[string]string{txnScript.name: txnScript.source}
+}
+
+func (fixedWindow) Consume(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	counted, err := p.consumeFixed(key, saturateInt32(params.Limit), params.Window, roundTripCall(ctx))
+	if err != nil {
+		return Decision{}, err
+	}
+
+	now := p.now()
+	decision := newFixedDecision(counted, now)
+	p.logDecision(key, txnScript.Hash(), params.Window, decision, now)
+	return decision, nil
+}
+
+// newFixedDecision returns the Decision for a request counted by
+// consumeFixed.
+func newFixedDecision(counted fixedCount, now time.Time) Decision {
+	decision := newDecision(counted.rl, now)
+	decision.Allowed = counted.before > 0
+	decision.FirstInWindow = counted.fresh
+	decision.FirstEver = counted.firstSeen
+	if counted.fresh {
+		decision.previous = counted.old
+	}
+	if decision.Allowed {
+		decision.RetryAfter = 0
+	} else {
+		decision.RetryAfter = decision.ResetAfter
+	}
+
+	return decision
+}
+
+func (fixedWindow) Peek(_ context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	rl, err := p.fetch(key, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	now := p.now()
+	if rl == nil || !rl.ResetTime.After(now) {
+		return Decision{Allowed: true, Limit: params.Limit, Remaining: params.Limit}, nil
+	}
+
+	return newDecision(rl, now), nil
+}
+
+func (fixedWindow) Reset(_ context.Context, store AlgorithmStore, key string) (bool, error) {
+	return store.provider.Reset(key)
+}
+
+// fixedCount is a request counted by consumeFixed.
+type fixedCount struct {
+	// before is how many requests the ratelimit had left before.
+	before int32
+
+	// rl is the ratelimit after.
+	rl *types.Ratelimit
+
+	// fresh is true if the request started a new window.
+	fresh bool
+
+	// old is the ratelimit of the window before if the request started a new
+	// one, or nil if there was none.
+	old *types.Ratelimit
+
+	// firstSeen is true if WithFirstSeenTracking saw the key for the first
+	// time.
+	firstSeen bool
+}
+
+// consumeFixed counts a request in the stored ratelimit of the given key. call
+// can be nil.
+func (p *Provider) consumeFixed(key string, limit int32, window time.Duration, call *callOptions) (counted fixedCount, err error) {
+	if p.noScripting {
+		return p.consumeUnscripted(key, limit, window, call)
+	}
+
+	counted.firstSeen, err = p.runTxn(key, true, call, func(tx *Txn) error {
+		current, err := tx.Get()
+		if err != nil {
+			return err
+		}
+
+		counted.old = current
+		current, counted.fresh = p.countedWindow(key, current, limit, window, p.now())
+		counted.before = current.Remaining
+		counted.rl = current.Copy()
+		return tx.Put(counted.rl)
+	})
+
+	if err != nil {
+		return fixedCount{}, err
+	}
+
+	return counted, nil
+}
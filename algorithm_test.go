@@ -0,0 +1,71 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsumeFixed(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	consume := func(allowed bool, remaining int64) Decision {
+		t.Helper()
+
+		decision, err := p.Consume("k", 3, time.Minute)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+
+		if decision.Allowed != allowed || decision.Remaining != remaining {
+			t.Fatalf("Consume = allowed %t, %d remaining; want %t, %d", decision.Allowed, decision.Remaining, allowed, remaining)
+		}
+
+		return decision
+	}
+
+	first := consume(true, 2)
+	if !first.FirstInWindow || !first.ResetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("first Consume = %+v", first)
+	}
+
+	consume(true, 1)
+	consume(true, 0)
+	if rejected := consume(false, 0); rejected.RetryAfter != time.Minute || rejected.FirstInWindow {
+		t.Fatalf("rejected Consume = %+v", rejected)
+	}
+
+	// A millisecond before the reset the window is still over its limit, and
+	// right at it a new one starts.
+	now = first.ResetAt.Add(-time.Millisecond)
+	server.SetTime(now)
+	consume(false, 0)
+
+	now = first.ResetAt
+	server.SetTime(now)
+	if next := consume(true, 2); !next.FirstInWindow || !next.ResetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("Consume in the next window = %+v", next)
+	}
+}
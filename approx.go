@@ -0,0 +1,224 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// approxConsumeScript checks and increments one count-min sketch. The estimate
+// for a key is the smallest of its counters, which is never lower than the
+// true count. Requests that are rejected aren't counted.
+//
+// KEYS[1] = sketch
+// ARGV[1] = limit, ARGV[2] = when the window ends in Unix milliseconds,
+// ARGV[3...] = counter offsets, one per row
+var approxConsumeScript = registerScript("approx_consume", `
+local get = {}
+local incr = { 'OVERFLOW', 'SAT' }
+for i = 3, #ARGV do
+	table.insert(get, 'GET')
+	table.insert(get, 'u32')
+	table.insert(get, '#' .. ARGV[i])
+	table.insert(incr, 'INCRBY')
+	table.insert(incr, 'u32')
+	table.insert(incr, '#' .. ARGV[i])
+	table.insert(incr, 1)
+end
+
+local estimate = nil
+for _, count in ipairs(redis.call('BITFIELD', KEYS[1], unpack(get))) do
+	if estimate == nil or count < estimate then
+		estimate = count
+	end
+end
+
+if estimate >= tonumber(ARGV[1]) then
+	return { 0, estimate }
+end
+
+redis.call('BITFIELD', KEYS[1], unpack(incr))
+redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+return { 1, estimate + 1 }
+`)
+
+// WithApproximateMode enables ConsumeApprox, which counts requests in a
+// count-min sketch of depth rows of width 32-bit counters per window instead of
+// one entry per key. A sketch takes width*depth*4 bytes no matter how many keys
+// it counts: 2^20 by 4 is 16 MiB per window, where 10 million exact entries
+// take well over a gigabyte.
+//
+// The estimate is never lower than the true count, so a key is never let
+// through more often than its limit allows. It can be higher, which rejects
+// some requests early: with n requests in a window, the estimate is at most
+// 2.72*n/width too high with a chance of 1-e^-depth.
+func WithApproximateMode(width, depth int) func(o *options) {
+	return func(o *options) {
+		o.approxWidth = width
+		o.approxDepth = depth
+	}
+}
+
+// ConsumeApprox counts a request for the given key in the sketch of the current
+// window, which WithApproximateMode has to have enabled. Windows are aligned to
+// multiples of window since the Unix epoch, and each sketch expires when its
+// window ends. The returned Decision's Remaining is based on the estimate. It's
+// Consume with the ApproximateWindow algorithm.
+func (p *Provider) ConsumeApprox(key string, limit int64, window time.Duration) (Decision, error) {
+	return p.consumeWith(ApproximateWindow(), key, AlgorithmParams{Limit: limit, Window: window})
+}
+
+// approximateWindow is the Algorithm of ApproximateWindow.
+type approximateWindow struct{}
+
+// ApproximateWindow is the Algorithm of ConsumeApprox, which needs
+// WithApproximateMode. A request counted in a sketch can't be taken back out
+// again, so its Reset does nothing and returns false.
+func ApproximateWindow() Algorithm {
+	return approximateWindow{}
+}
+
+func (approximateWindow) Name() string {
+	return "approximate_window"
+}
+
+func (approximateWindow) Scripts() map[string]string {
+	return map[string]string{approxConsumeScript.name: approxConsumeScript.source}
+}
+
+func (approximateWindow) Consume(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	if p.approxWidth <= 0 || p.approxDepth <= 0 {
+		return Decision{}, ErrApproximateDisabled
+	}
+
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	start := windowStart(now, params.Window)
+	resetAt := start.Add(params.Window)
+
+	args := []interface{}{scriptLimit(params.Limit), resetAt.UnixMilli()}
+	for _, offset := range p.approxOffsets(key) {
+		args = append(args, offset)
+	}
+
+	keys := []string{p.sketchKey(start)}
+	result, err := p.runScript(ctx, approxConsumeScript, keys, args...).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := newApproxDecision(result[0] == 1, result[1], params.Limit, resetAt, now)
+	p.logDecision(key, approxConsumeScript.Hash(), params.Window, decision, now)
+	return decision, nil
+}
+
+func (approximateWindow) Peek(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	if p.approxWidth <= 0 || p.approxDepth <= 0 {
+		return Decision{}, ErrApproximateDisabled
+	}
+
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	start := windowStart(now, params.Window)
+
+	var args []interface{}
+	for _, offset := range p.approxOffsets(key) {
+		args = append(args, "GET", "u32", "#"+strconv.FormatInt(offset, 10))
+	}
+
+	counts, err := p.cmd(ctx).BitField(ctx, p.sketchKey(start), args...).Result()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var estimate int64
+	for i, count := range counts {
+		if i == 0 || count < estimate {
+			estimate = count
+		}
+	}
+
+	// Consume would count the request on top of the estimate.
+	allowed := estimate < params.Limit
+	if allowed {
+		estimate++
+	}
+
+	return newApproxDecision(allowed, estimate, params.Limit, start.Add(params.Window), now), nil
+}
+
+func (approximateWindow) Reset(context.Context, AlgorithmStore, string) (bool, error) {
+	return false, nil
+}
+
+// newApproxDecision works out the Decision for a request from the estimate,
+// which includes the request itself if it was allowed.
+func newApproxDecision(allowed bool, estimate, limit int64, resetAt, now time.Time) Decision {
+	decision := Decision{
+		Allowed:    allowed,
+		Limit:      limit,
+		Remaining:  limit - estimate,
+		ResetAt:    resetAt,
+		ResetAfter: resetAt.Sub(now),
+	}
+
+	// Collisions can make the estimate go over the limit.
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+
+	if !allowed {
+		decision.RetryAfter = decision.ResetAfter
+	}
+
+	return decision
+}
+
+// sketchKey returns the sketch for the window that starts at the given time.
+// The dimensions are part of the key so that changing them starts over instead
+// of reading counters at the wrong offsets.
+func (p *Provider) sketchKey(start time.Time) string {
+	dimensions := strconv.Itoa(p.approxWidth) + "x" + strconv.Itoa(p.approxDepth)
+	return p.companionKey("sketch", dimensions+":"+strconv.FormatInt(start.UnixMilli(), 10))
+}
+
+// approxOffsets returns the counter of the given key in every row, as offsets
+// into the whole sketch. The rows' hashes are derived from a single hash of
+// WithHashFunc, which is as good as independent ones for a count-min sketch.
+func (p *Provider) approxOffsets(key string) []int64 {
+	sum := p.hash(key)
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	offsets := make([]int64, p.approxDepth)
+	for row := range offsets {
+		column := (h1 + uint64(row)*h2) % uint64(p.approxWidth)
+		offsets[row] = int64(row)*int64(p.approxWidth) + int64(column)
+	}
+
+	return offsets
+}
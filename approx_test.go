@@ -0,0 +1,278 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	lua "github.com/yuin/gopher-lua"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sketches implements the part of BITFIELD that the count-min sketch uses,
+// which miniredis doesn't have: OVERFLOW SAT, and GET and INCRBY of u32
+// counters at #offsets. miniredis can't hand arrays of integers to Lua either,
+// so approxConsumeScript runs in a Lua state of its own against the same
+// counters with runSketchScript.
+type sketches struct {
+	mu       sync.Mutex
+	counters map[string]map[int64]int64
+	expireAt map[string]int64
+}
+
+// registerSketches adds BITFIELD to the given server, for the reads of Peek.
+func registerSketches(t *testing.T, s *miniredis.Miniredis) *sketches {
+	t.Helper()
+
+	b := &sketches{counters: map[string]map[int64]int64{}, expireAt: map[string]int64{}}
+	err := s.Server().Register("BITFIELD", func(c *server.Peer, _ string, args []string) {
+		replies, err := b.bitfield(args[0], args[1:])
+		if err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+
+		c.WriteLen(len(replies))
+		for _, reply := range replies {
+			c.WriteInt(int(reply))
+		}
+	})
+
+	if err != nil {
+		t.Fatalf("registering BITFIELD: %v", err)
+	}
+
+	return b
+}
+
+func (b *sketches) bitfield(key string, args []string) ([]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counters := b.counters[key]
+	if counters == nil {
+		counters = map[int64]int64{}
+		b.counters[key] = counters
+	}
+
+	var replies []int64
+	for i := 0; i < len(args); {
+		switch args[i] {
+		case "OVERFLOW":
+			if args[i+1] != "SAT" {
+				return nil, fmt.Errorf("unsupported overflow %s", args[i+1])
+			}
+
+			i += 2
+
+		case "GET", "INCRBY":
+			offset, err := strconv.ParseInt(args[i+2][1:], 10, 64)
+			if err != nil || args[i+1] != "u32" {
+				return nil, fmt.Errorf("unsupported field %s %s", args[i+1], args[i+2])
+			}
+
+			if args[i] == "INCRBY" {
+				incr, _ := strconv.ParseInt(args[i+3], 10, 64)
+				counters[offset] += incr
+				if counters[offset] > 1<<32-1 {
+					counters[offset] = 1<<32 - 1
+				}
+
+				i++
+			}
+
+			replies = append(replies, counters[offset])
+			i += 3
+
+		default:
+			return nil, fmt.Errorf("unsupported subcommand %s", args[i])
+		}
+	}
+
+	return replies, nil
+}
+
+// runSketchScript runs approxConsumeScript like ConsumeApprox would for the
+// given key and returns whether the request was allowed and the estimate.
+func (b *sketches) runSketchScript(t *testing.T, p *Provider, key string, limit int64, resetAt time.Time) (bool, int64) {
+	t.Helper()
+
+	sketch := p.sketchKey(resetAt.Add(-time.Minute))
+	l := lua.NewState()
+	defer l.Close()
+
+	argv := l.NewTable()
+	argv.Append(lua.LString(strconv.FormatInt(scriptLimit(limit), 10)))
+	argv.Append(lua.LString(strconv.FormatInt(resetAt.UnixMilli(), 10)))
+	for _, offset := range p.approxOffsets(key) {
+		argv.Append(lua.LString(strconv.FormatInt(offset, 10)))
+	}
+
+	keys := l.NewTable()
+	keys.Append(lua.LString(sketch))
+	l.SetGlobal("KEYS", keys)
+	l.SetGlobal("ARGV", argv)
+
+	redis := l.NewTable()
+	redis.RawSetString("call", l.NewFunction(func(l *lua.LState) int {
+		args := make([]string, l.GetTop())
+		for i := range args {
+			args[i] = l.Get(i + 1).String()
+		}
+
+		switch args[0] {
+		case "BITFIELD":
+			replies, err := b.bitfield(args[1], args[2:])
+			if err != nil {
+				l.RaiseError("%v", err)
+			}
+
+			table := l.NewTable()
+			for _, reply := range replies {
+				table.Append(lua.LNumber(reply))
+			}
+
+			l.Push(table)
+
+		case "PEXPIREAT":
+			at, _ := strconv.ParseInt(args[2], 10, 64)
+			b.mu.Lock()
+			b.expireAt[args[1]] = at
+			b.mu.Unlock()
+			l.Push(lua.LNumber(1))
+
+		default:
+			l.RaiseError("unexpected command %s", args[0])
+		}
+
+		return 1
+	}))
+
+	l.SetGlobal("redis", redis)
+	if err := l.DoString(approxConsumeScript.source); err != nil {
+		t.Fatalf("approxConsumeScript: %v", err)
+	}
+
+	result := l.Get(-1).(*lua.LTable)
+	return result.RawGetInt(1) == lua.LNumber(1), int64(result.RawGetInt(2).(lua.LNumber))
+}
+
+func TestApproxScript(t *testing.T) {
+	s := miniredis.RunT(t)
+	b := registerSketches(t, s)
+	p := newTestProviderOn(t, s, WithApproximateMode(1024, 4))
+
+	resetAt := time.Unix(1700000100, 0)
+	for i, want := range []bool{true, true, true, false, false} {
+		allowed, estimate := b.runSketchScript(t, p, "k", 3, resetAt)
+
+		// Rejected requests aren't counted, so the estimate stays at the limit.
+		wantEstimate := int64(i + 1)
+		if wantEstimate > 3 {
+			wantEstimate = 3
+		}
+
+		if allowed != want || estimate != wantEstimate {
+			t.Fatalf("request %d = allowed %t, estimate %d", i+1, allowed, estimate)
+		}
+
+		decision := newApproxDecision(allowed, estimate, 3, resetAt, resetAt.Add(-time.Minute))
+		if decision.Remaining != 3-wantEstimate {
+			t.Fatalf("request %d has %d remaining", i+1, decision.Remaining)
+		}
+	}
+
+	sketch := p.sketchKey(resetAt.Add(-time.Minute))
+	if b.expireAt[sketch] != resetAt.UnixMilli() {
+		t.Fatalf("the sketch expires at %d, want %d", b.expireAt[sketch], resetAt.UnixMilli())
+	}
+
+	// Only one row of another key colliding with k doesn't count, since the
+	// estimate is the smallest counter.
+	offsets := p.approxOffsets("other")
+	b.counters[sketch][offsets[0]] = 100
+	if allowed, estimate := b.runSketchScript(t, p, "other", 3, resetAt); !allowed || estimate != 1 {
+		t.Fatalf("another key = allowed %t, estimate %d", allowed, estimate)
+	}
+
+	// Counters saturate instead of wrapping around.
+	for _, offset := range p.approxOffsets("full") {
+		b.counters[sketch][offset] = 1<<32 - 1
+	}
+
+	if allowed, estimate := b.runSketchScript(t, p, "full", 1<<40, resetAt); !allowed || estimate != 1<<32 {
+		t.Fatalf("a full key = allowed %t, estimate %d", allowed, estimate)
+	}
+
+	for _, offset := range p.approxOffsets("full") {
+		if count := b.counters[sketch][offset]; count != 1<<32-1 {
+			t.Fatalf("a full counter went to %d", count)
+		}
+	}
+}
+
+func TestInspectApprox(t *testing.T) {
+	now := time.Unix(1700000040, 0)
+	s := miniredis.RunT(t)
+	b := registerSketches(t, s)
+	p := newTestProviderOn(t, s, WithApproximateMode(1024, 4), WithAlgorithm(ApproximateWindow()), WithClock(func() time.Time { return now }))
+
+	for i := 0; i < 2; i++ {
+		b.runSketchScript(t, p, "k", 2, now.Add(time.Minute))
+	}
+
+	decision, err := p.Inspect("k", 2, time.Minute)
+	if err != nil || decision.Allowed || decision.Remaining != 0 {
+		t.Fatalf("Inspect = %+v, %v", decision, err)
+	}
+
+	decision, err = p.Inspect("other", 2, time.Minute)
+	if err != nil || !decision.Allowed || decision.Remaining != 1 {
+		t.Fatalf("Inspect of another key = %+v, %v", decision, err)
+	}
+}
+
+func TestConsumeApproxDisabled(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if _, err := p.ConsumeApprox("k", 3, time.Minute); !errors.Is(err, ErrApproximateDisabled) {
+		t.Fatalf("ConsumeApprox without WithApproximateMode = %v", err)
+	}
+}
+
+func TestInspectApproxEpochAligned(t *testing.T) {
+	// Windows are counted since the Unix epoch like the scripts' windows do,
+	// which 7s windows since the zero Time aren't.
+	now := time.Unix(1700000003, 0)
+	s := miniredis.RunT(t)
+	registerSketches(t, s)
+	p := newTestProviderOn(t, s, WithApproximateMode(1024, 4), WithAlgorithm(ApproximateWindow()), WithClock(func() time.Time { return now }))
+
+	decision, err := p.Inspect("k", 2, 7*time.Second)
+	if err != nil || decision.ResetAt.UnixMilli()%7000 != 0 || !decision.ResetAt.After(now) {
+		t.Fatalf("Inspect = %+v, %v; want a reset at the next multiple of 7s since the Unix epoch", decision, err)
+	}
+}
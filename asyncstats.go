@@ -0,0 +1,129 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "time"
+
+// AsyncStats describes the queue of WithAsyncWrites.
+type AsyncStats struct {
+	// Depth is how many writes are queued or being flushed, out of Capacity.
+	Depth    int
+	Capacity int
+
+	// OldestAge is how long ago the oldest write that isn't written yet was
+	// queued, or zero if there is none.
+	OldestAge time.Duration
+
+	// Flushed and Failed are how many queued writes reached Redis and how many
+	// failed to, and Dropped is how many were dropped without being written
+	// because a Synchronous write of the same key replaced them.
+	Flushed uint64
+	Failed  uint64
+	Dropped uint64
+
+	// LastFlushError is the first error of the last flush that wrote
+	// anything, or nil if all of its writes succeeded, and LastFlushLatency
+	// is how long that flush took.
+	LastFlushError   error
+	LastFlushLatency time.Duration
+}
+
+// WithAsyncBackpressureCallback calls fn with true once the queue of
+// WithAsyncWrites is at least the given fraction of its capacity full, and
+// with false once it's back at half of that fraction or less, so a queue that
+// stays around the mark doesn't call it on every write. It's called in order,
+// on the goroutine of the write or flush that crossed the mark, so it should
+// be quick.
+func WithAsyncBackpressureCallback(fraction float64, fn func(stats AsyncStats, backedUp bool)) func(o *options) {
+	return func(o *options) {
+		o.asyncPressureAt = fraction
+		o.asyncPressure = fn
+	}
+}
+
+// AsyncStats returns what the queue of WithAsyncWrites looks like right now,
+// which is the zero AsyncStats without it.
+func (p *Provider) AsyncStats() AsyncStats {
+	if p.async == nil {
+		return AsyncStats{}
+	}
+
+	return p.async.stats()
+}
+
+func (q *asyncQueue) stats() AsyncStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := AsyncStats{
+		Depth:            len(q.ops) + len(q.writing),
+		Capacity:         q.capacity,
+		Flushed:          q.flushed,
+		Failed:           q.failed,
+		Dropped:          q.dropped,
+		LastFlushError:   q.lastError,
+		LastFlushLatency: q.lastLatency,
+	}
+
+	// ops are in the order they were queued, but the writes being flushed
+	// can be older than all of them.
+	var oldest time.Time
+	if len(q.ops) > 0 {
+		oldest = q.ops[0].queuedAt
+	}
+
+	for op := range q.writing {
+		if oldest.IsZero() || op.queuedAt.Before(oldest) {
+			oldest = op.queuedAt
+		}
+	}
+
+	if !oldest.IsZero() {
+		stats.OldestAge = time.Since(oldest)
+	}
+
+	return stats
+}
+
+// checkPressure calls the callback of WithAsyncBackpressureCallback if the
+// depth of the queue crossed one of its marks since it was last called.
+func (q *asyncQueue) checkPressure() {
+	if q.pressure == nil {
+		return
+	}
+
+	q.pressureMu.Lock()
+	defer q.pressureMu.Unlock()
+
+	stats := q.stats()
+	depth := float64(stats.Depth) / float64(q.capacity)
+	switch {
+	case !q.backedUp && depth >= q.pressureAt:
+		q.backedUp = true
+	case q.backedUp && depth <= q.pressureAt/2:
+		q.backedUp = false
+	default:
+		return
+	}
+
+	q.pressure(stats, q.backedUp)
+}
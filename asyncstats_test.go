@@ -0,0 +1,190 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pressureCalls records the calls of WithAsyncBackpressureCallback.
+type pressureCalls struct {
+	mu    sync.Mutex
+	calls []bool
+	depth []int
+}
+
+func (c *pressureCalls) record(stats AsyncStats, backedUp bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, backedUp)
+	c.depth = append(c.depth, stats.Depth)
+}
+
+func (c *pressureCalls) get() ([]bool, []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]bool(nil), c.calls...), append([]int(nil), c.depth...)
+}
+
+// waitFor waits up to a second for done to return true.
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); !done(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
+
+func TestAsyncStats(t *testing.T) {
+	s := miniredis.RunT(t)
+	hook := &blockHook{released: make(chan struct{})}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	client.AddHook(hook)
+	t.Cleanup(func() { _ = client.Close() })
+
+	var (
+		pressure pressureCalls
+		flushes  = make(chan AsyncStats, 16)
+	)
+
+	p, err := New(
+		WithClient(client),
+		WithAsyncWrites(4, time.Hour),
+		WithAsyncBackpressureCallback(0.75, pressure.record),
+		WithMetricsHook(MetricsHook{AsyncFlush: func(stats AsyncStats) { flushes <- stats }}),
+	)
+
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+
+	// The second write wakes the flusher, which gets stuck writing both.
+	atomic.StoreInt32(&hook.blocking, 1)
+	putAll(t, p, "a", "b")
+	waitFor(t, func() bool {
+		p.async.mu.Lock()
+		defer p.async.mu.Unlock()
+
+		return len(p.async.writing) == 2
+	})
+
+	if calls, _ := pressure.get(); len(calls) != 0 {
+		t.Fatalf("the callback was called with %v at half the capacity", calls)
+	}
+
+	putAll(t, p, "c", "d")
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	if err := p.PutWithOptions("e", rl, CallTimeout(20*time.Millisecond)); !errors.Is(err, ErrAsyncQueueFull) {
+		t.Fatalf("PutWithOptions = %v, want ErrAsyncQueueFull", err)
+	}
+
+	stats := p.AsyncStats()
+	if stats.Depth != 4 || stats.Capacity != 4 || stats.OldestAge <= 0 || stats.Flushed != 0 {
+		t.Fatalf("AsyncStats = %+v, want a full queue with nothing flushed", stats)
+	}
+
+	if calls, depth := pressure.get(); len(calls) != 1 || !calls[0] || depth[0] != 3 {
+		t.Fatalf("the callback was called with %v at %v, want true once 3 of 4 were queued", calls, depth)
+	}
+
+	// Once the flusher is unstuck, everything is written, and the callback
+	// hears that the queue drained.
+	close(hook.released)
+	atomic.StoreInt32(&hook.blocking, 0)
+	waitFor(t, func() bool { return p.AsyncStats().Flushed == 4 })
+
+	stats = p.AsyncStats()
+	if stats.Depth != 0 || stats.OldestAge != 0 || stats.LastFlushError != nil || stats.LastFlushLatency <= 0 {
+		t.Fatalf("AsyncStats = %+v, want an empty queue after a successful flush", stats)
+	}
+
+	if calls, _ := pressure.get(); len(calls) != 2 || calls[1] {
+		t.Fatalf("the callback was called with %v, want true and then false", calls)
+	}
+
+	if reported := <-flushes; reported.Flushed == 0 || reported.LastFlushLatency <= 0 {
+		t.Fatalf("the hook was called with %+v after a flush", reported)
+	}
+}
+
+func TestAsyncStatsFailedAndDropped(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(16, time.Hour))
+	putAll(t, p, "k")
+	if _, err := p.ResetWithOptions("k", Synchronous()); err != nil {
+		t.Fatalf("ResetWithOptions: %v", err)
+	}
+
+	if stats := p.AsyncStats(); stats.Dropped != 1 || stats.Depth != 0 {
+		t.Fatalf("AsyncStats = %+v, want the Put dropped by the Synchronous Reset", stats)
+	}
+
+	// A hash that isn't one makes the write fail.
+	if err := s.Set(p.hashKey(), "not a hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	putAll(t, p, "k")
+	if err := p.FlushWrites(context.Background()); err != nil {
+		t.Fatalf("FlushWrites: %v", err)
+	}
+
+	stats := p.AsyncStats()
+	if stats.Failed != 1 || stats.Flushed != 0 || stats.LastFlushError == nil {
+		t.Fatalf("AsyncStats = %+v, want the failed write", stats)
+	}
+
+	if !strings.Contains(stats.LastFlushError.Error(), "WRONGTYPE") {
+		t.Fatalf("LastFlushError = %v, want the WRONGTYPE reply", stats.LastFlushError)
+	}
+}
+
+func TestAsyncBackpressureOptions(t *testing.T) {
+	fn := func(AsyncStats, bool) {}
+	for _, opts := range [][]func(o *options){
+		{WithAsyncBackpressureCallback(0.5, fn)},
+		{WithAsyncWrites(4, time.Second), WithAsyncBackpressureCallback(0, fn)},
+		{WithAsyncWrites(4, time.Second), WithAsyncBackpressureCallback(1.5, fn)},
+	} {
+		if _, err := New(opts...); err == nil {
+			t.Fatal("New accepted an invalid WithAsyncBackpressureCallback")
+		}
+	}
+
+	if stats := (&Provider{}).AsyncStats(); stats != (AsyncStats{}) {
+		t.Fatalf("AsyncStats without WithAsyncWrites = %+v", stats)
+	}
+}
@@ -0,0 +1,463 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// asyncFlushWorkers is how many keys the flusher of WithAsyncWrites writes at
+// the same time.
+const asyncFlushWorkers = 8
+
+// ReadAsyncQueue is the queue of WithAsyncWrites, whose writes Get and Peek
+// see before they reach Redis. Like the local cache, it's always read first.
+const ReadAsyncQueue ReadSource = "async_queue"
+
+// WithAsyncWrites makes Put, the write of Get, Reset and Ban return once the
+// write is queued, and writes the queue to Redis in the background every
+// interval, or sooner once it's half full. Get and Peek of this Provider see
+// the queued writes; other Providers only once they're flushed. Errors of
+// queued writes go to the handler of WithErrorHandler, and a queued Reset
+// always reports that it deleted something.
+//
+// Writes of the same key are flushed in the order they were made, so a Reset
+// followed by a Put always ends with the Put, while writes of different keys
+// can be flushed in any order. Writes that don't go through the queue, like
+// Consume and Txn, aren't ordered with the queued ones, so a key shouldn't get
+// both. A write that finds the queue full waits for room until its write
+// timeout, then fails with ErrAsyncQueueFull. Shutdown flushes what's left.
+// The queue holds capacity writes, including the ones being flushed;
+// AsyncStats and WithAsyncBackpressureCallback tell when it's backing up.
+func WithAsyncWrites(capacity int, interval time.Duration) func(o *options) {
+	return func(o *options) {
+		o.asyncCapacity = capacity
+		o.asyncInterval = interval
+	}
+}
+
+// Synchronous makes a Put, Reset or Ban of a Provider with WithAsyncWrites
+// reach Redis before the call returns, bypassing the queue. Queued writes of
+// the same key are dropped, since they were made before and would otherwise
+// undo it.
+func Synchronous() CallOption {
+	return func(c *callOptions) {
+		c.synchronous = true
+	}
+}
+
+// asyncOp is a write of WithAsyncWrites.
+type asyncOp struct {
+	// op and key name the write for errors, where key is the storage key.
+	op  string
+	key string
+
+	// target is the Redis key or field that the write changes, which writes
+	// are ordered by.
+	target string
+
+	// data is what a write of a ratelimit stores, unless it deleted it. until
+	// is when a ban ends.
+	data    string
+	deleted bool
+	until   time.Time
+
+	apply    func(call *callOptions) error
+	call     *callOptions
+	queuedAt time.Time
+}
+
+// asyncQueue is the queue of WithAsyncWrites.
+type asyncQueue struct {
+	capacity int
+	interval time.Duration
+
+	// slots has a value for every write in the queue or being flushed, so a
+	// write waits for room by sending to it.
+	slots chan struct{}
+
+	// wake makes the flusher flush before its interval is over.
+	wake chan struct{}
+
+	mu  sync.Mutex
+	ops []*asyncOp
+
+	// busy are the targets whose writes are being flushed, or that a
+	// Synchronous write is changing, which no other writes of are taken from
+	// the queue until it's done.
+	busy map[string]bool
+
+	// latest is the last queued write of each target until it's flushed,
+	// which reads answer from.
+	latest map[string]*asyncOp
+
+	// changed is closed and replaced whenever a target stops being busy.
+	changed chan struct{}
+
+	// closed is set once the queue was flushed for Shutdown, after which
+	// writes go to Redis right away.
+	closed bool
+
+	// writing are the writes that were taken from ops and aren't written yet.
+	// The rest is what AsyncStats returns about them.
+	writing     map[*asyncOp]bool
+	flushed     uint64
+	failed      uint64
+	dropped     uint64
+	lastError   error
+	lastLatency time.Duration
+
+	// pressure is the callback of WithAsyncBackpressureCallback, which is
+	// called in order under pressureMu, and backedUp is what it was last
+	// called with.
+	pressureAt float64
+	pressure   func(stats AsyncStats, backedUp bool)
+	pressureMu sync.Mutex
+	backedUp   bool
+}
+
+func newAsyncQueue(capacity int, interval time.Duration) *asyncQueue {
+	return &asyncQueue{
+		capacity: capacity,
+		interval: interval,
+		slots:    make(chan struct{}, capacity),
+		wake:     make(chan struct{}, 1),
+		busy:     map[string]bool{},
+		latest:   map[string]*asyncOp{},
+		changed:  make(chan struct{}),
+		writing:  map[*asyncOp]bool{},
+	}
+}
+
+// queueWrite queues op with WithAsyncWrites. It returns false without queueing
+// it if the Provider has no queue, or it's closed, or the call is Synchronous
+// or a dry run; the caller then writes right away, in exclusive. If it
+// returns true, err is only about queueing it.
+func (p *Provider) queueWrite(call *callOptions, op *asyncOp) (queued bool, err error) {
+	q := p.async
+	if q == nil || p.dryRun || (call != nil && call.synchronous) {
+		return false, nil
+	}
+
+	// The queued write outlives the call, so it can't be cancelled with it.
+	queuedCall := callOptions{}
+	if call != nil {
+		queuedCall = *call
+	}
+
+	queuedCall.parent, queuedCall.roundTrips = nil, nil
+	op.call, op.queuedAt = &queuedCall, time.Now()
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false, fmt.Errorf("%w: %v", ErrAsyncQueueFull, ctx.Err())
+	}
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.slots
+
+		return false, nil
+	}
+
+	q.ops = append(q.ops, op)
+	q.latest[op.target] = op
+	full := len(q.ops)*2 >= q.capacity
+	q.mu.Unlock()
+
+	if full {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	q.checkPressure()
+	return true, nil
+}
+
+// pending returns the last queued write of the given target, or nil if it has
+// none.
+func (q *asyncQueue) pending(target string) *asyncOp {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.latest[target]
+}
+
+// exclusive runs fn for the given target once none of its writes are being
+// flushed, dropping the ones that are still queued. Writes of the target that
+// are queued while fn runs wait for it. Without a queue, it just runs fn.
+func (q *asyncQueue) exclusive(target string, fn func() error) error {
+	if q == nil {
+		return fn()
+	}
+
+	q.mu.Lock()
+	kept := q.ops[:0]
+	for _, op := range q.ops {
+		if op.target == target {
+			<-q.slots
+			q.dropped++
+			continue
+		}
+
+		kept = append(kept, op)
+	}
+
+	dropped := len(q.ops) - len(kept)
+	for i := len(kept); i < len(q.ops); i++ {
+		q.ops[i] = nil
+	}
+
+	q.ops = kept
+	delete(q.latest, target)
+	if dropped > 0 {
+		q.mu.Unlock()
+		q.checkPressure()
+		q.mu.Lock()
+	}
+
+	for q.busy[target] {
+		changed := q.changed
+		q.mu.Unlock()
+		<-changed
+		q.mu.Lock()
+	}
+
+	q.busy[target] = true
+	q.mu.Unlock()
+
+	defer q.release(target)
+	return fn()
+}
+
+// release marks the target as not busy anymore.
+func (q *asyncQueue) release(target string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.busy, target)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// take takes every queued write whose target isn't busy, grouped by target in
+// the order they were queued, and marks their targets as busy.
+func (q *asyncQueue) take() [][]*asyncOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var (
+		batch  [][]*asyncOp
+		groups = map[string]int{}
+		kept   = q.ops[:0]
+	)
+
+	for _, op := range q.ops {
+		i, taken := groups[op.target]
+		switch {
+		case taken:
+			batch[i] = append(batch[i], op)
+			q.writing[op] = true
+		case q.busy[op.target]:
+			kept = append(kept, op)
+		default:
+			groups[op.target] = len(batch)
+			batch = append(batch, []*asyncOp{op})
+			q.writing[op] = true
+		}
+	}
+
+	// Clear what's left behind, so taken writes aren't kept alive by it.
+	for i := len(kept); i < len(q.ops); i++ {
+		q.ops[i] = nil
+	}
+
+	q.ops = kept
+	for target := range groups {
+		q.busy[target] = true
+	}
+
+	return batch
+}
+
+// flush writes what take returns, the writes of each target in order, and
+// returns how many it wrote. A flush that wrote anything is reported to
+// AsyncStats and WithMetricsHook.
+func (p *Provider) flush() int {
+	q := p.async
+	start := time.Now()
+	batch := q.take()
+
+	var (
+		errMu    sync.Mutex
+		firstErr error
+	)
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, asyncFlushWorkers)
+	for _, ops := range batch {
+		ops := ops
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			var failed uint64
+			for _, op := range ops {
+				if err := p.applyQueued(op); err != nil {
+					err = p.wrapError(op.op, op.key, err)
+					p.reportError(op.op, err)
+					failed++
+
+					errMu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+
+					errMu.Unlock()
+				}
+			}
+
+			q.mu.Lock()
+			if last := ops[len(ops)-1]; q.latest[last.target] == last {
+				delete(q.latest, last.target)
+			}
+
+			for _, op := range ops {
+				delete(q.writing, op)
+			}
+
+			q.flushed += uint64(len(ops)) - failed
+			q.failed += failed
+
+			q.mu.Unlock()
+			for range ops {
+				<-q.slots
+			}
+
+			q.release(ops[0].target)
+		}()
+	}
+
+	wg.Wait()
+
+	n := 0
+	for _, ops := range batch {
+		n += len(ops)
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	q.mu.Lock()
+	q.lastError, q.lastLatency = firstErr, time.Since(start)
+	q.mu.Unlock()
+
+	q.checkPressure()
+	if p.metrics.AsyncFlush != nil {
+		p.metrics.AsyncFlush(q.stats())
+	}
+
+	return n
+}
+
+// applyQueued writes a queued write to Redis.
+func (p *Provider) applyQueued(op *asyncOp) (err error) {
+	defer p.recoverPanic(&err, op.op, op.key)
+
+	return op.apply(op.call)
+}
+
+// FlushWrites writes everything that WithAsyncWrites queued until now to Redis,
+// and waits for the writes that were already being flushed, until ctx is done.
+// It's a no-op without WithAsyncWrites.
+func (p *Provider) FlushWrites(ctx context.Context) (err error) {
+	defer p.recoverPanic(&err, "flush_writes", "")
+
+	q := p.async
+	if q == nil {
+		return nil
+	}
+
+	for {
+		p.flush()
+
+		q.mu.Lock()
+		idle := len(q.ops) == 0 && len(q.busy) == 0
+		changed := q.changed
+		q.mu.Unlock()
+
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runFlusher flushes the queue every interval, or when it's woken, until the
+// Provider shuts down, and then flushes what's left and closes the queue.
+func (p *Provider) runFlusher() {
+	q := p.async
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			_ = p.FlushWrites(context.Background())
+
+			q.mu.Lock()
+			q.closed = true
+			q.mu.Unlock()
+
+			// Writes that were queued while the queue was closing.
+			_ = p.FlushWrites(context.Background())
+			return
+
+		case <-ticker.C:
+		case <-q.wake:
+		}
+
+		p.flush()
+	}
+}
@@ -0,0 +1,233 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockHook blocks every command while it's set, until it's released.
+type blockHook struct {
+	blocking int32
+	released chan struct{}
+}
+
+func (h *blockHook) wait() {
+	if atomic.LoadInt32(&h.blocking) == 1 {
+		<-h.released
+	}
+}
+
+func (h *blockHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	h.wait()
+	return ctx, nil
+}
+
+func (h *blockHook) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (h *blockHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.wait()
+	return ctx, nil
+}
+
+func (h *blockHook) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestAsyncWritesOrder(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(64, time.Hour))
+	random := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 20; round++ {
+		var last *types.Ratelimit
+		for i := 0; i < 1+random.Intn(20); i++ {
+			if random.Intn(3) == 0 {
+				if _, err := p.Reset("k"); err != nil {
+					t.Fatalf("Reset: %v", err)
+				}
+
+				last = nil
+				continue
+			}
+
+			last = &types.Ratelimit{Limit: 100, Remaining: int32(random.Intn(100)), ResetTime: time.Now().Add(time.Minute)}
+			if err := p.Put("k", last); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+
+		if err := p.FlushWrites(context.Background()); err != nil {
+			t.Fatalf("FlushWrites: %v", err)
+		}
+
+		stored := s.HGet(p.hashKey(), "k")
+		if last == nil {
+			if stored != "" {
+				t.Fatalf("round %d: stored %q after a final Reset", round, stored)
+			}
+
+			continue
+		}
+
+		rl, err := p.decode(stored)
+		if err != nil || rl.Remaining != last.Remaining {
+			t.Fatalf("round %d: stored %+v, %v, want Remaining %d", round, rl, err, last.Remaining)
+		}
+	}
+}
+
+func TestAsyncWritesRead(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(64, time.Hour))
+	putAll(t, p, "k")
+
+	if s.Exists(p.hashKey()) {
+		t.Fatal("the queued Put reached Redis before a flush")
+	}
+
+	rl, err := p.Peek("k")
+	if err != nil || rl == nil || rl.Remaining != 10 {
+		t.Fatalf("Peek = %+v, %v, want the queued ratelimit", rl, err)
+	}
+
+	for want := int32(9); want >= 7; want-- {
+		if rl, err := p.Get("k"); err != nil || rl.Remaining != want {
+			t.Fatalf("Get = %+v, %v, want Remaining %d", rl, err, want)
+		}
+	}
+
+	if err := p.FlushWrites(context.Background()); err != nil {
+		t.Fatalf("FlushWrites: %v", err)
+	}
+
+	if rl, err := p.decode(s.HGet(p.hashKey(), "k")); err != nil || rl.Remaining != 7 {
+		t.Fatalf("stored %+v, %v, want Remaining 7", rl, err)
+	}
+}
+
+func TestAsyncWritesSynchronousReset(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(64, time.Hour))
+	s.HSet(p.hashKey(), "k", "x")
+	putAll(t, p, "k")
+
+	if ok, err := p.ResetWithOptions("k", Synchronous()); err != nil || !ok {
+		t.Fatalf("ResetWithOptions = %v, %v", ok, err)
+	}
+
+	if s.HGet(p.hashKey(), "k") != "" {
+		t.Fatal("a Synchronous Reset didn't reach Redis before returning")
+	}
+
+	if err := p.FlushWrites(context.Background()); err != nil {
+		t.Fatalf("FlushWrites: %v", err)
+	}
+
+	if s.HGet(p.hashKey(), "k") != "" {
+		t.Fatal("the Put queued before the Synchronous Reset was flushed after it")
+	}
+}
+
+func TestAsyncWritesBan(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(64, time.Hour))
+	if err := p.Ban("queued", time.Minute); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	banKey := p.banKey(p.storageKey("queued"))
+	if s.Exists(banKey) {
+		t.Fatal("the queued ban reached Redis before a flush")
+	}
+
+	if d, err := p.Banned("queued"); err != nil || d <= 0 {
+		t.Fatalf("Banned = %v, %v, want the queued ban", d, err)
+	}
+
+	if err := p.FlushWrites(context.Background()); err != nil {
+		t.Fatalf("FlushWrites: %v", err)
+	}
+
+	if !s.Exists(banKey) {
+		t.Fatal("the queued ban wasn't flushed")
+	}
+
+	if err := p.BanWithOptions("now", time.Minute, Synchronous()); err != nil {
+		t.Fatalf("BanWithOptions: %v", err)
+	}
+
+	if !s.Exists(p.banKey(p.storageKey("now"))) {
+		t.Fatal("a Synchronous ban didn't reach Redis before returning")
+	}
+}
+
+func TestAsyncWritesShutdown(t *testing.T) {
+	p, s := newTestProvider(t, WithAsyncWrites(64, time.Hour))
+	putAll(t, p, "a", "b")
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if fields, _ := s.HKeys(p.hashKey()); len(fields) != 2 {
+		t.Fatalf("stored %v after Shutdown, want both writes", fields)
+	}
+}
+
+func TestAsyncWritesQueueFull(t *testing.T) {
+	s := miniredis.RunT(t)
+	hook := &blockHook{released: make(chan struct{})}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	client.AddHook(hook)
+	t.Cleanup(func() { _ = client.Close() })
+
+	p, err := New(WithClient(client), WithAsyncWrites(1, time.Hour))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+	defer close(hook.released)
+
+	atomic.StoreInt32(&hook.blocking, 1)
+	putAll(t, p, "a")
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	start := time.Now()
+	if err := p.PutWithOptions("b", rl, CallTimeout(50*time.Millisecond)); !errors.Is(err, ErrAsyncQueueFull) {
+		t.Fatalf("PutWithOptions = %v, want ErrAsyncQueueFull", err)
+	}
+
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("waited %v for room, past the call timeout", waited)
+	}
+}
+
+func TestAsyncWritesOptions(t *testing.T) {
+	for _, opt := range []func(o *options){WithAsyncWrites(0, time.Second), WithAsyncWrites(1, 0)} {
+		if _, err := New(opt); err == nil {
+			t.Fatal("New accepted WithAsyncWrites without a positive capacity and interval")
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyError is the error for a single entry of a batch.
+type KeyError struct {
+	// Index is the position of the entry in the batch.
+	Index int
+	Key   string
+	Err   error
+}
+
+// BatchError is returned from batch operations when only some of the entries
+// failed. errors.Is and errors.As look at the error of the first entry that
+// failed; use ForEach or Errors to see all of them.
+type BatchError struct {
+	errs []KeyError
+
+	// verbose lists entries by key instead of index, see WithVerboseErrors.
+	verbose bool
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, keyErr := range e.errs {
+		if e.verbose {
+			messages[i] = fmt.Sprintf("%q: %v", keyErr.Key, keyErr.Err)
+		} else {
+			messages[i] = fmt.Sprintf("#%d: %v", keyErr.Index, keyErr.Err)
+		}
+	}
+
+	return fmt.Sprintf("%d keys failed: %s", len(e.errs), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the error of the first entry that failed.
+func (e *BatchError) Unwrap() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+
+	return e.errs[0].Err
+}
+
+// Len returns how many entries failed.
+func (e *BatchError) Len() int {
+	return len(e.errs)
+}
+
+// Errors returns the entries that failed, in the order they're in the batch.
+func (e *BatchError) Errors() []KeyError {
+	return e.errs
+}
+
+// ForEach calls fn with the key and error of every entry that failed.
+func (e *BatchError) ForEach(fn func(key string, err error)) {
+	for _, keyErr := range e.errs {
+		fn(keyErr.Key, keyErr.Err)
+	}
+}
+
+// FailedKeys returns the keys of the entries that failed if err is (or wraps)
+// a BatchError, or nil otherwise.
+func FailedKeys(err error) []string {
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		return nil
+	}
+
+	keys := make([]string, len(batchErr.errs))
+	for i, keyErr := range batchErr.errs {
+		keys[i] = keyErr.Key
+	}
+
+	return keys
+}
+
+func (e *BatchError) add(index int, key string, err error) {
+	e.errs = append(e.errs, KeyError{Index: index, Key: key, Err: err})
+}
+
+// err returns the BatchError, or nil if nothing failed.
+func (e *BatchError) err() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+
+	return e
+}
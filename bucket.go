@@ -0,0 +1,174 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// burstBucketScript takes tokens from a bucket that holds up to burst tokens
+// and refills at the sustained rate, returning whether they were taken and how
+// many tokens are left. Denied requests take nothing.
+//
+// KEYS[1] = bucket
+// ARGV[1] = burst, ARGV[2] = sustained rate in tokens per second,
+// ARGV[3] = tokens to take, ARGV[4] = now in Unix milliseconds
+var burstBucketScript = registerScript("burst_bucket", `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2]) / 1000
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local tokens = burst
+local stored = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+if stored[1] and stored[2] then
+	tokens = math.min(burst, tonumber(stored[1]) + math.max(now - tonumber(stored[2]), 0) * rate)
+end
+
+local allowed = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.17g', tokens), 'at', string.format('%d', now))
+
+-- A full bucket is the same as none at all.
+redis.call('PEXPIRE', KEYS[1], math.ceil((burst - tokens) / rate) + 1)
+return { allowed, string.format('%.17g', tokens) }
+`)
+
+// burstBucket is the bucket of WithBurstBucket or of a Limiter.
+type burstBucket struct {
+	burst     int64
+	sustained float64
+}
+
+// WithBurstBucket enables Take and TakeN, which admit requests with a token
+// bucket that holds up to burst tokens and refills at sustained tokens per
+// second ("{<prefix>}:bucket:<key>"), so a key can burst up to burst requests
+// at once and then keep up sustained requests per second. It's a mode of its
+// own next to Consume; the same key can be used with both, but they don't see
+// each other's requests. A Limiter can set its own burst and sustained rate in
+// its LimiterDefaults.
+func WithBurstBucket(burst int64, sustained float64) func(o *options) {
+	return func(o *options) {
+		o.burstBucket = &burstBucket{burst: burst, sustained: sustained}
+	}
+}
+
+func (b *burstBucket) valid() bool {
+	return b.burst > 0 && b.sustained > 0 && !math.IsInf(b.sustained, 0)
+}
+
+// duration returns how long the bucket takes to refill the given amount of
+// tokens.
+func (b *burstBucket) duration(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+
+	return time.Duration(math.Ceil(tokens / b.sustained * float64(time.Second)))
+}
+
+func (p *Provider) bucketKey(key string) string {
+	return p.companionKey("bucket", key)
+}
+
+// Take takes a token from the bucket of WithBurstBucket for the given key and
+// returns its Decision, see TakeN.
+func (p *Provider) Take(key string) (Decision, error) {
+	return p.TakeN(key, 1)
+}
+
+// TakeN takes n tokens from the bucket of WithBurstBucket for the given key if
+// it has that many, or none at all. In the Decision, Limit is the burst,
+// Remaining the whole tokens that are left, Window how long an empty bucket
+// takes to fill up, and ResetAt when the bucket is full again. NextTokenAfter
+// and FullAfter say how long until the next token and the full bucket.
+// Rejected requests count towards the abuse score of WithAbuseScore.
+func (p *Provider) TakeN(key string, n int64) (decision Decision, err error) {
+	defer p.recoverPanic(&err, "take", key)
+
+	if p.burstBucket == nil {
+		return Decision{}, ErrBucketDisabled
+	}
+
+	return p.take(p.burstBucket, p.storageKey(key), n)
+}
+
+// take takes n tokens from the bucket of the given storage key.
+func (p *Provider) take(b *burstBucket, key string, n int64) (Decision, error) {
+	if n < 1 || n > b.burst {
+		return Decision{}, fmt.Errorf("can't take %d tokens from a bucket of %d", n, b.burst)
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	sustained := strconv.FormatFloat(b.sustained, 'g', -1, 64)
+	result, err := p.runScript(ctx, burstBucketScript, []string{p.bucketKey(key)}, b.burst, sustained, n, now.UnixMilli()).Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	allowed, _ := result[0].(int64)
+	encoded, _ := result[1].(string)
+	tokens, err := strconv.ParseFloat(encoded, 64)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := newBucketDecision(b, allowed == 1, tokens, n, now)
+	p.logDecision(key, burstBucketScript.Hash(), decision.Window, decision, now)
+	if !decision.Allowed {
+		return decision, p.recordRejection(ctx, key)
+	}
+
+	return decision, nil
+}
+
+// newBucketDecision works out the Decision for taking n tokens from a bucket
+// that has the given amount left.
+func newBucketDecision(b *burstBucket, allowed bool, tokens float64, n int64, now time.Time) Decision {
+	decision := Decision{
+		Allowed:        allowed,
+		Limit:          b.burst,
+		Remaining:      int64(math.Floor(tokens)),
+		Window:         b.duration(float64(b.burst)),
+		NextTokenAfter: b.duration(1 - tokens),
+		FullAfter:      b.duration(float64(b.burst) - tokens),
+	}
+
+	decision.ResetAt = now.Add(decision.FullAfter)
+	decision.ResetAfter = decision.FullAfter
+	if !allowed {
+		decision.RetryAfter = b.duration(float64(n) - tokens)
+	}
+
+	return decision
+}
@@ -0,0 +1,68 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTakeN(t *testing.T) {
+	now := time.Unix(1700000040, 0)
+	p, server := newTestProvider(t, WithBurstBucket(5, 1), WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	take := func(n int64, allowed bool, remaining int64) Decision {
+		t.Helper()
+
+		decision, err := p.TakeN("k", n)
+		if err != nil {
+			t.Fatalf("TakeN(%d): %v", n, err)
+		}
+
+		if decision.Allowed != allowed || decision.Remaining != remaining {
+			t.Fatalf("TakeN(%d) = %+v; want allowed %t, %d remaining", n, decision, allowed, remaining)
+		}
+
+		return decision
+	}
+
+	take(3, true, 2)
+
+	// Taking more tokens than are left takes none of them.
+	if rejected := take(3, false, 2); rejected.RetryAfter != time.Second {
+		t.Fatalf("rejected TakeN = %+v", rejected)
+	}
+
+	take(2, true, 0)
+
+	// Tokens refill at the sustained rate, up to the burst.
+	now = now.Add(2500 * time.Millisecond)
+	take(2, true, 0)
+
+	now = now.Add(time.Hour)
+	take(1, true, 4)
+
+	if _, err := p.TakeN("k", 6); err == nil {
+		t.Fatal("TakeN took more tokens than the bucket holds")
+	}
+}
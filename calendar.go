@@ -0,0 +1,104 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "time"
+
+type calendarKind int
+
+const (
+	calendarNone calendarKind = iota
+	calendarDay
+	calendarWeek
+	calendarMonth
+)
+
+// CalendarUnit is a calendar period that WithCalendarWindows aligns windows to.
+type CalendarUnit struct {
+	kind      calendarKind
+	weekStart time.Weekday
+}
+
+var (
+	// Day makes windows reset at midnight.
+	Day = CalendarUnit{kind: calendarDay}
+
+	// Month makes windows reset at midnight on the first of the month.
+	Month = CalendarUnit{kind: calendarMonth}
+)
+
+// Week makes windows reset at midnight on the given day of the week.
+func Week(start time.Weekday) CalendarUnit {
+	return CalendarUnit{kind: calendarWeek, weekStart: start}
+}
+
+// WithCalendarWindows makes new fixed windows end at the next boundary of the
+// given calendar unit in loc (UTC if it's nil) instead of a window length after
+// the first request, so a monthly quota resets on the first of the month no
+// matter when it was first used. Boundaries are computed on the calendar, so
+// a day that's shorter or longer because of a DST change and months of
+// different lengths reset when they're supposed to. The limit still comes from
+// the key's policy. Field TTLs are set to the same instant.
+//
+// It only changes FixedWindow, which is the default Algorithm.
+func WithCalendarWindows(unit CalendarUnit, loc *time.Location) func(o *options) {
+	return func(o *options) {
+		o.calendar = unit
+		o.calendarLoc = loc
+	}
+}
+
+// next returns the first boundary of the unit after t, in loc.
+func (u CalendarUnit) next(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	t = t.In(loc)
+	year, month, day := t.Date()
+	switch u.kind {
+	case calendarDay:
+		return time.Date(year, month, day+1, 0, 0, 0, 0, loc)
+
+	case calendarWeek:
+		days := (int(u.weekStart) - int(t.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+
+		return time.Date(year, month, day+days, 0, 0, 0, 0, loc)
+
+	case calendarMonth:
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, loc)
+	}
+
+	return t
+}
+
+// windowEnd returns when a fixed window that starts now ends.
+func (p *Provider) windowEnd(now time.Time, window time.Duration) time.Time {
+	if p.calendar.kind == calendarNone {
+		return now.Add(window)
+	}
+
+	return p.calendar.next(now, p.calendarLoc)
+}
@@ -0,0 +1,136 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// CallOption overrides the Provider's options for a single call of one of the
+// *WithOptions methods.
+type CallOption func(c *callOptions)
+
+type callOptions struct {
+	parent     context.Context
+	timeout    time.Duration
+	reason     ResetReason
+	note       string
+	persistent bool
+
+	// requireComplete is set by RequireComplete.
+	requireComplete bool
+
+	// partialReported is set by Sharded for the calls of its shards, since it
+	// reports partial batches for the whole batch itself.
+	partialReported bool
+
+	// skipWriteConcern is set by SkipWriteConcern.
+	skipWriteConcern bool
+
+	// synchronous is set by Synchronous.
+	synchronous bool
+
+	// roundTrips counts the round trips of a call that WithRoundTripSampling
+	// sampled.
+	roundTrips *roundTrips
+}
+
+// CallTimeout replaces the read or write timeout for the call.
+func CallTimeout(d time.Duration) CallOption {
+	return func(c *callOptions) {
+		c.timeout = d
+	}
+}
+
+// WithCallContext makes the call use the given context, so it's also cancelled
+// when ctx is. Timeouts still apply on top of it.
+func WithCallContext(ctx context.Context) CallOption {
+	return func(c *callOptions) {
+		c.parent = ctx
+	}
+}
+
+// SkipWriteConcern makes the writes of the call return without waiting for the
+// replicas of WithWriteConcern, like on a latency-critical path.
+func SkipWriteConcern() CallOption {
+	return func(c *callOptions) {
+		c.skipWriteConcern = true
+	}
+}
+
+// RequireComplete makes a batch operation like ConsumeManyKeys fail as a whole
+// when any of its keys failed, instead of returning what the others got.
+func RequireComplete() CallOption {
+	return func(c *callOptions) {
+		c.requireComplete = true
+	}
+}
+
+// completeRequired returns whether RequireComplete was given. A nil
+// *callOptions uses the defaults.
+func (c *callOptions) completeRequired() bool {
+	return c != nil && c.requireComplete
+}
+
+// newCallOptions returns the given options applied, or nil if there are none.
+func newCallOptions(opts []CallOption) *callOptions {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	call := &callOptions{}
+	for _, override := range opts {
+		override(call)
+	}
+
+	return call
+}
+
+// context returns the context for an operation whose timeout is d unless the
+// call overrides it. A nil *callOptions uses the defaults.
+func (c *callOptions) context(d time.Duration) (context.Context, context.CancelFunc) {
+	parent := context.TODO()
+	if c != nil {
+		if c.parent != nil {
+			parent = c.parent
+		}
+
+		if c.skipWriteConcern {
+			parent = context.WithValue(parent, skipWriteConcernKey{}, true)
+		}
+
+		if c.roundTrips != nil {
+			parent = context.WithValue(parent, roundTripKey{}, c.roundTrips)
+		}
+
+		if c.timeout > 0 {
+			d = c.timeout
+		}
+	}
+
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeout(parent, d)
+}
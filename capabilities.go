@@ -0,0 +1,172 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ServerKind is the server that a Provider is connected to, as far as INFO
+// tells.
+type ServerKind string
+
+const (
+	// ServerUnknown is a server that couldn't be told apart, like when INFO
+	// failed.
+	ServerUnknown ServerKind = ""
+
+	// ServerRedis is Redis itself, or a server that doesn't say otherwise.
+	ServerRedis ServerKind = "redis"
+
+	// ServerKeyDB is KeyDB.
+	ServerKeyDB ServerKind = "keydb"
+
+	// ServerDragonfly is Dragonfly.
+	ServerDragonfly ServerKind = "dragonfly"
+)
+
+// Capabilities is what AdminClient.Capabilities found out about the server.
+// Options that need a capability check it in New, so anything that differs
+// between servers depends on these flags rather than on Server.
+type Capabilities struct {
+	// Server is the server the Provider is connected to.
+	Server ServerKind
+
+	// Version is the version that the server reports for itself, which for
+	// Dragonfly is its own version rather than the one of Redis it's
+	// compatible with.
+	Version string
+
+	// FieldTTL is true if the server has hash field TTLs, which WithFieldTTL
+	// needs.
+	FieldTTL bool
+
+	// Wait is true if the server understands WAIT, which WithWriteConcern
+	// needs.
+	Wait bool
+}
+
+// Capabilities asks the server what it is and which of the commands that some
+// options need it supports. It never writes anything.
+func (a *AdminClient) Capabilities(ctx context.Context) (caps *Capabilities, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "capabilities", "")
+
+	return p.probeCapabilities(ctx, true, true)
+}
+
+// probeCapabilities is Capabilities, only probing for field TTLs and WAIT if
+// asked to, so New doesn't send commands that WithRestrictedCommands might not
+// allow. The server is only named for errors, so if INFO fails, like because
+// the ACL user isn't allowed to use it, it's left unknown.
+func (p *Provider) probeCapabilities(ctx context.Context, fieldTTL, wait bool) (*Capabilities, error) {
+	caps := &Capabilities{}
+	if info, err := p.client.Info(ctx, "server").Result(); err == nil {
+		caps.Server, caps.Version = serverKind(info)
+	}
+
+	var err error
+	if fieldTTL {
+		if caps.FieldTTL, err = p.supportsFieldTTL(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if wait {
+		if caps.Wait, err = p.supportsWait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return caps, nil
+}
+
+// serverKind tells the server and its version from the output of INFO server.
+// Dragonfly has a field of its own, while KeyDB only shows up in the paths of
+// its executable and config file.
+func serverKind(info string) (ServerKind, string) {
+	if version := infoField(info, "dragonfly_version"); version != "" {
+		return ServerDragonfly, version
+	}
+
+	version := infoField(info, "redis_version")
+	for _, field := range []string{"executable", "config_file"} {
+		if strings.Contains(strings.ToLower(infoField(info, field)), "keydb") {
+			return ServerKeyDB, version
+		}
+	}
+
+	return ServerRedis, version
+}
+
+// supportsWait sends a WAIT that returns right away, which only servers that
+// understand WAIT answer without an error.
+func (p *Provider) supportsWait(ctx context.Context) (bool, error) {
+	err := p.client.Do(ctx, "WAIT", 0, 0).Err()
+	if err == nil {
+		return true, nil
+	}
+
+	if hasErrorPrefix(err, "ERR unknown command") {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// serverName returns how errors about a missing capability name the server.
+func (c *Capabilities) serverName() string {
+	switch c.Server {
+	case ServerKeyDB:
+		return "KeyDB"
+	case ServerDragonfly:
+		return "Dragonfly"
+	case ServerRedis:
+		return fmt.Sprintf("Redis %s", c.Version)
+	default:
+		return "the server"
+	}
+}
+
+// checkCapabilities makes sure that the server has what the configured options
+// need, falling back where an option allows it.
+func (p *Provider) checkCapabilities(config *options) error {
+	ctx, cancel := p.readContext()
+	defer cancel()
+
+	caps, err := p.probeCapabilities(ctx, config.fieldTTL, config.writeReplicas > 0)
+	if err != nil {
+		return err
+	}
+
+	if config.writeReplicas > 0 && !caps.Wait {
+		return fmt.Errorf("WithWriteConcern needs WAIT, which %s doesn't support", caps.serverName())
+	}
+
+	if config.fieldTTL {
+		return p.setupFieldTTL(caps, config.fieldTTLFallback)
+	}
+
+	return nil
+}
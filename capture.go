@@ -0,0 +1,237 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceArgLength is how long an argument or reply in a CommandTrace can be
+// before it's cut off.
+const traceArgLength = 64
+
+// CommandTrace is a single command that CaptureKey recorded.
+type CommandTrace struct {
+	// Key is the key that was captured, as it was given to CaptureKey.
+	Key string
+
+	// Name is the name of the command, like "hget" or "evalsha".
+	Name string
+
+	// Args are the arguments after the name, each cut off after 64 bytes.
+	Args []string
+
+	// Reply summarizes what Redis replied.
+	Reply string
+
+	// Err is the error that the command failed with, if any.
+	Err error
+
+	// Latency is how long the command took. Commands that were sent in a
+	// pipeline all get the latency of the whole pipeline.
+	Latency time.Duration
+
+	// Instance is the ID of the Provider that sent the command.
+	Instance string
+
+	// At is when the command was sent.
+	At time.Time
+}
+
+// capture is a single CaptureKey call.
+type capture struct {
+	key        string
+	storageKey string
+	until      time.Time
+	sink       func(CommandTrace)
+}
+
+// captures are the CaptureKey calls that are running on a Provider.
+type captures struct {
+	installOnce sync.Once
+	mu          sync.RWMutex
+	active      map[*capture]struct{}
+}
+
+type captureStartKey struct{}
+
+// CaptureKey sends every command that this Provider sends to Redis for the
+// given key to sink for the duration d, including its companion keys like
+// tombstones, for debugging what happened to a single key. It returns a
+// function that stops the capture early. sink is called on the goroutine that
+// sent the command, so it should be quick.
+//
+// The first capture installs a hook on the Redis client, which stays there; when
+// nothing is being captured, it does nothing but check that.
+func (p *Provider) CaptureKey(key string, d time.Duration, sink func(CommandTrace)) (stop func()) {
+	c := &capture{key: key, storageKey: p.storageKey(key), until: time.Now().Add(d), sink: sink}
+
+	p.captures.installOnce.Do(func() {
+		p.client.AddHook(captureHook{provider: p})
+	})
+
+	p.captures.mu.Lock()
+	if p.captures.active == nil {
+		p.captures.active = map[*capture]struct{}{}
+	}
+
+	p.captures.active[c] = struct{}{}
+	p.capturing.Store(true)
+	p.captures.mu.Unlock()
+
+	timer := time.AfterFunc(d, func() { p.stopCapture(c) })
+	return func() {
+		timer.Stop()
+		p.stopCapture(c)
+	}
+}
+
+func (p *Provider) stopCapture(c *capture) {
+	p.captures.mu.Lock()
+	defer p.captures.mu.Unlock()
+
+	delete(p.captures.active, c)
+	p.capturing.Store(len(p.captures.active) > 0)
+}
+
+// traceCommand sends cmd to every capture whose key it touches.
+func (p *Provider) traceCommand(cmd redis.Cmder, at time.Time, latency time.Duration) {
+	args := cmd.Args()
+
+	p.captures.mu.RLock()
+	defer p.captures.mu.RUnlock()
+
+	for c := range p.captures.active {
+		if !touchesKey(args, c.storageKey) || time.Now().After(c.until) {
+			continue
+		}
+
+		trace := CommandTrace{
+			Key:      c.key,
+			Name:     cmd.Name(),
+			Reply:    replySummary(cmd),
+			Err:      cmd.Err(),
+			Latency:  latency,
+			Instance: p.instanceID,
+			At:       at,
+		}
+
+		for _, arg := range args[1:] {
+			trace.Args = append(trace.Args, truncateTrace(fmt.Sprint(arg)))
+		}
+
+		c.sink(trace)
+	}
+}
+
+// touchesKey returns true if one of the arguments is the storage key itself, as
+// a field, or a companion key of it.
+func touchesKey(args []interface{}, key string) bool {
+	for _, arg := range args {
+		value, ok := arg.(string)
+		if ok && (value == key || strings.HasSuffix(value, ":"+key)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replySummary returns what Redis replied to cmd, cut off after 64 bytes.
+func replySummary(cmd redis.Cmder) string {
+	if cmd.Err() != nil {
+		return truncateTrace(cmd.Err().Error())
+	}
+
+	var reply interface{}
+	switch cmd := cmd.(type) {
+	case *redis.Cmd:
+		reply = cmd.Val()
+	case *redis.StringCmd:
+		reply = cmd.Val()
+	case *redis.IntCmd:
+		reply = cmd.Val()
+	case *redis.BoolCmd:
+		reply = cmd.Val()
+	case *redis.StatusCmd:
+		reply = cmd.Val()
+	case *redis.SliceCmd:
+		reply = cmd.Val()
+	case *redis.StringSliceCmd:
+		reply = cmd.Val()
+	case *redis.StringStringMapCmd:
+		reply = fmt.Sprintf("%d fields", len(cmd.Val()))
+	default:
+		reply = cmd.String()
+	}
+
+	return truncateTrace(fmt.Sprint(reply))
+}
+
+func truncateTrace(value string) string {
+	if len(value) <= traceArgLength {
+		return value
+	}
+
+	return value[:traceArgLength] + "..."
+}
+
+// captureHook is the redis.Hook that CaptureKey installs.
+type captureHook struct {
+	provider *Provider
+}
+
+func (h captureHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	if !h.provider.capturing.Load() {
+		return ctx, nil
+	}
+
+	return context.WithValue(ctx, captureStartKey{}, time.Now()), nil
+}
+
+func (h captureHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(captureStartKey{}).(time.Time); ok {
+		h.provider.traceCommand(cmd, start, time.Since(start))
+	}
+
+	return nil
+}
+
+func (h captureHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.BeforeProcess(ctx, nil)
+}
+
+func (h captureHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(captureStartKey{}).(time.Time); ok {
+		latency := time.Since(start)
+		for _, cmd := range cmds {
+			h.provider.traceCommand(cmd, start, latency)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// cardinalityReconcileEvery is how many reports StartCardinalityReporter makes
+// from the estimate of WithCardinalityEstimate before it runs HLEN again.
+const cardinalityReconcileEvery = 10
+
+// cardinalityEstimate counts the ratelimits this Provider added and deleted
+// since the last time the hash was counted.
+type cardinalityEstimate struct {
+	delta atomic.Int64
+}
+
+// WithCardinalityEstimate makes StartCardinalityReporter report an estimate in
+// between its HLEN calls: the last count plus the ratelimits that this Provider
+// added with Put and deleted with Reset since. Writes by other processes and
+// expired entries aren't seen until the next HLEN, which happens every tenth
+// report and replaces the estimate, so any drift only lasts that long.
+func WithCardinalityEstimate() func(o *options) {
+	return func(o *options) {
+		o.cardinalityEstimate = true
+	}
+}
+
+// trackCardinality records that n ratelimits were added, or deleted if n is
+// negative.
+func (p *Provider) trackCardinality(n int64) {
+	if p.cardinality != nil && n != 0 {
+		p.cardinality.delta.Add(n)
+	}
+}
+
+// CardinalityReporter reports how many ratelimits its Provider stores, see
+// StartCardinalityReporter.
+type CardinalityReporter struct {
+	provider *Provider
+	interval time.Duration
+	report   func(int64)
+	done     chan struct{}
+}
+
+// StartCardinalityReporter calls report with how many ratelimits are stored
+// under the Provider's prefix every interval, from a background goroutine, until
+// ctx is done or the Provider is shut down. The count comes from HLEN, or from
+// WithCardinalityEstimate when it's enabled. Counts that fail are passed to the
+// error handler and skipped. Only one CardinalityReporter runs per Provider:
+// calling it again while one runs returns the running one, which keeps its own
+// interval and report. interval has to be positive.
+func (p *Provider) StartCardinalityReporter(ctx context.Context, interval time.Duration, report func(int64)) (*CardinalityReporter, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("StartCardinalityReporter needs a positive interval, not %v", interval)
+	}
+
+	r := &CardinalityReporter{provider: p, interval: interval, report: report, done: make(chan struct{})}
+	if !p.cardinalityReporter.CompareAndSwap(nil, r) {
+		return p.cardinalityReporter.Load(), nil
+	}
+
+	if !p.goBackground(func() { r.run(ctx) }) {
+		p.cardinalityReporter.CompareAndSwap(r, nil)
+		close(r.done)
+	}
+
+	return r, nil
+}
+
+// Done is closed once the CardinalityReporter stopped.
+func (r *CardinalityReporter) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *CardinalityReporter) run(ctx context.Context) {
+	defer close(r.done)
+	defer r.provider.cardinalityReporter.CompareAndSwap(r, nil)
+
+	p := r.provider
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	var (
+		counted int64
+		reports int
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			if p.cardinality != nil && reports%cardinalityReconcileEvery != 0 {
+				counted += p.cardinality.delta.Swap(0)
+				reports++
+				r.report(counted)
+				continue
+			}
+
+			count, err := p.countEntries(ctx, r.interval)
+			if err != nil {
+				p.reportError("cardinality", err)
+				continue
+			}
+
+			counted = count
+			reports = 1
+			r.report(counted)
+		}
+	}
+}
+
+// countEntries runs HLEN on the hash. With WithCardinalityEstimate, the deltas
+// up to now are dropped first, since HLEN already includes them.
+func (p *Provider) countEntries(ctx context.Context, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if p.cardinality != nil {
+		p.cardinality.delta.Store(0)
+	}
+
+	return p.cmd(ctx).HLen(ctx, p.hashKey()).Result()
+}
@@ -0,0 +1,99 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"go.uber.org/goleak"
+	"testing"
+	"time"
+)
+
+func TestCardinalityReporterInterval(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if r, err := p.StartCardinalityReporter(context.Background(), interval, func(int64) {}); r != nil || err == nil {
+			t.Fatalf("StartCardinalityReporter with an interval of %v = %v, %v", interval, r, err)
+		}
+	}
+}
+
+func TestCardinalityReporterRestart(t *testing.T) {
+	p, _ := newTestProvider(t)
+	putAll(t, p, "a", "b")
+	ignore := goleak.IgnoreCurrent()
+
+	counts := make(chan int64, 100)
+	report := func(n int64) { counts <- n }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first, err := p.StartCardinalityReporter(ctx, 5*time.Millisecond, report)
+	if err != nil {
+		t.Fatalf("StartCardinalityReporter: %v", err)
+	}
+
+	if n := <-counts; n != 2 {
+		t.Fatalf("reported %d ratelimits, want 2", n)
+	}
+
+	// Starting it again while it runs returns the running one.
+	if again, err := p.StartCardinalityReporter(ctx, time.Hour, func(int64) {}); again != first || err != nil {
+		t.Fatalf("StartCardinalityReporter while one runs = %p, %v, want %p", again, err, first)
+	}
+
+	cancel()
+	<-first.Done()
+	goleak.VerifyNone(t, ignore)
+
+	// Once it stopped, a new one can start, and stops with the Provider.
+	second, err := p.StartCardinalityReporter(context.Background(), 5*time.Millisecond, report)
+	if err != nil || second == first {
+		t.Fatalf("StartCardinalityReporter after the first stopped = %p, %v", second, err)
+	}
+
+	for len(counts) > 0 {
+		<-counts
+	}
+
+	if n := <-counts; n != 2 {
+		t.Fatalf("the second reporter reported %d ratelimits, want 2", n)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	<-second.Done()
+	goleak.VerifyNone(t, ignore)
+
+	// A Provider that was shut down doesn't start one at all.
+	third, err := p.StartCardinalityReporter(context.Background(), 5*time.Millisecond, report)
+	if err != nil {
+		t.Fatalf("StartCardinalityReporter after Shutdown: %v", err)
+	}
+
+	select {
+	case <-third.Done():
+	default:
+		t.Fatal("the reporter of a Provider that was shut down didn't stop")
+	}
+}
@@ -0,0 +1,154 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limitCatalog is the local copy of the hash that WithLimitCatalog reads.
+type limitCatalog struct {
+	key     string
+	tier    func(key string) string
+	refresh time.Duration
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	limits   map[string]catalogLimit
+	warned   map[string]struct{}
+}
+
+// catalogLimit is a single tier of the catalog.
+type catalogLimit struct {
+	limit  int32
+	window time.Duration
+}
+
+// WithLimitCatalog makes the adapter returned by NewConsumeAdapter look up the
+// limit and window of every new window in a catalog hash that something else
+// maintains in the same Redis. tierFn maps a key to a field of catalogKey, whose
+// value is "<limit>/<window>", like "100/1m". The catalog is read as a whole
+// and kept in memory for refresh; windows that already started keep the limit
+// they started with. Keys whose tier isn't in the catalog use the adapter's
+// own limit and window, which is logged once per tier and load. Limits above
+// math.MaxInt32 are stored as math.MaxInt32, which is logged on every load.
+func WithLimitCatalog(catalogKey string, tierFn func(key string) string, refresh time.Duration) func(o *options) {
+	return func(o *options) {
+		o.catalogKey = catalogKey
+		o.catalogTier = tierFn
+		o.catalogRefresh = refresh
+	}
+}
+
+// limitFor returns the limit and window that a new window for the given key
+// should have, falling back to the given ones.
+func (p *Provider) limitFor(key string, limit int32, window time.Duration) (int32, time.Duration) {
+	c := p.catalog
+	if c == nil {
+		return limit, window
+	}
+
+	tier := c.tier(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limits == nil || elapsed(p.now(), c.loadedAt) >= c.refresh {
+		p.loadCatalog(c)
+	}
+
+	if found, ok := c.limits[tier]; ok {
+		return found.limit, found.window
+	}
+
+	if _, ok := c.warned[tier]; !ok && p.logf != nil {
+		c.warned[tier] = struct{}{}
+		p.logf("chi-ratelimit-redis: tier %q isn't in the limit catalog %q, using the default limit", tier, c.key)
+	}
+
+	return limit, window
+}
+
+// loadCatalog reads the catalog into c, which must be locked. If that fails,
+// the previous copy is kept until the next refresh.
+func (p *Provider) loadCatalog(c *limitCatalog) {
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	c.loadedAt = p.now()
+	fields, err := p.cmd(ctx).HGetAll(ctx, c.key).Result()
+	if err != nil {
+		p.reportError("load_catalog", err)
+		if c.limits == nil {
+			c.limits, c.warned = map[string]catalogLimit{}, map[string]struct{}{}
+		}
+
+		return
+	}
+
+	c.limits = make(map[string]catalogLimit, len(fields))
+	c.warned = map[string]struct{}{}
+	for tier, value := range fields {
+		limit, saturated, err := parseCatalogLimit(value)
+		if err != nil {
+			if p.logf != nil {
+				p.logf("chi-ratelimit-redis: ignoring tier %q in the limit catalog %q: %v", tier, c.key, err)
+			}
+
+			continue
+		}
+
+		if saturated && p.logf != nil {
+			p.logf("chi-ratelimit-redis: the limit of tier %q in the limit catalog %q doesn't fit into a ratelimit, so it's stored as %d", tier, c.key, limit.limit)
+		}
+
+		c.limits[tier] = limit
+	}
+}
+
+// parseCatalogLimit parses a "<limit>/<window>" catalog value, also returning
+// whether the limit had to be saturated to fit into a ratelimit.
+func parseCatalogLimit(value string) (catalogLimit, bool, error) {
+	limit, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return catalogLimit{}, false, strconv.ErrSyntax
+	}
+
+	// Limits that don't even fit into an int64 are as good as unlimited too.
+	parsedLimit, err := strconv.ParseInt(strings.TrimSpace(limit), 10, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return catalogLimit{}, false, err
+	}
+
+	parsedWindow, err := time.ParseDuration(strings.TrimSpace(window))
+	if err != nil {
+		return catalogLimit{}, false, err
+	}
+
+	saturated := saturateInt32(parsedLimit)
+	return catalogLimit{limit: saturated, window: parsedWindow}, int64(saturated) != parsedLimit, nil
+}
@@ -0,0 +1,146 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// changeNotifications is the channel of WithChangeNotifications.
+type changeNotifications struct {
+	channel  string
+	maxValue int
+}
+
+// changeMessage is a message of WithChangeNotifications.
+type changeMessage struct {
+	// Origin is the instance ID of the Provider that made the change, which
+	// ignores its own messages.
+	Origin string `json:"origin"`
+
+	// Hash is the hash that the key was changed in, so Providers with another
+	// prefix or key window ignore it.
+	Hash string `json:"hash"`
+	Key  string `json:"key"`
+	Op   string `json:"op"`
+
+	// Value is the encoded ratelimit that the key has now. It's left out for
+	// changes that don't leave one behind, like a reset, and for ones that
+	// are too large, which only invalidate the copies of the key.
+	Value []byte `json:"value,omitempty"`
+}
+
+// WithChangeNotifications publishes a message to the given pub/sub channel
+// after every administrative change of this Provider: Put, Reset, Ban,
+// ExtendWindow, SetRemaining and SetLimitOverride. Every Provider with the
+// same channel subscribes to it and keeps its local caches in line with the
+// changes of the others, instead of serving what it had until the ttl of
+// WithLocalCache is over. A message carries the key, the operation and, if it's
+// at most maxValueBytes long, the encoded ratelimit the key has now, which is
+// stored as the local copy right away so the change doesn't make every
+// instance read Redis again. Larger values, or all of them with a
+// maxValueBytes of zero, only invalidate the copies.
+//
+// Like any pub/sub, it's best-effort: a Provider that's disconnected misses the
+// messages that were sent meanwhile, and changes that aren't administrative,
+// like Get and Consume, aren't published. Errors go to the handler of
+// WithErrorHandler. Queued writes of WithAsyncWrites are published once
+// they're flushed.
+func WithChangeNotifications(channel string, maxValueBytes int) func(o *options) {
+	return func(o *options) {
+		o.changeChannel = channel
+		o.changeMaxValue = maxValueBytes
+	}
+}
+
+// publishChange publishes a change of the given storage key, if
+// WithChangeNotifications is set. data is the encoded ratelimit it has now, or
+// empty if it has none.
+func (p *Provider) publishChange(op, key, data string, call *callOptions) {
+	if p.changes == nil {
+		return
+	}
+
+	message := changeMessage{Origin: p.instanceID, Hash: p.hashKey(), Key: key, Op: op}
+	if data != "" && len(data) <= p.changes.maxValue {
+		message.Value = []byte(data)
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		p.reportError("publish_change", err)
+		return
+	}
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+
+	if err := p.cmd(ctx).Publish(ctx, p.changes.channel, payload).Err(); err != nil {
+		p.reportError("publish_change", err)
+	}
+}
+
+// runChanges applies the messages of WithChangeNotifications until the
+// Provider shuts down.
+func (p *Provider) runChanges() {
+	pubsub := p.client.Subscribe(context.Background(), p.changes.channel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			p.applyChange(message.Payload)
+		}
+	}
+}
+
+// applyChange applies a message of another Provider to the local caches.
+func (p *Provider) applyChange(payload string) {
+	var message changeMessage
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		p.reportError("apply_change", err)
+		return
+	}
+
+	// A ban doesn't change the ratelimit, so it's only published for other
+	// subscribers of the channel.
+	if message.Origin == p.instanceID || message.Hash != p.hashKey() || message.Op == "ban" {
+		return
+	}
+
+	// The last write that was deduplicated isn't what Redis has anymore
+	// either, so it's forgotten with the copy, and the copy is only replaced
+	// if the message had the value.
+	p.forgetLocal(message.Key)
+	if len(message.Value) > 0 {
+		p.cacheChange(message.Hash, message.Key, string(message.Value), false)
+	}
+}
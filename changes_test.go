@@ -0,0 +1,172 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+// newChangeProviders returns two Providers on the same server that notify each
+// other of their changes, once both are subscribed.
+func newChangeProviders(t *testing.T, maxValueBytes int) (a, b *Provider, s *miniredis.Miniredis) {
+	t.Helper()
+
+	opts := []func(o *options){WithLocalCache(time.Minute), WithChangeNotifications("changes", maxValueBytes)}
+	a, s = newTestProvider(t, opts...)
+	b = newTestProviderOn(t, s, opts...)
+	waitFor(t, func() bool { return s.PubSubNumSub("changes")["changes"] == 2 })
+
+	return a, b, s
+}
+
+func TestChangeNotificationsValue(t *testing.T) {
+	a, b, s := newChangeProviders(t, 1024)
+	putAll(t, a, "k")
+
+	// b has its own copy, which a's changes replace without b reading Redis.
+	if rl, err := b.Peek("k"); err != nil || rl == nil || rl.Remaining != 10 {
+		t.Fatalf("Peek = %+v, %v", rl, err)
+	}
+
+	if err := a.Put("k", &types.Ratelimit{Limit: 10, Remaining: 4, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		commands := s.CommandCount()
+		rl, err := b.Peek("k")
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+
+		return rl.Remaining == 4 && s.CommandCount() == commands
+	})
+
+	for name, change := range map[string]func() (bool, error){
+		"SetRemaining":     func() (bool, error) { return a.SetRemaining("k", 7) },
+		"SetLimitOverride": func() (bool, error) { return a.SetLimitOverride("k", 12) },
+		"ExtendWindow":     func() (bool, error) { return a.ExtendWindow("k", time.Minute) },
+	} {
+		before, err := a.Peek("k")
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+
+		if ok, err := change(); err != nil || !ok {
+			t.Fatalf("%s = %v, %v", name, ok, err)
+		}
+
+		after, err := a.Peek("k")
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+
+		if *after == *before {
+			t.Fatalf("%s didn't change %+v", name, after)
+		}
+
+		waitFor(t, func() bool {
+			rl, err := b.Peek("k")
+			if err != nil {
+				t.Fatalf("Peek: %v", err)
+			}
+
+			return *rl == *after
+		})
+	}
+
+	// A Reset only leaves the copy behind on b.
+	if _, err := a.Reset("k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		rl, err := b.Peek("k")
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+
+		return rl == nil
+	})
+}
+
+func TestChangeNotificationsInvalidate(t *testing.T) {
+	a, b, s := newChangeProviders(t, 1)
+	putAll(t, a, "k")
+
+	if rl, err := b.Peek("k"); err != nil || rl == nil {
+		t.Fatalf("Peek = %+v, %v", rl, err)
+	}
+
+	// Values over maxValueBytes only invalidate b's copy, so it reads the new
+	// one from Redis.
+	if ok, err := a.SetRemaining("k", 2); err != nil || !ok {
+		t.Fatalf("SetRemaining = %v, %v", ok, err)
+	}
+
+	waitFor(t, func() bool {
+		commands := s.CommandCount()
+		rl, err := b.Peek("k")
+		if err != nil {
+			t.Fatalf("Peek: %v", err)
+		}
+
+		return rl.Remaining == 2 && s.CommandCount() > commands
+	})
+}
+
+func TestChangeNotificationsIgnoreOthers(t *testing.T) {
+	a, b, s := newChangeProviders(t, 1024)
+	other := newTestProviderOn(t, s, WithLocalCache(time.Minute), WithChangeNotifications("changes", 1024), WithKeyPrefix("other"))
+	waitFor(t, func() bool { return s.PubSubNumSub("changes")["changes"] == 3 })
+
+	putAll(t, b, "k")
+	putAll(t, other, "k")
+
+	// A ban doesn't change the ratelimit, and other has its own hash, so
+	// neither copy is touched. The Put after the ban tells when b got both.
+	if err := a.Ban("k", time.Minute); err != nil {
+		t.Fatalf("Ban: %v", err)
+	}
+
+	if err := a.Put("k", &types.Ratelimit{Limit: 10, Remaining: 4, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		rl, err := b.Peek("k")
+		return err == nil && rl.Remaining == 4
+	})
+
+	if rl, source, err := other.GetDetailed("k"); err != nil || source != ReadLocalCache || rl.Remaining != 9 {
+		t.Fatalf("GetDetailed of another prefix = %+v, %q, %v, want its own copy", rl, source, err)
+	}
+}
+
+func TestChangeNotificationsOptions(t *testing.T) {
+	if _, err := New(WithChangeNotifications("changes", -1)); err == nil {
+		t.Fatal("New accepted a negative maxValueBytes")
+	}
+}
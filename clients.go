@@ -0,0 +1,262 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// constructionWarnCount is how many Providers can be created within
+	// constructionWarnWindow before New logs a warning.
+	constructionWarnCount = 20
+
+	constructionWarnWindow = time.Minute
+)
+
+// sharedClient is a client created by WithConfig or WithURL, which is reused by
+// every Provider that was constructed with the same connection options.
+type sharedClient struct {
+	key     string
+	options redis.Options
+	client  *redis.Client
+	err     error
+	refs    int
+
+	// connected is closed once client is connected, or err is set.
+	connected chan struct{}
+
+	// onRelease is called after the client was closed, if it's set.
+	onRelease func()
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string][]*sharedClient{}
+
+	constructionsMu    sync.Mutex
+	constructions      int
+	constructionsSince time.Time
+)
+
+// WithConfig creates and connects a new Redis client and appends it
+// to the Provider. Providers constructed with the same connection options
+// share a single client (and so a single connection pool), unless
+// WithNoClientReuse is used. Options only count as the same if every field is
+// equal, the TLSConfig is the same pointer and none of the function fields like
+// Dialer or OnConnect are set, since those can't be compared. The client is
+// connected when New is called and closed once every Provider using it is
+// closed. The given options aren't changed.
+//
+// The returned error is always nil; it's kept for compatibility.
+func WithConfig(config *redis.Options) (func(o *options), error) {
+	return func(o *options) {
+		o.clientConfig = config
+	}, nil
+}
+
+// WithURL is WithConfig with options parsed from a redis:// or rediss:// URL.
+func WithURL(url string) (func(o *options), error) {
+	config, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return WithConfig(config)
+}
+
+// WithNoClientReuse makes WithConfig and WithURL always create a new client for
+// this Provider instead of reusing one with the same connection options.
+func WithNoClientReuse() func(o *options) {
+	return func(o *options) {
+		o.noClientReuse = true
+	}
+}
+
+// WithClientHooks adds the given hooks, in order, to the clients that the
+// Provider creates itself with WithConfig, WithURL or WithMaintenancePoolSize,
+// so instrumentation like tracing also sees their commands. A client given
+// with WithClient or WithMaintenanceClient is left as it is. The hooks are also
+// added to the single connections that the Provider takes from its client,
+// like for WithWriteConcern, which don't run the hooks of the client; with
+// WithClient, pass its hooks here too to see those commands. Since hooks would
+// apply to every Provider sharing a client, a Provider with hooks always gets
+// its own client, as if WithNoClientReuse was used.
+func WithClientHooks(hooks ...redis.Hook) func(o *options) {
+	return func(o *options) {
+		o.clientHooks = append(o.clientHooks, hooks...)
+	}
+}
+
+// clientKey returns which clients might be reused for the given options; only
+// those with the same options are.
+func clientKey(config *redis.Options) string {
+	return fmt.Sprintf("%s|%s|%d", config.Network, config.Addr, config.DB)
+}
+
+// sameOptions returns true if a client created with a can be used for b.
+func sameOptions(a, b redis.Options) bool {
+	if a.TLSConfig != b.TLSConfig {
+		return false
+	}
+
+	// reflect.DeepEqual only considers nil functions equal.
+	a.TLSConfig, b.TLSConfig = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// acquireClient returns a connected client for the given options, reusing an
+// existing one when reuse is true. The hooks are only added to a new client.
+// Clients are connected without holding clientsMu; a Provider that reuses one
+// that's still connecting waits for it.
+func acquireClient(config *redis.Options, reuse bool, hooks []redis.Hook) (*sharedClient, error) {
+	options := *config
+	if !reuse {
+		client, err := connect(&options, hooks)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sharedClient{client: client, refs: 1}, nil
+	}
+
+	key := clientKey(config)
+	clientsMu.Lock()
+	for _, shared := range clients[key] {
+		if sameOptions(shared.options, options) {
+			shared.refs++
+			clientsMu.Unlock()
+
+			<-shared.connected
+			if shared.err != nil {
+				return nil, shared.err
+			}
+
+			return shared, nil
+		}
+	}
+
+	shared := &sharedClient{key: key, options: options, refs: 1, connected: make(chan struct{})}
+	clients[key] = append(clients[key], shared)
+	clientsMu.Unlock()
+
+	copied := options
+	shared.client, shared.err = connect(&copied, hooks)
+	if shared.err != nil {
+		clientsMu.Lock()
+		removeClient(shared)
+		clientsMu.Unlock()
+	}
+
+	close(shared.connected)
+	if shared.err != nil {
+		return nil, shared.err
+	}
+
+	return shared, nil
+}
+
+// removeClient removes the client from clients. clientsMu must be held.
+func removeClient(shared *sharedClient) {
+	list := clients[shared.key]
+	for i, candidate := range list {
+		if candidate == shared {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+
+	if len(list) == 0 {
+		delete(clients, shared.key)
+		return
+	}
+
+	clients[shared.key] = list
+}
+
+// release drops one reference to the client, closing it once nothing uses it.
+func (s *sharedClient) release() error {
+	clientsMu.Lock()
+	s.refs--
+	last := s.refs == 0
+	if last && s.key != "" {
+		removeClient(s)
+	}
+
+	clientsMu.Unlock()
+
+	if !last {
+		return nil
+	}
+
+	err := s.client.Close()
+	if s.onRelease != nil {
+		s.onRelease()
+	}
+
+	return err
+}
+
+func connect(config *redis.Options, hooks []redis.Hook) (*redis.Client, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+	defer cancel()
+
+	client := redis.NewClient(config)
+	for _, hook := range hooks {
+		client.AddHook(hook)
+	}
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// trackConstruction returns true if an unusual amount of Providers were created
+// recently, which usually means one is being created per request.
+func trackConstruction(now time.Time) bool {
+	constructionsMu.Lock()
+	defer constructionsMu.Unlock()
+
+	if now.Sub(constructionsSince) > constructionWarnWindow {
+		constructions = 0
+		constructionsSince = now
+	}
+
+	constructions++
+	return constructions == constructionWarnCount
+}
+
+// Close is Shutdown with a deadline of five seconds.
+func (p *Provider) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+
+	return p.Shutdown(ctx)
+}
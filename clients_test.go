@@ -0,0 +1,117 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newConfigProvider(t *testing.T, config *redis.Options) *Provider {
+	t.Helper()
+
+	option, _ := WithConfig(config)
+	p, err := New(option)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestClientReuse(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	a := newConfigProvider(t, &redis.Options{Addr: server.Addr()})
+	b := newConfigProvider(t, &redis.Options{Addr: server.Addr()})
+	if a.client != b.client {
+		t.Fatal("providers with the same options don't share a client")
+	}
+
+	pooled := newConfigProvider(t, &redis.Options{Addr: server.Addr(), PoolSize: 3})
+	if pooled.client == a.client {
+		t.Fatal("providers with different pool sizes share a client")
+	}
+
+	timeout := newConfigProvider(t, &redis.Options{Addr: server.Addr(), ReadTimeout: time.Second})
+	if timeout.client == a.client {
+		t.Fatal("providers with different timeouts share a client")
+	}
+}
+
+func TestClientReuseConcurrent(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	providers := make([]*Provider, 8)
+	var wg sync.WaitGroup
+	for i := range providers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			option, _ := WithConfig(&redis.Options{Addr: server.Addr()})
+			providers[i], _ = New(option)
+		}(i)
+	}
+
+	wg.Wait()
+	for _, p := range providers {
+		if p == nil {
+			t.Fatal("New failed")
+		}
+
+		defer p.Close()
+		if p.client != providers[0].client {
+			t.Fatal("providers created at the same time don't share a client")
+		}
+	}
+}
+
+func TestClientReuseFailedConnect(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		option, _ := WithConfig(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+		if _, err := New(option); err == nil {
+			t.Fatal("New connected to a closed port")
+		}
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if len(clients["|127.0.0.1:1|0"]) != 0 {
+		t.Fatal("a client that failed to connect is still shared")
+	}
+}
+
+func TestWithConfigKeepsOptions(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	config := &redis.Options{Addr: server.Addr()}
+	newConfigProvider(t, config)
+	if config.PoolSize != 0 {
+		t.Fatalf("PoolSize = %d, the options were changed", config.PoolSize)
+	}
+}
@@ -0,0 +1,74 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"sync"
+	"time"
+)
+
+// skewClock is the clock of WithClockSkewTolerance.
+type skewClock struct {
+	now       func() time.Time
+	tolerance time.Duration
+
+	mu     sync.Mutex
+	latest time.Time
+}
+
+// WithClockSkewTolerance keeps the Provider's clock from going backwards by up
+// to d, like when NTP steps the wall clock back a few hundred milliseconds:
+// until the clock has caught up again, the Provider keeps using the latest
+// time it has seen, so windows aren't started early or late and no duration
+// comes out negative. Larger steps are taken as they are. Times from the clock
+// are compared by their wall clock reading, since that's what reset times are
+// stored as. Scripts that take the time from Redis aren't affected.
+func WithClockSkewTolerance(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.clockSkewTolerance = d
+	}
+}
+
+func (c *skewClock) read() time.Time {
+	// Monotonic readings never go backwards, so they'd hide the step.
+	now := c.now().Round(0)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if back := c.latest.Sub(now); back > 0 && back <= c.tolerance {
+		return c.latest
+	}
+
+	c.latest = now
+	return now
+}
+
+// elapsed returns how long ago since was at now, or zero if since is later,
+// which it can be if the wall clock was stepped back in between.
+func elapsed(now, since time.Time) time.Duration {
+	if d := now.Sub(since); d > 0 {
+		return d
+	}
+
+	return 0
+}
@@ -0,0 +1,89 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// storedRatelimit is the JSON that ratelimits are stored as without
+// WithWireFormat. It mirrors types.Ratelimit, but is defined here so the
+// stored shape doesn't change with the core library's struct tags, and no
+// field is ever left out for being zero. A remaining count of 0 has to come
+// back as 0, not as a missing field that something fills in from the limit.
+type storedRatelimit struct {
+	ResetTime time.Time `json:"reset_time"`
+	Remaining int32     `json:"remaining"`
+	Global    bool      `json:"global"`
+	Limit     int32     `json:"limit"`
+}
+
+// decodedRatelimit is storedRatelimit with every field's presence tracked.
+type decodedRatelimit struct {
+	ResetTime *time.Time `json:"reset_time"`
+	Remaining *int32     `json:"remaining"`
+	Global    *bool      `json:"global"`
+	Limit     *int32     `json:"limit"`
+}
+
+func encodeJSON(rl *types.Ratelimit) ([]byte, error) {
+	if rl == nil {
+		return nil, ErrEmptyValue
+	}
+
+	return json.Marshal(storedRatelimit{ResetTime: rl.ResetTime, Remaining: rl.Remaining, Global: rl.Global, Limit: rl.Limit})
+}
+
+// decodeJSON decodes what encodeJSON wrote. Every field has to be there, so a
+// value that's missing one fails with ErrMalformedValue instead of decoding as
+// if it were zero.
+func decodeJSON(data []byte) (*types.Ratelimit, error) {
+	var decoded *decodedRatelimit
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if decoded == nil {
+		return nil, ErrEmptyValue
+	}
+
+	missing := ""
+	switch {
+	case decoded.ResetTime == nil:
+		missing = "reset_time"
+	case decoded.Remaining == nil:
+		missing = "remaining"
+	case decoded.Global == nil:
+		missing = "global"
+	case decoded.Limit == nil:
+		missing = "limit"
+	}
+
+	if missing != "" {
+		return nil, fmt.Errorf("%w: no %q field", ErrMalformedValue, missing)
+	}
+
+	return &types.Ratelimit{ResetTime: *decoded.ResetTime, Remaining: *decoded.Remaining, Global: *decoded.Global, Limit: *decoded.Limit}, nil
+}
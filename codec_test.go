@@ -0,0 +1,62 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	rl := &types.Ratelimit{Limit: 10, Remaining: 0, ResetTime: time.Date(2022, 10, 5, 20, 0, 0, 0, time.UTC)}
+	data, err := encodeJSON(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is left out for being zero.
+	want := `{"reset_time":"2022-10-05T20:00:00Z","remaining":0,"global":false,"limit":10}`
+	if string(data) != want {
+		t.Fatalf("encodeJSON = %s, want %s", data, want)
+	}
+
+	if _, err := encodeJSON(nil); !errors.Is(err, ErrEmptyValue) {
+		t.Fatalf("encodeJSON(nil) = %v", err)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	for data, want := range map[string]error{
+		`null`: ErrEmptyValue,
+		`{"remaining":0,"global":false,"limit":10}`:                                     ErrMalformedValue,
+		`{"reset_time":"2022-10-05T20:00:00Z","global":false,"limit":10}`:               ErrMalformedValue,
+		`{"reset_time":"2022-10-05T20:00:00Z","remaining":0,"limit":10}`:                ErrMalformedValue,
+		`{"reset_time":"2022-10-05T20:00:00Z","remaining":0,"global":false}`:            ErrMalformedValue,
+		`{"reset_time":"2022-10-05T20:00:00Z","remaining":0,"global":false,"limit":10}`: nil,
+	} {
+		if _, err := decodeJSON([]byte(data)); !errors.Is(err, want) {
+			t.Errorf("decodeJSON(%s) = %v, want %v", data, err, want)
+		}
+	}
+}
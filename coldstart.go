@@ -0,0 +1,84 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"math"
+	"time"
+)
+
+// WithColdStartRamp shrinks the limit of new windows that the adapter returned
+// by NewConsumeAdapter starts for a while after Redis lost its state, so every
+// client doesn't get a full budget at the same moment. The limit starts at
+// floorFraction of itself when the marker key (see WithStateLossDetection) is
+// written and grows linearly to the full limit over duration. The time is
+// measured from when the server created the marker, by the server's clock, so
+// every instance ramps up the same way.
+//
+// The marker is written when the Provider is created, so a ramp also happens
+// the first time a Provider runs against an empty Redis. Without
+// WithStateLossDetection, a state loss is only noticed by Providers that are
+// created after it.
+func WithColdStartRamp(duration time.Duration, floorFraction float64) func(o *options) {
+	return func(o *options) {
+		o.coldStartRamp = duration
+		o.coldStartFloor = floorFraction
+	}
+}
+
+// observeMarker remembers the server time that the marker was created at, and
+// how far the server's clock is from the Provider's.
+func (p *Provider) observeMarker(createdAt, serverNow int64) {
+	if p.coldStartRamp <= 0 {
+		return
+	}
+
+	p.rampStart.Store(createdAt)
+	p.clockOffset.Store(serverNow - p.now().UnixMilli())
+}
+
+// rampedLimit returns the limit that a window starting now gets during the
+// cold start ramp, which is never less than one.
+func (p *Provider) rampedLimit(limit int32) int32 {
+	start := p.rampStart.Load()
+	if p.coldStartRamp <= 0 || start == 0 {
+		return limit
+	}
+
+	elapsed := p.now().UnixMilli() + p.clockOffset.Load() - start
+	progress := float64(elapsed) / float64(p.coldStartRamp.Milliseconds())
+	if progress >= 1 {
+		return limit
+	}
+
+	if progress < 0 {
+		progress = 0
+	}
+
+	fraction := p.coldStartFloor + (1-p.coldStartFloor)*progress
+	ramped := int32(math.Floor(float64(limit) * fraction))
+	if ramped < 1 {
+		return 1
+	}
+
+	return ramped
+}
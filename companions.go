@@ -0,0 +1,363 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strings"
+)
+
+// orphanBatchSize is how many companion entries FindOrphans checks at once.
+const orphanBatchSize = 500
+
+// companionType is how a companion is stored.
+type companionType int
+
+const (
+	// companionField is a field of a hash that has one for every ratelimit.
+	companionField companionType = iota
+
+	// companionMember is a member of a set that has one for every ratelimit.
+	companionMember
+
+	// companionScored is a member of a sorted set that has one for every
+	// ratelimit.
+	companionScored
+
+	// companionKeyed is a key of its own for every ratelimit, which is the
+	// storage key behind a prefix.
+	companionKeyed
+)
+
+// companion is state that an enabled feature keeps for every ratelimit outside
+// of its field in the hash. Every way a ratelimit is deleted deletes its
+// companions too, and FindOrphans looks for ones whose ratelimit is gone.
+type companion struct {
+	// name is what an OrphanReport lists the companion under.
+	name string
+
+	typ companionType
+
+	// key returns the hash or set that the companion is part of, or the
+	// prefix of the keys of a companionKeyed one.
+	key func() string
+
+	// detached is set for companions that can exist without a ratelimit,
+	// like the abuse scores of keys counted by ConsumeSliding, which
+	// FindOrphans leaves alone.
+	detached bool
+}
+
+// registerCompanions returns the companions of the enabled features.
+func (p *Provider) registerCompanions() []companion {
+	// PutWithMeta can always be used, so there is always metadata.
+	companions := []companion{{name: "meta", typ: companionField, key: p.metaKey}}
+	if p.resetIndex {
+		companions = append(companions, companion{name: "reset_index", typ: companionScored, key: p.indexKey})
+	}
+
+	if p.windowCreatedAt {
+		companions = append(companions, companion{name: "created", typ: companionField, key: p.createdKey})
+	}
+
+	if p.persistentEntries {
+		companions = append(companions, companion{name: "persistent", typ: companionMember, key: p.persistentKey})
+	}
+
+	if p.abuseHalfLife > 0 {
+		companions = append(companions, companion{name: "abuse", typ: companionKeyed, key: p.companionPrefix("abuse"), detached: true})
+	}
+
+	// Violations outlive the window they were counted in.
+	if p.penaltyBackoff != nil {
+		companions = append(companions, companion{name: "penalty", typ: companionKeyed, key: p.companionPrefix("penalty"), detached: true})
+	}
+
+	if p.graceRequests > 0 {
+		companions = append(companions, companion{name: "grace", typ: companionKeyed, key: p.companionPrefix("grace"), detached: true})
+	}
+
+	if p.rateHalfLife > 0 {
+		companions = append(companions, companion{name: "rate", typ: companionKeyed, key: p.companionPrefix("rate")})
+	}
+
+	// Buckets are kept apart from the hash, like the state of ConsumeSliding.
+	if p.burstBucket != nil {
+		companions = append(companions, companion{name: "bucket", typ: companionKeyed, key: p.companionPrefix("bucket"), detached: true})
+	}
+
+	// Like the abuse scores, windows of ConsumeSliding are notified about
+	// without a ratelimit in the hash.
+	if p.threshold != nil {
+		companions = append(companions, companion{name: "notified", typ: companionKeyed, key: p.companionPrefix("notified"), detached: true})
+	}
+
+	if p.mirrorPrefix != "" {
+		companions = append(companions, companion{name: "mirror", typ: companionKeyed, key: func() string { return p.mirrorPrefix }})
+	}
+
+	// Ratelimits on the per-key layout of WithLayoutRollout aren't in the
+	// hash at all.
+	if p.layout != nil {
+		companions = append(companions, companion{name: "entry", typ: companionKeyed, key: p.companionPrefix("entry"), detached: true})
+	}
+
+	return companions
+}
+
+// companionPrefix returns the prefix of the companionKey of the given kind.
+func (p *Provider) companionPrefix(kind string) func() string {
+	return func() string {
+		return p.companionKey(kind, "")
+	}
+}
+
+// forgetCompanions queues the deletion of the companions of the given storage
+// keys.
+func (p *Provider) forgetCompanions(ctx context.Context, pipe redis.Pipeliner, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+
+	for _, c := range p.companions {
+		switch c.typ {
+		case companionField:
+			pipe.HDel(ctx, c.key(), keys...)
+		case companionMember:
+			pipe.SRem(ctx, c.key(), members...)
+		case companionScored:
+			pipe.ZRem(ctx, c.key(), members...)
+		case companionKeyed:
+			// DEL rather than UNLINK, since these are small and this has to
+			// work where ResetAll falls back to HDEL.
+			companionKeys := make([]string, len(keys))
+			for i, key := range keys {
+				companionKeys[i] = c.key() + key
+			}
+
+			pipe.Del(ctx, companionKeys...)
+		}
+	}
+}
+
+// deleteCompanions deletes the companions of the given storage keys in one
+// step.
+func (p *Provider) deleteCompanions(ctx context.Context, keys ...string) error {
+	_, err := p.cmd(ctx).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		p.forgetCompanions(ctx, pipe, keys...)
+		return nil
+	})
+
+	return err
+}
+
+// companionKeyPattern returns the SCAN pattern that matches every key of a
+// companionKeyed companion, and the prefix that comes before the storage key.
+func (p *Provider) companionKeyPattern(c companion) (pattern, prefix string) {
+	prefix = c.key()
+	return EscapeGlob(prefix) + "*", prefix
+}
+
+// unlinkCompanionKeys unlinks every key of the companionKeyed companions, for
+// ResetAll.
+func (p *Provider) unlinkCompanionKeys(ctx context.Context) error {
+	for _, c := range p.companions {
+		if c.typ != companionKeyed {
+			continue
+		}
+
+		pattern, _ := p.companionKeyPattern(c)
+		iter := p.cmd(ctx).Scan(ctx, 0, pattern, orphanBatchSize).Iterator()
+
+		var batch []string
+		for iter.Next(ctx) {
+			if batch = append(batch, iter.Val()); len(batch) == orphanBatchSize {
+				if err := p.cmd(ctx).Unlink(ctx, batch...).Err(); err != nil {
+					return err
+				}
+
+				batch = batch[:0]
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		if len(batch) > 0 {
+			if err := p.cmd(ctx).Unlink(ctx, batch...).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// OrphanReport is the result of AdminClient.FindOrphans.
+type OrphanReport struct {
+	// Checked is how many companion entries were checked.
+	Checked int
+
+	// Orphans lists the keys whose ratelimit is gone by the name of the
+	// companion that is left, like "meta", "reset_index", "created",
+	// "persistent" or "rate". Keys shortened by WithMaxKeyLength are listed
+	// as they're stored.
+	Orphans map[string][]string
+}
+
+// Len returns how many orphans were found.
+func (r *OrphanReport) Len() int {
+	total := 0
+	for _, keys := range r.Orphans {
+		total += len(keys)
+	}
+
+	return total
+}
+
+// FindOrphans goes through the state that enabled features keep next to every
+// ratelimit, like metadata or the reset index, and reports the entries whose
+// ratelimit no longer exists, such as the ones a ratelimit leaves behind when
+// its field TTL runs out. It never deletes anything. Like ResetAll when it
+// can't unlink the hash, it scans everything, so it's a maintenance operation;
+// a ratelimit written while it runs can show up as an orphan. Windowed keys
+// aren't supported.
+func (a *AdminClient) FindOrphans(ctx context.Context) (report *OrphanReport, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "find_orphans", "")
+	ctx = p.maintenanceContext(ctx)
+
+	if p.keyWindow > 0 {
+		return nil, errors.New("FindOrphans doesn't support WithWindowedKeys")
+	}
+
+	report = &OrphanReport{Orphans: map[string][]string{}}
+	for _, c := range p.companions {
+		if c.detached {
+			continue
+		}
+
+		err := p.companionEntries(ctx, c, func(keys []string) error {
+			orphans, err := p.missingEntries(ctx, keys)
+			if err != nil {
+				return err
+			}
+
+			report.Checked += len(keys)
+			if len(orphans) > 0 {
+				report.Orphans[c.name] = append(report.Orphans[c.name], orphans...)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// companionEntries calls fn with the storage keys that have the given
+// companion, in batches.
+func (p *Provider) companionEntries(ctx context.Context, c companion, fn func(keys []string) error) error {
+	var (
+		iter   *redis.ScanIterator
+		pairs  bool
+		prefix string
+	)
+
+	switch c.typ {
+	case companionField:
+		iter, pairs = p.cmd(ctx).HScan(ctx, c.key(), 0, "", orphanBatchSize).Iterator(), true
+	case companionMember:
+		iter = p.cmd(ctx).SScan(ctx, c.key(), 0, "", orphanBatchSize).Iterator()
+	case companionScored:
+		iter, pairs = p.cmd(ctx).ZScan(ctx, c.key(), 0, "", orphanBatchSize).Iterator(), true
+	case companionKeyed:
+		var pattern string
+		pattern, prefix = p.companionKeyPattern(c)
+		iter = p.cmd(ctx).Scan(ctx, 0, pattern, orphanBatchSize).Iterator()
+	}
+
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, strings.TrimPrefix(iter.Val(), prefix))
+
+		// HSCAN and ZSCAN return each field with its value or score.
+		if pairs && !iter.Next(ctx) {
+			break
+		}
+
+		if len(batch) == orphanBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+
+			batch = nil
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+
+	return nil
+}
+
+// missingEntries returns the storage keys that have no ratelimit in the hash.
+func (p *Provider) missingEntries(ctx context.Context, keys []string) ([]string, error) {
+	cmds := make([]*redis.BoolCmd, len(keys))
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.HExists(ctx, p.hashKey(), key)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for i, cmd := range cmds {
+		if !cmd.Val() {
+			missing = append(missing, keys[i])
+		}
+	}
+
+	return missing, nil
+}
@@ -0,0 +1,137 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/snappy"
+	"io"
+)
+
+// CompressionCodec compresses values for WithCompression. Compressed values are
+// stored with the codec's ID as their first byte, so it has to be unique and
+// can't be a byte that an uncompressed value starts with. IDs 1 and 2 are taken
+// by GzipCompression and SnappyCompression; custom codecs should use 3 to 8.
+type CompressionCodec interface {
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompression compresses values with gzip, which is slower than snappy but
+// compresses better.
+var GzipCompression CompressionCodec = gzipCodec{}
+
+// SnappyCompression compresses values with snappy.
+var SnappyCompression CompressionCodec = snappyCodec{}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte {
+	return 1
+}
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) ID() byte {
+	return 2
+}
+
+func (snappyCodec) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCodec) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
+
+// WithCompression compresses every stored value (ratelimits and metadata) that
+// is larger than minSize bytes with the given codec. Smaller values are stored
+// as they are, and so are values that don't get any smaller. Values written
+// before compression was enabled, or with another built-in codec, can still be
+// read. WithMaxValueSize applies to the compressed size.
+func WithCompression(codec CompressionCodec, minSize int) func(o *options) {
+	return func(o *options) {
+		o.compression = codec
+		o.compressionMinSize = minSize
+	}
+}
+
+// compress compresses data if compression is enabled and it's large enough.
+func (p *Provider) compress(data []byte) ([]byte, error) {
+	if p.compression == nil || len(data) <= p.compressionMinSize {
+		return data, nil
+	}
+
+	compressed, err := p.compression.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(compressed)+1 >= len(data) {
+		return data, nil
+	}
+
+	return append([]byte{p.compression.ID()}, compressed...), nil
+}
+
+// decompress decompresses data if it starts with the ID of a codec, and returns
+// it as-is otherwise.
+func (p *Provider) decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] > 8 {
+		return data, nil
+	}
+
+	for _, codec := range []CompressionCodec{p.compression, GzipCompression, SnappyCompression} {
+		if codec != nil && codec.ID() == data[0] {
+			return codec.Decompress(data[1:])
+		}
+	}
+
+	return nil, fmt.Errorf("value is compressed with unknown codec %d", data[0])
+}
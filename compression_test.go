@@ -0,0 +1,88 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	gzip, _ := newTestProvider(t, WithCompression(GzipCompression, 0))
+	snappy, _ := newTestProvider(t, WithCompression(SnappyCompression, 0))
+	plain, _ := newTestProvider(t)
+
+	data := bytes.Repeat([]byte(`{"limit":10}`), 32)
+	compressed, err := gzip.compress(data)
+	if err != nil || compressed[0] != GzipCompression.ID() || len(compressed) >= len(data) {
+		t.Fatalf("compress = %d bytes, %v", len(compressed), err)
+	}
+
+	// Values compressed with another built-in codec or not at all can still
+	// be read, with or without compression.
+	for _, p := range []*Provider{gzip, snappy, plain} {
+		for _, stored := range [][]byte{compressed, data} {
+			if decompressed, err := p.decompress(stored); err != nil || !bytes.Equal(decompressed, data) {
+				t.Fatalf("decompress = %q, %v", decompressed, err)
+			}
+		}
+	}
+
+	// Values that are small enough or don't get smaller are stored as they
+	// are.
+	small, _ := newTestProvider(t, WithCompression(SnappyCompression, len(data)))
+	if stored, err := small.compress(data); err != nil || !bytes.Equal(stored, data) {
+		t.Fatalf("compress below minSize = %q, %v", stored, err)
+	}
+
+	if stored, err := snappy.compress([]byte(`{}`)); err != nil || string(stored) != `{}` {
+		t.Fatalf("compress of an incompressible value = %q, %v", stored, err)
+	}
+
+	if _, err := plain.decompress([]byte{7, 'x'}); err == nil {
+		t.Fatal("decompress accepted an unknown codec")
+	}
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	// Ratelimits are too small for gzip to make smaller, so one is stored
+	// compressed by hand like a larger value would be.
+	p, server := newTestProvider(t, WithCompression(SnappyCompression, 0))
+	rl := &types.Ratelimit{Limit: 100, Remaining: 42, ResetTime: time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)}
+	data, err := encodeJSON(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, err := GzipCompression.Compress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.HSet(p.hashKey(), "k", string(append([]byte{GzipCompression.ID()}, compressed...)))
+	stored, err := p.Peek("k")
+	if err != nil || stored.Limit != rl.Limit || stored.Remaining != rl.Remaining || !stored.ResetTime.Equal(rl.ResetTime) {
+		t.Fatalf("Peek = %+v, %v", stored, err)
+	}
+}
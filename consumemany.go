@@ -0,0 +1,160 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// ConsumeRequest is a single request for ConsumeManyKeys.
+type ConsumeRequest struct {
+	Key    string
+	Limit  int64
+	Window time.Duration
+}
+
+// ConsumeManyKeys is ConsumeSliding for many keys at once, sent to Redis in a
+// single pipeline. Every key is still counted atomically on its own, but the
+// batch as a whole isn't. Decisions are returned in the same order as reqs; if
+// only some of them failed, their Decision is left empty and the error is a
+// *BatchError with one entry for each of them, while the keys that succeeded
+// stay counted. Rejected keys whose abuse score couldn't be updated keep their
+// Decision, but are in the BatchError as well. With RequireComplete, no
+// Decisions are returned if any key failed.
+func (p *Provider) ConsumeManyKeys(reqs []ConsumeRequest, opts ...CallOption) (decisions []Decision, err error) {
+	defer p.recoverPanic(&err, "consume_many_keys", "")
+
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+
+	if p.noScripting {
+		return nil, scriptingDisabled(slidingConsumeScript).Err()
+	}
+
+	now := p.now()
+	call := newCallOptions(opts)
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	pending := make([]int, len(reqs))
+	for i := range reqs {
+		pending[i] = i
+	}
+
+	cmds := make([]*redis.Cmd, len(reqs))
+	if err := p.pipelineSliding(ctx, reqs, pending, cmds, now); err != nil {
+		return nil, err
+	}
+
+	// Scripts that Redis didn't know yet weren't run at all, so they can be
+	// loaded and sent again.
+	var missing []int
+	for _, i := range pending {
+		if noScript(cmds[i]) {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(missing) > 0 {
+		err := p.loadScript(ctx, slidingConsumeScript)
+		switch {
+		case errors.Is(err, ErrScriptNotLoaded):
+			for _, i := range missing {
+				cmds[i].SetErr(err)
+			}
+
+		case err != nil:
+			return nil, err
+
+		default:
+			if err := p.pipelineSliding(ctx, reqs, missing, cmds, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	decisions = make([]Decision, len(reqs))
+	var (
+		failed    = BatchError{verbose: p.verboseErrors}
+		uncounted int
+	)
+
+	for i, req := range reqs {
+		result, err := cmds[i].Int64Slice()
+		if err != nil {
+			failed.add(i, req.Key, err)
+			uncounted++
+			continue
+		}
+
+		decisions[i] = newSlidingDecision(result[0] == 1, result[1], result[2], req.Limit, req.Window, now)
+		p.logDecision(p.storageKey(req.Key), slidingConsumeScript.Hash(), req.Window, decisions[i], now)
+		if !decisions[i].Allowed {
+			if err := p.recordRejection(ctx, p.storageKey(req.Key)); err != nil {
+				failed.add(i, req.Key, err)
+			}
+		}
+	}
+
+	if uncounted < len(reqs) {
+		p.reportPartial("consume_many_keys", call, failed.Len(), len(reqs))
+	}
+
+	if uncounted == len(reqs) || (call.completeRequired() && failed.Len() > 0) {
+		return nil, failed.err()
+	}
+
+	return decisions, failed.err()
+}
+
+// pipelineSliding sends slidingConsumeScript with queueScript for the requests at
+// the given indexes, storing their commands in cmds. Only errors that aren't
+// tied to a single command, and that none of the commands got through, are
+// returned.
+func (p *Provider) pipelineSliding(ctx context.Context, reqs []ConsumeRequest, indexes []int, cmds []*redis.Cmd, now time.Time) error {
+	pipe := p.cmd(ctx).Pipeline()
+	for _, i := range indexes {
+		req := reqs[i]
+		keys := []string{p.slidingKey(p.storageKey(req.Key))}
+		cmds[i] = p.queueScript(ctx, pipe, slidingConsumeScript, keys, scriptLimit(req.Limit), req.Window.Milliseconds(), now.UnixMilli())
+	}
+
+	var redisErr redis.Error
+	if _, err := pipe.Exec(ctx); err != nil && !errors.As(err, &redisErr) {
+		// Every command keeps its own error, so the ones that got through are
+		// still used.
+		for _, i := range indexes {
+			if cmds[i].Err() == nil {
+				return nil
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// createdScript remembers when the window of a ratelimit started: the first
+// time it's written with a reset time it didn't have before.
+//
+// KEYS[1] = created hash
+// ARGV[1] = field, ARGV[2] = reset time in Unix milliseconds, ARGV[3] = now in
+// Unix milliseconds
+var createdScript = registerScript("created", `
+local stored = redis.call('HGET', KEYS[1], ARGV[1])
+if stored and string.match(stored, '^[^:]*') == ARGV[2] then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2] .. ':' .. ARGV[3])
+return 1
+`)
+
+// resetIfOlderScript deletes a ratelimit only if its window started before the
+// given time. Ratelimits without a start time were written before it was kept
+// track of, so they count as older.
+//
+// KEYS[1] = hash, KEYS[2] = created hash, KEYS[3] = metadata hash,
+// KEYS[4] = reset index (optional)
+// ARGV[1] = field, ARGV[2] = time in Unix milliseconds
+var resetIfOlderScript = registerScript("reset_if_older", `
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+
+local stored = redis.call('HGET', KEYS[2], ARGV[1])
+if stored then
+	local created = tonumber(string.match(stored, ':(%d+)$'))
+	if created and created >= tonumber(ARGV[2]) then
+		return 0
+	end
+end
+
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+if KEYS[4] then
+	redis.call('ZREM', KEYS[4], ARGV[1])
+end
+
+return 1
+`)
+
+// WithWindowCreatedAt keeps when the window of every ratelimit started in a
+// hash next to the ratelimits ("{<prefix>}:created"), which ResetIfOlderThan
+// needs. A window starts the first time a ratelimit is written with a new reset
+// time. This costs another round trip per write.
+func WithWindowCreatedAt() func(o *options) {
+	return func(o *options) {
+		o.windowCreatedAt = true
+	}
+}
+
+func (p *Provider) createdKey() string {
+	return p.space.Load().created
+}
+
+// trackCreated records the start of a new window for the given storage key, if
+// WithWindowCreatedAt is enabled.
+func (p *Provider) trackCreated(ctx context.Context, key string, resetAt time.Time) error {
+	if !p.windowCreatedAt {
+		return nil
+	}
+
+	keys := []string{p.createdKey()}
+	return p.runScript(ctx, createdScript, keys, key, indexScore(resetAt), p.now().UnixMilli()).Err()
+}
+
+// ResetIfOlderThan is Reset, but only if the key's window started before t, so
+// a reset that races with a request starting a new window can't delete that
+// window. It returns false if nothing was deleted, either because there was no
+// ratelimit or because it's newer. It needs WithWindowCreatedAt, and unlike
+// Reset it never leaves a tombstone behind.
+func (p *Provider) ResetIfOlderThan(key string, t time.Time) (ok bool, err error) {
+	defer p.recoverPanic(&err, "reset_if_older_than", key)
+
+	if !p.windowCreatedAt {
+		return false, ErrCreatedAtDisabled
+	}
+
+	key = p.storageKey(key)
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	defer p.forgetLocal(key)
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	keys := p.entryKeys(p.hashKey(), p.createdKey())
+	deleted, err := p.runScript(ctx, resetIfOlderScript, keys, key, t.UnixMilli()).Int()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+
+	if err := p.deleteCompanions(ctx, key); err != nil {
+		return true, err
+	}
+
+	p.trackCardinality(-1)
+	p.recordChange(p.hashKey(), key, "", true)
+	if err := p.releaseTenantKeys(ctx, key); err != nil {
+		return true, err
+	}
+
+	return true, replication.wait(ctx)
+}
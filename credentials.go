@@ -0,0 +1,164 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+)
+
+// CredentialsFunc returns the username (which can be empty) and password that
+// new connections authenticate with.
+type CredentialsFunc func(ctx context.Context) (username, password string, err error)
+
+// CredentialsError is returned when the CredentialsFunc of
+// WithCredentialsProvider failed. It matches ErrUnavailable, so DecideOnError
+// treats it like any other failure to reach Redis.
+type CredentialsError struct {
+	Err error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("%v: couldn't fetch credentials: %v", ErrUnavailable, e.Err)
+}
+
+func (e *CredentialsError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+func (e *CredentialsError) Unwrap() error {
+	return e.Err
+}
+
+// WithCredentialsProvider makes every new connection of the clients that the
+// Provider creates itself, with WithConfig, WithURL and WithMaintenancePoolSize,
+// authenticate with what fn returns instead of the configured username and
+// password, so rotated passwords are picked up without a new Provider. Since fn
+// is called for every new connection, it should cache the secret itself. A
+// Provider with a credentials provider always gets its own client, as if
+// WithNoClientReuse was used, and New fails if a client is given with
+// WithClient. See RefreshCredentials for the connections that already exist.
+func WithCredentialsProvider(fn CredentialsFunc) func(o *options) {
+	return func(o *options) {
+		o.credentials = fn
+	}
+}
+
+// withCredentials returns a copy of the client options that authenticates new
+// connections with fn. The database is selected after that, since it can't be
+// before AUTH.
+func withCredentials(config *redis.Options, fn CredentialsFunc) *redis.Options {
+	copied := *config
+	copied.Username, copied.Password, copied.DB = "", "", 0
+
+	db, next := config.DB, config.OnConnect
+	copied.OnConnect = func(ctx context.Context, cn *redis.Conn) error {
+		username, password, err := fn(ctx)
+		if err != nil {
+			// go-redis unwraps what OnConnect returns once before it gets to
+			// the caller, so the CredentialsError is wrapped in another error.
+			return fmt.Errorf("%w", &CredentialsError{Err: err})
+		}
+
+		if err := authenticate(ctx, cn, username, password); err != nil {
+			return err
+		}
+
+		if db > 0 {
+			if err := cn.Select(ctx, db).Err(); err != nil {
+				return err
+			}
+		}
+
+		if next != nil {
+			return next(ctx, cn)
+		}
+
+		return nil
+	}
+
+	return &copied
+}
+
+func authenticate(ctx context.Context, cn *redis.Conn, username, password string) error {
+	if username != "" {
+		return cn.AuthACL(ctx, username, password).Err()
+	}
+
+	return cn.Auth(ctx, password).Err()
+}
+
+// RefreshCredentials fetches the credentials of WithCredentialsProvider and
+// authenticates the idle connections of the Provider's clients with them, like
+// right after a rotation that revokes the old password. New connections pick
+// up new credentials on their own. Connections that are busy while it runs
+// keep what they were authenticated with, which still works as long as the old
+// password does.
+func (p *Provider) RefreshCredentials(ctx context.Context) (err error) {
+	defer p.recoverPanic(&err, "refresh_credentials", "")
+
+	if p.credentials == nil {
+		return errors.New("RefreshCredentials needs WithCredentialsProvider")
+	}
+
+	username, password, err := p.credentials(ctx)
+	if err != nil {
+		return &CredentialsError{Err: err}
+	}
+
+	clients := []*redis.Client{p.client}
+	if p.ownedMaintenance != nil {
+		clients = append(clients, p.ownedMaintenance)
+	}
+
+	for _, client := range clients {
+		if err := reauthenticate(ctx, client, username, password); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reauthenticate authenticates the idle connections of the client again. They
+// are all taken out of the pool at once, so none of them is seen twice.
+func reauthenticate(ctx context.Context, client *redis.Client, username, password string) error {
+	idle := int(client.PoolStats().IdleConns)
+	conns := make([]*redis.Conn, 0, idle)
+	defer func() {
+		for _, cn := range conns {
+			_ = cn.Close()
+		}
+	}()
+
+	for i := 0; i < idle; i++ {
+		cn := client.Conn(ctx)
+		conns = append(conns, cn)
+		if err := authenticate(ctx, cn, username, password); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
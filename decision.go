@@ -0,0 +1,201 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HeaderStyle is which set of headers Decision.SetHeaders writes.
+type HeaderStyle int
+
+const (
+	// HeaderStyleLegacy writes X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset (in Unix milliseconds, like the chi-ratelimit
+	// middleware does).
+	HeaderStyleLegacy HeaderStyle = iota
+
+	// HeaderStyleDraft writes RateLimit-Limit, RateLimit-Remaining and
+	// RateLimit-Reset (in seconds from now) from the IETF RateLimit header
+	// fields draft.
+	HeaderStyleDraft
+)
+
+// Decision is a ratelimit with everything that's needed to answer a request
+// already worked out. Its JSON encoding has fixed snake_case field names, so it
+// can be embedded in API error payloads as it is; the durations are in
+// nanoseconds, like encoding/json writes any time.Duration.
+type Decision struct {
+	// Allowed is true if the ratelimit has requests remaining or its window
+	// has already been reset.
+	Allowed bool `json:"allowed"`
+
+	Limit     int64 `json:"limit"`
+	Remaining int64 `json:"remaining"`
+
+	// Window is how long the window of the limit that made the Decision is, or
+	// zero if it isn't known, like for Decide and DecideOnError.
+	Window time.Duration `json:"window"`
+
+	// PolicyName is the name of the Limiter or GlobalLimiter that made the
+	// Decision. It's empty for the Provider's own methods.
+	PolicyName string `json:"policy_name"`
+
+	// ResetAt is when the window is reset.
+	ResetAt time.Time `json:"reset_at"`
+
+	// ResetAfter is how long until the window is reset, or zero if it already
+	// has been.
+	ResetAfter time.Duration `json:"reset_after"`
+
+	// RetryAfter is how long until another request may be sent, which is zero
+	// if Allowed is true.
+	RetryAfter time.Duration `json:"retry_after"`
+
+	// NextTokenAfter and FullAfter are how long until the bucket of Take has
+	// another token and is full again. Only Take and TakeN set them.
+	NextTokenAfter time.Duration `json:"next_token_after"`
+	FullAfter      time.Duration `json:"full_after"`
+
+	// Degraded is true if the Decision was made without being able to reach
+	// Redis, see DecideOnError.
+	Degraded bool `json:"degraded"`
+
+	// FirstInWindow is true if the request started a new window, like the
+	// first request of a key or the first one after its window was over or
+	// reset. Only Consume sets it.
+	FirstInWindow bool `json:"first_in_window"`
+
+	// FirstEver is true if the request is the first that was ever counted for
+	// the key. It needs WithFirstSeenTracking.
+	FirstEver bool `json:"first_ever"`
+
+	// OverLimit is true if the request was only allowed as one of the grace
+	// requests of WithGrace, after the limit was used up. Only Consume sets it.
+	OverLimit bool `json:"over_limit"`
+
+	// RoundTrips is how many round trips to Redis Consume took, if
+	// WithRoundTripSampling sampled it, and zero otherwise.
+	RoundTrips int `json:"round_trips"`
+
+	// previous is the ratelimit of the window before a request that started
+	// a new one, for WithRolloverCallback, if it's known.
+	previous *types.Ratelimit
+}
+
+// Decide returns the Decision for the given ratelimit, using the Provider's
+// clock. It doesn't count as a request.
+func (p *Provider) Decide(rl *types.Ratelimit) Decision {
+	return newDecision(rl, p.now())
+}
+
+// DecisionFromRatelimit returns the Decision for a ratelimit from Get or Put
+// with the given policy name and window, so those have the same shape as the
+// ones of Consume. It uses the system clock; Decide uses the Provider's.
+func DecisionFromRatelimit(rl *types.Ratelimit, policy string, window time.Duration) Decision {
+	decision := newDecision(rl, time.Now())
+	decision.PolicyName, decision.Window = policy, window
+	return decision
+}
+
+func newDecision(rl *types.Ratelimit, now time.Time) Decision {
+	if rl == nil {
+		return Decision{Allowed: true}
+	}
+
+	resetAfter := rl.ResetTime.Sub(now)
+	if resetAfter < 0 {
+		resetAfter = 0
+	}
+
+	retryAfter := retryAfter(rl, now)
+	return Decision{
+		Allowed:    retryAfter == 0,
+		Limit:      int64(rl.Limit),
+		Remaining:  int64(rl.Remaining),
+		ResetAt:    rl.ResetTime,
+		ResetAfter: resetAfter,
+		RetryAfter: retryAfter,
+	}
+}
+
+// saturateInt32 converts n to the int32 that ratelimits store, saturating at
+// the bounds instead of wrapping around.
+func saturateInt32(n int64) int32 {
+	switch {
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	case n < math.MinInt32:
+		return math.MinInt32
+	default:
+		return int32(n)
+	}
+}
+
+// maxScriptLimit is the largest limit that Lua, whose numbers are doubles, can
+// compare a count against exactly.
+const maxScriptLimit = 1<<53 - 1
+
+// scriptLimit returns the limit to pass to a script, saturating at
+// maxScriptLimit. Larger limits would only behave differently after 2^53
+// requests in one window, and Decisions keep the exact limit.
+func scriptLimit(limit int64) int64 {
+	if limit > maxScriptLimit {
+		return maxScriptLimit
+	}
+
+	return limit
+}
+
+// SetHeaders writes the ratelimit headers of the given style into h, and
+// Retry-After (in whole seconds, rounded up) if the request isn't allowed.
+func (d Decision) SetHeaders(h http.Header, style HeaderStyle) {
+	remaining := d.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	switch style {
+	case HeaderStyleDraft:
+		h.Set("RateLimit-Limit", strconv.FormatInt(d.Limit, 10))
+		h.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(d.ResetAfter), 10))
+
+	default:
+		h.Set("X-RateLimit-Limit", strconv.FormatInt(d.Limit, 10))
+		h.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(d.ResetAt.UnixMilli(), 10))
+	}
+
+	if !d.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(ceilSeconds(d.RetryAfter), 10))
+	}
+}
+
+// ceilSeconds returns d in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,208 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// decisionLogBuffer is how many records can wait for the sink before new ones
+// are dropped.
+const decisionLogBuffer = 1024
+
+// DecisionRecord is what WithDecisionLog emits for a single consumed request,
+// or for a Reset.
+type DecisionRecord struct {
+	// Key is the key as it's stored, so keys shortened by WithMaxKeyLength
+	// appear as their hash.
+	Key string
+
+	// Reset is true if the record is for a Reset of the key, in which case
+	// only Key, Time and Forced are set.
+	Reset bool
+
+	Limit           int64
+	Window          time.Duration
+	RemainingBefore int64
+	RemainingAfter  int64
+	ResetAt         time.Time
+
+	// Time is the time that was sent to Redis to make the decision with.
+	Time time.Time
+
+	// Script is the SHA1 of the script that made the decision.
+	Script string
+
+	Allowed bool
+
+	// Forced is true if the record was emitted because of ForceLog instead of
+	// being sampled.
+	Forced bool
+}
+
+// decisionLog holds what WithDecisionLog needs.
+type decisionLog struct {
+	sink    func(DecisionRecord)
+	rate    float64
+	records chan DecisionRecord
+
+	mu     sync.Mutex
+	random func() float64
+}
+
+// WithDecisionLog emits a DecisionRecord to sink for a sampleRate fraction (from
+// 0 to 1) of the requests counted by Consume, ConsumeSliding, ConsumeApprox and
+// ConsumeManyKeys, and of the keys that were reset, and for every request of
+// the keys given to ForceLog. Records
+// are passed to sink from a single background goroutine, so it never slows
+// down a request; if sink falls far enough behind, records are dropped instead.
+func WithDecisionLog(sink func(DecisionRecord), sampleRate float64) func(o *options) {
+	return func(o *options) {
+		o.decisionSink = sink
+		o.decisionSampleRate = sampleRate
+	}
+}
+
+func newDecisionLog(sink func(DecisionRecord), rate float64) *decisionLog {
+	return &decisionLog{
+		sink:    sink,
+		rate:    rate,
+		records: make(chan DecisionRecord, decisionLogBuffer),
+		random:  rand.Float64,
+	}
+}
+
+// ForceLog makes WithDecisionLog emit a record for every request of the given
+// key for the next d, no matter the sample rate. Only as many keys as
+// WithKeyStateLimit allows can be forced at once.
+func (p *Provider) ForceLog(key string, d time.Duration) (err error) {
+	defer p.recoverPanic(&err, "force_log", key)
+
+	if p.decisions == nil {
+		return ErrDecisionLogDisabled
+	}
+
+	until := p.now().Add(d)
+	p.states.update(p.storageKey(key), true, func(s *keyState) {
+		s.forcedUntil = until
+	})
+
+	return nil
+}
+
+// sampled returns whether a record should be emitted for the given stored key,
+// and whether that's because of ForceLog.
+func (p *Provider) sampled(key string, now time.Time) (ok, forced bool) {
+	p.states.update(key, false, func(s *keyState) {
+		if now.Before(s.forcedUntil) {
+			forced = true
+		} else {
+			s.forcedUntil = time.Time{}
+		}
+	})
+
+	if forced {
+		return true, true
+	}
+
+	l := p.decisions
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate > 0 && l.random() < l.rate, false
+}
+
+// logDecision queues a record for the given decision if it's sampled.
+func (p *Provider) logDecision(key, scriptSHA string, window time.Duration, decision Decision, now time.Time) {
+	if p.decisions == nil {
+		return
+	}
+
+	ok, forced := p.sampled(key, now)
+	if !ok {
+		return
+	}
+
+	// Every consumed request that was let through took exactly one.
+	before := decision.Remaining
+	if decision.Allowed {
+		before++
+	}
+
+	record := DecisionRecord{
+		Key:             key,
+		Limit:           decision.Limit,
+		Window:          window,
+		RemainingBefore: before,
+		RemainingAfter:  decision.Remaining,
+		ResetAt:         decision.ResetAt,
+		Time:            now,
+		Script:          scriptSHA,
+		Allowed:         decision.Allowed,
+		Forced:          forced,
+	}
+
+	p.queueRecord(record)
+}
+
+// logReset queues a record for a Reset of the given key if it's sampled.
+func (p *Provider) logReset(key string, now time.Time) {
+	if p.decisions == nil {
+		return
+	}
+
+	if ok, forced := p.sampled(key, now); ok {
+		p.queueRecord(DecisionRecord{Key: key, Reset: true, Time: now, Forced: forced})
+	}
+}
+
+// queueRecord queues a record for the sink, dropping it if the sink is too far
+// behind.
+func (p *Provider) queueRecord(record DecisionRecord) {
+	select {
+	case p.decisions.records <- record:
+	default:
+	}
+}
+
+// emitDecisions passes queued records to the sink until the Provider is shut
+// down, then emits whatever is still queued.
+func (p *Provider) emitDecisions() {
+	l := p.decisions
+	for {
+		select {
+		case record := <-l.records:
+			l.sink(record)
+		case <-p.stop:
+			for {
+				select {
+				case record := <-l.records:
+					l.sink(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
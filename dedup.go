@@ -0,0 +1,83 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// WithPutDeduplication makes Put skip the write to Redis when the encoded value
+// is byte-identical to the last one this Provider wrote for the same key within
+// the given window, like a key that stays at zero remaining while it's being
+// hammered. Writes from other Provider instances aren't seen, so the window
+// should be kept short. Only as many keys as WithKeyStateLimit allows are
+// remembered.
+func WithPutDeduplication(window time.Duration) func(o *options) {
+	return func(o *options) {
+		o.dedupWindow = window
+	}
+}
+
+// putDedup remembers the hash of the last value written per key in the key
+// state table.
+type putDedup struct {
+	window time.Duration
+	now    func() time.Time
+	states *keyStates
+}
+
+func newPutDedup(window time.Duration, now func() time.Time, states *keyStates) *putDedup {
+	return &putDedup{window: window, now: now, states: states}
+}
+
+// seen returns true if data is what was last written for key within the window.
+func (d *putDedup) seen(key string, data []byte) (seen bool) {
+	d.states.update(key, false, func(s *keyState) {
+		seen = !s.dedupWritten.IsZero() && d.now().Sub(s.dedupWritten) < d.window && s.dedupSum == sha256.Sum256(data)
+	})
+
+	return seen
+}
+
+// record remembers that data was just written for key.
+func (d *putDedup) record(key string, data []byte) {
+	d.states.update(key, true, func(s *keyState) {
+		s.dedupSum, s.dedupWritten = sha256.Sum256(data), d.now()
+	})
+}
+
+// forget makes the next Put for each of the given keys go through.
+func (d *putDedup) forget(keys ...string) {
+	for _, key := range keys {
+		d.states.update(key, false, func(s *keyState) {
+			s.dedupSum, s.dedupWritten = [sha256.Size]byte{}, time.Time{}
+		})
+	}
+}
+
+// clear forgets every key.
+func (d *putDedup) clear() {
+	d.states.each(func(s *keyState) {
+		s.dedupSum, s.dedupWritten = [sha256.Size]byte{}, time.Time{}
+	})
+}
@@ -0,0 +1,147 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// WithDerivedReset makes reads take the reset time of a ratelimit from the
+// field TTL that WithFieldTTL gave it (HPEXPIRETIME, less WithExpiryGrace),
+// instead of the one stored in the value. The reset that clients are told about
+// then always is when Redis actually removes the ratelimit, even after
+// ExtendWindow or something outside the Provider changed the TTL. Fields
+// without a TTL, like ones written before WithFieldTTL was used or persistent
+// ones of WithPersistentEntries, keep their stored reset time.
+//
+// Get, Peek, Consume and Txn derive it. The value still has the reset time it
+// was written with, which reads of many ratelimits at once, like GetMany, List
+// and Export, report instead. It needs WithFieldTTL, and is off if
+// WithFieldTTLFallback turned field TTLs off.
+func WithDerivedReset() func(o *options) {
+	return func(o *options) {
+		o.derivedReset = true
+	}
+}
+
+// ExtendWindow moves the reset time of the ratelimit stored for the given key
+// by the given duration, along with its field TTL, without touching how many
+// requests it has left. A negative duration ends the window early. It returns
+// false if there is no ratelimit whose window hasn't reset yet.
+func (p *Provider) ExtendWindow(key string, by time.Duration) (ok bool, err error) {
+	defer p.recoverPanic(&err, "extend_window", key)
+
+	return p.adjustWindow("extend_window", key, func(rl *types.Ratelimit) {
+		rl.ResetTime = rl.ResetTime.Add(by)
+	})
+}
+
+// fetchExpiry is fetchSource, also returning when the field expires with
+// WithDerivedReset, pipelined with the read. The time is zero without it, or
+// if the field has no TTL.
+func (p *Provider) fetchExpiry(key string, call *callOptions) (string, ReadSource, time.Time, error) {
+	if !p.derivedReset {
+		data, source, err := p.fetchSource(key, call)
+		return data, source, time.Time{}, err
+	}
+
+	ctx, cancel := p.readContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	hash := p.hashKey()
+	if p.fallback != nil && p.now().Before(p.fallback.until) {
+		// A field moved from the old prefix has no TTL until its next
+		// write, so it's asked for after the move.
+		data, source, err := p.fetchWithFallback(ctx, hash, key)
+		if err != nil || source == "" {
+			return "", "", time.Time{}, err
+		}
+
+		// Cmdable has no Do, but a pipeline of one command has.
+		var expiry *redis.Cmd
+		if _, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			expiry = pipe.Do(ctx, "HPEXPIRETIME", hash, "FIELDS", 1, key)
+			return nil
+		}); err != nil {
+			return "", "", time.Time{}, err
+		}
+
+		at, err := p.fieldExpiry(expiry)
+		return data, source, at, err
+	}
+
+	var (
+		value  *redis.StringCmd
+		expiry *redis.Cmd
+	)
+
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.HGet(ctx, hash, key)
+		expiry = pipe.Do(ctx, "HPEXPIRETIME", hash, "FIELDS", 1, key)
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", time.Time{}, err
+	}
+
+	data, err := value.Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", time.Time{}, nil
+	}
+
+	at, err := p.fieldExpiry(expiry)
+	return data, ReadPrimary, at, err
+}
+
+// fieldExpiry returns the reset time that the reply of HPEXPIRETIME for a
+// single field stands for, or zero if the field has no TTL.
+func (p *Provider) fieldExpiry(cmd *redis.Cmd) (time.Time, error) {
+	values, err := cmd.Slice()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// -1 is a field without a TTL and -2 one that doesn't exist.
+	if len(values) != 1 {
+		return time.Time{}, nil
+	}
+
+	if at, ok := values[0].(int64); ok && at > 0 {
+		return time.UnixMilli(at).Add(-p.expiryGrace), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// withExpiry makes the given expiry the reset time of rl, unless it's zero.
+func withExpiry(rl *types.Ratelimit, expiry time.Time) *types.Ratelimit {
+	if rl != nil && !expiry.IsZero() {
+		rl.ResetTime = expiry
+	}
+
+	return rl
+}
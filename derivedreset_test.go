@@ -0,0 +1,309 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fieldTTLs adds the field TTL commands that WithFieldTTL and WithDerivedReset
+// use to a miniredis server, which doesn't have them. They're only kept track
+// of; nothing expires.
+type fieldTTLs struct {
+	mu sync.Mutex
+	at map[string]int64
+}
+
+func registerFieldTTL(t *testing.T, s *miniredis.Miniredis) *fieldTTLs {
+	t.Helper()
+
+	f := &fieldTTLs{at: map[string]int64{}}
+	commands := map[string]func(c *server.Peer, args []string){
+		// HPEXPIREAT hash ms FIELDS n field...
+		"HPEXPIREAT": func(c *server.Peer, args []string) {
+			at, _ := strconv.ParseInt(args[1], 10, 64)
+			fields := args[4:]
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			c.WriteLen(len(fields))
+			for _, field := range fields {
+				f.at[args[0]+"\x00"+field] = at
+				c.WriteInt(1)
+			}
+		},
+
+		// HPEXPIRETIME hash FIELDS n field...
+		"HPEXPIRETIME": func(c *server.Peer, args []string) {
+			f.reply(c, s, args, func(at int64) int64 { return at })
+		},
+
+		// HPTTL hash FIELDS n field...
+		"HPTTL": func(c *server.Peer, args []string) {
+			f.reply(c, s, args, func(at int64) int64 { return at - time.Now().UnixMilli() })
+		},
+	}
+
+	for name, fn := range commands {
+		fn := fn
+		if err := s.Server().Register(name, func(c *server.Peer, _ string, args []string) { fn(c, args) }); err != nil {
+			t.Fatalf("registering %s: %v", name, err)
+		}
+	}
+
+	return f
+}
+
+// reply answers a command that reads the TTL of fields, with -2 for ones that
+// don't exist and -1 for ones without a TTL.
+func (f *fieldTTLs) reply(c *server.Peer, s *miniredis.Miniredis, args []string, value func(at int64) int64) {
+	fields := args[3:]
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c.WriteLen(len(fields))
+	for _, field := range fields {
+		at, ok := f.at[args[0]+"\x00"+field]
+		switch {
+		case !hasField(s, args[0], field):
+			delete(f.at, args[0]+"\x00"+field)
+			c.WriteInt(-2)
+		case !ok:
+			c.WriteInt(-1)
+		default:
+			c.WriteInt(int(value(at)))
+		}
+	}
+}
+
+func hasField(s *miniredis.Miniredis, hash, field string) bool {
+	fields, _ := s.HKeys(hash)
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// set gives a field the given expiry, like a TTL change from outside the
+// Provider.
+func (f *fieldTTLs) set(hash, field string, at time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.at[hash+"\x00"+field] = at.UnixMilli()
+}
+
+func (f *fieldTTLs) get(hash, field string) (time.Time, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	at, ok := f.at[hash+"\x00"+field]
+	return time.UnixMilli(at), ok
+}
+
+func TestDerivedReset(t *testing.T) {
+	s := miniredis.RunT(t)
+	ttls := registerFieldTTL(t, s)
+	p := newTestProviderOn(t, s, WithFieldTTL(), WithDerivedReset())
+
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: resetAt}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if at, ok := ttls.get(p.hashKey(), "k"); !ok || !at.Equal(resetAt) {
+		t.Fatalf("field TTL = %v, %t; want %v", at, ok, resetAt)
+	}
+
+	// A TTL that changed outside the Provider wins over the stored reset.
+	changed := resetAt.Add(time.Hour)
+	ttls.set(p.hashKey(), "k", changed)
+	rl, err := p.Peek("k")
+	if err != nil || rl == nil || !rl.ResetTime.Equal(changed) {
+		t.Fatalf("Peek = %+v, %v; want a reset at %v", rl, err, changed)
+	}
+
+	// Get writes the derived reset back, so the value agrees with it again.
+	if rl, err = p.Get("k"); err != nil || rl == nil || !rl.ResetTime.Equal(changed) || rl.Remaining != 9 {
+		t.Fatalf("Get = %+v, %v; want 9 remaining and a reset at %v", rl, err, changed)
+	}
+
+	stored, err := p.decode(s.HGet(p.hashKey(), "k"))
+	if err != nil || !stored.ResetTime.Equal(changed) {
+		t.Fatalf("stored = %+v, %v; want a reset at %v", stored, err, changed)
+	}
+
+	if d, err := p.Consume("k", 10, time.Minute); err != nil || !d.Allowed || d.Remaining != 8 || !d.ResetAt.Equal(changed) {
+		t.Fatalf("Consume = %+v, %v; want the derived reset at %v", d, err, changed)
+	}
+}
+
+func TestDerivedResetWithoutTTL(t *testing.T) {
+	s := miniredis.RunT(t)
+	registerFieldTTL(t, s)
+	p := newTestProviderOn(t, s, WithFieldTTL(), WithDerivedReset())
+
+	// Like a ratelimit that was written before WithFieldTTL was used.
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	data, err := p.encode(&types.Ratelimit{Limit: 10, Remaining: 4, ResetTime: resetAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.HSet(p.hashKey(), "legacy", string(data))
+	rl, err := p.Peek("legacy")
+	if err != nil || rl == nil || !rl.ResetTime.Equal(resetAt) || rl.Remaining != 4 {
+		t.Fatalf("Peek = %+v, %v; want the stored reset at %v", rl, err, resetAt)
+	}
+
+	if rl, err := p.Peek("missing"); rl != nil || err != nil {
+		t.Fatalf("Peek of a missing key = %+v, %v", rl, err)
+	}
+}
+
+func TestDerivedResetConsumeUsesTTL(t *testing.T) {
+	s := miniredis.RunT(t)
+	ttls := registerFieldTTL(t, s)
+	p := newTestProviderOn(t, s, WithFieldTTL(), WithDerivedReset())
+
+	// The stored reset already passed, but the TTL says the window still has
+	// a minute to go, so Consume doesn't start a new one.
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 0, ResetTime: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	ttls.set(p.hashKey(), "k", resetAt)
+	d, err := p.Consume("k", 10, time.Minute)
+	if err != nil || d.Allowed || !d.ResetAt.Equal(resetAt) {
+		t.Fatalf("Consume = %+v, %v; want it denied until %v", d, err, resetAt)
+	}
+}
+
+func TestDerivedResetLocalCache(t *testing.T) {
+	s := miniredis.RunT(t)
+	ttls := registerFieldTTL(t, s)
+	writer := newTestProviderOn(t, s, WithFieldTTL())
+	p := newTestProviderOn(t, s, WithFieldTTL(), WithDerivedReset(), WithLocalCache(time.Minute))
+
+	if err := writer.Put("k", &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// The local copy keeps the derived reset, not the stored one.
+	changed := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	ttls.set(p.hashKey(), "k", changed)
+	before := s.CommandCount()
+	for i := 0; i < 2; i++ {
+		if rl, err := p.Peek("k"); err != nil || rl == nil || !rl.ResetTime.Equal(changed) {
+			t.Fatalf("Peek %d = %+v, %v; want a reset at %v", i, rl, err, changed)
+		}
+	}
+
+	if commands := s.CommandCount() - before; commands != 2 {
+		t.Fatalf("Peeks sent %d commands, want only the pipelined HGET and HPEXPIRETIME", commands)
+	}
+}
+
+func TestDerivedResetNeedsFieldTTL(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if _, err := New(WithClient(p.client), WithDerivedReset()); err == nil || !strings.Contains(err.Error(), "WithFieldTTL") {
+		t.Fatalf("New = %v, want an error about WithFieldTTL", err)
+	}
+}
+
+func TestExtendWindowDerived(t *testing.T) {
+	s := miniredis.RunT(t)
+	ttls := registerFieldTTL(t, s)
+	p := newTestProviderOn(t, s, WithFieldTTL(), WithDerivedReset())
+
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 3, ResetTime: resetAt}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if ok, err := p.ExtendWindow("k", 30*time.Second); !ok || err != nil {
+		t.Fatalf("ExtendWindow = %t, %v", ok, err)
+	}
+
+	extended := resetAt.Add(30 * time.Second)
+	if at, ok := ttls.get(p.hashKey(), "k"); !ok || !at.Equal(extended) {
+		t.Fatalf("field TTL = %v, %t; want %v", at, ok, extended)
+	}
+
+	rl, err := p.Peek("k")
+	if err != nil || rl == nil || !rl.ResetTime.Equal(extended) || rl.Remaining != 3 {
+		t.Fatalf("Peek = %+v, %v; want 3 remaining and a reset at %v", rl, err, extended)
+	}
+
+	// It extends what the TTL says, even if that isn't what's stored.
+	changed := resetAt.Add(time.Hour)
+	ttls.set(p.hashKey(), "k", changed)
+	if ok, err := p.ExtendWindow("k", -time.Minute); !ok || err != nil {
+		t.Fatalf("ExtendWindow = %t, %v", ok, err)
+	}
+
+	if rl, err = p.Peek("k"); err != nil || rl == nil || !rl.ResetTime.Equal(changed.Add(-time.Minute)) {
+		t.Fatalf("Peek = %+v, %v; want a reset at %v", rl, err, changed.Add(-time.Minute))
+	}
+}
+
+func TestExtendWindow(t *testing.T) {
+	p, _ := newTestProvider(t)
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 3, ResetTime: resetAt}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if ok, err := p.ExtendWindow("k", time.Minute); !ok || err != nil {
+		t.Fatalf("ExtendWindow = %t, %v", ok, err)
+	}
+
+	rl, err := p.Peek("k")
+	if err != nil || rl == nil || !rl.ResetTime.Equal(resetAt.Add(time.Minute)) || rl.Remaining != 3 {
+		t.Fatalf("Peek = %+v, %v; want the reset a minute later", rl, err)
+	}
+
+	if ok, err := p.ExtendWindow("missing", time.Minute); ok || err != nil {
+		t.Fatalf("ExtendWindow of a missing key = %t, %v", ok, err)
+	}
+
+	if err := p.Put("over", &types.Ratelimit{Limit: 10, Remaining: 0, ResetTime: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if ok, err := p.ExtendWindow("over", time.Minute); ok || err != nil {
+		t.Fatalf("ExtendWindow of a window that reset = %t, %v", ok, err)
+	}
+}
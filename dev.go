@@ -0,0 +1,87 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build redisdev
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// devTick is how often the server of NewDev moves its TTLs forward.
+const devTick = 100 * time.Millisecond
+
+// NewDev creates a Provider backed by an in-process Redis emulation
+// (miniredis) instead of a real server, so code can run locally and in tests
+// without Redis. It's the same *Provider that New returns, with the same
+// options, except that the client comes from NewDev; WithClient, WithConfig and
+// WithURL are overridden. Every Provider gets its own empty store, which is
+// gone once the Provider is closed.
+//
+// NewDev is only built with the redisdev build tag, so programs that import
+// this package without it don't pull in the emulation and its Lua VM.
+//
+// This is not for production: state lives only in memory, nothing is shared
+// between processes, and the emulation lacks some commands, so
+// ConsumeApprox, WithWriteConcern, WithFieldTTL and Verify don't work with it.
+func NewDev(opts ...func(o *options)) (*Provider, error) {
+	server := miniredis.NewMiniRedis()
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+
+	dev := func(o *options) {
+		o.client = nil
+		o.clientConfig = &redis.Options{Addr: server.Addr()}
+		o.noClientReuse = true
+	}
+
+	p, err := New(append(opts, dev)...)
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	p.owned.onRelease = server.Close
+
+	// The emulation only expires keys when it's told how much time passed.
+	p.goBackground(func() {
+		ticker := time.NewTicker(devTick)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for {
+			select {
+			case <-p.stop:
+				return
+
+			case now := <-ticker.C:
+				server.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	})
+
+	return p, nil
+}
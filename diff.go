@@ -0,0 +1,176 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// defaultMaxDifferences is how many differences Diff lists by default.
+const defaultMaxDifferences = 1000
+
+// DiffOption configures AdminClient.Diff.
+type DiffOption func(o *diffOptions)
+
+type diffOptions struct {
+	tolerance      time.Duration
+	maxDifferences int
+}
+
+// WithDiffTolerance makes Diff treat reset times that are at most the given
+// duration apart as equal.
+func WithDiffTolerance(tolerance time.Duration) DiffOption {
+	return func(o *diffOptions) {
+		o.tolerance = tolerance
+	}
+}
+
+// WithMaxDifferences sets how many keys Diff lists in total before it only
+// counts them. It's 1000 by default.
+func WithMaxDifferences(limit int) DiffOption {
+	return func(o *diffOptions) {
+		o.maxDifferences = limit
+	}
+}
+
+// DiffEntry is a key whose ratelimit differs between the two Providers. A side
+// is nil if its value couldn't be decoded.
+type DiffEntry struct {
+	Key string
+	A   *types.Ratelimit
+	B   *types.Ratelimit
+}
+
+// DiffReport is the result of AdminClient.Diff, where A is the Provider that
+// Diff was called on and B the other one.
+type DiffReport struct {
+	// Compared is how many keys exist on both sides.
+	Compared int64
+
+	// Differences is how many keys are missing on one side or differ, including
+	// the ones that weren't listed.
+	Differences int64
+
+	OnlyInA   []string
+	OnlyInB   []string
+	Differing []DiffEntry
+
+	// Truncated is true if there were more differences than
+	// WithMaxDifferences allows to list.
+	Truncated bool
+}
+
+// OK returns true if both sides hold the same ratelimits.
+func (r *DiffReport) OK() bool {
+	return r.Differences == 0
+}
+
+// Diff compares every ratelimit of this Provider with the ones stored by other,
+// which can use another prefix, server or wire format since the values are
+// compared after decoding. Keys are compared as they're stored, so both should
+// use the same WithMaxKeyLength. Writes that happen during the diff can show
+// up as differences.
+func (a *AdminClient) Diff(ctx context.Context, other *Provider, opts ...DiffOption) (report *DiffReport, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "diff", "")
+	ctx = p.maintenanceContext(ctx)
+
+	config := &diffOptions{maxDifferences: defaultMaxDifferences}
+	for _, override := range opts {
+		override(config)
+	}
+
+	report = &DiffReport{}
+	listed := func() bool {
+		report.Differences++
+		if len(report.OnlyInA)+len(report.OnlyInB)+len(report.Differing) >= config.maxDifferences {
+			report.Truncated = true
+			return false
+		}
+
+		return true
+	}
+
+	err = p.scan(ctx, "Diff", "", 100, func(fields, values []string) error {
+		theirs, err := other.cmd(ctx).HMGet(ctx, other.hashKey(), fields...).Result()
+		if err != nil {
+			return err
+		}
+
+		for i, field := range fields {
+			data, ok := theirs[i].(string)
+			if !ok {
+				if listed() {
+					report.OnlyInA = append(report.OnlyInA, field)
+				}
+
+				continue
+			}
+
+			report.Compared++
+
+			mine, mineErr := p.decode(values[i])
+			their, theirErr := other.decode(data)
+			if mineErr == nil && theirErr == nil && sameRatelimit(mine, their, config.tolerance) {
+				continue
+			}
+
+			if listed() {
+				report.Differing = append(report.Differing, DiffEntry{Key: field, A: mine, B: their})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return report, err
+	}
+
+	err = other.scan(ctx, "Diff", "", 100, func(fields, _ []string) error {
+		ours, err := p.cmd(ctx).HMGet(ctx, p.hashKey(), fields...).Result()
+		if err != nil {
+			return err
+		}
+
+		for i, field := range fields {
+			if ours[i] == nil && listed() {
+				report.OnlyInB = append(report.OnlyInB, field)
+			}
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+func sameRatelimit(a, b *types.Ratelimit, tolerance time.Duration) bool {
+	drift := a.ResetTime.Sub(b.ResetTime)
+	if drift < 0 {
+		drift = -drift
+	}
+
+	return a.Limit == b.Limit && a.Remaining == b.Remaining && a.Global == b.Global && drift <= tolerance
+}
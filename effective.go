@@ -0,0 +1,94 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "time"
+
+// StateSource is what decided an EffectiveState.
+type StateSource string
+
+const (
+	// StateSourceNone means nothing limits the key right now.
+	StateSourceNone StateSource = "none"
+
+	// StateSourceWindow means the key's window has no requests remaining.
+	StateSourceWindow StateSource = "window"
+
+	// StateSourceBan means the key is banned by Ban or WithAutoBan.
+	StateSourceBan StateSource = "ban"
+)
+
+// EffectiveState is everything that limits a key, merged into one answer.
+type EffectiveState struct {
+	// Allowed is true if nothing denies the key's next request.
+	Allowed bool
+
+	// RetryAfter is how long until every state that denies the key is over,
+	// so a client that waits that long isn't turned away by another one.
+	RetryAfter time.Duration
+
+	// Source is the state that denies the key with the highest precedence: a
+	// ban first, then the window. It's StateSourceNone if Allowed is true.
+	Source StateSource
+
+	// Window is the key's window, as Decide sees it.
+	Window Decision
+
+	// Banned is how much longer the key is banned, or zero if it isn't.
+	Banned time.Duration
+}
+
+// EffectiveState returns the window and the ban of the given key merged into
+// one EffectiveState, without counting a request. Bans are only recorded, so
+// Consume doesn't turn banned keys away; use this where they should be.
+func (p *Provider) EffectiveState(key string) (state *EffectiveState, err error) {
+	defer p.recoverPanic(&err, "effective_state", key)
+
+	rl, err := p.fetch(p.storageKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	banned, err := p.Banned(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveState(newDecision(rl, p.now()), banned), nil
+}
+
+// resolveState merges a window and the remaining time of a ban.
+func resolveState(window Decision, banned time.Duration) *EffectiveState {
+	state := &EffectiveState{Allowed: true, Source: StateSourceNone, Window: window, Banned: banned}
+	if !window.Allowed {
+		state.Allowed, state.Source, state.RetryAfter = false, StateSourceWindow, window.RetryAfter
+	}
+
+	if banned > 0 {
+		state.Allowed, state.Source = false, StateSourceBan
+		if banned > state.RetryAfter {
+			state.RetryAfter = banned
+		}
+	}
+
+	return state
+}
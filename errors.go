@@ -0,0 +1,211 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+)
+
+// ErrValueTooLarge is returned by Put when the encoded ratelimit is larger
+// than the size that was configured with WithMaxValueSize.
+var ErrValueTooLarge = errors.New("ratelimit value is too large")
+
+// ErrNotFound is returned when an operation needs a stored ratelimit, but there
+// is none for the key.
+var ErrNotFound = errors.New("no ratelimit is stored for the key")
+
+// ErrScriptNotLoaded is returned when scripts are pinned with WithPinnedScripts,
+// but the script that was needed hasn't been loaded into Redis.
+var ErrScriptNotLoaded = errors.New("lua script is not loaded")
+
+// ErrInternal is returned when a Provider method panicked. The returned error
+// is a *PanicError that carries the panic value and stack trace.
+var ErrInternal = errors.New("internal error in redis provider")
+
+// ErrEmptyValue is returned when a stored ratelimit decodes to nothing, like a
+// JSON null.
+var ErrEmptyValue = errors.New("stored ratelimit is empty")
+
+// ErrInconsistent is returned by Validate when a ratelimit's values contradict
+// each other, like more requests remaining than its limit.
+var ErrInconsistent = errors.New("ratelimit is inconsistent")
+
+// ErrIndexDisabled is returned by the index queries when the Provider wasn't
+// constructed with WithResetIndex.
+var ErrIndexDisabled = errors.New("reset index is not enabled")
+
+// ErrTxnConflict is returned by Txn when other writes to the key kept getting
+// in between, and it ran out of attempts.
+var ErrTxnConflict = errors.New("transaction kept conflicting with other writes")
+
+// ErrPrefixExists is returned by RenamePrefix when something is already stored
+// under the new prefix.
+var ErrPrefixExists = errors.New("key prefix is already in use")
+
+// ErrApproximateDisabled is returned by ConsumeApprox when the Provider wasn't
+// constructed with WithApproximateMode.
+var ErrApproximateDisabled = errors.New("approximate mode is not enabled")
+
+// ErrLocalCacheDisabled is returned by Prewarm when the Provider wasn't
+// constructed with WithLocalCache.
+var ErrLocalCacheDisabled = errors.New("local cache is not enabled")
+
+// ErrReplicationLag is returned when a write happened, but fewer replicas than
+// WithWriteConcern asks for acknowledged it in time.
+var ErrReplicationLag = errors.New("write wasn't acknowledged by enough replicas")
+
+// ErrAbuseScoreDisabled is returned by AbuseScore when the Provider wasn't
+// constructed with WithAbuseScore.
+var ErrAbuseScoreDisabled = errors.New("abuse scores are not enabled")
+
+// ErrUnknownResetReason is returned by ResetWithReason for a reason that
+// wasn't registered with RegisterResetReason.
+var ErrUnknownResetReason = errors.New("reset reason is not registered")
+
+// ErrNoPermission is returned when the Redis user isn't allowed to run a
+// command that the Provider needed, see WithRestrictedCommands. The NOPERM reply
+// itself is wrapped as well.
+var ErrNoPermission = errors.New("redis user lacks permission for a command")
+
+// ErrClientSaturated is returned when every connection of the client's pool was
+// busy for longer than its PoolTimeout. That means the pool is too small for
+// the load, not that Redis is unhealthy. The error from go-redis is wrapped as
+// well.
+var ErrClientSaturated = errors.New("redis client has no free connections")
+
+// ErrDecisionLogDisabled is returned by ForceLog when the Provider wasn't
+// constructed with WithDecisionLog.
+var ErrDecisionLogDisabled = errors.New("decision log is not enabled")
+
+// ErrWriteMismatch is matched by the WriteMismatchError that
+// WithWriteVerification reports when a value read back differs from what was
+// written.
+var ErrWriteMismatch = errors.New("written ratelimit didn't read back the same")
+
+// ErrTenantKeyBudget is returned when a write would create a new ratelimit for
+// a tenant that already has as many as WithTenantKeyBudget allows.
+var ErrTenantKeyBudget = errors.New("tenant has too many ratelimits")
+
+// ErrCreatedAtDisabled is returned by ResetIfOlderThan when the Provider wasn't
+// constructed with WithWindowCreatedAt.
+var ErrCreatedAtDisabled = errors.New("window start times are not kept")
+
+// ErrPersistentDisabled is returned by writes with the Persistent call option
+// when the Provider wasn't constructed with WithPersistentEntries.
+var ErrPersistentDisabled = errors.New("persistent entries are not enabled")
+
+// ErrMalformedValue is returned when a stored ratelimit is missing a field, or
+// when WithLenientDecoding can't make sense of it.
+var ErrMalformedValue = errors.New("stored ratelimit is malformed")
+
+// ErrScriptingDisabled is returned by New when WithNoScripting is combined
+// with features that need Lua scripts, and by anything that would run a script
+// under WithNoScripting.
+var ErrScriptingDisabled = errors.New("lua scripting is disabled")
+
+// ErrRateEstimationDisabled is returned by EstimatedRate when the Provider
+// wasn't constructed with WithRateEstimation.
+var ErrRateEstimationDisabled = errors.New("rate estimation is not enabled")
+
+// ErrNoShard is returned by Sharded when its picker returns a shard that
+// doesn't exist.
+var ErrNoShard = errors.New("picked shard doesn't exist")
+
+// ErrSchemaConflict is returned by New when the SchemaInfo stored with
+// WithSchemaInfo doesn't match how the Provider is configured.
+var ErrSchemaConflict = errors.New("stored schema conflicts with the configuration")
+
+// ErrUnavailable is matched by errors that mean Redis couldn't be used at all,
+// like a *CredentialsError.
+var ErrUnavailable = errors.New("redis is unavailable")
+
+// ErrCircuitOpen is returned without sending anything to Redis while the
+// circuit breaker of WithCircuitBreaker or WithSharedHealth is open. Errors
+// that match it match ErrUnavailable too.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrBucketDisabled is returned by Take and TakeN when the Provider wasn't
+// constructed with WithBurstBucket.
+var ErrBucketDisabled = errors.New("burst buckets are not enabled")
+
+// ErrLockNotHeld is returned by the unlock function of TryLock when the lock
+// expired or was taken over by someone else.
+var ErrLockNotHeld = errors.New("lock is not held")
+
+// ErrExportFormat is returned by Import for a dump that it can't read, like one
+// of a format version written by a newer version of this package.
+var ErrExportFormat = errors.New("export format is not supported")
+
+// ErrAsyncQueueFull is returned by a write that WithAsyncWrites couldn't queue
+// before its timeout, because the queue stayed full.
+var ErrAsyncQueueFull = errors.New("async write queue is full")
+
+// OpError is what every error returned by a Provider method, its AdminClient
+// and the types they hand out is wrapped in, so they all read
+// "chi-ratelimit-redis: <operation>: <cause>" and are easy to find in logs.
+// The cause is kept for errors.Is and errors.As.
+type OpError struct {
+	// Op is the operation that failed, like "get", "put", "reset" or
+	// "consume".
+	Op string
+
+	// Key is the key that the operation was called with, or empty if it
+	// wasn't called with one. It's only part of the error text with
+	// WithVerboseErrors.
+	Key string
+
+	// Err is the cause.
+	Err error
+
+	verbose bool
+}
+
+func (e *OpError) Error() string {
+	if e.verbose && e.Key != "" {
+		return "chi-ratelimit-redis: " + e.Op + " " + strconv.Quote(e.Key) + ": " + e.Err.Error()
+	}
+
+	return "chi-ratelimit-redis: " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// WithVerboseErrors puts the key that an operation was called with into the
+// text of its errors. Keys are often IP addresses or account IDs, so they're
+// left out by default; OpError.Key always has them.
+func WithVerboseErrors() func(o *options) {
+	return func(o *options) {
+		o.verboseErrors = true
+	}
+}
+
+// hasErrorPrefix returns true if err is an error reply from Redis that starts
+// with the given prefix, like "WRONGTYPE".
+func hasErrorPrefix(err error, prefix string) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(redisErr.Error(), prefix)
+}
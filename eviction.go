@@ -0,0 +1,195 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// evictionCanaries is how many canary keys WithEvictionDetection writes.
+const evictionCanaries = 8
+
+// EvictionEvent describes the evictions that WithEvictionDetection noticed.
+type EvictionEvent struct {
+	// CanariesLost is how many of the canary keys disappeared since the last
+	// check, out of Canaries.
+	CanariesLost int
+	Canaries     int
+
+	// EvictedKeys is how many keys the server evicted since the last check,
+	// from INFO's evicted_keys, including keys that aren't this Provider's.
+	// It's -1 if INFO couldn't be read.
+	EvictedKeys int64
+}
+
+// evictionDetector holds what WithEvictionDetection needs.
+type evictionDetector struct {
+	fn     func(EvictionEvent)
+	budget float64
+
+	// pressure holds the bits of the float64 that EvictionPressure returns.
+	pressure atomic.Uint64
+}
+
+// WithEvictionDetection notices when Redis evicts keys under memory pressure,
+// like with an allkeys-lru policy, which would otherwise look like windows that
+// ended and silently hand out fresh budgets. It writes a few canary keys
+// ("{<prefix>}:canary:<n>") that nothing else touches, so LRU eviction picks
+// them before busy ratelimits, and checks every interval whether they're still
+// there, along with INFO's evicted_keys. fn is called when canaries went
+// missing, and they're written again after every check. The check stops when
+// the Provider is closed.
+func WithEvictionDetection(interval time.Duration, fn func(EvictionEvent)) func(o *options) {
+	return func(o *options) {
+		o.evictionInterval = interval
+		o.evictionCallback = fn
+	}
+}
+
+// WithEvictionBudget makes windows that start while EvictionPressure is above
+// zero get only the given fraction (from 0 to 1) of their limit, but at least
+// one request, so a ratelimit that was evicted doesn't start over with its
+// whole budget. It needs WithEvictionDetection.
+func WithEvictionBudget(fraction float64) func(o *options) {
+	return func(o *options) {
+		o.evictionBudget = fraction
+	}
+}
+
+// EvictionPressure returns the fraction of canary keys that were lost in the
+// last check of WithEvictionDetection, from 0 to 1. It's always 0 without
+// WithEvictionDetection.
+func (p *Provider) EvictionPressure() float64 {
+	if p.evictions == nil {
+		return 0
+	}
+
+	return math.Float64frombits(p.evictions.pressure.Load())
+}
+
+func (p *Provider) canaryKeys() []string {
+	keys := make([]string, evictionCanaries)
+	for i := range keys {
+		keys[i] = p.companionKey("canary", strconv.Itoa(i))
+	}
+
+	return keys
+}
+
+// writeCanaries writes every canary key.
+func (p *Provider) writeCanaries(ctx context.Context) error {
+	pairs := make([]interface{}, 0, 2*evictionCanaries)
+	for _, key := range p.canaryKeys() {
+		pairs = append(pairs, key, p.instanceID)
+	}
+
+	return p.client.MSet(ctx, pairs...).Err()
+}
+
+// evictedKeys returns INFO's evicted_keys.
+func (p *Provider) evictedKeys(ctx context.Context) (int64, error) {
+	info, err := p.client.Info(ctx, "stats").Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(infoField(info, "evicted_keys"), 10, 64)
+}
+
+// detectEvictions runs until the Provider is closed.
+func (p *Provider) detectEvictions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	previous, err := p.evictedKeys(ctx)
+	cancel()
+	if err != nil {
+		previous = -1
+	}
+
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			previous = p.checkEvictions(interval, previous)
+		}
+	}
+}
+
+// checkEvictions does a single eviction check and returns the server's
+// evicted_keys now, or -1 if it couldn't be read.
+func (p *Provider) checkEvictions(timeout time.Duration, previous int64) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	existing, err := p.client.Exists(ctx, p.canaryKeys()...).Result()
+	if err != nil {
+		p.reportError("detect_evictions", err)
+		return previous
+	}
+
+	event := EvictionEvent{CanariesLost: evictionCanaries - int(existing), Canaries: evictionCanaries, EvictedKeys: -1}
+	current, err := p.evictedKeys(ctx)
+	if err != nil {
+		current = -1
+	} else if previous >= 0 {
+		event.EvictedKeys = current - previous
+	}
+
+	pressure := float64(event.CanariesLost) / float64(evictionCanaries)
+	p.evictions.pressure.Store(math.Float64bits(pressure))
+	if event.CanariesLost > 0 {
+		p.evictions.fn(event)
+	}
+
+	if err := p.writeCanaries(ctx); err != nil {
+		p.reportError("detect_evictions", err)
+	}
+
+	return current
+}
+
+// evictionLimit returns the limit that a window starting now gets with
+// WithEvictionBudget, which is never less than one.
+func (p *Provider) evictionLimit(limit int32) int32 {
+	if p.evictions == nil || p.evictions.budget <= 0 || p.EvictionPressure() == 0 {
+		return limit
+	}
+
+	reduced := int32(math.Floor(float64(limit) * p.evictions.budget))
+	if reduced < 1 {
+		return 1
+	}
+
+	if reduced > limit {
+		return limit
+	}
+
+	return reduced
+}
@@ -0,0 +1,125 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Command server is a small HTTP server with a chi router that limits every
+// request with this Provider, and has the redisadmin routes mounted under
+// /admin:
+//
+//	go run -tags redisdev ./examples/server -listen :3030
+//	curl -i localhost:3030/
+//	curl -X DELETE localhost:3030/admin/ratelimits/127.0.0.1
+//
+// Without -addr it uses NewDev, so it doesn't need a Redis server; that needs
+// the redisdev build tag. -headers picks the style of the ratelimit headers,
+// "legacy" or "draft", and -fail-closed rejects requests while Redis can't be
+// reached instead of letting them through.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	var (
+		listen     = flag.String("listen", ":3030", "address to listen on")
+		addr       = flag.String("addr", "", "address of the Redis server; an in-process emulation is used if empty")
+		limit      = flag.Int64("limit", 10, "requests allowed per window")
+		window     = flag.Duration("window", time.Minute, "length of a window")
+		headers    = flag.String("headers", "legacy", `style of the ratelimit headers, "legacy" or "draft"`)
+		failClosed = flag.Bool("fail-closed", false, "reject requests while Redis can't be reached")
+	)
+
+	flag.Parse()
+
+	l := limits{limit: *limit, window: *window}
+	switch *headers {
+	case "legacy":
+	case "draft":
+		l.style = redis.HeaderStyleDraft
+	default:
+		log.Fatalf("unknown header style %q", *headers)
+	}
+
+	if *failClosed {
+		l.policy = redis.FailClosed
+	}
+
+	provider, err := newProvider(*addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := &http.Server{Addr: *listen, Handler: newRouter(provider, l)}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		shutdown(shutdownCtx, server, provider)
+	}()
+
+	log.Printf("listening on %s", *listen)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	<-done
+}
+
+// shutdown stops taking requests first, then lets the Provider finish what it
+// still does in the background before its client is closed.
+func shutdown(ctx context.Context, server *http.Server, provider *redis.Provider) {
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutting down the server: %v", err)
+	}
+
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Printf("shutting down the provider: %v", err)
+	}
+}
+
+// newProvider connects to the given Redis server, or creates a development
+// Provider if addr is empty.
+func newProvider(addr string) (*redis.Provider, error) {
+	if addr == "" {
+		return newDevProvider()
+	}
+
+	return redis.New(
+		redis.WithClient(goredis.NewClient(&goredis.Options{Addr: addr})),
+		redis.WithKeyPrefix("example"),
+	)
+}
@@ -0,0 +1,178 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, server *miniredis.Miniredis) *goredis.Client {
+	t.Helper()
+
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func request(t *testing.T, handler http.Handler, method, path, client string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Real-IP", client)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestServer(t *testing.T) {
+	server := miniredis.RunT(t)
+	provider, err := redis.New(redis.WithClient(newTestClient(t, server)), redis.WithKeyPrefix("example"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	legacy := newRouter(provider, limits{limit: 2, window: time.Minute})
+	draft := newRouter(provider, limits{limit: 2, window: time.Minute, style: redis.HeaderStyleDraft})
+
+	for i, want := range []struct {
+		code      int
+		remaining string
+	}{{http.StatusOK, "1"}, {http.StatusOK, "0"}, {http.StatusTooManyRequests, "0"}} {
+		res := request(t, legacy, http.MethodGet, "/", "10.0.0.1")
+		if res.Code != want.code || res.Header().Get("X-RateLimit-Remaining") != want.remaining || res.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Fatalf("request %d = %d with %v; want %d with %s remaining", i+1, res.Code, res.Header(), want.code, want.remaining)
+		}
+
+		if rejected := want.code == http.StatusTooManyRequests; rejected != (res.Header().Get("Retry-After") != "") {
+			t.Fatalf("request %d has a Retry-After of %q", i+1, res.Header().Get("Retry-After"))
+		}
+	}
+
+	res := request(t, draft, http.MethodGet, "/", "10.0.0.2")
+	if res.Code != http.StatusOK || res.Header().Get("RateLimit-Remaining") != "1" || res.Header().Get("RateLimit-Reset") != "60" || res.Header().Get("X-RateLimit-Remaining") != "" {
+		t.Fatalf("a request with draft headers = %d with %v", res.Code, res.Header())
+	}
+
+	// Resetting a client through the admin routes lets it in again.
+	if res := request(t, legacy, http.MethodDelete, "/admin/ratelimits/10.0.0.1", "10.0.0.1"); res.Code != http.StatusOK {
+		t.Fatalf("DELETE /admin/ratelimits/10.0.0.1 = %d: %s", res.Code, res.Body)
+	}
+
+	if res := request(t, legacy, http.MethodGet, "/", "10.0.0.1"); res.Code != http.StatusOK || res.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Fatalf("a request after the reset = %d with %v", res.Code, res.Header())
+	}
+}
+
+func TestServerOutage(t *testing.T) {
+	server := miniredis.RunT(t)
+	provider, err := redis.New(redis.WithClient(newTestClient(t, server)), redis.WithOperationTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	open := newRouter(provider, limits{limit: 1, window: time.Minute})
+	closed := newRouter(provider, limits{limit: 1, window: time.Minute, policy: redis.FailClosed})
+
+	request(t, open, http.MethodGet, "/", "10.0.0.1")
+	server.Close()
+
+	// Requests over the limit go through while Redis can't say otherwise.
+	res := request(t, open, http.MethodGet, "/", "10.0.0.1")
+	if res.Code != http.StatusOK || res.Header().Get("X-RateLimit-Remaining") != "" {
+		t.Fatalf("a request during the outage = %d with %v; want it through without headers", res.Code, res.Header())
+	}
+
+	res = request(t, closed, http.MethodGet, "/", "10.0.0.2")
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "1" {
+		t.Fatalf("a request during the outage that fails closed = %d with %v", res.Code, res.Header())
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+
+	if res := request(t, open, http.MethodGet, "/", "10.0.0.1"); res.Code != http.StatusTooManyRequests {
+		t.Fatalf("a request over the limit after the outage = %d", res.Code)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	var notified atomic.Bool
+	provider, err := redis.New(redis.WithClient(newTestClient(t, server)), redis.WithThresholdCallback(1, func(string, *types.Ratelimit) {
+		time.Sleep(50 * time.Millisecond)
+		notified.Store(true)
+	}))
+
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpServer := &http.Server{Handler: newRouter(provider, limits{limit: 1, window: time.Minute})}
+	served := make(chan error, 1)
+	go func() { served <- httpServer.Serve(listener) }()
+
+	res, err := http.Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET /: %v", err)
+	}
+
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET / = %d", res.StatusCode)
+	}
+
+	// The request used up the limit, so its notification is still running in
+	// the background; shutting down waits for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	shutdown(ctx, httpServer, provider)
+	if !notified.Load() {
+		t.Fatal("shutdown returned before the background work of the provider was done")
+	}
+
+	if err := <-served; err != http.ErrServerClosed {
+		t.Fatalf("Serve = %v, want http.ErrServerClosed", err)
+	}
+
+	if _, err := http.Get("http://" + listener.Addr().String() + "/"); err == nil {
+		t.Fatal("the server still takes requests after shutting down")
+	}
+}
@@ -0,0 +1,100 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit-redis/redisadmin"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// limits is how the router limits requests.
+type limits struct {
+	limit  int64
+	window time.Duration
+	style  redis.HeaderStyle
+	policy redis.FailurePolicy
+}
+
+// newRouter returns the chi router of the server: the redisadmin routes under
+// /admin, and everything else limited per client address.
+func newRouter(provider *redis.Provider, l limits) http.Handler {
+	router := chi.NewRouter()
+	router.Use(middleware.RealIP)
+
+	router.Mount("/admin", redisadmin.New(provider, redisadmin.WithErrorHandler(func(r *http.Request, err error) {
+		log.Printf("admin: %s %s: %v", r.Method, r.URL.Path, err)
+	})))
+
+	router.Group(func(r chi.Router) {
+		r.Use(ratelimited(provider, l))
+		r.Get("/", func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("hello\n"))
+		})
+	})
+
+	return router
+}
+
+// ratelimited is the middleware that counts every request with Consume. The
+// middleware of chi-ratelimit can't be used for this: it panics when the
+// Provider fails, and never rejects the requests that NewConsumeAdapter counts,
+// see its doc. When Redis can't be reached, the policy decides, and the request
+// goes through without headers if it's allowed.
+func ratelimited(provider *redis.Provider, l limits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			decision, err := provider.Consume(clientKey(r), l.limit, l.window)
+			if err != nil {
+				log.Printf("ratelimit: %v", err)
+				decision = redis.DecideOnError(err, l.policy, nil)
+			}
+
+			if !decision.Degraded || !decision.Allowed {
+				decision.SetHeaders(w.Header(), l.style)
+			}
+
+			if !decision.Allowed {
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey is the address of the client, which RealIP took from X-Real-IP or
+// X-Forwarded-For if there is one.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
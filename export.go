@@ -0,0 +1,201 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"io"
+	"time"
+)
+
+const (
+	// ExportVersion is the version of the format that Snapshot.Export writes.
+	// It changes whenever a record field is removed or changes its meaning;
+	// Import keeps reading every version before it.
+	ExportVersion = 1
+
+	// exportFormat is the Format of every ExportHeader.
+	exportFormat = "chi-ratelimit-redis"
+
+	// exportMaxLine is the longest line that Import reads.
+	exportMaxLine = 1 << 20
+)
+
+// ExportHeader is the first line of a dump written by Snapshot.Export, which
+// says how the lines after it are encoded.
+type ExportHeader struct {
+	// Format is always "chi-ratelimit-redis".
+	Format string `json:"format"`
+
+	// Version is the ExportVersion that the dump was written with.
+	Version int `json:"version"`
+
+	// Codec is how the records are encoded. Records never depend on the
+	// codec, compression or wire format that the ratelimits were stored in;
+	// it's always "json" for now.
+	Codec string `json:"codec"`
+
+	// Layout is how the ratelimits were stored, which is "hash" for the hash
+	// under the key prefix.
+	Layout string `json:"layout"`
+
+	// CreatedAt is when the snapshot was taken.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Count is how many records follow the header.
+	Count int `json:"count"`
+}
+
+// exportRecord is a single ratelimit in a version 1 dump.
+type exportRecord struct {
+	Key       string    `json:"key"`
+	Limit     int32     `json:"limit"`
+	Remaining int32     `json:"remaining"`
+	Global    bool      `json:"global"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// exportDecoders decodes the records of every format version that Import can
+// read, by version. Versions that change the records add a decoder here, and
+// keep the older ones.
+var exportDecoders = map[int]func(line []byte) (string, *types.Ratelimit, error){
+	1: decodeExportV1,
+}
+
+func decodeExportV1(line []byte) (string, *types.Ratelimit, error) {
+	var record exportRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return "", nil, err
+	}
+
+	return record.Key, &types.Ratelimit{
+		Limit:     record.Limit,
+		Remaining: record.Remaining,
+		Global:    record.Global,
+		ResetTime: record.ResetAt,
+	}, nil
+}
+
+// Export writes the snapshot to w as a header line followed by one line for
+// every ratelimit, sorted by key, each of them a JSON object. The same snapshot
+// is always written the same way, and the dump can be read by Import of this
+// and every later version of this package.
+func (s *Snapshot) Export(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	header := ExportHeader{
+		Format:    exportFormat,
+		Version:   ExportVersion,
+		Codec:     "json",
+		Layout:    "hash",
+		CreatedAt: s.TakenAt.UTC(),
+		Count:     len(s.entries),
+	}
+
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	for _, key := range s.Keys() {
+		rl := s.entries[key]
+		record := exportRecord{Key: key, Limit: rl.Limit, Remaining: rl.Remaining, Global: rl.Global, ResetAt: rl.ResetTime.UTC()}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// Import writes every ratelimit of a dump from Snapshot.Export, under the key
+// it was stored under, and returns how many it wrote. The whole dump is read
+// and checked before anything is written, so a dump that fails with
+// ErrExportFormat, like one of a newer version or a truncated one, writes
+// nothing. Ratelimits that are already stored for a key are replaced.
+func (a *AdminClient) Import(ctx context.Context, r io.Reader) (imported int, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "import", "")
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, exportMaxLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+
+		return 0, fmt.Errorf("%w: the dump is empty", ErrExportFormat)
+	}
+
+	var header ExportHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Format != exportFormat {
+		return 0, fmt.Errorf("%w: the dump doesn't start with a header", ErrExportFormat)
+	}
+
+	decode, ok := exportDecoders[header.Version]
+	if !ok {
+		return 0, fmt.Errorf("%w: version %d, this version of the package reads up to %d", ErrExportFormat, header.Version, ExportVersion)
+	}
+
+	if header.Codec != "json" || header.Layout != "hash" {
+		return 0, fmt.Errorf("%w: codec %q and layout %q", ErrExportFormat, header.Codec, header.Layout)
+	}
+
+	keys := make([]string, 0, header.Count)
+	entries := make([]*types.Ratelimit, 0, header.Count)
+	for scanner.Scan() {
+		key, rl, err := decode(scanner.Bytes())
+		if err != nil {
+			return 0, fmt.Errorf("%w: record %d: %v", ErrExportFormat, len(keys)+1, err)
+		}
+
+		keys, entries = append(keys, key), append(entries, rl)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(keys) != header.Count {
+		return 0, fmt.Errorf("%w: the header counts %d records, but there are %d", ErrExportFormat, header.Count, len(keys))
+	}
+
+	call := &callOptions{parent: p.maintenanceContext(ctx)}
+	for i, key := range keys {
+		data, err := p.encode(entries[i])
+		if err != nil {
+			return imported, err
+		}
+
+		if err := p.write(key, data, entries[i].ResetTime, call); err != nil {
+			return imported, err
+		}
+
+		imported++
+	}
+
+	return imported, nil
+}
@@ -0,0 +1,105 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportExportV1(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/export-v1.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	p, _ := newTestProvider(t, WithClock(func() time.Time { return createdAt }))
+
+	imported, err := p.Admin().Import(context.Background(), bytes.NewReader(fixture))
+	if err != nil || imported != 3 {
+		t.Fatalf("Import = %d, %v", imported, err)
+	}
+
+	snapshot, err := p.Admin().Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	want := map[string]struct {
+		limit, remaining int32
+		global           bool
+		resetAt          time.Time
+	}{
+		"api:10.0.0.1":  {10, 3, false, time.Date(2100, 1, 1, 0, 0, 0, 123456789, time.UTC)},
+		"global":        {2147483647, 2147483646, true, time.Date(2100, 6, 30, 12, 0, 0, 0, time.UTC)},
+		`route\:x:user`: {1, 0, false, time.Date(2099, 12, 31, 23, 59, 59, 500000000, time.UTC)},
+	}
+
+	for key, w := range want {
+		rl := snapshot.Get(key)
+		if rl == nil {
+			t.Fatalf("%q wasn't imported", key)
+		}
+
+		if rl.Limit != w.limit || rl.Remaining != w.remaining || rl.Global != w.global || !rl.ResetTime.Equal(w.resetAt) {
+			t.Errorf("%q = %+v, want %+v", key, rl, w)
+		}
+	}
+
+	// Exporting what was imported gives the exact same dump.
+	var exported bytes.Buffer
+	if err := snapshot.Export(&exported); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	if !bytes.Equal(exported.Bytes(), fixture) {
+		t.Fatalf("Export wrote\n%s\nwant\n%s", exported.Bytes(), fixture)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	record := `{"key":"k","limit":1,"remaining":1,"global":false,"reset_at":"2100-01-01T00:00:00Z"}` + "\n"
+	for name, dump := range map[string]string{
+		"empty":     "",
+		"no header": record,
+		"future":    `{"format":"chi-ratelimit-redis","version":99,"codec":"json","layout":"hash","count":1}` + "\n" + record,
+		"codec":     `{"format":"chi-ratelimit-redis","version":1,"codec":"msgpack","layout":"hash","count":1}` + "\n" + record,
+		"truncated": `{"format":"chi-ratelimit-redis","version":1,"codec":"json","layout":"hash","count":2}` + "\n" + record,
+		"garbage":   `{"format":"chi-ratelimit-redis","version":1,"codec":"json","layout":"hash","count":1}` + "\n{",
+	} {
+		p, server := newTestProvider(t)
+		imported, err := p.Admin().Import(context.Background(), strings.NewReader(dump))
+		if !errors.Is(err, ErrExportFormat) {
+			t.Errorf("%s: Import = %v, want ErrExportFormat", name, err)
+		}
+
+		if imported != 0 || len(server.Keys()) != 0 {
+			t.Errorf("%s: Import wrote %d ratelimits", name, imported)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// failClosedRetryAfter is the Retry-After of a request that is denied because
+// Redis couldn't be reached.
+const failClosedRetryAfter = time.Second
+
+// FailurePolicy is what DecideOnError does with a request when Redis couldn't
+// be reached.
+type FailurePolicy int
+
+const (
+	// FailOpen allows the request.
+	FailOpen FailurePolicy = iota
+
+	// FailClosed denies the request.
+	FailClosed
+
+	// FailWithLastKnown decides based on the last ratelimit that was seen for
+	// the key, which might be stale, and allows the request if there is none.
+	FailWithLastKnown
+)
+
+// DecideOnError returns the Decision for a request whose ratelimit couldn't be
+// read or written because of err, with Decision.Degraded set:
+//
+//   - A nil err or ErrNotFound isn't a failure, so the Decision is based on
+//     lastKnown (or allows the request if it's nil) and isn't degraded.
+//   - ErrReplicationLag means that the write happened, so the Decision is
+//     based on lastKnown too, but it's degraded.
+//   - Anything else, like a timeout, is decided by the policy.
+func DecideOnError(err error, policy FailurePolicy, lastKnown *types.Ratelimit) Decision {
+	now := time.Now()
+	switch {
+	case err == nil || errors.Is(err, ErrNotFound):
+		return newDecision(lastKnown, now)
+
+	case errors.Is(err, ErrReplicationLag):
+		decision := newDecision(lastKnown, now)
+		decision.Degraded = true
+
+		return decision
+	}
+
+	var decision Decision
+	switch {
+	case policy == FailClosed:
+		decision = Decision{RetryAfter: failClosedRetryAfter}
+
+	case policy == FailWithLastKnown && lastKnown != nil:
+		decision = newDecision(lastKnown, now)
+
+	default:
+		decision = Decision{Allowed: true}
+	}
+
+	decision.Degraded = true
+	return decision
+}
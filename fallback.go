@@ -0,0 +1,120 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// migrateFieldScript moves a ratelimit from the hash of the old prefix to the
+// one of the new prefix, unless the new one got a value of its own in the
+// meantime (or ARGV[3] is '1'), and returns whatever the new hash holds
+// afterwards.
+//
+// KEYS[1] = new hash, KEYS[2] = old hash
+// ARGV[1] = field, ARGV[2] = value read from the old hash, ARGV[3] = '1' to
+// replace the value of the new hash
+var migrateFieldScript = registerScript("migrate_field", `
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if not current or ARGV[3] == '1' then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	current = ARGV[2]
+end
+
+redis.call('HDEL', KEYS[2], ARGV[1])
+return current
+`)
+
+// fallbackPrefix is what WithFallbackPrefix configured.
+type fallbackPrefix struct {
+	hash  string
+	until time.Time
+}
+
+// WithFallbackPrefix makes reads that find nothing under the configured prefix
+// look under the old one as well until the given time, for changing the prefix
+// without a moment where every ratelimit is gone. Both are read in a single
+// pipeline. A ratelimit that's only found under the old prefix is moved to the
+// new one as it's read; if both have one, the new prefix wins. Writes only go
+// to the new prefix. Moved ratelimits get their reset index entry and field
+// TTL on their next write. After until, the old prefix isn't read anymore.
+//
+// For a prefix change without a deadline, see AdminClient.RenamePrefix.
+func WithFallbackPrefix(old string, until time.Time) func(o *options) {
+	return func(o *options) {
+		o.fallbackPrefix = old
+		o.fallbackUntil = until
+	}
+}
+
+// fetchWithFallback is fetchSource while WithFallbackPrefix is active.
+func (p *Provider) fetchWithFallback(ctx context.Context, hash, key string) (string, ReadSource, error) {
+	var current, old *redis.StringCmd
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		current = pipe.HGet(ctx, hash, key)
+		old = pipe.HGet(ctx, p.fallback.hash, key)
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+
+	for _, source := range p.readPath {
+		switch source {
+		case ReadPrimary:
+			if data, err := current.Result(); err == nil {
+				return data, ReadPrimary, nil
+			}
+
+		case ReadFallbackPrefix:
+			data, err := old.Result()
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+
+			if err != nil {
+				return "", "", err
+			}
+
+			// When the new prefix has a value too, the old one only wins if
+			// it comes first, and then it replaces the new one.
+			replace := ""
+			if current.Err() == nil {
+				replace = "1"
+			}
+
+			data, err = p.runScript(ctx, migrateFieldScript, []string{hash, p.fallback.hash}, key, data, replace).Text()
+			if err != nil {
+				return "", "", err
+			}
+
+			p.recordChange(hash, key, data, false)
+			return data, ReadFallbackPrefix, nil
+		}
+	}
+
+	return "", "", nil
+}
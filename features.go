@@ -0,0 +1,125 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "sort"
+
+// Features returns the identifiers of the optional behaviors that the Provider
+// was constructed with, sorted, like for auditing which services run which
+// features. Identifiers are kebab-case versions of the option names, like
+// "reset-index" for WithResetIndex, and never change once released; a
+// configured Algorithm other than FixedWindow shows up as "algorithm:" and its
+// name. Options that only tune a behavior, like timeouts or the key prefix,
+// aren't features.
+func (p *Provider) Features() []string {
+	return append([]string(nil), p.features...)
+}
+
+// enabledFeatures returns what Features returns for a Provider that was just
+// constructed with the given options.
+func (p *Provider) enabledFeatures(o *options) []string {
+	_, fixed := o.algorithm.(fixedWindow)
+	flags := []struct {
+		name    string
+		enabled bool
+	}{
+		{"abuse-score", o.abuseHalfLife > 0},
+		{"approximate-mode", o.approxWidth > 0 && o.approxDepth > 0},
+		{"async-backpressure-callback", o.asyncPressure != nil},
+		{"async-writes", o.asyncCapacity > 0},
+		{"auto-ban", o.abuseHalfLife > 0 && o.autoBanFor > 0},
+		{"auto-legacy-migration", o.autoLegacyMigration},
+		{"burst-bucket", o.burstBucket != nil},
+		{"calendar-windows", o.calendar.kind != calendarNone},
+		{"cardinality-estimate", o.cardinalityEstimate},
+		{"change-notifications", o.changeChannel != ""},
+		{"circuit-breaker", o.breakerFailures > 0},
+		{"clock-skew-tolerance", o.clockSkewTolerance > 0},
+		{"cold-start-ramp", o.coldStartRamp > 0},
+		{"compression", o.compression != nil},
+		{"credentials-provider", o.credentials != nil},
+		{"decision-log", o.decisionSink != nil},
+		{"derived-reset", p.derivedReset},
+		{"degradation-callback", o.degradedThreshold > 0 && o.degradedCallback != nil},
+		{"dry-run", o.dryRun},
+		{"eviction-detection", o.evictionInterval > 0 && o.evictionCallback != nil},
+		{"expiry-grace", o.expiryGrace > 0},
+		{"fallback-prefix", o.fallbackPrefix != ""},
+		{"field-ttl", p.fieldTTL},
+		{"first-seen-tracking", o.firstSeenTracking},
+		{"grace-requests", o.graceRequests > 0},
+		{"hash-func", o.hash != nil},
+		{"layout-rollout", o.layoutRollout != nil},
+		{"lenient-decoding", o.lenientDecoding},
+		{"limit-catalog", o.catalogKey != "" && o.catalogTier != nil},
+		{"local-cache", o.localCacheTTL > 0},
+		{"local-cache-bytes", o.localCacheBytes > 0},
+		{"maintenance-client", p.maintenance != nil},
+		{"max-staleness", o.maxStaleness > 0},
+		{"member-limit", o.pool != nil && o.pool.memberLimit > 0},
+		{"metrics-hook", o.metrics.Read != nil || o.metrics.PartialBatch != nil || o.metrics.LocalCache != nil || o.metrics.AsyncFlush != nil},
+		{"negative-cache", o.negativeCacheTTL > 0},
+		{"mirror-format", o.mirrorPrefix != ""},
+		{"no-scripting", o.noScripting},
+		{"penalty-backoff", o.penaltyBackoff != nil},
+		{"persistent-entries", o.persistentEntries},
+		{"pinned-scripts", o.pinnedScripts != nil},
+		{"put-deduplication", o.dedupWindow > 0},
+		{"rate-estimation", o.rateHalfLife > 0},
+		{"reset-index", o.resetIndex},
+		{"reset-jitter", o.resetJitter > 0},
+		{"round-trip-sampling", o.roundTripFn != nil},
+		{"restricted-commands", o.allowedCommands != nil},
+		{"rollover-callback", o.rolloverFn != nil},
+		{"sampled-writes", o.sampleEvery > 1},
+		{"schema-info", o.schemaInfo},
+		{"shared-health", o.sharedHealth != nil},
+		{"shared-pool", o.pool != nil},
+		{"stat-noise", o.statNoise > 0},
+		{"state-loss-detection", p.detectsStateLoss},
+		{"tenant-key-budget", o.tenantFn != nil && o.tenantMaxKeys > 0},
+		{"threshold-callback", o.threshold != nil},
+		{"tombstones", o.tombstoneTTL > 0},
+		{"tracing", o.tracer != nil},
+		{"unsafe-raw", o.unsafeRaw},
+		{"verbose-errors", o.verboseErrors},
+		{"window-created-at", o.windowCreatedAt},
+		{"windowed-keys", o.keyWindow > 0},
+		{"wire-format", o.wireFormat != nil},
+		{"write-concern", o.writeReplicas > 0},
+		{"write-verification", o.verifyRate > 0},
+	}
+
+	var features []string
+	for _, flag := range flags {
+		if flag.enabled {
+			features = append(features, flag.name)
+		}
+	}
+
+	if o.algorithm != nil && !fixed {
+		features = append(features, "algorithm:"+o.algorithm.Name())
+	}
+
+	sort.Strings(features)
+	return features
+}
@@ -0,0 +1,106 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// WithFieldTTL gives every ratelimit a field TTL (HPEXPIREAT) that ends when
+// its window resets, so Redis removes it on its own and a read after that is a
+// miss. It needs Redis 7.4 or newer, which New checks; on older servers New
+// fails, unless WithFieldTTLFallback is used too.
+func WithFieldTTL() func(o *options) {
+	return func(o *options) {
+		o.fieldTTL = true
+	}
+}
+
+// WithFieldTTLFallback makes New log a warning and keep ratelimits without a
+// field TTL instead of failing when WithFieldTTL is used with a server that
+// doesn't support them.
+func WithFieldTTLFallback() func(o *options) {
+	return func(o *options) {
+		o.fieldTTLFallback = true
+	}
+}
+
+// supportsFieldTTL asks the server for the field TTL of a field that doesn't
+// exist, which only servers with field TTLs understand.
+func (p *Provider) supportsFieldTTL(ctx context.Context) (bool, error) {
+	err := p.client.Do(ctx, "HPTTL", p.hashKey(), "FIELDS", 1, "__probe__").Err()
+	if err == nil {
+		return true, nil
+	}
+
+	if hasErrorPrefix(err, "ERR unknown command") {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// setupFieldTTL decides whether field TTLs are used, following
+// WithFieldTTLFallback if the server doesn't support them.
+func (p *Provider) setupFieldTTL(caps *Capabilities, fallback bool) error {
+	supported := caps.FieldTTL
+	if !supported {
+		if !fallback {
+			return fmt.Errorf("WithFieldTTL needs a server with hash field TTLs (Redis 7.4 or newer), but %s doesn't have them", caps.serverName())
+		}
+
+		if p.logf != nil {
+			p.logf("chi-ratelimit-redis: the server doesn't support hash field TTLs, so ratelimits are kept without one")
+		}
+	}
+
+	p.fieldTTL = supported
+	return nil
+}
+
+// expireFields makes the given fields of hash expire at resetAt, plus the grace
+// of WithExpiryGrace, when field TTLs are used. A zero resetAt leaves them
+// alone.
+func (p *Provider) expireFields(ctx context.Context, hash string, resetAt time.Time, fields ...string) error {
+	if !p.fieldTTL || resetAt.IsZero() {
+		return nil
+	}
+
+	if p.persistentEntries {
+		return p.expirePersistentFields(ctx, hash, resetAt.Add(p.expiryGrace), fields...)
+	}
+
+	args := []interface{}{"HPEXPIREAT", hash, resetAt.Add(p.expiryGrace).UnixMilli(), "FIELDS", len(fields)}
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	// Cmdable has no Do, but a pipeline of one command has.
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		return pipe.Do(ctx, args...).Err()
+	})
+
+	return err
+}
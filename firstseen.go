@@ -0,0 +1,52 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "time"
+
+// WithFirstSeenTracking makes Consume set Decision.FirstEver on the first
+// request that is ever counted for a key, like for sending a welcome webhook.
+// Every key is marked with when it was first seen in a hash next to the
+// ratelimits ("{<prefix>}:seen"), in the same script that counts the request,
+// so it costs no extra round trip; without scripting, it's one more command.
+// Marks outlive resets and expired windows, and are only removed when ResetAll
+// unlinks the whole hash.
+// FixedWindow and SlidingWindow support it.
+func WithFirstSeenTracking() func(o *options) {
+	return func(o *options) {
+		o.firstSeenTracking = true
+	}
+}
+
+func (p *Provider) seenKey() string {
+	return p.space.Load().seen
+}
+
+// markSeen marks the given storage key as seen, returning true if it wasn't
+// before.
+func (p *Provider) markSeen(key string) (bool, error) {
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	return p.cmd(ctx).HSetNX(ctx, p.seenKey(), key, p.now().UnixMilli()).Result()
+}
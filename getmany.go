@@ -0,0 +1,124 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// readChunkSize is how many keys every HMGET of GetMany and Prewarm asks for.
+const readChunkSize = 500
+
+// GetMany reads the ratelimits of the given keys like Peek, without counting a
+// request for them, with one HMGET per 500 keys. Ratelimits are returned in the
+// same order as keys, and nil for keys that don't have one. If only some of the
+// chunks failed, the keys of the others are still returned, and the error is a
+// *BatchError with an entry for every key that couldn't be read, whose ratelimit
+// is nil; with RequireComplete, nothing is returned if any key failed.
+func (p *Provider) GetMany(keys []string, opts ...CallOption) (rls []*types.Ratelimit, err error) {
+	defer p.recoverPanic(&err, "get_many", "")
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	call := newCallOptions(opts)
+	parent := context.Background()
+	if call != nil && call.parent != nil {
+		parent = call.parent
+	}
+
+	rls = make([]*types.Ratelimit, len(keys))
+	failed := p.readMany(parent, keys, call, func(i int, field, data string) {
+		if rl, err := p.decode(data); err == nil {
+			rls[i] = p.clampRead(rl)
+		}
+	})
+
+	p.reportPartial("get_many", call, failed.Len(), len(keys))
+	if failed.Len() == len(keys) || (call.completeRequired() && failed.Len() > 0) {
+		return nil, failed.err()
+	}
+
+	return rls, failed.err()
+}
+
+// readMany reads the given keys in chunks of readChunkSize, calling fn with the
+// index, storage key and stored value of every key that has a ratelimit. Every
+// chunk has a read timeout of its own; the keys of chunks that failed are in
+// the returned BatchError. ctx is checked between chunks, and the keys of the
+// chunks that weren't read anymore fail with its error.
+func (p *Provider) readMany(ctx context.Context, keys []string, call *callOptions, fn func(i int, field, data string)) *BatchError {
+	failed := &BatchError{verbose: p.verboseErrors}
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = p.storageKey(p.pooledKey(key))
+	}
+
+	for start := 0; start < len(fields); start += readChunkSize {
+		end := start + readChunkSize
+		if end > len(fields) {
+			end = len(fields)
+		}
+
+		values, err := p.readChunk(ctx, fields[start:end], call)
+		if err == nil {
+			err = ctx.Err()
+		}
+
+		if err != nil {
+			for i := start; i < end; i++ {
+				failed.add(i, keys[i], err)
+			}
+
+			continue
+		}
+
+		for j, value := range values {
+			if data, ok := value.(string); ok {
+				fn(start+j, fields[start+j], data)
+			}
+		}
+	}
+
+	return failed
+}
+
+func (p *Provider) readChunk(ctx context.Context, fields []string, call *callOptions) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	chunk := callOptions{}
+	if call != nil {
+		chunk = *call
+	}
+
+	chunk.parent = ctx
+	ctx, cancel := p.readContextFor(&chunk)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	return p.cmd(ctx).HMGet(ctx, p.hashKey(), fields...).Result()
+}
@@ -0,0 +1,68 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestGetMany(t *testing.T) {
+	p, server := newTestProvider(t)
+
+	keys := make([]string, 1200)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+		if i%2 == 0 {
+			putAll(t, p, keys[i])
+		}
+	}
+
+	before := server.CommandCount()
+	rls, err := p.GetMany(keys)
+	if err != nil || len(rls) != len(keys) {
+		t.Fatalf("GetMany = %d ratelimits, %v", len(rls), err)
+	}
+
+	for i, rl := range rls {
+		if (rl != nil) != (i%2 == 0) || (rl != nil && rl.Remaining != 10) {
+			t.Fatalf("GetMany returned %+v for %s", rl, keys[i])
+		}
+	}
+
+	// Nothing is written back, unlike Get.
+	if commands := server.CommandCount() - before; commands != 3 {
+		t.Fatalf("GetMany ran %d commands, want 3 chunks", commands)
+	}
+}
+
+func TestGetManyFailed(t *testing.T) {
+	p, server := newTestProvider(t)
+	server.Close()
+
+	rls, err := p.GetMany([]string{"a", "b"})
+	var batchErr *BatchError
+	if rls != nil || !errors.As(err, &batchErr) || batchErr.Len() != 2 {
+		t.Fatalf("GetMany on a server that is down = %v, %v; want nothing and both keys failed", rls, err)
+	}
+}
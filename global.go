@@ -0,0 +1,254 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// globalShards is how many counters a GlobalLimiter spreads its count over.
+const globalShards = 8
+
+// globalConsumeScript counts n requests in one shard of a global limit, if the
+// sum of every shard leaves room for them. It returns whether they were counted
+// and the total from before.
+//
+// KEYS = the shard counters of the current window
+// ARGV[1] = index of the shard to count in (from 1), ARGV[2] = n,
+// ARGV[3] = limit, ARGV[4] = end of the window in Unix milliseconds
+var globalConsumeScript = registerScript("global_consume", `
+local total = 0
+for _, key in ipairs(KEYS) do
+	total = total + tonumber(redis.call('GET', key) or '0')
+end
+
+if total + tonumber(ARGV[2]) > tonumber(ARGV[3]) then
+	return { 0, total }
+end
+
+local shard = KEYS[tonumber(ARGV[1])]
+redis.call('INCRBY', shard, ARGV[2])
+redis.call('PEXPIREAT', shard, ARGV[4])
+return { 1, total }
+`)
+
+// GlobalLimiter is a limit on the total throughput of everything that consumes
+// from it, like "the whole API serves at most 50000 requests a second", shared
+// by every Provider with the same key prefix.
+type GlobalLimiter struct {
+	provider *Provider
+	name     string
+	limit    int64
+	window   time.Duration
+}
+
+// GlobalLimiter returns the global limit with the given name. Its count is
+// spread over 8 counters per fixed window ("{<prefix>}:global:<name>:<window
+// start>:<n>"), so no single key takes every write, and a script sums them up
+// to check the limit. Windows are aligned to multiples of window since the Unix
+// epoch.
+func (p *Provider) GlobalLimiter(name string, limit int64, window time.Duration) *GlobalLimiter {
+	return &GlobalLimiter{provider: p, name: name, limit: limit, window: window}
+}
+
+// Consume counts n requests against the global limit, if they all fit.
+func (g *GlobalLimiter) Consume(n int64) (decision Decision, err error) {
+	p := g.provider
+	defer p.recoverPanic(&err, "global_consume", "")
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	keys, args := g.scriptArgs(n, now)
+	result, err := p.runScript(ctx, globalConsumeScript, keys, args...).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return g.decision(result[0] == 1, result[1], n, now), nil
+}
+
+// ConsumeWithGlobal counts a request for the given key with ConsumeSliding and
+// one against global, in a single pipelined round trip. Each one is counted on
+// its own, so a request that one of them rejects still counts towards the
+// other; the request should only go through if both Decisions allow it. The key
+// goes through everything ConsumeSliding does, like pools, WithGraceRequests,
+// penalties and WithFirstSeenTracking; members of a pool with a member limit
+// are counted in a round trip of their own, with global counted after.
+func (p *Provider) ConsumeWithGlobal(key string, limit int64, window time.Duration, global *GlobalLimiter) (keyDecision, globalDecision Decision, err error) {
+	if p.noScripting {
+		return Decision{}, Decision{}, scriptingDisabled(globalConsumeScript).Err()
+	}
+
+	combined := &withGlobal{global: global}
+	keyDecision, err = p.consumeWith(combined, key, AlgorithmParams{Limit: limit, Window: window})
+	if combined.decision == nil && err == nil {
+		globalDecision, err = global.Consume(1)
+		return keyDecision, globalDecision, err
+	}
+
+	if combined.decision != nil {
+		globalDecision = *combined.decision
+	}
+
+	return keyDecision, globalDecision, err
+}
+
+// withGlobal is SlidingWindow with a request for a GlobalLimiter pipelined
+// along, for ConsumeWithGlobal. It keeps the Decision of the global limit once
+// it's counted.
+type withGlobal struct {
+	slidingWindow
+	global   *GlobalLimiter
+	decision *Decision
+}
+
+func (w *withGlobal) Scripts() map[string]string {
+	return map[string]string{
+		slidingConsumeScript.name: slidingConsumeScript.source,
+		globalConsumeScript.name:  globalConsumeScript.source,
+	}
+}
+
+func (w *withGlobal) Consume(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	slidingKeys := []string{p.slidingKey(key)}
+	if p.firstSeenTracking {
+		slidingKeys = append(slidingKeys, p.seenKey())
+	}
+
+	globalKeys, globalArgs := w.global.scriptArgs(1, now)
+
+	var keyCmd, globalCmd *redis.Cmd
+	send := func(sliding, global bool) error {
+		pipe := p.cmd(ctx).Pipeline()
+		if sliding {
+			keyCmd = p.queueScript(ctx, pipe, slidingConsumeScript, slidingKeys, scriptLimit(params.Limit), params.Window.Milliseconds(), now.UnixMilli(), key)
+		}
+
+		if global {
+			globalCmd = p.queueScript(ctx, pipe, globalConsumeScript, globalKeys, globalArgs...)
+		}
+
+		var redisErr redis.Error
+		if _, err := pipe.Exec(ctx); err != nil && !errors.As(err, &redisErr) {
+			return err
+		}
+
+		return nil
+	}
+
+	if err := send(true, true); err != nil {
+		return Decision{}, err
+	}
+
+	// Scripts that Redis didn't know yet weren't run at all, so they can be
+	// loaded and sent again. The other one already counted.
+	missingKey, missingGlobal := noScript(keyCmd), noScript(globalCmd)
+	if missingKey || missingGlobal {
+		for _, s := range []struct {
+			script  *script
+			missing bool
+		}{{slidingConsumeScript, missingKey}, {globalConsumeScript, missingGlobal}} {
+			if !s.missing {
+				continue
+			}
+
+			if err := p.loadScript(ctx, s.script); err != nil {
+				return Decision{}, err
+			}
+		}
+
+		if err := send(missingKey, missingGlobal); err != nil {
+			return Decision{}, err
+		}
+	}
+
+	keyResult, err := keyCmd.Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	globalResult, err := globalCmd.Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	globalDecision := w.global.decision(globalResult[0] == 1, globalResult[1], 1, now)
+	w.decision = &globalDecision
+
+	decision := newSlidingDecision(keyResult[0] == 1, keyResult[1], keyResult[2], params.Limit, params.Window, now)
+	decision.FirstEver = len(keyResult) > 3 && keyResult[3] == 1
+	p.logDecision(key, slidingConsumeScript.Hash(), params.Window, decision, now)
+	return decision, nil
+}
+
+// scriptArgs returns the keys and arguments of globalConsumeScript for
+// counting n requests at now, in a random shard.
+func (g *GlobalLimiter) scriptArgs(n int64, now time.Time) ([]string, []interface{}) {
+	start := windowStart(now, g.window)
+	prefix := g.name + ":" + strconv.FormatInt(start.UnixMilli(), 10) + ":"
+
+	keys := make([]string, globalShards)
+	for i := range keys {
+		keys[i] = g.provider.companionKey("global", prefix+strconv.Itoa(i))
+	}
+
+	return keys, []interface{}{rand.Intn(globalShards) + 1, n, scriptLimit(g.limit), start.Add(g.window).UnixMilli()}
+}
+
+func (g *GlobalLimiter) decision(allowed bool, total, n int64, now time.Time) Decision {
+	resetAt := windowStart(now, g.window).Add(g.window)
+	if allowed {
+		total += n
+	}
+
+	decision := Decision{
+		Allowed:    allowed,
+		Limit:      g.limit,
+		Remaining:  g.limit - total,
+		Window:     g.window,
+		PolicyName: g.name,
+		ResetAt:    resetAt,
+		ResetAfter: resetAt.Sub(now),
+	}
+
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+
+	if !allowed {
+		decision.RetryAfter = decision.ResetAfter
+	}
+
+	return decision
+}
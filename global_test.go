@@ -0,0 +1,112 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlobalLimiter(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	now := start
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	global := p.GlobalLimiter("api", 10, time.Minute)
+	consume := func(n int64, allowed bool, remaining int64) {
+		t.Helper()
+
+		decision, err := global.Consume(n)
+		if err != nil {
+			t.Fatalf("Consume(%d): %v", n, err)
+		}
+
+		if decision.Allowed != allowed || decision.Remaining != remaining || decision.PolicyName != "api" {
+			t.Fatalf("Consume(%d) = %+v; want allowed %t, %d remaining", n, decision, allowed, remaining)
+		}
+	}
+
+	// The shards are summed up, whichever of them a request is counted in.
+	for i := int64(1); i <= 7; i++ {
+		consume(1, true, 10-i)
+	}
+
+	// More than what's left is rejected as a whole and not counted.
+	consume(4, false, 3)
+	consume(3, true, 0)
+	consume(1, false, 0)
+
+	// Every counter expires with its window.
+	keys, _ := global.scriptArgs(1, start)
+	for _, key := range keys {
+		if server.Exists(key) && server.TTL(key) != time.Minute {
+			t.Fatalf("%s has a TTL of %s", key, server.TTL(key))
+		}
+	}
+
+	// Right at the end of the window, a new one starts.
+	now = start.Add(time.Minute)
+	server.SetTime(now)
+	consume(10, true, 0)
+}
+
+func TestGlobalLimiterEpochAligned(t *testing.T) {
+	// 7s doesn't divide the time between year 1 and the Unix epoch, so
+	// windows truncated from the zero Time would be off.
+	now := time.Unix(1700000003, 0).In(time.FixedZone("UTC+1", 3600))
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	decision, err := p.GlobalLimiter("api", 10, 7*time.Second).Consume(1)
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	if decision.ResetAt.UnixMilli()%7000 != 0 || !decision.ResetAt.After(now) || decision.ResetAfter > 7*time.Second {
+		t.Fatalf("Consume resets at %v, want the next multiple of 7s since the Unix epoch", decision.ResetAt)
+	}
+}
+
+func TestConsumeWithGlobal(t *testing.T) {
+	now := time.Unix(1700000040, 0)
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }), WithGrace(1), WithFirstSeenTracking())
+	server.SetTime(now)
+
+	global := p.GlobalLimiter("api", 10, time.Minute)
+	first, globalDecision, err := p.ConsumeWithGlobal("a", 1, time.Minute, global)
+	if err != nil || !first.Allowed || !first.FirstEver || !globalDecision.Allowed || globalDecision.Remaining != 9 {
+		t.Fatalf("ConsumeWithGlobal = %+v, %+v, %v; want both allowed, the key seen first", first, globalDecision, err)
+	}
+
+	// The key's limit is used up, so its grace request is next; the global
+	// limit still counts it.
+	grace, globalDecision, err := p.ConsumeWithGlobal("a", 1, time.Minute, global)
+	if err != nil || !grace.Allowed || !grace.OverLimit || grace.FirstEver || globalDecision.Remaining != 8 {
+		t.Fatalf("ConsumeWithGlobal = %+v, %+v, %v; want a grace request, 8 left globally", grace, globalDecision, err)
+	}
+
+	rejected, globalDecision, err := p.ConsumeWithGlobal("a", 1, time.Minute, global)
+	if err != nil || rejected.Allowed || globalDecision.Remaining != 7 {
+		t.Fatalf("ConsumeWithGlobal = %+v, %+v, %v; want the key rejected, 7 left globally", rejected, globalDecision, err)
+	}
+}
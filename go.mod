@@ -3,11 +3,17 @@ module github.com/noelware/chi-ratelimit-redis
 go 1.19
 
 require (
+	github.com/alicebob/miniredis/v2 v2.23.0
+	github.com/go-chi/chi/v5 v5.0.8
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/golang/snappy v0.0.4
 	github.com/noelware/chi-ratelimit v0.0.3
+	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
+	go.uber.org/goleak v1.2.1
 )
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 )
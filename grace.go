@@ -0,0 +1,52 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// WithExpiryGrace makes Get treat a ratelimit whose window reset less than d
+// ago as still there, but without any requests remaining: it returns it with
+// its old reset time and zero remaining, and doesn't write anything. Instances
+// whose clocks are a few milliseconds apart then agree at window boundaries
+// instead of flapping between the old and the new window. Field TTLs set by
+// WithFieldTTL are extended by d so the entry is still there to read.
+//
+// Only Get is affected; the adapter returned by NewConsumeAdapter still starts
+// the next window as soon as the old one is over.
+func WithExpiryGrace(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.expiryGrace = d
+	}
+}
+
+// inExpiryGrace returns true if rl's window is over, but only for less than the
+// grace period.
+func (p *Provider) inExpiryGrace(rl *types.Ratelimit, now time.Time) bool {
+	if p.expiryGrace <= 0 || rl.ResetTime.IsZero() || rl.ResetTime.After(now) {
+		return false
+	}
+
+	return now.Sub(rl.ResetTime) < p.expiryGrace
+}
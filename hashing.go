@@ -0,0 +1,63 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "hash/fnv"
+
+// WithHashFunc replaces the hash that the Provider derives everything from
+// that depends on a key but isn't stored under it: the shard of the in-process
+// key state table, the counters of WithApproximateMode's sketches and the
+// offset of WithResetJitter. It's FNV-1a by default; a different one, like the
+// seeded xxhash64 of other services, keeps those decisions in line with them.
+// HashPick uses it for the shards of NewSharded too.
+//
+// Changing it re-shards everything that depends on it, since nothing is moved:
+// keys picked by HashPick start over on their new shard, sketches count keys
+// at new offsets, so their estimates start over as well, and jittered windows
+// end at a different offset from their next window on. It should only change
+// together with a migration, like the one that NewSharded's doc describes.
+func WithHashFunc(fn func(key string) uint64) func(o *options) {
+	return func(o *options) {
+		o.hash = fn
+	}
+}
+
+// fnv1a is the default hash of WithHashFunc.
+func fnv1a(key string) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+
+	return hash.Sum64()
+}
+
+// HashPick returns a pick function for NewSharded that spreads keys evenly
+// over the given amount of shards by the given hash, which is FNV-1a if it's
+// nil. Pass the hash of WithHashFunc so the shards agree with it.
+func HashPick(shards int, hash func(key string) uint64) func(key string) int {
+	if hash == nil {
+		hash = fnv1a
+	}
+
+	return func(key string) int {
+		return int(hash(key) % uint64(shards))
+	}
+}
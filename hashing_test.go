@@ -0,0 +1,85 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingHash struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *countingHash) hash(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls[key]++
+	return 7
+}
+
+func (c *countingHash) count(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[key]
+}
+
+func TestHashFunc(t *testing.T) {
+	counter := &countingHash{calls: map[string]int{}}
+	p, _ := newTestProvider(t, WithHashFunc(counter.hash), WithResetJitter(time.Second), WithApproximateMode(1024, 4))
+
+	if got := p.jitter("jittered"); got != 7 {
+		t.Fatalf("jitter = %v, want 7ns from the hash", got)
+	}
+
+	offsets, others := p.approxOffsets("sketched"), p.approxOffsets("other")
+	for i := range offsets {
+		if offsets[i] != others[i] {
+			t.Fatalf("approxOffsets differ for keys with the same hash: %v, %v", offsets, others)
+		}
+	}
+
+	p.states.update("state", true, func(s *keyState) { s.missingUntil = time.Now().Add(time.Minute) })
+	if counter.count("jittered") == 0 || counter.count("sketched") == 0 || counter.count("state") == 0 {
+		t.Fatalf("the hash wasn't used for every decision: %v", counter.calls)
+	}
+
+	if entries := len(p.states.shards[7%keyStateShards].entries); entries != 1 {
+		t.Fatalf("the shard of the hash holds %d entries, want 1", entries)
+	}
+}
+
+func TestHashFuncDefault(t *testing.T) {
+	p, _ := newTestProvider(t, WithResetJitter(time.Hour))
+	if got, want := p.jitter("key"), time.Duration(fnv1a("key")%uint64(time.Hour)); got != want {
+		t.Fatalf("jitter = %v, want %v from FNV-1a", got, want)
+	}
+
+	pick := HashPick(3, nil)
+	if got, want := pick("key"), int(fnv1a("key")%3); got != want {
+		t.Fatalf("HashPick = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+// ClientHealth is the circuit breaker of a Redis client, which every Provider
+// constructed with it through WithSharedHealth shares. When one of them sees
+// the client fail, the others fail fast too, instead of each one finding out
+// on its own. Commands that other code sends with the client aren't affected.
+//
+// After the given amount of transport failures in a row, like timeouts or
+// refused connections, the breaker opens: every command fails with an error
+// matching ErrCircuitOpen without being sent. Once the cooldown is over, a
+// single command is let through to probe the client, which closes the breaker
+// again if it succeeds. Replies from Redis, even error replies like NOSCRIPT,
+// and running out of free connections, see ErrClientSaturated, aren't failures
+// of the client.
+type ClientHealth struct {
+	client   *redis.Client
+	failures int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	failed    int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewClientHealth returns a ClientHealth for the given client, which opens
+// after the given amount of transport failures in a row and stays open for
+// the cooldown.
+func NewClientHealth(client *redis.Client, failures int, cooldown time.Duration) *ClientHealth {
+	return &ClientHealth{client: client, failures: failures, cooldown: cooldown}
+}
+
+// WithCircuitBreaker gives the Provider a circuit breaker of its own, which
+// works like the one of a ClientHealth but only sees the Provider's own
+// commands; see WithSharedHealth to share one.
+func WithCircuitBreaker(failures int, cooldown time.Duration) func(o *options) {
+	return func(o *options) {
+		o.breakerFailures = failures
+		o.breakerCooldown = cooldown
+	}
+}
+
+// WithSharedHealth makes the Provider use the circuit breaker of the given
+// ClientHealth, and its client unless WithClient is used with the same one.
+func WithSharedHealth(h *ClientHealth) func(o *options) {
+	return func(o *options) {
+		o.sharedHealth = h
+	}
+}
+
+// Health returns the ClientHealth of the Provider's circuit breaker, or nil if
+// it has none.
+func (p *Provider) Health() *ClientHealth {
+	return p.health
+}
+
+// Available returns false while the breaker is open and its cooldown isn't over.
+func (h *ClientHealth) Available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.failed < h.failures || !time.Now().Before(h.openUntil)
+}
+
+// circuitOpenError is the error of a command that the open breaker didn't let
+// through. It matches ErrCircuitOpen and ErrUnavailable.
+type circuitOpenError struct {
+	until time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%v: %v until %s", ErrUnavailable, ErrCircuitOpen, e.until.Format(time.RFC3339Nano))
+}
+
+func (e *circuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen || target == ErrUnavailable
+}
+
+// allow returns an error if a command can't be sent right now. Once the
+// cooldown is over, only the one probing the client is let through until it
+// finished.
+func (h *ClientHealth) allow() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.failed < h.failures {
+		return nil
+	}
+
+	if time.Now().Before(h.openUntil) || h.probing {
+		return &circuitOpenError{until: h.openUntil}
+	}
+
+	h.probing = true
+	return nil
+}
+
+// observe counts the outcome of a command that was sent.
+func (h *ClientHealth) observe(err error) {
+	if errors.Is(err, ErrCircuitOpen) {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	probe := h.probing
+	h.probing = false
+	if !isTransportFailure(err) {
+		h.failed = 0
+		return
+	}
+
+	h.failed++
+	if h.failed >= h.failures && (probe || h.failed == h.failures) {
+		h.openUntil = time.Now().Add(h.cooldown)
+	}
+}
+
+// isTransportFailure returns true if err means the client couldn't talk to
+// Redis, rather than Redis replying with an error.
+func isTransportFailure(err error) bool {
+	var reply redis.Error
+	switch {
+	case err == nil, errors.Is(err, redis.Nil), errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &reply), isPoolTimeout(err):
+		return false
+	default:
+		return true
+	}
+}
+
+// healthHook runs every command of a Provider past its circuit breaker.
+type healthHook struct {
+	health *ClientHealth
+}
+
+func (h healthHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, h.health.allow()
+}
+
+func (h healthHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	h.health.observe(cmd.Err())
+	return nil
+}
+
+func (h healthHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, h.health.allow()
+}
+
+// AfterProcessPipeline counts a pipeline as one command, which failed if any of
+// its commands did.
+func (h healthHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	var failure error
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && (failure == nil || isTransportFailure(err)) {
+			failure = err
+		}
+	}
+
+	h.health.observe(failure)
+	return nil
+}
+
+// checkHealth validates WithCircuitBreaker and WithSharedHealth.
+func checkHealth(config *options) error {
+	h := config.sharedHealth
+	switch {
+	case config.breakerFailures < 0 || (config.breakerFailures > 0 && config.breakerCooldown <= 0):
+		return errors.New("WithCircuitBreaker needs a positive amount of failures and cooldown")
+
+	case h == nil:
+		return nil
+
+	case config.breakerFailures > 0:
+		return errors.New("WithCircuitBreaker can't be used with WithSharedHealth, whose breaker is shared")
+
+	case h.client == nil || h.failures <= 0 || h.cooldown <= 0:
+		return errors.New("WithSharedHealth needs a ClientHealth with a client, and a positive amount of failures and cooldown")
+
+	case config.client != h.client:
+		return errors.New("WithSharedHealth needs the client that its ClientHealth was made for")
+	}
+
+	return nil
+}
+
+// watchHealth puts the Provider's client behind its circuit breaker, if it has
+// one. The hook goes on a copy of the client, which shares its connections, so
+// the client itself and whatever else uses it are left alone.
+func (p *Provider) watchHealth(config *options) {
+	p.health = config.sharedHealth
+	if config.breakerFailures > 0 {
+		p.health = NewClientHealth(p.client, config.breakerFailures, config.breakerCooldown)
+	}
+
+	if p.health == nil {
+		return
+	}
+
+	p.client = p.client.WithContext(context.Background())
+	p.client.AddHook(healthHook{health: p.health})
+}
@@ -0,0 +1,207 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHealthClient returns a client of the given server that doesn't retry, so
+// every failed command is a single failure.
+func newHealthClient(t *testing.T, s *miniredis.Miniredis) *redis.Client {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func newHealthProvider(t *testing.T, opts ...func(o *options)) *Provider {
+	t.Helper()
+
+	p, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+func TestSharedHealth(t *testing.T) {
+	s := miniredis.RunT(t)
+	health := NewClientHealth(newHealthClient(t, s), 3, 50*time.Millisecond)
+	a := newHealthProvider(t, WithSharedHealth(health), WithKeyPrefix("a"))
+	b := newHealthProvider(t, WithSharedHealth(health), WithKeyPrefix("b"))
+	putAll(t, a, "k")
+	putAll(t, b, "k")
+
+	s.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := a.Peek("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Peek %d = %v, want a transport failure", i, err)
+		}
+	}
+
+	if health.Available() {
+		t.Fatal("health is available after 3 failures")
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b never failed itself, but fails fast too, even though the server is
+	// back.
+	before := s.CommandCount()
+	_, err := b.Peek("k")
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Peek of the other Provider = %v, want ErrCircuitOpen", err)
+	}
+
+	if commands := s.CommandCount() - before; commands != 0 {
+		t.Fatalf("an open breaker sent %d commands", commands)
+	}
+
+	if decision := DecideOnError(err, FailClosed, nil); decision.Allowed || !decision.Degraded {
+		t.Fatalf("DecideOnError = %+v, want a degraded denial", decision)
+	}
+
+	// Once the cooldown is over, b's probe closes the breaker for a too.
+	time.Sleep(60 * time.Millisecond)
+	if !health.Available() {
+		t.Fatal("health isn't available after the cooldown")
+	}
+
+	if _, err := b.Peek("k"); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+
+	if _, err := a.Peek("k"); err != nil {
+		t.Fatalf("Peek after the probe: %v", err)
+	}
+}
+
+func TestSharedHealthFailedProbe(t *testing.T) {
+	s := miniredis.RunT(t)
+	health := NewClientHealth(newHealthClient(t, s), 1, 50*time.Millisecond)
+	p := newHealthProvider(t, WithSharedHealth(health))
+
+	s.Close()
+	if _, err := p.Peek("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Peek = %v, want a transport failure", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := p.Peek("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe = %v, want a transport failure", err)
+	}
+
+	// The failed probe opened it for another cooldown.
+	if _, err := p.Peek("k"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Peek after the probe = %v, want ErrCircuitOpen", err)
+	}
+}
+
+func TestCircuitBreakerPerProvider(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := newHealthClient(t, s)
+	a := newHealthProvider(t, WithClient(client), WithCircuitBreaker(1, time.Minute), WithKeyPrefix("a"))
+	b := newHealthProvider(t, WithClient(client), WithCircuitBreaker(1, time.Minute), WithKeyPrefix("b"))
+
+	s.Close()
+	if _, err := a.Peek("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Peek = %v, want a transport failure", err)
+	}
+
+	if err := s.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Peek("k"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Peek = %v, want ErrCircuitOpen", err)
+	}
+
+	// Neither b nor the client itself are affected by a's breaker.
+	if _, err := b.Peek("k"); err != nil {
+		t.Fatalf("Peek of the other Provider: %v", err)
+	}
+
+	if err := client.Ping(client.Context()).Err(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresReplies(t *testing.T) {
+	p, s := newTestProvider(t, WithCircuitBreaker(1, time.Minute))
+	s.HSet(p.hashKey(), "k", "not json")
+	if _, err := p.Peek("k"); err == nil {
+		t.Fatal("Peek of a malformed value didn't fail")
+	}
+
+	s.SetError("ERR something")
+	if _, err := p.Peek("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Peek = %v, want the error reply", err)
+	}
+
+	s.SetError("")
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put after error replies: %v", err)
+	}
+
+	if !p.Health().Available() {
+		t.Fatal("error replies opened the breaker")
+	}
+}
+
+func TestHealthOptions(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := newHealthClient(t, s)
+	health := NewClientHealth(client, 1, time.Second)
+
+	for name, opts := range map[string][]func(o *options){
+		"WithCircuitBreaker needs":    {WithClient(client), WithCircuitBreaker(1, 0)},
+		"whose breaker is shared":     {WithSharedHealth(health), WithCircuitBreaker(1, time.Second)},
+		"the client that its":         {WithSharedHealth(health), WithClient(newHealthClient(t, s))},
+		"a ClientHealth with a":       {WithSharedHealth(NewClientHealth(client, 0, time.Second))},
+		"missing redis client to use": {WithSharedHealth(NewClientHealth(nil, 1, time.Second))},
+	} {
+		if _, err := New(opts...); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("New = %v, want an error with %q", err, name)
+		}
+	}
+
+	p := newHealthProvider(t, WithSharedHealth(health), WithClient(client))
+	if p.Health() != health {
+		t.Fatal("Health isn't the shared ClientHealth")
+	}
+
+	if p := newHealthProvider(t, WithClient(client)); p.Health() != nil {
+		t.Fatal("a Provider without a breaker has a Health")
+	}
+}
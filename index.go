@@ -0,0 +1,230 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// indexedPutScript writes a ratelimit and its reset time in the index together.
+//
+// KEYS[1] = hash, KEYS[2] = index
+// ARGV[1] = field, ARGV[2] = value, ARGV[3] = reset time in Unix milliseconds,
+// or empty if it isn't known
+var indexedPutScript = registerScript("put_indexed", `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if ARGV[3] == '' then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+else
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+end
+
+return 1
+`)
+
+// pruneIndexScript removes index entries whose ratelimit no longer exists and
+// returns them. The check happens in the script itself so an entry that was
+// written back in the meantime is never removed.
+//
+// KEYS[1] = hash, KEYS[2] = index
+// ARGV = fields to check
+var pruneIndexScript = registerScript("prune_index", `
+local pruned = {}
+for _, field in ipairs(ARGV) do
+	if redis.call('HEXISTS', KEYS[1], field) == 0 then
+		redis.call('ZREM', KEYS[2], field)
+		table.insert(pruned, field)
+	end
+end
+
+return pruned
+`)
+
+// WithResetIndex keeps a sorted set ("{<prefix>}:resets") of every ratelimit's
+// reset time next to the hash, updated in the same step as every write and
+// reset, so AdminClient.ExpiredKeys and AdminClient.NextReset don't have to scan
+// the whole hash.
+func WithResetIndex() func(o *options) {
+	return func(o *options) {
+		o.resetIndex = true
+	}
+}
+
+func (p *Provider) indexKey() string {
+	return p.space.Load().resetIndex
+}
+
+// indexScore returns the score that a reset time is stored with in the index,
+// or an empty string if it isn't known.
+func indexScore(resetAt time.Time) string {
+	if resetAt.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(resetAt.UnixMilli(), 10)
+}
+
+// deleteFields deletes the given fields from the hash together with their
+// companions, returning how many were deleted from the hash.
+func (p *Provider) deleteFields(ctx context.Context, fields ...string) (int64, error) {
+	// With WithTenantKeyBudget, the fields are deleted one by one to know
+	// which tenants to take them off.
+	var deleted []*redis.IntCmd
+	_, err := p.cmd(ctx).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if p.tenants != nil {
+			for _, field := range fields {
+				deleted = append(deleted, pipe.HDel(ctx, p.hashKey(), field))
+			}
+		} else {
+			deleted = append(deleted, pipe.HDel(ctx, p.hashKey(), fields...))
+		}
+
+		p.forgetCompanions(ctx, pipe, fields...)
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		total int64
+		gone  []string
+	)
+
+	for i, cmd := range deleted {
+		total += cmd.Val()
+		if p.tenants != nil && cmd.Val() == 1 {
+			gone = append(gone, fields[i])
+		}
+	}
+
+	p.trackCardinality(-total)
+	for _, field := range fields {
+		p.recordChange(p.hashKey(), field, "", true)
+	}
+
+	return total, p.releaseTenantKeys(ctx, gone...)
+}
+
+// prune removes the given keys from the index if their ratelimit is gone, and
+// returns the ones that still exist.
+func (p *Provider) prune(ctx context.Context, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+
+	pruned, err := p.runScript(ctx, pruneIndexScript, []string{p.hashKey(), p.indexKey()}, args...).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	gone := make(map[string]struct{}, len(pruned))
+	for _, key := range pruned {
+		gone[key] = struct{}{}
+	}
+
+	existing := keys[:0]
+	for _, key := range keys {
+		if _, ok := gone[key]; !ok {
+			existing = append(existing, key)
+		}
+	}
+
+	return existing, nil
+}
+
+// ExpiredKeys returns up to limit keys whose ratelimit reset before the given
+// time, oldest first. It needs WithResetIndex. Index entries for ratelimits
+// that no longer exist are cleaned up along the way, so fewer than limit keys
+// can be returned even if more have expired. Keys shortened by
+// WithMaxKeyLength are returned as they're stored. limit has to be positive,
+// since an unbounded read of the index could be as large as the hash.
+func (a *AdminClient) ExpiredKeys(before time.Time, limit int) (keys []string, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "expired_keys", "")
+
+	if !p.resetIndex {
+		return nil, ErrIndexDisabled
+	}
+
+	if limit <= 0 {
+		return nil, fmt.Errorf("ExpiredKeys needs a positive limit, not %d", limit)
+	}
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+
+	ctx = p.maintenanceContext(ctx)
+	keys, err = p.cmd(ctx).ZRangeByScore(ctx, p.indexKey(), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(before.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.prune(ctx, keys)
+}
+
+// NextReset returns the key whose ratelimit resets next and when, or an empty
+// key if there are none. It needs WithResetIndex.
+func (a *AdminClient) NextReset() (key string, resetAt time.Time, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "next_reset", "")
+
+	if !p.resetIndex {
+		return "", time.Time{}, ErrIndexDisabled
+	}
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+
+	ctx = p.maintenanceContext(ctx)
+	for {
+		next, err := p.cmd(ctx).ZRangeWithScores(ctx, p.indexKey(), 0, 0).Result()
+		if err != nil || len(next) == 0 {
+			return "", time.Time{}, err
+		}
+
+		key, _ := next[0].Member.(string)
+		existing, err := p.prune(ctx, []string{key})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		if len(existing) == 1 {
+			return key, time.UnixMilli(int64(next[0].Score)), nil
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiredKeys(t *testing.T) {
+	p, _ := newTestProvider(t, WithResetIndex())
+
+	now := time.Now()
+	for i, key := range []string{"a", "b", "c"} {
+		if err := p.Put(key, types.NewRatelimit(10, false, now.Add(time.Duration(i-2)*time.Minute))); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	keys, err := p.Admin().ExpiredKeys(now, 10)
+	if err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("ExpiredKeys = %q, %v", keys, err)
+	}
+
+	if keys, err := p.Admin().ExpiredKeys(now, 1); err != nil || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("ExpiredKeys with a limit of 1 = %q, %v", keys, err)
+	}
+
+	for _, limit := range []int{0, -1} {
+		if _, err := p.Admin().ExpiredKeys(now, limit); err == nil {
+			t.Fatalf("ExpiredKeys accepted a limit of %d", limit)
+		}
+	}
+
+	key, resetAt, err := p.Admin().NextReset()
+	if err != nil || key != "a" || resetAt.UnixMilli() != now.Add(-2*time.Minute).UnixMilli() {
+		t.Fatalf("NextReset = %q, %v, %v", key, resetAt, err)
+	}
+}
+
+func TestExpiredKeysDisabled(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if _, err := p.Admin().ExpiredKeys(time.Now(), 10); !errors.Is(err, ErrIndexDisabled) {
+		t.Fatalf("ExpiredKeys = %v, want ErrIndexDisabled", err)
+	}
+}
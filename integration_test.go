@@ -0,0 +1,275 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build integration
+
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit-redis/redistest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// These tests run against real servers, which the environment names:
+//
+//	go test -tags integration ./...
+//
+// with CHI_RATELIMIT_REDIS_ADDR, CHI_RATELIMIT_KEYDB_ADDR and
+// CHI_RATELIMIT_DRAGONFLY_ADDR set to the address of each server to test. A
+// server without an address is skipped. The topologies with a write concern
+// also need CHI_RATELIMIT_<SERVER>_REPLICAS, the amount of replicas that the
+// server has. Dragonfly has to be started with
+// --default_lua_flags=allow-undeclared-keys, since the scripts of some options
+// build keys of their own.
+
+// integrationServer is a server of the matrix.
+type integrationServer struct {
+	name string
+	env  string
+}
+
+var integrationServers = []integrationServer{
+	{name: "Redis", env: "REDIS"},
+	{name: "KeyDB", env: "KEYDB"},
+	{name: "Dragonfly", env: "DRAGONFLY"},
+}
+
+// integrationProvider is what the matrix runs against: a Provider, or a
+// Sharded over two of them.
+type integrationProvider interface {
+	redistest.ConformanceProvider
+	Consume(key string, limit int64, window time.Duration) (redis.Decision, error)
+	Close() error
+}
+
+var integrationPrefixes uint64
+
+func TestIntegration(t *testing.T) {
+	for _, server := range integrationServers {
+		server := server
+		t.Run(server.name, func(t *testing.T) {
+			addr := os.Getenv("CHI_RATELIMIT_" + server.env + "_ADDR")
+			if addr == "" {
+				t.Skipf("set CHI_RATELIMIT_%s_ADDR to the address of a %s server to test it", server.env, server.name)
+			}
+
+			client := goredis.NewClient(&goredis.Options{Addr: addr})
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := client.Ping(ctx).Err(); err != nil {
+				t.Fatalf("%s at %s (CHI_RATELIMIT_%s_ADDR) isn't reachable: %v", server.name, addr, server.env, err)
+			}
+
+			for _, replicated := range []bool{false, true} {
+				replicated := replicated
+				name := "Primary"
+				if replicated {
+					name = "WriteConcern"
+				}
+
+				t.Run(name, func(t *testing.T) {
+					replicas := integrationReplicas(t, server, client, replicated)
+					for _, topology := range []string{"Standalone", "Sharded"} {
+						topology := topology
+						t.Run(topology, func(t *testing.T) {
+							newProvider := func(t *testing.T, prefix string) integrationProvider {
+								t.Helper()
+
+								client := goredis.NewClient(&goredis.Options{Addr: addr})
+								p, err := newIntegrationProvider(topology, client, prefix, replicas)
+								if err != nil {
+									_ = client.Close()
+									t.Fatalf("New: %v", err)
+								}
+
+								t.Cleanup(func() {
+									_ = p.Close()
+									_ = client.Close()
+								})
+								return p
+							}
+
+							t.Run("Conformance", func(t *testing.T) {
+								redistest.RunConformance(t, func(t *testing.T) redistest.ConformanceProvider {
+									return newProvider(t, integrationPrefix())
+								})
+							})
+
+							t.Run("AtomicConsume", func(t *testing.T) {
+								testAtomicConsume(t, func(prefix string) integrationProvider {
+									return newProvider(t, prefix)
+								})
+							})
+						})
+					}
+				})
+			}
+		})
+	}
+}
+
+// integrationReplicas returns the amount of replicas that the Providers of a
+// topology wait for, or skips it if the server can't run it. Zero is no write
+// concern at all.
+func integrationReplicas(t *testing.T, server integrationServer, client *goredis.Client, replicated bool) int {
+	if !replicated {
+		return 0
+	}
+
+	env := "CHI_RATELIMIT_" + server.env + "_REPLICAS"
+	replicas, err := strconv.Atoi(os.Getenv(env))
+	switch {
+	case os.Getenv(env) == "":
+		t.Skipf("set %s to the amount of replicas of the %s server to test WithWriteConcern", env, server.name)
+	case err != nil || replicas <= 0:
+		t.Fatalf("%s = %q, want a positive amount of replicas", env, os.Getenv(env))
+	}
+
+	probe, err := redis.New(redis.WithClient(client))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	defer probe.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	caps, err := probe.Admin().Capabilities(ctx)
+	if err != nil {
+		t.Fatalf("Capabilities: %v", err)
+	}
+
+	if !caps.Wait {
+		t.Skipf("%s at %s doesn't support WAIT, which WithWriteConcern needs; unset %s to stop testing it", server.name, client.Options().Addr, env)
+	}
+
+	return replicas
+}
+
+// newIntegrationProvider returns the Provider of a topology, whose keys are all
+// under prefix. The sharded one splits them across two prefixes of the same
+// server.
+func newIntegrationProvider(topology string, client *goredis.Client, prefix string, replicas int) (integrationProvider, error) {
+	if topology == "Standalone" {
+		return redis.New(redis.WithClient(client), redis.WithKeyPrefix(prefix), redis.WithWriteConcern(replicas, 5*time.Second))
+	}
+
+	return redis.NewSharded([]redis.ShardConfig{
+		redis.Shard(redis.WithClient(client), redis.WithKeyPrefix(prefix+"0:")),
+		redis.Shard(redis.WithClient(client), redis.WithKeyPrefix(prefix+"1:")),
+	}, redis.HashPick(2, nil), redis.WithShardOptions(redis.WithWriteConcern(replicas, 5*time.Second)))
+}
+
+// integrationPrefix returns a key prefix that no other test uses, so every
+// test starts on an empty store without flushing the server.
+func integrationPrefix() string {
+	return fmt.Sprintf("chi-ratelimit-redis:integration:%d:%d:", time.Now().UnixNano(), atomic.AddUint64(&integrationPrefixes, 1))
+}
+
+// testAtomicConsume consumes a single key from several Providers at once, each
+// with a connection pool of its own, and checks that every allowed request was
+// counted exactly once: no more than the limit are allowed, and what remains is
+// the limit less them. A request that gave up with ErrTxnConflict wasn't
+// counted, so with enough of them fewer than the limit can be allowed.
+func testAtomicConsume(t *testing.T, newProvider func(prefix string) integrationProvider) {
+	const (
+		providers = 4
+		workers   = 16
+		requests  = 25
+		limit     = 100
+	)
+
+	prefix := integrationPrefix()
+	var allowed, denied, conflicts int64
+	var failures sync.Map
+
+	var wg sync.WaitGroup
+	var last integrationProvider
+	for i := 0; i < providers; i++ {
+		p := newProvider(prefix)
+		last = p
+		for j := 0; j < workers; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				for k := 0; k < requests; k++ {
+					decision, err := p.Consume("stress", limit, time.Minute)
+					switch {
+					case errors.Is(err, redis.ErrTxnConflict):
+						atomic.AddInt64(&conflicts, 1)
+					case err != nil:
+						failures.Store(err.Error(), struct{}{})
+					case decision.Allowed:
+						atomic.AddInt64(&allowed, 1)
+					default:
+						atomic.AddInt64(&denied, 1)
+					}
+				}
+			}()
+		}
+	}
+
+	wg.Wait()
+
+	var errs []string
+	failures.Range(func(key, _ interface{}) bool {
+		errs = append(errs, key.(string))
+		return true
+	})
+
+	if len(errs) > 0 {
+		t.Fatalf("Consume failed: %s", strings.Join(errs, "; "))
+	}
+
+	total := int64(providers * workers * requests)
+	if allowed+denied+conflicts != total {
+		t.Fatalf("%d allowed, %d denied and %d conflicting of %d requests", allowed, denied, conflicts, total)
+	}
+
+	if allowed > limit || (conflicts == 0 && allowed != limit) {
+		t.Fatalf("allowed %d of %d requests with a limit of %d (%d conflicting)", allowed, total, limit, conflicts)
+	}
+
+	rl, err := last.Peek("stress")
+	if err != nil || rl == nil {
+		t.Fatalf("Peek = %+v, %v", rl, err)
+	}
+
+	if want := int32(limit - allowed); rl.Remaining != want {
+		t.Fatalf("Remaining = %d after %d allowed requests, want %d", rl.Remaining, allowed, want)
+	}
+
+	t.Logf("%d allowed, %d denied and %d gave up with ErrTxnConflict", allowed, denied, conflicts)
+}
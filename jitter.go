@@ -0,0 +1,50 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "time"
+
+// WithResetJitter makes every new fixed window end up to maxJitter later than
+// it would otherwise, by an offset that comes from a hash of the key, so the
+// windows of many keys that would all reset at the same instant, like the ones
+// of WithCalendarWindows, are spread out instead of all being recreated at
+// once. A key always gets the same offset, from the hash of WithHashFunc, and windows never end earlier than
+// they would without it.
+//
+// It only changes FixedWindow, which is the default Algorithm, and the
+// adapter and scopes that count like it; the other algorithms align their
+// windows to the Unix epoch in their scripts.
+func WithResetJitter(maxJitter time.Duration) func(o *options) {
+	return func(o *options) {
+		o.resetJitter = maxJitter
+	}
+}
+
+// jitter returns how much later than nominal the fixed windows of the given key
+// end with WithResetJitter.
+func (p *Provider) jitter(key string) time.Duration {
+	if p.resetJitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(p.hash(key) % uint64(p.resetJitter))
+}
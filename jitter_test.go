@@ -0,0 +1,95 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestResetJitter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := WithClock(func() time.Time { return now })
+	p, server := newTestProvider(t, clock, WithResetJitter(10*time.Second))
+	server.SetTime(now)
+	other := newTestProviderOn(t, server, clock, WithResetJitter(10*time.Second))
+
+	offsets := map[time.Duration]bool{}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		decision, err := p.Consume(key, 10, time.Minute)
+		if err != nil {
+			t.Fatalf("Consume(%q): %v", key, err)
+		}
+
+		offset := decision.ResetAt.Sub(now) - time.Minute
+		if offset < 0 || offset >= 10*time.Second {
+			t.Fatalf("Consume(%q) resets %v after the window, want [0, 10s)", key, offset)
+		}
+
+		offsets[offset] = true
+
+		// Another Provider, and the next window, jitter the key the same.
+		if _, err := other.Reset(key); err != nil {
+			t.Fatalf("Reset(%q): %v", key, err)
+		}
+
+		again, err := other.Consume(key, 10, time.Minute)
+		if err != nil || !again.ResetAt.Equal(decision.ResetAt) {
+			t.Fatalf("Consume(%q) on another Provider resets at %v, %v, want %v", key, again.ResetAt, err, decision.ResetAt)
+		}
+	}
+
+	if len(offsets) == 1 {
+		t.Fatalf("every key got the same offset: %v", offsets)
+	}
+}
+
+func TestResetJitterDistribution(t *testing.T) {
+	p, _ := newTestProvider(t, WithResetJitter(time.Second))
+
+	const keys, buckets = 10000, 10
+	counts := make([]int, buckets)
+	for i := 0; i < keys; i++ {
+		key := "key-" + strconv.Itoa(i)
+		offset := p.jitter(key)
+		if offset < 0 || offset >= time.Second || offset != p.jitter(key) {
+			t.Fatalf("jitter(%q) = %v, want the same offset in [0, 1s) every time", key, offset)
+		}
+
+		counts[offset*buckets/time.Second]++
+	}
+
+	// Every tenth of the jitter should get about a tenth of the keys.
+	for i, count := range counts {
+		if count < keys/buckets*8/10 || count > keys/buckets*12/10 {
+			t.Fatalf("bucket %d of the jitter has %d of %d keys: %v", i, count, keys, counts)
+		}
+	}
+}
+
+func TestResetJitterNegative(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if _, err := New(WithClient(p.client), WithResetJitter(-time.Second)); err == nil {
+		t.Fatal("New accepted a negative reset jitter")
+	}
+}
@@ -0,0 +1,282 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/noelware/chi-ratelimit/types"
+	"strings"
+)
+
+const (
+	// KeySeparator is the character that joins the parts of a composite
+	// key built with CompositeKey.
+	KeySeparator = ':'
+
+	// keyEscape is the character used to escape KeySeparator (and itself)
+	// inside a single part.
+	keyEscape = '\\'
+)
+
+// CompositeKey joins the given parts into a single key, escaping any
+// KeySeparator or escape character ('\') found inside a part so SplitKey
+// can recover the exact parts that were given. The only exception is that
+// no parts and a single empty part both give the empty key.
+func CompositeKey(parts ...string) string {
+	var builder strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			builder.WriteByte(KeySeparator)
+		}
+
+		for j := 0; j < len(part); j++ {
+			if part[j] == KeySeparator || part[j] == keyEscape {
+				builder.WriteByte(keyEscape)
+			}
+
+			builder.WriteByte(part[j])
+		}
+	}
+
+	return builder.String()
+}
+
+// SplitKey splits a key built by CompositeKey back into its parts, and an
+// empty key into one empty part. Other keys are split the same way, at every
+// KeySeparator that isn't escaped, so a key like an IPv6 address that was
+// never built with CompositeKey is split into several parts; a '\' in them
+// escapes the byte that follows it and is dropped.
+func SplitKey(key string) []string {
+	var (
+		parts   []string
+		current strings.Builder
+	)
+
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case keyEscape:
+			if i+1 < len(key) {
+				i++
+			}
+
+			current.WriteByte(key[i])
+
+		case KeySeparator:
+			parts = append(parts, current.String())
+			current.Reset()
+
+		default:
+			current.WriteByte(key[i])
+		}
+	}
+
+	return append(parts, current.String())
+}
+
+// maxKeyDigestLength is how long the suffix of a key shortened by
+// WithMaxKeyLength is: '#' and the hex of its SHA-256.
+const maxKeyDigestLength = 1 + 2*sha256.Size
+
+// keyspace holds every key that is derived from the key prefix. They're built
+// once instead of on every operation, and swapped out as a whole when the
+// prefix is renamed.
+type keyspace struct {
+	prefix     string
+	tagged     string
+	resetIndex string
+	meta       string
+	marker     string
+	tenants    string
+	created    string
+	persistent string
+	seen       string
+	schema     string
+}
+
+func newKeyspace(prefix string) *keyspace {
+	tagged := hashTag(prefix)
+	return &keyspace{
+		prefix:     prefix,
+		tagged:     tagged,
+		resetIndex: tagged + ":resets",
+		meta:       tagged + ":meta",
+		marker:     tagged + ":__meta__",
+		tenants:    tagged + ":tenants",
+		created:    tagged + ":created",
+		persistent: tagged + ":persistent",
+		seen:       tagged + ":seen",
+		schema:     tagged + ":__schema__",
+	}
+}
+
+// KeyPrefix returns the key prefix that the ratelimits are stored under.
+func (p *Provider) KeyPrefix() string {
+	return p.space.Load().prefix
+}
+
+// hashKey returns the key of the hash that every ratelimit is stored in, which
+// is the current window's hash with WithWindowedKeys.
+func (p *Provider) hashKey() string {
+	space := p.space.Load()
+	if p.keyWindow > 0 {
+		return p.windowHash(space, p.now())
+	}
+
+	return space.prefix
+}
+
+// storageKey returns the hash field that the given key is stored under. Keys
+// longer than the configured maximum length are cut down and suffixed with
+// the SHA-256 of the full key, so two keys that only differ after the cut
+// point still end up in different fields.
+func (p *Provider) storageKey(key string) string {
+	if p.maxKeyLength <= 0 || len(key) <= p.maxKeyLength {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return key[:p.maxKeyLength-maxKeyDigestLength] + "#" + hex.EncodeToString(sum[:])
+}
+
+// companionKey returns the key for data that belongs to a stored ratelimit but
+// lives outside the hash, like a tombstone. The prefix is wrapped in a hash tag
+// so the companion key always hashes to the same cluster slot as the hash
+// itself, which lets a script touch both without a CROSSSLOT error.
+func (p *Provider) companionKey(kind, key string) string {
+	// The tagged prefix is precomputed, so this is a single allocation.
+	return p.space.Load().tagged + ":" + kind + ":" + key
+}
+
+// hashTag wraps the given key in a Redis Cluster hash tag, unless it already
+// has one, so that anything built from it shares its slot.
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key
+		}
+	}
+
+	return "{" + key + "}"
+}
+
+// EscapeGlob escapes every glob metacharacter that Redis understands in
+// MATCH patterns, so the given string only ever matches itself. Keys are
+// treated as opaque bytes, so this is safe to use on arbitrary user input.
+func EscapeGlob(literal string) string {
+	var builder strings.Builder
+	for i := 0; i < len(literal); i++ {
+		switch literal[i] {
+		case '*', '?', '[', ']', '\\':
+			builder.WriteByte('\\')
+		}
+
+		builder.WriteByte(literal[i])
+	}
+
+	return builder.String()
+}
+
+// ResetByPart deletes every ratelimit whose composite key has the given
+// value at the given part index, i.e. ResetByPart(1, apiKey) clears every
+// route for one API key when keys are built with CompositeKey(route, apiKey).
+// It returns how many ratelimits were deleted.
+//
+// Keys that were shortened by WithMaxKeyLength no longer contain all of their
+// parts, so they might not be matched.
+func (a *AdminClient) ResetByPart(index int, value string) (deleted int64, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "reset_by_part", "")
+
+	match, matches := partMatcher(index, value)
+	return p.resetScanned(context.Background(), "ResetByPart", match, matches)
+}
+
+// ResetMatching deletes every ratelimit whose key matches the given HSCAN MATCH
+// pattern and returns how many were deleted. Keys are matched as the bytes they
+// are, so literal portions of the pattern should be escaped with EscapeGlob,
+// and parts of composite keys with EscapeGlob(CompositeKey(part)), like
+// EscapeGlob(CompositeKey(route)) + ":*" for every key of one route.
+func (a *AdminClient) ResetMatching(ctx context.Context, match string) (deleted int64, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "reset_matching", "")
+
+	return p.resetScanned(ctx, "ResetMatching", match, nil)
+}
+
+// IterateMatching is Iterate over the ratelimits whose key matches the given
+// HSCAN MATCH pattern, which is escaped like the one of ResetMatching.
+func (a *AdminClient) IterateMatching(ctx context.Context, match string, fn func(key string, rl *types.Ratelimit) bool) error {
+	return a.iterateDecoded(ctx, "IterateMatching", match, nil, fn)
+}
+
+// IterateByPart is Iterate over the ratelimits that ResetByPart would delete.
+func (a *AdminClient) IterateByPart(ctx context.Context, index int, value string, fn func(key string, rl *types.Ratelimit) bool) error {
+	match, matches := partMatcher(index, value)
+	return a.iterateDecoded(ctx, "IterateByPart", match, matches, fn)
+}
+
+// partMatcher returns the MATCH pattern and the check of the keys that have
+// value at the given part index. The escaped part has to appear somewhere in
+// the key, so Redis filters out everything else before the keys that are left
+// are split.
+func partMatcher(index int, value string) (string, func(key string) bool) {
+	match := "*" + EscapeGlob(CompositeKey(value)) + "*"
+	return match, func(key string) bool {
+		parts := SplitKey(key)
+		return index >= 0 && index < len(parts) && parts[index] == value
+	}
+}
+
+// resetScanned deletes every field that matches the MATCH pattern and, if it
+// isn't nil, the given check.
+func (p *Provider) resetScanned(ctx context.Context, operation, match string, matches func(key string) bool) (deleted int64, err error) {
+	ctx = p.maintenanceContext(ctx)
+	err = p.scan(ctx, operation, match, 100, func(fields, _ []string) error {
+		matched := fields
+		if matches != nil {
+			matched = nil
+			for _, field := range fields {
+				if matches(field) {
+					matched = append(matched, field)
+				}
+			}
+		}
+
+		if len(matched) == 0 {
+			return nil
+		}
+
+		count, err := p.deleteFields(ctx, matched...)
+		if err != nil {
+			return err
+		}
+
+		deleted += count
+		p.forgetLocal(matched...)
+
+		return nil
+	})
+
+	return deleted, err
+}
@@ -0,0 +1,338 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestCompositeKeyRoundTrip(t *testing.T) {
+	for _, parts := range [][]string{
+		{"route"},
+		{"route", "127.0.0.1"},
+		{"route", "::1"},
+		{`a\`, `:b\:`, ""},
+		{"", ""},
+	} {
+		key := CompositeKey(parts...)
+		if got := SplitKey(key); !reflect.DeepEqual(got, parts) {
+			t.Errorf("SplitKey(CompositeKey(%q)) = %q", parts, got)
+		}
+	}
+}
+
+func TestSplitKey(t *testing.T) {
+	for key, want := range map[string][]string{
+		"":        {""},
+		"plain":   {"plain"},
+		"::1":     {"", "", "1"},
+		`a\:b:c`:  {"a:b", "c"},
+		`dangle\`: {`dangle\`},
+	} {
+		if got := SplitKey(key); !reflect.DeepEqual(got, want) {
+			t.Errorf("SplitKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestResetByPart(t *testing.T) {
+	p, server := newTestProvider(t)
+
+	// The glob metacharacters in the value have to match literally.
+	putAll(t, p,
+		CompositeKey("route1", "k*"),
+		CompositeKey("route2", "k*"),
+		CompositeKey("route1", "k1"),
+		CompositeKey("k*", "route3"),
+		CompositeKey("route4", "k*", "extra"),
+	)
+
+	deleted, err := p.Admin().ResetByPart(1, "k*")
+	if err != nil || deleted != 3 {
+		t.Fatalf("ResetByPart = %d, %v", deleted, err)
+	}
+
+	left, _ := server.HKeys(p.hashKey())
+	want := map[string]bool{CompositeKey("route1", "k1"): true, CompositeKey("k*", "route3"): true}
+	if len(left) != len(want) {
+		t.Fatalf("ResetByPart left %q", left)
+	}
+
+	for _, key := range left {
+		if !want[key] {
+			t.Fatalf("ResetByPart left %q", left)
+		}
+	}
+
+	// Out of range indexes match nothing.
+	if deleted, err := p.Admin().ResetByPart(5, "k1"); err != nil || deleted != 0 {
+		t.Fatalf("ResetByPart with an index out of range = %d, %v", deleted, err)
+	}
+}
+
+func TestResetMatching(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a*", "ab", "a?c", `a\`, CompositeKey("route1", "k1"), CompositeKey("route1", "k2"), CompositeKey("route10", "k1"))
+
+	for _, tc := range []struct {
+		match   string
+		deleted []string
+	}{
+		{EscapeGlob("a*"), []string{"a*"}},
+		{EscapeGlob("a?c"), []string{"a?c"}},
+		{EscapeGlob(`a\`), []string{`a\`}},
+		{EscapeGlob(CompositeKey("route1")) + ":*", []string{CompositeKey("route1", "k1"), CompositeKey("route1", "k2")}},
+	} {
+		deleted, err := p.Admin().ResetMatching(context.Background(), tc.match)
+		if err != nil || deleted != int64(len(tc.deleted)) {
+			t.Fatalf("ResetMatching(%q) = %d, %v; want %d", tc.match, deleted, err, len(tc.deleted))
+		}
+
+		for _, key := range tc.deleted {
+			if server.HGet(p.hashKey(), key) != "" {
+				t.Fatalf("ResetMatching(%q) left %q", tc.match, key)
+			}
+		}
+	}
+
+	left, _ := server.HKeys(p.hashKey())
+	sort.Strings(left)
+	if want := []string{"ab", CompositeKey("route10", "k1")}; !reflect.DeepEqual(left, want) {
+		t.Fatalf("ResetMatching left %q, want %q", left, want)
+	}
+}
+
+func TestIterateByPart(t *testing.T) {
+	p, _ := newTestProvider(t)
+	putAll(t, p, CompositeKey("route1", "k:1"), CompositeKey("route2", "k:1"), CompositeKey("route1", "k:10"), CompositeKey("k:1", "route3"))
+
+	var keys []string
+	err := p.Admin().IterateByPart(context.Background(), 1, "k:1", func(key string, rl *types.Ratelimit) bool {
+		if rl == nil || rl.Limit != 10 {
+			t.Errorf("IterateByPart got %q = %+v", key, rl)
+		}
+
+		keys = append(keys, key)
+		return true
+	})
+
+	sort.Strings(keys)
+	if want := []string{CompositeKey("route1", "k:1"), CompositeKey("route2", "k:1")}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Fatalf("IterateByPart = %q, %v; want %q", keys, err, want)
+	}
+
+	keys = nil
+	err = p.Admin().IterateMatching(context.Background(), EscapeGlob(CompositeKey("route1"))+":*", func(key string, _ *types.Ratelimit) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	sort.Strings(keys)
+	if want := []string{CompositeKey("route1", "k:1"), CompositeKey("route1", "k:10")}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Fatalf("IterateMatching = %q, %v; want %q", keys, err, want)
+	}
+}
+
+// randomKeys returns keys of random bytes, which aren't valid UTF-8 most of
+// the time, along with ones that are hard to get right otherwise.
+func randomKeys(seed int64, n int) []string {
+	keys := []string{
+		"emoji 🚦🔥", "مرحبا بالعالم", "שלום‏ עולם", "line\nbreak\r\n",
+		" spaced  out ", "nul\x00byte", "*", "?", "[a-z]", `\*`, "k*", "k", "kk",
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		seen[key] = true
+	}
+
+	for len(keys) < n {
+		key := make([]byte, 1+random.Intn(32))
+		random.Read(key)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			keys = append(keys, string(key))
+		}
+	}
+
+	return keys
+}
+
+func TestKeysBinarySafe(t *testing.T) {
+	p, _ := newTestProvider(t)
+	keys := randomKeys(102, 200)
+
+	resetAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	for i, key := range keys {
+		if err := p.Put(key, &types.Ratelimit{Limit: int32(i + 1), Remaining: int32(i + 1), ResetTime: resetAt}); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+
+	for i, key := range keys {
+		rl, err := p.Peek(key)
+		if err != nil || rl == nil || rl.Limit != int32(i+1) {
+			t.Fatalf("Peek(%q) = %+v, %v; want the ratelimit with limit %d", key, rl, err, i+1)
+		}
+	}
+
+	iterated := map[string]int32{}
+	err := p.Admin().Iterate(context.Background(), func(key string, rl *types.Ratelimit) bool {
+		if rl != nil {
+			iterated[key] = rl.Limit
+		}
+
+		return true
+	})
+
+	if err != nil || len(iterated) != len(keys) {
+		t.Fatalf("Iterate = %d keys, %v; want %d", len(iterated), err, len(keys))
+	}
+
+	for i, key := range keys {
+		if iterated[key] != int32(i+1) {
+			t.Fatalf("Iterate didn't return %q byte for byte", key)
+		}
+	}
+
+	// An escaped key only ever matches itself, whatever it contains.
+	// miniredis turns patterns into regular expressions, which have to be
+	// valid UTF-8, so the other keys are left to the real thing.
+	for _, key := range keys {
+		if !utf8.ValidString(key) {
+			continue
+		}
+
+		deleted, err := p.Admin().ResetMatching(context.Background(), EscapeGlob(key))
+		if err != nil || deleted != 1 {
+			t.Fatalf("ResetMatching(EscapeGlob(%q)) = %d, %v; want 1", key, deleted, err)
+		}
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	p, server := newTestProvider(t, WithMaxKeyLength(70))
+
+	// The format is part of what's stored, so it can't change.
+	long := strings.Repeat("a", 100)
+	if got, want := p.storageKey(long), "aaaaa#2816597888e4a0d3a36b82b83316ab32680eb8f00f8cd3b904d681246d285a0e"; got != want {
+		t.Fatalf("storageKey = %q, want %q", got, want)
+	}
+
+	if got := p.storageKey(long[:70]); got != long[:70] {
+		t.Fatalf("storageKey of a key that fits = %q", got)
+	}
+
+	// Two long keys that only differ after the cut get fields of their own.
+	first := "https://example.com/?q=" + strings.Repeat("x", 2048) + "1"
+	second := "https://example.com/?q=" + strings.Repeat("x", 2048) + "2"
+	mustPut(t, p, first, 1)
+	mustPut(t, p, second, 2)
+
+	fields, _ := server.HKeys(p.hashKey())
+	if len(fields) != 2 || len(fields[0]) != 70 || len(fields[1]) != 70 {
+		t.Fatalf("the long keys are stored as %q, want two fields of 70 bytes", fields)
+	}
+
+	for limit, key := range map[int32]string{1: first, 2: second} {
+		if rl, err := p.Peek(key); err != nil || rl == nil || rl.Limit != limit {
+			t.Fatalf("Peek = %+v, %v; want limit %d", rl, err, limit)
+		}
+	}
+
+	if ok, err := p.Reset(first); err != nil || !ok {
+		t.Fatalf("Reset = %t, %v", ok, err)
+	}
+
+	if rl, err := p.Peek(second); err != nil || rl == nil {
+		t.Fatalf("Reset of one long key took the other one too: %+v, %v", rl, err)
+	}
+}
+
+func TestMaxKeyLengthTooShort(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, n := range []int{-1, 1, 64} {
+		if _, err := New(WithClient(p.client), WithMaxKeyLength(n)); err == nil {
+			t.Fatalf("New accepted WithMaxKeyLength(%d)", n)
+		}
+	}
+
+	if _, err := New(WithClient(p.client), WithMaxKeyLength(65)); err != nil {
+		t.Fatalf("New with WithMaxKeyLength(65): %v", err)
+	}
+}
+
+func mustPut(t *testing.T, p *Provider, key string, limit int32) {
+	t.Helper()
+
+	if err := p.Put(key, &types.Ratelimit{Limit: limit, Remaining: limit, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+}
+
+func TestCompanionKey(t *testing.T) {
+	// Keys have to stay byte for byte what they were, so stored data still
+	// matches.
+	for prefix, want := range map[string]string{
+		"ratelimits":   "{ratelimits}:sliding:k",
+		"app:{limits}": "app:{limits}:sliding:k",
+		"a{}b":         "{a{}b}:sliding:k",
+	} {
+		p, _ := newTestProvider(t, WithKeyPrefix(prefix))
+		if got := p.companionKey("sliding", "k"); got != want || got != hashTag(prefix)+":sliding:k" {
+			t.Errorf("companionKey with prefix %q = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestKeyAllocs(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for name, test := range map[string]struct {
+		max float64
+		fn  func()
+	}{
+		"hashKey":      {0, func() { _ = p.hashKey() }},
+		"storageKey":   {0, func() { _ = p.storageKey("127.0.0.1") }},
+		"companionKey": {1, func() { _ = p.companionKey("sliding", "127.0.0.1") }},
+	} {
+		if allocs := testing.AllocsPerRun(100, test.fn); allocs > test.max {
+			t.Errorf("%s allocates %.0f times, want at most %.0f", name, allocs, test.max)
+		}
+	}
+}
+
+func BenchmarkCompanionKey(b *testing.B) {
+	p, _ := newTestProvider(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = p.companionKey("sliding", "127.0.0.1")
+	}
+}
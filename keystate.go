@@ -0,0 +1,338 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultKeyStateEntries is how many keys the Provider keeps in-process
+	// state for by default.
+	defaultKeyStateEntries = 10000
+
+	// keyStateShards is how many independently locked parts the key state
+	// table is split into.
+	keyStateShards = 16
+)
+
+// keyState is everything the Provider remembers in-process about one storage
+// key. Each feature only uses its own fields; an entry whose fields are all
+// unused is removed.
+type keyState struct {
+	key string
+
+	// dedupSum and dedupWritten are the last write of WithPutDeduplication.
+	dedupSum     [sha256.Size]byte
+	dedupWritten time.Time
+
+	// flight is the running lookup of PrefetchAsync.
+	flight *prefetchFlight
+
+	// forcedUntil is when ForceLog stops logging every request.
+	forcedUntil time.Time
+
+	// cacheData is the copy of WithLocalCache until cacheUntil, and
+	// missingUntil is when WithNegativeCache stops remembering that the key
+	// has no ratelimit.
+	cacheData    string
+	cacheUntil   time.Time
+	missingUntil time.Time
+}
+
+// cached returns whether the state has a local copy, and how many bytes it
+// takes against WithLocalCacheBytes: its key and its encoded ratelimit.
+func (s *keyState) cached() (bool, int64) {
+	if s.cacheUntil.IsZero() {
+		return false, 0
+	}
+
+	return true, int64(len(s.key) + len(s.cacheData))
+}
+
+func (s *keyState) empty() bool {
+	return s.dedupWritten.IsZero() && s.flight == nil && s.forcedUntil.IsZero() && s.cacheUntil.IsZero() && s.missingUntil.IsZero()
+}
+
+type keyStateShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// keyStates is the table that put deduplication, prefetching, forced decision
+// logging and the local caches share, so enabling all of them still only keeps state for a
+// bounded amount of keys. It's split into shards that are each bounded by an
+// LRU. Evicting a key only forgets what was remembered about it: a dedup'd
+// write goes through again, and a running prefetch still finishes for the
+// callers that joined it, but later callers start their own.
+type keyStates struct {
+	shards    [keyStateShards]keyStateShard
+	perShard  int
+	hash      func(string) uint64
+	evictions atomic.Uint64
+
+	// maxCacheBytes is the budget of WithLocalCacheBytes, or zero if there's
+	// none. The byte budget is kept over all shards, so it evicts from one
+	// shard after the other, starting where the last eviction stopped.
+	maxCacheBytes  int64
+	evictCursor    atomic.Uint32
+	cacheEntries   atomic.Int64
+	cacheBytes     atomic.Int64
+	cacheEvictions atomic.Uint64
+	cacheHits      atomic.Uint64
+	cacheMisses    atomic.Uint64
+}
+
+// KeyStateStats describes the table of in-process per-key state.
+type KeyStateStats struct {
+	// Entries is how many keys have state right now.
+	Entries int
+
+	// MaxEntries is the most keys that can have state at once.
+	MaxEntries int
+
+	// Evictions is how many keys were forgotten to stay under MaxEntries.
+	Evictions uint64
+
+	// LocalCache describes the copies of WithLocalCache in the table.
+	LocalCache LocalCacheStats
+}
+
+// LocalCacheStats describes the copies of WithLocalCache.
+type LocalCacheStats struct {
+	// Entries is how many keys have a copy right now, and Bytes how much
+	// their keys and encoded ratelimits take.
+	Entries int
+	Bytes   int64
+
+	// MaxBytes is the budget of WithLocalCacheBytes, or zero if there's none.
+	MaxBytes int64
+
+	// Hits and Misses are how many reads were answered from a copy and how
+	// many had to read Redis, and HitRate is the share of hits, or zero
+	// before the first read.
+	Hits    uint64
+	Misses  uint64
+	HitRate float64
+
+	// Evictions is how many copies were dropped to stay under MaxBytes or
+	// because their key was forgotten to stay under MaxEntries. Copies that
+	// are replaced, expire or are forgotten by a write aren't counted.
+	Evictions uint64
+}
+
+// WithKeyStateLimit sets the most keys that the Provider keeps in-process state
+// for, which is shared by WithPutDeduplication, PrefetchAsync, ForceLog,
+// WithLocalCache and WithNegativeCache. The
+// least recently used keys are forgotten first. It's 10,000 by default.
+func WithKeyStateLimit(maxEntries int) func(o *options) {
+	return func(o *options) {
+		o.keyStateEntries = maxEntries
+	}
+}
+
+func newKeyStates(maxEntries int, hash func(string) uint64) *keyStates {
+	perShard := maxEntries / keyStateShards
+	if perShard < 1 {
+		perShard = 1
+	}
+
+	t := &keyStates{perShard: perShard, hash: hash}
+	for i := range t.shards {
+		t.shards[i].entries = map[string]*list.Element{}
+		t.shards[i].order = list.New()
+	}
+
+	return t
+}
+
+func (t *keyStates) shard(key string) *keyStateShard {
+	return &t.shards[t.hash(key)%keyStateShards]
+}
+
+// update calls fn with the state of key while holding its shard's lock. If the
+// key has no state, fn gets a new one when create is true and isn't called
+// otherwise. States that are empty after fn are removed.
+func (t *keyStates) update(key string, create bool, fn func(s *keyState)) {
+	shard := t.shard(key)
+	defer t.trim(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	element, ok := shard.entries[key]
+	if !ok {
+		if !create {
+			return
+		}
+
+		element = shard.order.PushFront(&keyState{key: key})
+		shard.entries[key] = element
+	} else {
+		shard.order.MoveToFront(element)
+	}
+
+	state := element.Value.(*keyState)
+	t.change(state, fn)
+
+	if state.empty() {
+		shard.order.Remove(element)
+		delete(shard.entries, key)
+		return
+	}
+
+	for shard.order.Len() > t.perShard {
+		oldest := shard.order.Back()
+		shard.order.Remove(oldest)
+		delete(shard.entries, oldest.Value.(*keyState).key)
+		t.evictions.Add(1)
+
+		if cached, size := oldest.Value.(*keyState).cached(); cached {
+			t.cacheEntries.Add(-1)
+			t.cacheBytes.Add(-size)
+			t.cacheEvictions.Add(1)
+		}
+	}
+}
+
+// change calls fn with the given state, keeping the totals of the local
+// copies up to date.
+func (t *keyStates) change(s *keyState, fn func(s *keyState)) {
+	wasCached, before := s.cached()
+	fn(s)
+	cached, after := s.cached()
+
+	t.cacheBytes.Add(after - before)
+	switch {
+	case cached && !wasCached:
+		t.cacheEntries.Add(1)
+	case wasCached && !cached:
+		t.cacheEntries.Add(-1)
+	}
+}
+
+// trim evicts local copies until they fit in the budget of
+// WithLocalCacheBytes, the least recently used one of a shard at a time. The
+// copy of the given key, which was just used, is only kept over the budget if
+// it's the only one left. It only holds one shard's lock at a time, so it
+// can't deadlock with other updates.
+func (t *keyStates) trim(keep string) {
+	if t.maxCacheBytes <= 0 {
+		return
+	}
+
+	for empty := 0; empty < keyStateShards && t.cacheBytes.Load() > t.maxCacheBytes; {
+		if t.evictCopy(&t.shards[t.evictCursor.Add(1)%keyStateShards], keep) {
+			empty = 0
+		} else {
+			empty++
+		}
+	}
+}
+
+// evictCopy drops the least recently used local copy of the given shard other
+// than the one of keep, returning false if it has none.
+func (t *keyStates) evictCopy(shard *keyStateShard, keep string) bool {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	for element := shard.order.Back(); element != nil; element = element.Prev() {
+		state := element.Value.(*keyState)
+		if cached, _ := state.cached(); !cached || state.key == keep {
+			continue
+		}
+
+		t.change(state, func(s *keyState) {
+			s.cacheData, s.cacheUntil = "", time.Time{}
+		})
+
+		t.cacheEvictions.Add(1)
+		if state.empty() {
+			shard.order.Remove(element)
+			delete(shard.entries, state.key)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// each calls fn with every state, removing the ones that are empty afterwards.
+func (t *keyStates) each(fn func(s *keyState)) {
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mu.Lock()
+		for key, element := range shard.entries {
+			state := element.Value.(*keyState)
+			t.change(state, fn)
+
+			if state.empty() {
+				shard.order.Remove(element)
+				delete(shard.entries, key)
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+}
+
+// KeyStateStats returns how full the table of in-process per-key state is.
+func (p *Provider) KeyStateStats() KeyStateStats {
+	stats := KeyStateStats{
+		MaxEntries: p.states.perShard * keyStateShards,
+		Evictions:  p.states.evictions.Load(),
+		LocalCache: p.states.cacheStats(),
+	}
+
+	for i := range p.states.shards {
+		shard := &p.states.shards[i]
+		shard.mu.Lock()
+		stats.Entries += shard.order.Len()
+		shard.mu.Unlock()
+	}
+
+	return stats
+}
+
+// cacheStats returns the LocalCacheStats of the table.
+func (t *keyStates) cacheStats() LocalCacheStats {
+	stats := LocalCacheStats{
+		Entries:   int(t.cacheEntries.Load()),
+		Bytes:     t.cacheBytes.Load(),
+		MaxBytes:  t.maxCacheBytes,
+		Hits:      t.cacheHits.Load(),
+		Misses:    t.cacheMisses.Load(),
+		Evictions: t.cacheEvictions.Load(),
+	}
+
+	if reads := stats.Hits + stats.Misses; reads > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(reads)
+	}
+
+	return stats
+}
@@ -0,0 +1,203 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySamples is how many of the most recent operations LatencyStats
+	// looks at.
+	latencySamples = 1024
+
+	// latencyCheckInterval is how often the degradation threshold is checked.
+	latencyCheckInterval = time.Second
+
+	// latencyRecovery is the fraction of the threshold that p95 has to drop
+	// below before the Provider counts as recovered, so it doesn't flap.
+	latencyRecovery = 0.8
+)
+
+// LatencySnapshot describes how long the most recent Redis operations took.
+type LatencySnapshot struct {
+	// Operations is how many operations were tracked since the Provider was
+	// created.
+	Operations uint64
+
+	// Samples is how many of the most recent operations the percentiles are
+	// computed from.
+	Samples int
+
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+
+	// Degraded is true if p95 crossed the threshold set with
+	// WithDegradationCallback and hasn't recovered yet.
+	Degraded bool
+
+	// Timeout is the timeout that WithAdaptiveTimeout currently gives reads
+	// and writes, or zero without it.
+	Timeout time.Duration
+}
+
+// WithDegradationCallback calls fn, on its own goroutine, when the p95 latency
+// of the most recent operations crosses the given threshold and again once it
+// has recovered below 80% of it. p95 is checked once a second from a background
+// goroutine, so operations only record how long they took. New fails if the
+// threshold isn't positive.
+func WithDegradationCallback(threshold time.Duration, fn func(LatencySnapshot)) func(o *options) {
+	return func(o *options) {
+		o.degradedThreshold = threshold
+		o.degradedCallback = fn
+	}
+}
+
+// latencyTracker keeps the durations of the most recent operations in a ring
+// buffer, which can be written to without taking a lock.
+type latencyTracker struct {
+	samples   [latencySamples]atomic.Int64
+	count     atomic.Uint64
+	degraded  atomic.Bool
+	threshold time.Duration
+	callback  func(LatencySnapshot)
+	spawn     func(fn func()) bool
+	adaptive  *adaptiveTimeout
+}
+
+func (t *latencyTracker) record(d time.Duration) {
+	n := t.count.Add(1)
+	t.samples[(n-1)%latencySamples].Store(int64(d))
+}
+
+// watch checks the threshold every latencyCheckInterval until stop is closed,
+// skipping checks when no operation was recorded since the last one.
+func (t *latencyTracker) watch(stop <-chan struct{}) {
+	ticker := time.NewTicker(latencyCheckInterval)
+	defer ticker.Stop()
+
+	var checked uint64
+	for {
+		select {
+		case <-stop:
+			return
+
+		case <-ticker.C:
+			if count := t.count.Load(); count != checked {
+				checked = count
+				t.check()
+			}
+		}
+	}
+}
+
+// check calls the callback if p95 crossed the threshold in either direction.
+func (t *latencyTracker) check() {
+	snapshot := t.snapshot()
+	switch {
+	case !snapshot.Degraded && snapshot.P95 > t.threshold:
+		t.degraded.Store(true)
+
+	case snapshot.Degraded && float64(snapshot.P95) < float64(t.threshold)*latencyRecovery:
+		t.degraded.Store(false)
+
+	default:
+		return
+	}
+
+	snapshot.Degraded = t.degraded.Load()
+	t.spawn(func() { t.callback(snapshot) })
+}
+
+func (t *latencyTracker) snapshot() LatencySnapshot {
+	durations := t.sorted()
+	samples := len(durations)
+	snapshot := LatencySnapshot{
+		Operations: t.count.Load(),
+		Samples:    samples,
+		Degraded:   t.degraded.Load(),
+	}
+
+	if t.adaptive != nil {
+		snapshot.Timeout = time.Duration(t.adaptive.timeout.Load())
+	}
+
+	if samples == 0 {
+		return snapshot
+	}
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+
+	snapshot.Mean = total / time.Duration(samples)
+	snapshot.P50 = percentile(durations, 0.50)
+	snapshot.P95 = percentile(durations, 0.95)
+	snapshot.P99 = percentile(durations, 0.99)
+	snapshot.Max = durations[samples-1]
+
+	return snapshot
+}
+
+// sorted returns the durations of the most recent operations, shortest first.
+func (t *latencyTracker) sorted() []time.Duration {
+	count := t.count.Load()
+	samples := int(count)
+	if count > latencySamples {
+		samples = latencySamples
+	}
+
+	durations := make([]time.Duration, samples)
+	for i := range durations {
+		durations[i] = time.Duration(t.samples[i].Load())
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations
+}
+
+// percentile returns the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+
+	return sorted[rank]
+}
+
+// LatencyStats returns how long the most recent Redis operations took.
+func (p *Provider) LatencyStats() LatencySnapshot {
+	return p.latency.snapshot()
+}
+
+// trackLatency records how long the operation that started at start took. It
+// should be deferred at the start of an operation.
+func (p *Provider) trackLatency(start time.Time) {
+	p.latency.record(time.Since(start))
+}
@@ -0,0 +1,105 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyDegradation(t *testing.T) {
+	var snapshots []LatencySnapshot
+	tracker := &latencyTracker{
+		threshold: 10 * time.Millisecond,
+		callback:  func(s LatencySnapshot) { snapshots = append(snapshots, s) },
+		spawn: func(fn func()) bool {
+			fn()
+			return true
+		},
+	}
+
+	for i := 0; i < latencySamples; i++ {
+		tracker.record(20 * time.Millisecond)
+	}
+
+	tracker.check()
+	tracker.check()
+	if len(snapshots) != 1 || !snapshots[0].Degraded {
+		t.Fatalf("snapshots after crossing the threshold = %+v", snapshots)
+	}
+
+	// 9ms is below the threshold, but not below 80% of it.
+	for i := 0; i < latencySamples; i++ {
+		tracker.record(9 * time.Millisecond)
+	}
+
+	tracker.check()
+	if len(snapshots) != 1 {
+		t.Fatalf("recovered at 90%% of the threshold: %+v", snapshots)
+	}
+
+	for i := 0; i < latencySamples; i++ {
+		tracker.record(time.Millisecond)
+	}
+
+	tracker.check()
+	if len(snapshots) != 2 || snapshots[1].Degraded {
+		t.Fatalf("snapshots after recovering = %+v", snapshots)
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	tracker := &latencyTracker{}
+	for i := 1; i <= 100; i++ {
+		tracker.record(time.Duration(i) * time.Millisecond)
+	}
+
+	snapshot := tracker.snapshot()
+	if snapshot.Samples != 100 || snapshot.P50 != 50*time.Millisecond || snapshot.P95 != 95*time.Millisecond || snapshot.Max != 100*time.Millisecond {
+		t.Fatalf("snapshot = %+v", snapshot)
+	}
+}
+
+func TestDegradationThreshold(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	for _, threshold := range []time.Duration{0, -time.Second} {
+		_, err := New(WithClient(client), WithDegradationCallback(threshold, func(LatencySnapshot) {}))
+		if err == nil || !strings.Contains(err.Error(), "positive threshold") {
+			t.Fatalf("New with a threshold of %v = %v", threshold, err)
+		}
+	}
+}
+
+func BenchmarkTrackLatency(b *testing.B) {
+	tracker := &latencyTracker{threshold: time.Second, callback: func(LatencySnapshot) {}}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tracker.record(time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,340 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// putEntryScript writes a ratelimit to its own key, expiring at ARGV[3] in Unix
+// milliseconds unless that's empty, and deletes its field in the hash.
+//
+// KEYS[1] = entry key, KEYS[2] = hash
+// ARGV[1] = field, ARGV[2] = value, ARGV[3] = expiry (optional)
+var putEntryScript = registerScript("put_entry", `
+if ARGV[3] == '' then
+	redis.call('SET', KEYS[1], ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[2], 'PXAT', ARGV[3])
+end
+
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 1
+`)
+
+// entryTxnScript is txnScript for a ratelimit on the per-key layout. While
+// reads fall back to the hash (ARGV[6] is '1'), a field that showed up in the
+// hash in the meantime is a conflict, since the next read moves it over;
+// otherwise it's deleted.
+//
+// KEYS[1] = entry key, KEYS[2] = hash, KEYS[3] = metadata hash
+// ARGV[1] = field, ARGV[2] = '1' if the value existed when it was read,
+// ARGV[3] = value that was read, ARGV[4] = new value or ” to delete it,
+// ARGV[5] = expiry in Unix milliseconds (optional), ARGV[6] = '1' with the
+// fallback
+var entryTxnScript = registerScript("entry_txn", `
+if ARGV[6] == '1' then
+	if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 then
+		return 0
+	end
+else
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+
+local current = redis.call('GET', KEYS[1])
+if ARGV[2] == '1' then
+	if current ~= ARGV[3] then
+		return 0
+	end
+elseif current then
+	return 0
+end
+
+if ARGV[4] == '' then
+	redis.call('DEL', KEYS[1])
+	redis.call('HDEL', KEYS[3], ARGV[1])
+elseif ARGV[5] == '' then
+	redis.call('SET', KEYS[1], ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[4], 'PXAT', ARGV[5])
+end
+
+return 1
+`)
+
+// migrateToEntryScript moves a ratelimit from the hash to its own key, unless
+// the key got a value of its own in the meantime, and returns whatever the key
+// holds afterwards. The field is only deleted if it still has the value that
+// was moved.
+//
+// KEYS[1] = entry key, KEYS[2] = hash
+// ARGV[1] = field, ARGV[2] = value read from the hash, ARGV[3] = expiry in
+// Unix milliseconds (optional)
+var migrateToEntryScript = registerScript("migrate_to_entry", `
+local current = redis.call('GET', KEYS[1])
+if not current then
+	if ARGV[3] == '' then
+		redis.call('SET', KEYS[1], ARGV[2])
+	else
+		redis.call('SET', KEYS[1], ARGV[2], 'PXAT', ARGV[3])
+	end
+
+	current = ARGV[2]
+end
+
+if redis.call('HGET', KEYS[2], ARGV[1]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+end
+
+return current
+`)
+
+// migrateToHashScript is migrateToEntryScript the other way around.
+//
+// KEYS[1] = hash, KEYS[2] = entry key
+// ARGV[1] = field, ARGV[2] = value read from the entry key
+var migrateToHashScript = registerScript("migrate_to_hash", `
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if not current then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	current = ARGV[2]
+end
+
+if redis.call('GET', KEYS[2]) == ARGV[2] then
+	redis.call('DEL', KEYS[2])
+end
+
+return current
+`)
+
+// layoutRollout is what WithLayoutRollout configured.
+type layoutRollout struct {
+	fraction float64
+	fallback bool
+}
+
+// WithLayoutRollout moves the given fraction of keys, from 0 to 1, from the
+// hash layout, where every ratelimit is a field of one hash, to the per-key
+// layout, where every ratelimit is a key of its own that expires with its
+// window. Which keys move is decided by the hash of WithHashFunc, so instances
+// with the same fraction agree on every key, and raising the fraction only
+// adds keys: one on the per-key layout at 1% still is at 50%.
+//
+// Reads look at a key's own layout first and then at the other one, in a
+// single pipeline, and move a ratelimit they only find in the other one over,
+// so the fraction can be changed between deploys while instances with the old
+// and the new one run side by side, and lowered again to roll back. Writes
+// remove the copy in the other layout. Once every instance is at 1,
+// WithoutLayoutFallback drops the second read.
+//
+// Get, GetDetailed, Peek, Put, Reset, Txn and Consume with FixedWindow use the
+// layouts. Reads of many ratelimits at once, like GetMany and the scans of
+// AdminClient, only see the hash, although ResetAll deletes both. Options that
+// keep per-key state next to the field, like WithFieldTTL or WithResetIndex,
+// make New fail.
+func WithLayoutRollout(fraction float64) func(o *options) {
+	return func(o *options) {
+		o.layoutRollout = &layoutRollout{fraction: fraction}
+	}
+}
+
+// WithoutLayoutFallback makes reads of WithLayoutRollout only look at a key's
+// own layout, for once every instance is at the same fraction, usually 1, and
+// nothing is left in the other layout. Ratelimits that still are there are
+// ignored and removed by the next write.
+func WithoutLayoutFallback() func(o *options) {
+	return func(o *options) {
+		o.layoutNoFallback = true
+	}
+}
+
+// checkLayoutRollout returns an error that lists the configured options that
+// can't be used with WithLayoutRollout.
+func checkLayoutRollout(config *options) error {
+	if config.layoutRollout == nil {
+		if config.layoutNoFallback {
+			return errors.New("WithoutLayoutFallback needs WithLayoutRollout")
+		}
+
+		return nil
+	}
+
+	if fraction := config.layoutRollout.fraction; !(fraction >= 0 && fraction <= 1) {
+		return fmt.Errorf("WithLayoutRollout needs a fraction in [0, 1], not %v", fraction)
+	}
+
+	var conflicts []string
+	for _, option := range []struct {
+		name string
+		set  bool
+	}{
+		{"WithDerivedReset", config.derivedReset},
+		{"WithFallbackPrefix", config.fallbackPrefix != ""},
+		{"WithFieldTTL", config.fieldTTL},
+		{"WithFirstSeenTracking", config.firstSeenTracking},
+		{"WithMirrorFormat", config.mirrorPrefix != ""},
+		{"WithPersistentEntries", config.persistentEntries},
+		{"WithRateEstimation", config.rateHalfLife > 0},
+		{"WithResetIndex", config.resetIndex},
+		{"WithTenantKeyBudget", config.tenantMaxKeys > 0},
+		{"WithTombstones", config.tombstoneTTL > 0},
+		{"WithWindowedKeys", config.keyWindow > 0},
+		{"WithWriteVerification", config.verifyRate > 0},
+	} {
+		if option.set {
+			conflicts = append(conflicts, option.name)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("WithLayoutRollout can't be used with %s", strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// entryKey returns the key that the given storage key has on the per-key
+// layout.
+func (p *Provider) entryKey(key string) string {
+	return p.companionKey("entry", key)
+}
+
+// onEntryLayout returns whether the given storage key is on the per-key layout.
+func (p *Provider) onEntryLayout(key string) bool {
+	if p.layout == nil {
+		return false
+	}
+
+	// FNV-1a barely changes the top bits of keys that only differ at the
+	// end, so the hash is mixed with the finalizer of SplitMix64 before they
+	// make a value in [0, 1).
+	h := p.hash(key)
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return float64(h>>11)/(1<<53) < p.layout.fraction
+}
+
+// entryExpiry returns when a ratelimit that resets at the given time expires
+// on the per-key layout, in Unix milliseconds, or an empty string if it
+// doesn't.
+func (p *Provider) entryExpiry(resetAt time.Time) string {
+	if resetAt.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(resetAt.Add(p.expiryGrace).UnixMilli(), 10)
+}
+
+// fetchLayout is fetchSource with WithLayoutRollout.
+func (p *Provider) fetchLayout(ctx context.Context, key string) (string, ReadSource, error) {
+	var (
+		hash         = p.hashKey()
+		onEntry      = p.onEntryLayout(key)
+		entry, field *redis.StringCmd
+	)
+
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if onEntry || p.layout.fallback {
+			entry = pipe.Get(ctx, p.entryKey(key))
+		}
+
+		if !onEntry || p.layout.fallback {
+			field = pipe.HGet(ctx, hash, key)
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+
+	own, other := field, entry
+	if onEntry {
+		own, other = entry, field
+	}
+
+	data, err := own.Result()
+	if err == nil {
+		return data, ReadPrimary, nil
+	}
+
+	if !errors.Is(err, redis.Nil) {
+		return "", "", err
+	}
+
+	if other == nil {
+		return "", "", nil
+	}
+
+	data, err = other.Result()
+	if errors.Is(err, redis.Nil) {
+		return "", "", nil
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if onEntry {
+		expiry := ""
+		if rl, err := p.decode(data); err == nil {
+			expiry = p.entryExpiry(rl.ResetTime)
+		}
+
+		data, err = p.runScript(ctx, migrateToEntryScript, []string{p.entryKey(key), hash}, key, data, expiry).Text()
+	} else {
+		data, err = p.runScript(ctx, migrateToHashScript, []string{hash, p.entryKey(key)}, key, data).Text()
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	p.recordChange(hash, key, data, false)
+	return data, ReadPrimary, nil
+}
+
+// putEntry writes a ratelimit on the per-key layout.
+func (p *Provider) putEntry(ctx context.Context, hash, key string, data []byte, resetAt time.Time) error {
+	return p.runScript(ctx, putEntryScript, []string{p.entryKey(key), hash}, key, string(data), p.entryExpiry(resetAt)).Err()
+}
+
+// commitEntry runs entryTxnScript for the given transaction, returning false
+// if the key was changed in the meantime.
+func (p *Provider) commitEntry(ctx context.Context, hash, key, existed string, tx *Txn) (bool, error) {
+	fallback := ""
+	if p.layout.fallback {
+		fallback = "1"
+	}
+
+	keys := []string{p.entryKey(key), hash, p.metaKey()}
+	committed, err := p.runScript(ctx, entryTxnScript, keys, key, existed, tx.data, tx.next, p.entryExpiry(tx.resetAt), fallback).Int()
+	return committed == 1, err
+}
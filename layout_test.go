@@ -0,0 +1,214 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLayoutRolloutPlacement(t *testing.T) {
+	low, _ := newTestProvider(t, WithLayoutRollout(0.2))
+	high, _ := newTestProvider(t, WithLayoutRollout(0.5))
+	again, _ := newTestProvider(t, WithLayoutRollout(0.2))
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if low.onEntryLayout(key) != again.onEntryLayout(key) {
+			t.Fatalf("%q is placed differently by providers with the same fraction", key)
+		}
+
+		if low.onEntryLayout(key) {
+			moved++
+			if !high.onEntryLayout(key) {
+				t.Fatalf("%q went back to the hash at a higher fraction", key)
+			}
+		}
+	}
+
+	if moved < 1800 || moved > 2200 {
+		t.Fatalf("%d of 10000 keys moved at 0.2", moved)
+	}
+}
+
+func TestLayoutRolloutPut(t *testing.T) {
+	resetAt := time.Now().Add(time.Minute).Truncate(time.Millisecond)
+	rl := &types.Ratelimit{Limit: 10, Remaining: 7, ResetTime: resetAt}
+
+	hashed, s := newTestProvider(t, WithLayoutRollout(0))
+	if err := hashed.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if s.HGet(hashed.hashKey(), hashed.storageKey("k")) == "" || s.Exists(hashed.entryKey(hashed.storageKey("k"))) {
+		t.Fatal("Put at 0 didn't write to the hash")
+	}
+
+	keyed, s := newTestProvider(t, WithLayoutRollout(1))
+	if err := keyed.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	entry := keyed.entryKey(keyed.storageKey("k"))
+	if !s.Exists(entry) || s.HGet(keyed.hashKey(), keyed.storageKey("k")) != "" {
+		t.Fatal("Put at 1 didn't write a key of its own")
+	}
+
+	if ttl := s.TTL(entry); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("the key expires in %v, want up to a minute", ttl)
+	}
+
+	if got, err := keyed.Peek("k"); err != nil || got == nil || got.Remaining != 7 || !got.ResetTime.Equal(resetAt) {
+		t.Fatalf("Peek = %+v, %v", got, err)
+	}
+}
+
+func TestLayoutRolloutMigratesOnRead(t *testing.T) {
+	hashed, s := newTestProvider(t, WithLayoutRollout(0))
+	keyed := newTestProviderOn(t, s, WithLayoutRollout(1))
+	key := hashed.storageKey("k")
+
+	putAll(t, hashed, "k")
+	if rl, err := keyed.Peek("k"); err != nil || rl == nil || rl.Remaining != 10 {
+		t.Fatalf("Peek from the other layout = %+v, %v", rl, err)
+	}
+
+	if !s.Exists(keyed.entryKey(key)) || s.HGet(hashed.hashKey(), key) != "" {
+		t.Fatal("the read didn't move the ratelimit to its own key")
+	}
+
+	// And back again, like after a rollback.
+	if rl, err := hashed.Get("k"); err != nil || rl == nil || rl.Remaining != 9 {
+		t.Fatalf("Get from the other layout = %+v, %v", rl, err)
+	}
+
+	if s.Exists(keyed.entryKey(key)) || s.HGet(hashed.hashKey(), key) == "" {
+		t.Fatal("the read didn't move the ratelimit back to the hash")
+	}
+}
+
+func TestLayoutRolloutMixedFractions(t *testing.T) {
+	before, s := newTestProvider(t, WithLayoutRollout(0.2))
+	after := newTestProviderOn(t, s, WithLayoutRollout(0.8))
+
+	differ := 0
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if before.onEntryLayout(key) != after.onEntryLayout(key) {
+			differ++
+		}
+
+		allowed := 0
+		for j := 0; j < 15; j++ {
+			p := before
+			if j%2 == 1 {
+				p = after
+			}
+
+			decision, err := p.Consume(key, 10, time.Minute)
+			if err != nil {
+				t.Fatalf("Consume(%q): %v", key, err)
+			}
+
+			if decision.Allowed {
+				allowed++
+			}
+		}
+
+		if allowed != 10 {
+			t.Fatalf("%q allowed %d requests, want 10", key, allowed)
+		}
+	}
+
+	if differ == 0 {
+		t.Fatal("no key is on different layouts")
+	}
+}
+
+func TestWithoutLayoutFallback(t *testing.T) {
+	hashed, s := newTestProvider(t, WithLayoutRollout(0))
+	keyed := newTestProviderOn(t, s, WithLayoutRollout(1), WithoutLayoutFallback())
+
+	putAll(t, hashed, "k")
+	before := s.CommandCount()
+	if rl, err := keyed.Peek("k"); err != nil || rl != nil {
+		t.Fatalf("Peek without the fallback = %+v, %v", rl, err)
+	}
+
+	if commands := s.CommandCount() - before; commands != 1 {
+		t.Fatalf("Peek sent %d commands, want 1", commands)
+	}
+
+	// The next write removes what's left in the hash.
+	putAll(t, keyed, "k")
+	if s.HGet(hashed.hashKey(), hashed.storageKey("k")) != "" {
+		t.Fatal("Put left the ratelimit in the hash")
+	}
+
+	if _, err := New(WithClient(keyed.client), WithoutLayoutFallback()); err == nil {
+		t.Fatal("New accepted WithoutLayoutFallback without WithLayoutRollout")
+	}
+}
+
+func TestLayoutRolloutReset(t *testing.T) {
+	keyed, s := newTestProvider(t, WithLayoutRollout(1))
+	putAll(t, keyed, "a", "b")
+
+	if ok, err := keyed.Reset("a"); err != nil || !ok {
+		t.Fatalf("Reset = %v, %v", ok, err)
+	}
+
+	if s.Exists(keyed.entryKey(keyed.storageKey("a"))) {
+		t.Fatal("Reset left the key behind")
+	}
+
+	if ok, err := keyed.Reset("a"); err != nil || ok {
+		t.Fatalf("Reset of a missing key = %v, %v", ok, err)
+	}
+
+	if _, err := keyed.ResetAll(context.Background(), nil); err != nil {
+		t.Fatalf("ResetAll: %v", err)
+	}
+
+	if s.Exists(keyed.entryKey(keyed.storageKey("b"))) {
+		t.Fatal("ResetAll left a key behind")
+	}
+}
+
+func TestLayoutRolloutOptions(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, fraction := range []float64{-0.1, 1.5} {
+		if _, err := New(WithClient(p.client), WithLayoutRollout(fraction)); err == nil {
+			t.Fatalf("New accepted a fraction of %v", fraction)
+		}
+	}
+
+	_, err := New(WithClient(p.client), WithLayoutRollout(0.5), WithFieldTTL(), WithResetIndex())
+	if err == nil || !strings.Contains(err.Error(), "WithFieldTTL, WithResetIndex") {
+		t.Fatalf("New with conflicting options = %v", err)
+	}
+}
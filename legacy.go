@@ -0,0 +1,302 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// legacyDefaultPrefix is the prefix that was the default before it could
+	// be configured, which MigrateLegacy looks under unless told otherwise.
+	legacyDefaultPrefix = "chi_ratelimit"
+
+	// legacyBatchSize is how many fields MigrateLegacy looks at at once.
+	legacyBatchSize = 100
+
+	// autoLegacyThrottle is how long WithAutoLegacyMigration waits between
+	// batches.
+	autoLegacyThrottle = 100 * time.Millisecond
+)
+
+// LegacyOption configures MigrateLegacy.
+type LegacyOption func(o *legacyOptions)
+
+type legacyOptions struct {
+	prefixes []string
+	throttle time.Duration
+	progress func(MigrateReport)
+}
+
+// WithLegacyPrefixes sets the prefixes whose hashes MigrateLegacy moves
+// ratelimits out of. It's "chi_ratelimit", the default prefix, unless that's
+// the configured one.
+func WithLegacyPrefixes(prefixes ...string) LegacyOption {
+	return func(o *legacyOptions) {
+		o.prefixes = prefixes
+	}
+}
+
+// WithLegacyThrottle makes MigrateLegacy wait d after every batch, so it
+// doesn't compete with traffic.
+func WithLegacyThrottle(d time.Duration) LegacyOption {
+	return func(o *legacyOptions) {
+		o.throttle = d
+	}
+}
+
+// WithLegacyProgress calls fn with the report so far after every batch.
+func WithLegacyProgress(fn func(MigrateReport)) LegacyOption {
+	return func(o *legacyOptions) {
+		o.progress = fn
+	}
+}
+
+// MigrateReport is the result of AdminClient.MigrateLegacy.
+type MigrateReport struct {
+	// Scanned is how many entries were looked at.
+	Scanned int64
+
+	// Migrated is how many ratelimits were moved from a legacy prefix to the
+	// configured one.
+	Migrated int64
+
+	// Superseded is how many ratelimits under a legacy prefix were dropped
+	// because the configured prefix already had one for their key.
+	Superseded int64
+
+	// Normalized is how many ratelimits only decoded leniently and were
+	// rewritten in the current format, moved or not.
+	Normalized int64
+
+	// Undecodable lists the keys whose value couldn't be decoded at all, by
+	// the hash they're in. They are left where they are.
+	Undecodable map[string][]string
+}
+
+// WithAutoLegacyMigration runs AdminClient.MigrateLegacy with the given
+// options in the background once the Provider is created, waiting 100ms
+// between batches unless WithLegacyThrottle says otherwise, and logs its
+// progress and result to the logger of WithLogger. Shutdown stops it after the
+// batch it's in.
+func WithAutoLegacyMigration(opts ...LegacyOption) func(o *options) {
+	return func(o *options) {
+		o.autoLegacyMigration = true
+		o.autoLegacyOptions = opts
+	}
+}
+
+// MigrateLegacy moves ratelimits that older deployments left under other
+// prefixes (see WithLegacyPrefixes) to the configured one, and rewrites the
+// ones under every prefix, the configured one included, that only decode
+// leniently (like camelCase fields or numbers as strings) in the current
+// format. Values that decode as they are aren't rewritten.
+//
+// It's safe to run while traffic flows and to run again: a moved ratelimit is
+// deleted from the legacy hash in the same step it's written under the
+// configured prefix, a ratelimit the configured prefix already has always
+// wins, and a rewrite only happens if the value didn't change since it was
+// read. Legacy hashes aren't expected to be written anymore while it runs.
+// Moved ratelimits get their reset index entry and field TTL on their next
+// write.
+func (a *AdminClient) MigrateLegacy(ctx context.Context, opts ...LegacyOption) (report *MigrateReport, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "migrate_legacy", "")
+	ctx = p.maintenanceContext(ctx)
+
+	config := &legacyOptions{}
+	if p.KeyPrefix() != legacyDefaultPrefix {
+		config.prefixes = []string{legacyDefaultPrefix}
+	}
+
+	for _, override := range opts {
+		override(config)
+	}
+
+	report = &MigrateReport{}
+	hash := p.hashKey()
+	for i, prefix := range append([]string{hash}, config.prefixes...) {
+		if i > 0 && prefix == hash {
+			continue
+		}
+
+		if err := p.migrateHash(ctx, hash, prefix, config, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// migrateHash moves or normalizes every ratelimit in source, which is hash
+// itself when only normalizing.
+func (p *Provider) migrateHash(ctx context.Context, hash, source string, config *legacyOptions, report *MigrateReport) error {
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		items, next, err := p.cmd(ctx).HScan(ctx, source, cursor, "", legacyBatchSize).Result()
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i+1 < len(items); i += 2 {
+			if err := p.migrateField(ctx, hash, source, items[i], items[i+1], report); err != nil {
+				return err
+			}
+		}
+
+		if config.progress != nil {
+			config.progress(*report)
+		}
+
+		if next == 0 {
+			return nil
+		}
+
+		cursor = next
+		if config.throttle > 0 {
+			timer := time.NewTimer(config.throttle)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// migrateField moves or normalizes a single field of source.
+func (p *Provider) migrateField(ctx context.Context, hash, source, key, value string, report *MigrateReport) error {
+	report.Scanned++
+
+	data, normalized, err := p.normalizeLegacy(value)
+	if err != nil {
+		if report.Undecodable == nil {
+			report.Undecodable = map[string][]string{}
+		}
+
+		report.Undecodable[source] = append(report.Undecodable[source], key)
+		return nil
+	}
+
+	if normalized {
+		report.Normalized++
+	}
+
+	if source == hash {
+		if !normalized || p.dryRun {
+			return nil
+		}
+
+		// The same check-and-set that Txn commits with, so a value that was
+		// written in the meantime is never overwritten.
+		_, err := p.runScript(ctx, txnScript, p.entryKeys(hash), key, "1", value, data, "", "", p.now().UnixMilli()).Int()
+		if err == nil {
+			p.recordChange(hash, key, data, false)
+		}
+
+		return err
+	}
+
+	if p.dryRun {
+		return nil
+	}
+
+	current, err := p.runScript(ctx, migrateFieldScript, []string{hash, source}, key, data).Text()
+	if err != nil {
+		return err
+	}
+
+	if current != data {
+		report.Superseded++
+		return nil
+	}
+
+	report.Migrated++
+	p.recordChange(hash, key, data, false)
+	return nil
+}
+
+// normalizeLegacy returns value in the current format, and whether it had to
+// be rewritten for that.
+func (p *Provider) normalizeLegacy(value string) (string, bool, error) {
+	if _, err := p.decode(value); err == nil {
+		return value, false, nil
+	}
+
+	rl, err := decodeLenient(bytes.TrimSpace([]byte(value)))
+	if err != nil {
+		return "", false, err
+	}
+
+	data, err := p.encode(rl)
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(data), true, nil
+}
+
+// autoMigrateLegacy is the background work of WithAutoLegacyMigration.
+func (p *Provider) autoMigrateLegacy(opts []LegacyOption) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	logf := p.logf
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+
+	progress := func(report MigrateReport) {
+		logf("chi-ratelimit-redis: legacy migration: %d scanned, %d migrated, %d normalized", report.Scanned, report.Migrated, report.Normalized)
+	}
+
+	opts = append([]LegacyOption{WithLegacyThrottle(autoLegacyThrottle)}, opts...)
+	report, err := p.Admin().MigrateLegacy(ctx, append(opts, WithLegacyProgress(progress))...)
+	if err != nil {
+		logf("chi-ratelimit-redis: legacy migration stopped: %v", err)
+		return
+	}
+
+	summary := fmt.Sprintf("%d migrated, %d superseded, %d normalized", report.Migrated, report.Superseded, report.Normalized)
+	for hash, keys := range report.Undecodable {
+		summary += fmt.Sprintf(", %d undecodable in %q", len(keys), hash)
+	}
+
+	logf("chi-ratelimit-redis: legacy migration done: %s", summary)
+}
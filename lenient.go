@@ -0,0 +1,155 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+	"time"
+)
+
+// unixMillisThreshold separates Unix timestamps in seconds from ones in
+// milliseconds: seconds only get this large in the year 33658.
+const unixMillisThreshold = 1e12
+
+// WithLenientDecoding accepts JSON ratelimits written by other clients of the
+// same hash, like edge workers in another language, that don't quite match
+// the shape this Provider writes. On top of what is normally accepted, it
+// takes:
+//
+//   - camelCase field names ("resetTime") next to the usual snake_case ones
+//   - numbers and booleans stored as strings ("10", "true")
+//   - reset times as RFC 3339 strings, Unix seconds, or Unix milliseconds,
+//     either as numbers or as strings
+//   - a missing "remaining", which is filled in from "limit"
+//
+// Unknown fields are ignored, like they always are. Without it, strings in
+// place of numbers, other timestamp formats and missing fields fail to decode,
+// so the read returns the error. It doesn't change what's written, and it has
+// no effect with WithWireFormat.
+func WithLenientDecoding() func(o *options) {
+	return func(o *options) {
+		o.lenientDecoding = true
+	}
+}
+
+// lenientRatelimit is the loosely typed shape that WithLenientDecoding reads.
+type lenientRatelimit struct {
+	ResetTime      json.RawMessage `json:"reset_time"`
+	ResetTimeCamel json.RawMessage `json:"resetTime"`
+	Remaining      json.RawMessage `json:"remaining"`
+	Global         json.RawMessage `json:"global"`
+	Limit          json.RawMessage `json:"limit"`
+}
+
+// decodeLenient decodes a JSON ratelimit of any shape WithLenientDecoding
+// accepts.
+func decodeLenient(data []byte) (*types.Ratelimit, error) {
+	var raw *lenientRatelimit
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if raw == nil {
+		return nil, ErrEmptyValue
+	}
+
+	resetField := raw.ResetTime
+	if resetField == nil {
+		resetField = raw.ResetTimeCamel
+	}
+
+	if resetField == nil {
+		return nil, fmt.Errorf("%w: no reset time", ErrMalformedValue)
+	}
+
+	resetTime, err := lenientTime(resetField)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := lenientInt(raw.Limit, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := limit
+	if raw.Remaining != nil {
+		if remaining, err = lenientInt(raw.Remaining, "remaining"); err != nil {
+			return nil, err
+		}
+	}
+
+	global := false
+	if raw.Global != nil {
+		if global, err = strconv.ParseBool(unquote(raw.Global)); err != nil {
+			return nil, fmt.Errorf("%w: global is %s", ErrMalformedValue, raw.Global)
+		}
+	}
+
+	return &types.Ratelimit{ResetTime: resetTime, Remaining: remaining, Global: global, Limit: limit}, nil
+}
+
+// unquote returns the contents of a JSON string, or the raw value if it isn't
+// one.
+func unquote(value json.RawMessage) string {
+	var s string
+	if bytes.HasPrefix(value, []byte(`"`)) && json.Unmarshal(value, &s) == nil {
+		return s
+	}
+
+	return string(value)
+}
+
+func lenientInt(value json.RawMessage, name string) (int32, error) {
+	if value == nil {
+		return 0, fmt.Errorf("%w: no %s", ErrMalformedValue, name)
+	}
+
+	n, err := strconv.ParseInt(unquote(value), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s is %s", ErrMalformedValue, name, value)
+	}
+
+	return int32(n), nil
+}
+
+func lenientTime(value json.RawMessage) (time.Time, error) {
+	s := unquote(value)
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n >= unixMillisThreshold {
+			return time.UnixMilli(int64(n)), nil
+		}
+
+		return time.Unix(int64(n), 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: reset time is %s", ErrMalformedValue, value)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,88 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// LimitChangePolicy is what Consume does with a window whose stored limit
+// differs from the one that it's called with, like after a customer's limit
+// was lowered.
+type LimitChangePolicy int
+
+const (
+	// LimitChangeNextWindow keeps counting against the stored limit until the
+	// window is over, and only the next window gets the new one.
+	LimitChangeNextWindow LimitChangePolicy = iota
+
+	// LimitChangeClamp stores the new limit right away, and lowers the
+	// remaining requests to it if they are above it. The window keeps its
+	// reset time.
+	LimitChangeClamp
+
+	// LimitChangeReset starts a new window with the new limit right away.
+	LimitChangeReset
+)
+
+// WithLimitChangePolicy sets what Consume with FixedWindow, and the
+// chi-ratelimit adapter, do when the limit they're called with (after
+// WithLimitCatalog) differs from the stored one. It's LimitChangeNextWindow
+// by default. The check happens in the same transaction that counts the
+// request, so the adjustment is never lost to a concurrent Consume. While
+// WithColdStartRamp or WithEvictionBudget lower the limits of new windows,
+// limits aren't compared at all, since every such window would look changed.
+func WithLimitChangePolicy(policy LimitChangePolicy) func(o *options) {
+	return func(o *options) {
+		o.limitChangePolicy = policy
+	}
+}
+
+// countedWindow returns the fixed window that a request at now for the given
+// key counts in: a new one if there is none or current is over, or current with
+// the LimitChangePolicy applied. fresh is true if the window is a new one.
+func (p *Provider) countedWindow(key string, current *types.Ratelimit, limit int32, window time.Duration, now time.Time) (rl *types.Ratelimit, fresh bool) {
+	if current != nil && current.ResetTime.After(now) {
+		if p.limitChangePolicy == LimitChangeNextWindow {
+			return current, false
+		}
+
+		limit, _ := p.limitFor(key, limit, window)
+		if current.Limit == limit || p.evictionLimit(p.rampedLimit(limit)) != limit {
+			return current, false
+		}
+
+		if p.limitChangePolicy == LimitChangeClamp {
+			clamped := *current
+			clamped.Limit = limit
+			if clamped.Remaining > limit {
+				clamped.Remaining = limit
+			}
+
+			return &clamped, false
+		}
+	}
+
+	limit, window = p.limitFor(key, limit, window)
+	return types.NewRatelimit(p.evictionLimit(p.rampedLimit(limit)), false, p.windowEnd(now, window).Add(p.jitter(key))), true
+}
@@ -0,0 +1,226 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"sync/atomic"
+	"time"
+)
+
+// LimiterDefaults is what a Limiter's Consume and Inspect limit requests by.
+type LimiterDefaults struct {
+	Limit  int64
+	Window time.Duration
+
+	// Burst and Sustained, if Burst isn't zero, are the bucket that the
+	// Limiter's Take and TakeN use instead of the one of WithBurstBucket.
+	Burst     int64
+	Sustained float64
+}
+
+// LimiterStats counts what a Limiter's Consume and TakeN did since it was
+// created.
+type LimiterStats struct {
+	// Allowed and Rejected count the requests that Consume and TakeN decided
+	// on.
+	Allowed  uint64
+	Rejected uint64
+
+	// Errors counts the calls to Consume and TakeN that failed.
+	Errors uint64
+}
+
+// Limiter is a named policy, like "login" or "search", on top of a Provider.
+// Its ratelimits live in the Provider's hash under their own sub-prefix
+// ("<name>:<key>"), so several Limiters share one Provider, with its client,
+// options and background work, without seeing each other's keys. It is a
+// providers.Provider, so it can be given to the chi-ratelimit middleware.
+type Limiter struct {
+	provider *Provider
+	name     string
+	prefix   string
+	defaults LimiterDefaults
+
+	allowed  atomic.Uint64
+	rejected atomic.Uint64
+	errors   atomic.Uint64
+}
+
+var _ providers.Provider = (*Limiter)(nil)
+
+// Limiter returns a Limiter with the given name, which should be unique per
+// Provider and shouldn't contain ':', since a Limiter named "a" with the key
+// "b:c" would share its ratelimit with one named "a:b" and the key "c". Keys
+// stored through the Provider itself can collide with a Limiter's too if they
+// start with its name and a ':'.
+func (p *Provider) Limiter(name string, defaults LimiterDefaults) *Limiter {
+	return &Limiter{provider: p, name: name, prefix: name + ":", defaults: defaults}
+}
+
+// key returns the key that the Provider stores the Limiter's key under.
+func (l *Limiter) key(key string) string {
+	return l.prefix + key
+}
+
+func (l *Limiter) Name() string {
+	return l.provider.Name() + " (limiter " + l.name + ")"
+}
+
+// LimiterName returns the name that the Limiter was created with, like for a
+// metrics label.
+func (l *Limiter) LimiterName() string {
+	return l.name
+}
+
+// Defaults returns the limit and window that the Limiter was created with.
+func (l *Limiter) Defaults() LimiterDefaults {
+	return l.defaults
+}
+
+// Consume is Provider.Consume for the given key with the Limiter's defaults.
+func (l *Limiter) Consume(key string) (Decision, error) {
+	decision, err := l.provider.Consume(l.key(key), l.defaults.Limit, l.defaults.Window)
+	switch {
+	case err != nil:
+		l.errors.Add(1)
+		return decision, err
+	case decision.Allowed:
+		l.allowed.Add(1)
+	default:
+		l.rejected.Add(1)
+	}
+
+	decision.PolicyName = l.name
+	return decision, err
+}
+
+// Inspect is Consume without counting a request.
+func (l *Limiter) Inspect(key string) (Decision, error) {
+	decision, err := l.provider.Inspect(l.key(key), l.defaults.Limit, l.defaults.Window)
+	if err != nil {
+		return decision, err
+	}
+
+	decision.PolicyName = l.name
+	return decision, nil
+}
+
+// Take is Provider.Take for the Limiter's key, see TakeN.
+func (l *Limiter) Take(key string) (Decision, error) {
+	return l.TakeN(key, 1)
+}
+
+// TakeN is Provider.TakeN for the Limiter's key, with the bucket of its
+// LimiterDefaults if they have one. It still needs WithBurstBucket.
+func (l *Limiter) TakeN(key string, n int64) (decision Decision, err error) {
+	p := l.provider
+	defer p.recoverPanic(&err, "take", key)
+
+	if p.burstBucket == nil {
+		return Decision{}, ErrBucketDisabled
+	}
+
+	bucket := p.burstBucket
+	if l.defaults.Burst != 0 {
+		bucket = &burstBucket{burst: l.defaults.Burst, sustained: l.defaults.Sustained}
+	}
+
+	if !bucket.valid() {
+		return Decision{}, errors.New("the Limiter's bucket needs a positive burst and sustained rate")
+	}
+
+	decision, err = p.take(bucket, p.storageKey(l.key(key)), n)
+	switch {
+	case err != nil:
+		l.errors.Add(1)
+		return decision, err
+	case decision.Allowed:
+		l.allowed.Add(1)
+	default:
+		l.rejected.Add(1)
+	}
+
+	decision.PolicyName = l.name
+	return decision, nil
+}
+
+// Get is Provider.Get for the Limiter's key.
+func (l *Limiter) Get(key string) (*types.Ratelimit, error) {
+	return l.provider.Get(l.key(key))
+}
+
+// Peek is Provider.Peek for the Limiter's key.
+func (l *Limiter) Peek(key string) (*types.Ratelimit, error) {
+	return l.provider.Peek(l.key(key))
+}
+
+// Put is Provider.Put for the Limiter's key.
+func (l *Limiter) Put(key string, rl *types.Ratelimit) error {
+	return l.provider.Put(l.key(key), rl)
+}
+
+// Reset is Provider.Reset for the Limiter's key.
+func (l *Limiter) Reset(key string) (bool, error) {
+	return l.provider.Reset(l.key(key))
+}
+
+// Stats returns what the Limiter's Consume counted so far.
+func (l *Limiter) Stats() LimiterStats {
+	return LimiterStats{
+		Allowed:  l.allowed.Load(),
+		Rejected: l.rejected.Load(),
+		Errors:   l.errors.Load(),
+	}
+}
+
+// ResetAll deletes every ratelimit of the Limiter and returns how many were
+// deleted, leaving the ones of other Limiters and of the Provider alone. Like
+// AdminClient.ResetAll when it can't unlink the hash, it scans the whole hash,
+// so it's a maintenance operation and shouldn't be called from a request
+// handler. Keys shortened by WithMaxKeyLength are deleted too if their prefix
+// survived the cut.
+func (l *Limiter) ResetAll(ctx context.Context, progress func(deleted int64)) (deleted int64, err error) {
+	p := l.provider
+	defer p.recoverPanic(&err, "reset_all", "")
+	ctx = p.maintenanceContext(ctx)
+	defer p.clearLocal()
+
+	err = p.scan(ctx, "ResetAll", EscapeGlob(l.prefix)+"*", resetAllBatchSize, func(fields, _ []string) error {
+		count, err := p.deleteFields(ctx, fields...)
+		if err != nil {
+			return err
+		}
+
+		deleted += count
+		if progress != nil {
+			progress(deleted)
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
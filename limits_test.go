@@ -0,0 +1,172 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"math"
+	"testing"
+	"time"
+)
+
+// largeLimits are limits around where doubles stop counting exactly, and the
+// largest one there is.
+var largeLimits = []int64{1<<53 - 1, 1 << 53, 1<<53 + 1, math.MaxInt64 - 1, math.MaxInt64}
+
+func TestCodecRoundTripBoundaries(t *testing.T) {
+	codecs := map[string][]func(o *options){
+		"json":                 nil,
+		"stable wire format":   {WithWireFormat(StableWireFormat)},
+		"rfc 3339 wire format": {WithWireFormat(WireFormat{LimitField: "l", RemainingField: "r", ResetField: "t", GlobalField: "g"})},
+		"gzip":                 {WithCompression(GzipCompression, 0)},
+		"snappy":               {WithWireFormat(StableWireFormat), WithCompression(SnappyCompression, 0)},
+	}
+
+	resetAt := time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	values := []int32{math.MaxInt32, math.MaxInt32 - 1, 0, -1, math.MinInt32}
+	for name, opts := range codecs {
+		p, _ := newTestProvider(t, opts...)
+		for _, limit := range values {
+			for _, remaining := range values {
+				rl := &types.Ratelimit{Limit: limit, Remaining: remaining, Global: remaining == 0, ResetTime: resetAt}
+				data, err := p.encode(rl)
+				if err != nil {
+					t.Fatalf("%s: encode: %v", name, err)
+				}
+
+				decoded, err := p.decode(string(data))
+				if err != nil {
+					t.Fatalf("%s: decode: %v", name, err)
+				}
+
+				if decoded.Limit != rl.Limit || decoded.Remaining != rl.Remaining || decoded.Global != rl.Global || !decoded.ResetTime.Equal(rl.ResetTime) {
+					t.Fatalf("%s: %+v came back as %+v", name, rl, decoded)
+				}
+			}
+		}
+	}
+}
+
+func TestDecisionJSONBoundaries(t *testing.T) {
+	for _, limit := range largeLimits {
+		decision := Decision{Allowed: true, Limit: limit, Remaining: limit - 1}
+		data, err := json.Marshal(decision)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Decision
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Limit != limit || decoded.Remaining != limit-1 {
+			t.Fatalf("%d came back as %d/%d from %s", limit, decoded.Limit, decoded.Remaining, data)
+		}
+	}
+}
+
+func TestConsumeLargeLimits(t *testing.T) {
+	p, _ := newTestProvider(t)
+
+	consumers := map[string]func(key string, limit int64) (Decision, error){
+		"ConsumeSliding": func(key string, limit int64) (Decision, error) {
+			return p.ConsumeSliding(key, limit, time.Minute)
+		},
+		"GlobalLimiter": func(key string, limit int64) (Decision, error) {
+			return p.GlobalLimiter(key, limit, time.Minute).Consume(1)
+		},
+		"ConsumeManyKeys": func(key string, limit int64) (Decision, error) {
+			decisions, err := p.ConsumeManyKeys([]ConsumeRequest{{Key: key, Limit: limit, Window: time.Minute}})
+			if err != nil {
+				return Decision{}, err
+			}
+
+			return decisions[0], nil
+		},
+	}
+
+	for name, consume := range consumers {
+		for _, limit := range largeLimits {
+			key := fmt.Sprintf("%s:%d", name, limit)
+			for i := int64(1); i <= 2; i++ {
+				decision, err := consume(key, limit)
+				if err != nil {
+					t.Fatalf("%s with a limit of %d: %v", name, limit, err)
+				}
+
+				if !decision.Allowed || decision.Limit != limit || decision.Remaining != limit-i {
+					t.Fatalf("%s with a limit of %d = allowed %t, %d/%d", name, limit, decision.Allowed, decision.Remaining, decision.Limit)
+				}
+			}
+		}
+	}
+}
+
+// ConsumeApprox needs BITFIELD, which miniredis doesn't have, so only what it
+// makes of the estimate is tested.
+func TestApproxLargeLimits(t *testing.T) {
+	now := time.Now()
+	for _, limit := range largeLimits {
+		if got := scriptLimit(limit); got != limit && (limit <= maxScriptLimit || got != maxScriptLimit) {
+			t.Fatalf("script limit for %d is %d", limit, got)
+		}
+
+		decision := newApproxDecision(true, 1, limit, now.Add(time.Minute), now)
+		if decision.Limit != limit || decision.Remaining != limit-1 {
+			t.Fatalf("limit of %d = %d/%d", limit, decision.Remaining, decision.Limit)
+		}
+	}
+}
+
+func TestSaturatedLimits(t *testing.T) {
+	for value, want := range map[string]struct {
+		limit     int32
+		saturated bool
+	}{
+		"100/1m":                  {100, false},
+		"2147483647/1m":           {math.MaxInt32, false},
+		"2147483648/1m":           {math.MaxInt32, true},
+		"9007199254740993/1m":     {math.MaxInt32, true},
+		"9223372036854775807/1m":  {math.MaxInt32, true},
+		"99999999999999999999/1m": {math.MaxInt32, true},
+	} {
+		limit, saturated, err := parseCatalogLimit(value)
+		if err != nil || limit.limit != want.limit || saturated != want.saturated {
+			t.Errorf("parseCatalogLimit(%q) = %d, %t, %v", value, limit.limit, saturated, err)
+		}
+	}
+
+	var logged int
+	p, _ := newTestProvider(t, WithLogger(func(string, ...interface{}) { logged++ }))
+	adapter := NewConsumeAdapter(p, math.MaxInt64, time.Minute)
+	if logged != 1 {
+		t.Fatalf("NewConsumeAdapter logged %d times for a saturated limit", logged)
+	}
+
+	rl, err := adapter.Get("k")
+	if err != nil || rl.Limit != math.MaxInt32 || rl.Remaining != math.MaxInt32-1 {
+		t.Fatalf("Get = %+v, %v", rl, err)
+	}
+}
@@ -0,0 +1,142 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+// linkScript links a child to its parent, making the link set live at least as
+// long as the child's window.
+//
+// KEYS[1] = link set
+// ARGV[1] = child field, ARGV[2] = milliseconds until the child resets
+var linkScript = registerScript("link", `
+redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+
+return 1
+`)
+
+// cascadeScript deletes a parent, every child linked to it, and then the link
+// set itself. It returns how many ratelimits were deleted followed by the
+// children that were linked.
+//
+// KEYS[1] = hash, KEYS[2] = link set, KEYS[3] = metadata hash,
+// KEYS[4] = reset index (optional)
+// ARGV[1] = parent field
+var cascadeScript = registerScript("cascade", `
+local children = redis.call('SMEMBERS', KEYS[2])
+local fields = { ARGV[1] }
+for _, child in ipairs(children) do
+	table.insert(fields, child)
+end
+
+local deleted = 0
+for i = 1, #fields, 1000 do
+	local batch = { unpack(fields, i, math.min(i + 999, #fields)) }
+	deleted = deleted + redis.call('HDEL', KEYS[1], unpack(batch))
+	redis.call('HDEL', KEYS[3], unpack(batch))
+	if KEYS[4] then
+		redis.call('ZREM', KEYS[4], unpack(batch))
+	end
+end
+
+redis.call('DEL', KEYS[2])
+table.insert(children, 1, deleted)
+return children
+`)
+
+func (p *Provider) linkKey(parent string) string {
+	return p.companionKey("links", parent)
+}
+
+// Link links childKey to parentKey, so ResetCascade on the parent also resets
+// the child, like clearing every per-IP ratelimit of an account together with
+// the account's own. The child must already have a ratelimit; the links of a
+// parent expire once the last of its children's windows has been reset.
+func (p *Provider) Link(parentKey, childKey string) (err error) {
+	defer p.recoverPanic(&err, "link", childKey)
+
+	child := p.storageKey(childKey)
+	rl, err := p.fetch(child, nil)
+	if err != nil {
+		return err
+	}
+
+	if rl == nil {
+		return fmt.Errorf("%w: can't link the child", ErrNotFound)
+	}
+
+	ttl := rl.ResetTime.Sub(p.now())
+	if ttl < time.Millisecond {
+		// The window is already over, so there is nothing left to reset.
+		return nil
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	keys := []string{p.linkKey(p.storageKey(parentKey))}
+	return p.runScript(ctx, linkScript, keys, child, ttl.Milliseconds()).Err()
+}
+
+// ResetCascade deletes the ratelimit of parentKey and of every key linked to it
+// with Link in one step, then the links themselves, and returns how many
+// ratelimits were deleted. Tombstones aren't kept for these.
+func (p *Provider) ResetCascade(parentKey string) (deleted int64, err error) {
+	defer p.recoverPanic(&err, "reset_cascade", parentKey)
+
+	parent := p.storageKey(parentKey)
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	keys := p.entryKeys(p.hashKey(), p.linkKey(parent))
+	result, err := p.runScript(ctx, cascadeScript, keys, parent).Slice()
+	if err != nil {
+		return 0, err
+	}
+
+	fields := []string{parent}
+	for _, child := range result[1:] {
+		if child, ok := child.(string); ok {
+			fields = append(fields, child)
+		}
+	}
+
+	p.forgetLocal(fields...)
+
+	if err := p.deleteCompanions(ctx, fields...); err != nil {
+		return 0, err
+	}
+
+	deleted, _ = result[0].(int64)
+	return deleted, replication.wait(ctx)
+}
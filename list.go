@@ -0,0 +1,130 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+)
+
+// iterateBatchSize is how many ratelimits every batch of IterateRaw asks Redis
+// for, unless WithScanBatchSize says otherwise.
+const iterateBatchSize = 100
+
+// errStopIteration stops a scan when the function given to IterateRaw returns
+// false.
+var errStopIteration = errors.New("iteration stopped")
+
+// ListEntry is a single ratelimit returned by AdminClient.List.
+type ListEntry struct {
+	// Key is the key as it's stored, which is shortened for keys longer
+	// than WithMaxKeyLength.
+	Key string
+
+	// Ratelimit is the stored ratelimit, or nil if it couldn't be decoded.
+	Ratelimit *types.Ratelimit
+}
+
+// List returns a page of the stored ratelimits, starting at cursor, which is 0
+// for the first page. count is a hint for how many entries a page should have,
+// like with HSCAN. The returned cursor is the one for the next page, and 0 once
+// there are no more. Entries can show up on more than one page if the hash
+// changes in between.
+func (a *AdminClient) List(ctx context.Context, cursor uint64, count int64) (entries []ListEntry, next uint64, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "list", "")
+	ctx = p.maintenanceContext(ctx)
+
+	items, next, err := p.cmd(ctx).HScan(ctx, p.hashKey(), cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := 0; i+1 < len(items); i += 2 {
+		entry := ListEntry{Key: items[i]}
+		if rl, err := p.decode(items[i+1]); err == nil {
+			entry.Ratelimit = p.clampRead(rl)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, next, nil
+}
+
+// IterateRaw calls fn with every stored ratelimit exactly as it's stored, with
+// any compression still applied and without decoding it, until fn returns
+// false. Keys are as they're stored, and can show up more than once if the hash
+// changes in between. It goes through the hash like the other scans, so
+// WithScanBatchSize, WithScanThrottle and WithScanProgress apply.
+func (a *AdminClient) IterateRaw(ctx context.Context, fn func(key string, raw []byte) bool) error {
+	return a.iterate(ctx, "IterateRaw", "", nil, fn)
+}
+
+// Iterate is IterateRaw with every ratelimit decoded, or nil if it couldn't be
+// decoded, like with List. Keys are the exact bytes they were stored as, so a
+// composite key can be split with SplitKey; IterateMatching and IterateByPart
+// only go through some of them.
+func (a *AdminClient) Iterate(ctx context.Context, fn func(key string, rl *types.Ratelimit) bool) error {
+	return a.iterateDecoded(ctx, "Iterate", "", nil, fn)
+}
+
+// iterate calls fn with every field that matches the MATCH pattern and, if it
+// isn't nil, the given check, until fn returns false.
+func (a *AdminClient) iterate(ctx context.Context, operation, match string, matches func(key string) bool, fn func(key string, raw []byte) bool) (err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "iterate", "")
+	ctx = p.maintenanceContext(ctx)
+
+	err = p.scan(ctx, operation, match, iterateBatchSize, func(fields, values []string) error {
+		for i, field := range fields {
+			if matches != nil && !matches(field) {
+				continue
+			}
+
+			if !fn(field, []byte(values[i])) {
+				return errStopIteration
+			}
+		}
+
+		return nil
+	})
+
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+
+	return err
+}
+
+func (a *AdminClient) iterateDecoded(ctx context.Context, operation, match string, matches func(key string) bool, fn func(key string, rl *types.Ratelimit) bool) error {
+	p := a.provider
+	return a.iterate(ctx, operation, match, matches, func(key string, raw []byte) bool {
+		rl, err := p.decode(string(raw))
+		if err != nil {
+			return fn(key, nil)
+		}
+
+		return fn(key, p.clampRead(rl))
+	})
+}
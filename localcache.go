@@ -0,0 +1,246 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+const (
+	// ReadLocalCache is the in-process cache of WithLocalCache. It can't be
+	// listed in WithReadPath, since it's always read first.
+	ReadLocalCache ReadSource = "local_cache"
+
+	// ReadNegativeCache is the in-process cache of WithNegativeCache, which
+	// remembers keys that have no ratelimit. It can't be listed in
+	// WithReadPath either.
+	ReadNegativeCache ReadSource = "negative_cache"
+)
+
+// WithLocalCache makes Get, GetDetailed and Peek answer from an in-process copy
+// of what this Provider last read or wrote for a key, for up to ttl and never
+// past the ratelimit's reset, instead of reading Redis again. Get still writes
+// the request it counts to Redis. Every write and reset of this Provider
+// updates its own copies, but writes of other instances aren't seen until ttl
+// is over, so a key can be counted against a budget that's up to ttl old; keep
+// ttl short. Copies are kept in the table that WithKeyStateLimit bounds, and
+// WithLocalCacheBytes can bound how much memory they take too. Consume, Txn
+// and the other atomic paths always read Redis.
+func WithLocalCache(ttl time.Duration) func(o *options) {
+	return func(o *options) {
+		o.localCacheTTL = ttl
+	}
+}
+
+// WithLocalCacheBytes bounds the copies of WithLocalCache by how many bytes
+// their keys and encoded ratelimits take, since those vary a lot, on top of
+// the count of WithKeyStateLimit. Once a copy goes over maxBytes, the least
+// recently used copies are dropped until they fit again, so the cache stays
+// within maxBytes plus one copy. KeyStateStats and the LocalCache callback of
+// WithMetricsHook describe the copies.
+func WithLocalCacheBytes(maxBytes int64) func(o *options) {
+	return func(o *options) {
+		o.localCacheBytes = maxBytes
+	}
+}
+
+// WithNegativeCache makes Get, GetDetailed and Peek remember for ttl that a key
+// has no ratelimit, so requests of keys that never get one, like ones the
+// middleware doesn't limit, don't read Redis every time. Like WithLocalCache,
+// only this Provider's own writes end it early.
+func WithNegativeCache(ttl time.Duration) func(o *options) {
+	return func(o *options) {
+		o.negativeCacheTTL = ttl
+	}
+}
+
+// fetchCached is fetchDetailed for Get and Peek, which answer from the queue of
+// WithAsyncWrites and the local and negative caches when they can. For a key that has no ratelimit, the
+// source is ReadNegativeCache if the negative cache knew it, and empty
+// otherwise.
+func (p *Provider) fetchCached(key string, call *callOptions) (*types.Ratelimit, ReadSource, error) {
+	if op := p.async.pending(key); op != nil {
+		if op.deleted {
+			return nil, "", nil
+		}
+
+		rl, err := p.decode(op.data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return p.clampRead(rl), ReadAsyncQueue, nil
+	}
+
+	if p.localCacheTTL <= 0 && p.negativeCacheTTL <= 0 {
+		return p.fetchDetailed(key, call)
+	}
+
+	var (
+		data    string
+		cached  bool
+		missing bool
+	)
+
+	now := p.now()
+	p.states.update(key, false, func(s *keyState) {
+		switch {
+		case now.Before(s.cacheUntil):
+			data, cached = s.cacheData, true
+		case now.Before(s.missingUntil):
+			missing = true
+		}
+	})
+
+	if missing {
+		return nil, ReadNegativeCache, nil
+	}
+
+	// A copy whose window is over is read again, since Redis might not have
+	// it anymore.
+	if cached {
+		rl, err := p.decode(data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if rl.ResetTime.IsZero() || rl.ResetTime.After(now) {
+			p.countCacheRead(true)
+			return p.clampRead(rl), ReadLocalCache, nil
+		}
+	}
+
+	p.countCacheRead(false)
+
+	data, source, expiry, err := p.fetchExpiry(key, call)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if source == "" {
+		p.cacheMissing(key)
+		return nil, "", nil
+	}
+
+	rl, err := p.decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The copy keeps the derived reset time, so it expires with the field.
+	if !expiry.IsZero() {
+		rl = withExpiry(rl, expiry)
+		if encoded, err := p.encode(rl); err == nil {
+			data = string(encoded)
+		}
+	}
+
+	p.cacheStore(key, data)
+	return p.clampRead(rl), source, nil
+}
+
+// countCacheRead counts a read of the local cache that was a hit or a miss, and
+// reports the new stats to WithMetricsHook.
+func (p *Provider) countCacheRead(hit bool) {
+	if p.localCacheTTL <= 0 {
+		return
+	}
+
+	if hit {
+		p.states.cacheHits.Add(1)
+	} else {
+		p.states.cacheMisses.Add(1)
+	}
+
+	if p.metrics.LocalCache != nil {
+		p.metrics.LocalCache(p.states.cacheStats())
+	}
+}
+
+// cacheStore makes data the local copy of the given storage key, if the local
+// cache is enabled.
+func (p *Provider) cacheStore(key, data string) {
+	if p.localCacheTTL <= 0 {
+		p.forgetLocal(key)
+		return
+	}
+
+	until := p.now().Add(p.localCacheTTL)
+	p.states.update(key, true, func(s *keyState) {
+		s.cacheData, s.cacheUntil = data, until
+		s.missingUntil = time.Time{}
+	})
+}
+
+// cacheMissing remembers that the given storage key has no ratelimit, if the
+// negative cache is enabled.
+func (p *Provider) cacheMissing(key string) {
+	if p.negativeCacheTTL <= 0 {
+		return
+	}
+
+	until := p.now().Add(p.negativeCacheTTL)
+	p.states.update(key, true, func(s *keyState) {
+		s.missingUntil = until
+	})
+}
+
+// cacheChange updates the caches for a change of this Provider to the given
+// storage key in the hash.
+func (p *Provider) cacheChange(hash, key, data string, deleted bool) {
+	if hash != p.hashKey() || (p.localCacheTTL <= 0 && p.negativeCacheTTL <= 0) {
+		return
+	}
+
+	if deleted {
+		p.forgetLocal(key)
+		return
+	}
+
+	p.cacheStore(key, data)
+}
+
+// forgetLocal forgets what this Provider remembers about the given storage
+// keys: their cached copies and their last deduplicated write.
+func (p *Provider) forgetLocal(keys ...string) {
+	if p.dedup != nil {
+		p.dedup.forget(keys...)
+	}
+
+	for _, key := range keys {
+		p.states.update(key, false, func(s *keyState) {
+			s.cacheData, s.cacheUntil, s.missingUntil = "", time.Time{}, time.Time{}
+		})
+	}
+}
+
+// clearLocal is forgetLocal for every key.
+func (p *Provider) clearLocal() {
+	if p.dedup != nil {
+		p.dedup.clear()
+	}
+
+	p.states.each(func(s *keyState) {
+		s.cacheData, s.cacheUntil, s.missingUntil = "", time.Time{}, time.Time{}
+	})
+}
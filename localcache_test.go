@@ -0,0 +1,301 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTracer keeps the attributes of every span it started.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	op    string
+	attrs map[string]string
+	ended bool
+}
+
+func (t *recordingTracer) Start(ctx context.Context, op string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := &recordingSpan{op: op, attrs: map[string]string{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) { s.attrs[key] = value }
+func (s *recordingSpan) End()                           { s.ended = true }
+
+func TestGetDetailedSources(t *testing.T) {
+	var sources []ReadSource
+	tracer := &recordingTracer{}
+	hook := MetricsHook{Read: func(op string, source ReadSource, _ time.Duration, err error) {
+		if op != "get" || err != nil {
+			t.Errorf("the metrics hook got %q, %v", op, err)
+		}
+
+		sources = append(sources, source)
+	}}
+
+	p, server := newTestProvider(t,
+		WithLocalCache(time.Minute),
+		WithNegativeCache(time.Minute),
+		WithFallbackPrefix("old", time.Now().Add(time.Hour)),
+		WithMetricsHook(hook),
+		WithTracer(tracer),
+	)
+
+	other := newTestProviderOn(t, server)
+	old := newTestProviderOn(t, server, WithKeyPrefix("old"))
+	putAll(t, other, "stored")
+	putAll(t, old, "moved")
+
+	get := func(key string, want ReadSource) {
+		t.Helper()
+		if _, source, err := p.GetDetailed(key); err != nil || source != want {
+			t.Fatalf("GetDetailed(%q) = %q, %v, want %q", key, source, err, want)
+		}
+	}
+
+	get("stored", ReadPrimary)
+	get("moved", ReadFallbackPrefix)
+	get("missing", "")
+
+	// From now on, Get doesn't have to read Redis for any of them.
+	commands := server.CommandCount()
+	get("missing", ReadNegativeCache)
+	if server.CommandCount() != commands {
+		t.Fatalf("a negative cache hit sent %d commands", server.CommandCount()-commands)
+	}
+
+	get("stored", ReadLocalCache)
+	get("moved", ReadLocalCache)
+
+	want := []ReadSource{ReadPrimary, ReadFallbackPrefix, "", ReadNegativeCache, ReadLocalCache, ReadLocalCache}
+	if !reflect.DeepEqual(sources, want) {
+		t.Fatalf("the metrics hook got %q, want %q", sources, want)
+	}
+
+	if len(tracer.spans) != len(want) {
+		t.Fatalf("started %d spans, want %d", len(tracer.spans), len(want))
+	}
+
+	for i, span := range tracer.spans {
+		if span.op != "chi-ratelimit-redis.get" || !span.ended || span.attrs["ratelimit.source"] != string(want[i]) {
+			t.Fatalf("span %d = %+v, want one for %q", i, span, want[i])
+		}
+	}
+
+	// Plain Get still returns the ratelimit.
+	if rl, err := p.Get("stored"); err != nil || rl == nil || rl.Remaining != 7 {
+		t.Fatalf("Get = %+v, %v, want 7 remaining after the third request", rl, err)
+	}
+}
+
+func TestLocalCache(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }), WithLocalCache(time.Minute), WithNegativeCache(time.Minute))
+	server.SetTime(now)
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: now.Add(time.Hour)}
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Peek answers from the copy that Put left.
+	commands := server.CommandCount()
+	if peeked, err := p.Peek("k"); err != nil || peeked == nil || peeked.Remaining != 10 {
+		t.Fatalf("Peek = %+v, %v", peeked, err)
+	}
+
+	if server.CommandCount() != commands {
+		t.Fatalf("Peek of a cached key sent %d commands", server.CommandCount()-commands)
+	}
+
+	// A Reset forgets it, and its miss is remembered instead.
+	if _, err := p.Reset("k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if _, source, err := p.GetDetailed("k"); err != nil || source != "" {
+		t.Fatalf("GetDetailed after Reset = %q, %v", source, err)
+	}
+
+	if _, source, err := p.GetDetailed("k"); err != nil || source != ReadNegativeCache {
+		t.Fatalf("GetDetailed after a miss = %q, %v", source, err)
+	}
+
+	// A Put ends the negative cache's entry.
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if _, source, err := p.GetDetailed("k"); err != nil || source != ReadLocalCache {
+		t.Fatalf("GetDetailed after Put = %q, %v", source, err)
+	}
+
+	// Copies are read again once they're older than the TTL.
+	now = now.Add(time.Minute)
+	if _, source, err := p.GetDetailed("k"); err != nil || source != ReadPrimary {
+		t.Fatalf("GetDetailed after the TTL = %q, %v", source, err)
+	}
+
+	// And so are ones whose window is over.
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: now.Add(time.Second)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, source, err := p.GetDetailed("k"); err != nil || source != ReadPrimary {
+		t.Fatalf("GetDetailed after the reset = %q, %v", source, err)
+	}
+}
+
+func TestReadPathRejectsCaches(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, source := range []ReadSource{ReadLocalCache, ReadNegativeCache} {
+		if _, err := New(WithClient(p.client), WithReadPath([]ReadSource{source, ReadPrimary})); err == nil {
+			t.Fatalf("New accepted %q in WithReadPath", source)
+		}
+	}
+}
+
+func TestLocalCacheBytes(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: now.Add(time.Hour)}
+
+	// Every key has as many bytes, so the budget fits exactly 10 copies and a
+	// half.
+	probe, _ := newTestProvider(t)
+	data, err := probe.encode(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(probe.storageKey("k000")) + len(data))
+	budget := 10*size + size/2
+
+	var reported LocalCacheStats
+	hook := MetricsHook{LocalCache: func(stats LocalCacheStats) { reported = stats }}
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }), WithLocalCache(time.Minute), WithLocalCacheBytes(budget), WithMetricsHook(hook))
+	server.SetTime(now)
+
+	for i := 0; i < 100; i++ {
+		if err := p.Put(fmt.Sprintf("k%03d", i), rl); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		if stats := p.KeyStateStats().LocalCache; stats.Bytes > budget {
+			t.Fatalf("%d copies take %d bytes, over the budget of %d", stats.Entries, stats.Bytes, budget)
+		}
+	}
+
+	stats := p.KeyStateStats().LocalCache
+	if stats.Entries != 10 || stats.Bytes != 10*size || stats.MaxBytes != budget || stats.Evictions != 90 {
+		t.Fatalf("KeyStateStats().LocalCache = %+v, want 10 copies of %d bytes and 90 evictions", stats, size)
+	}
+
+	// The latest key is still cached, and the first one was evicted.
+	if _, source, err := p.GetDetailed("k099"); err != nil || source != ReadLocalCache {
+		t.Fatalf("GetDetailed of the latest key = %q, %v", source, err)
+	}
+
+	if _, source, err := p.GetDetailed("k000"); err != nil || source != ReadPrimary {
+		t.Fatalf("GetDetailed of the first key = %q, %v", source, err)
+	}
+
+	if reported.Hits != 1 || reported.Misses != 1 || reported.HitRate != 0.5 || reported.Entries != 10 {
+		t.Fatalf("the hook was called with %+v, want a hit and a miss", reported)
+	}
+}
+
+func TestLocalCacheBytesOversized(t *testing.T) {
+	p, _ := newTestProvider(t, WithLocalCache(time.Minute), WithLocalCacheBytes(1))
+	putAll(t, p, "a", "b")
+
+	// A copy over the whole budget is kept until another one replaces it.
+	if stats := p.KeyStateStats().LocalCache; stats.Entries != 1 || stats.Evictions != 1 {
+		t.Fatalf("KeyStateStats().LocalCache = %+v, want only the latest copy", stats)
+	}
+
+	if _, source, err := p.GetDetailed("b"); err != nil || source != ReadLocalCache {
+		t.Fatalf("GetDetailed of the latest key = %q, %v", source, err)
+	}
+}
+
+func TestLocalCacheBytesConcurrent(t *testing.T) {
+	p, _ := newTestProvider(t, WithLocalCache(time.Minute), WithLocalCacheBytes(2000), WithKeyStateLimit(64))
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Hour)}
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		worker := worker
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("k%d-%d", worker, i%40)
+				if err := p.Put(key, rl); err != nil {
+					t.Errorf("Put: %v", err)
+					return
+				}
+
+				if _, err := p.Peek(key); err != nil {
+					t.Errorf("Peek: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// With every copy accounted for, the totals add up to what's left.
+	stats := p.KeyStateStats().LocalCache
+	entries, bytes := 0, int64(0)
+	p.states.each(func(s *keyState) {
+		if cached, size := s.cached(); cached {
+			entries++
+			bytes += size
+		}
+	})
+
+	if stats.Entries != entries || stats.Bytes != bytes || bytes > 2000 {
+		t.Fatalf("KeyStateStats().LocalCache = %+v, but %d copies take %d bytes", stats, entries, bytes)
+	}
+}
+
+func TestLocalCacheBytesOptions(t *testing.T) {
+	if _, err := New(WithLocalCacheBytes(100)); err == nil {
+		t.Fatal("New accepted WithLocalCacheBytes without WithLocalCache")
+	}
+}
@@ -0,0 +1,105 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// unlockScript deletes a lock, but only if it still holds the token of the
+// caller, so an unlock after the lock expired can't release the lock of
+// whoever took it over. It returns 1 if the lock was released.
+//
+// KEYS[1] = lock
+// ARGV[1] = token
+var unlockScript = registerScript("unlock", `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+
+return 0
+`)
+
+func (p *Provider) lockKey(key string) string {
+	return p.companionKey("lock", key)
+}
+
+// TryLock takes a short-lived lock for the given key, like to run a webhook
+// only once when a key first goes over its limit, and returns a function that
+// releases it. If someone else holds the lock, ok is false. The lock is
+// released after ttl even if unlock is never called; unlock then returns an
+// error that wraps ErrLockNotHeld and leaves the lock alone, since someone
+// else may have it by now.
+//
+// This is a convenience on a single Redis server, not Redlock: the lock can be
+// lost on a failover before it was replicated, and a holder that stalls past
+// ttl can't tell that it lost it, so it shouldn't guard anything that has to
+// be correct.
+func (p *Provider) TryLock(key string, ttl time.Duration) (unlock func() error, ok bool, err error) {
+	defer p.recoverPanic(&err, "try_lock", key)
+
+	if ttl < time.Millisecond {
+		return nil, false, errors.New("the ttl of a lock must be at least a millisecond")
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, false, err
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	lock, fence := p.lockKey(p.storageKey(key)), hex.EncodeToString(token)
+	if ok, err = p.cmd(ctx).SetNX(ctx, lock, fence, ttl).Result(); err != nil || !ok {
+		return nil, false, err
+	}
+
+	return func() error {
+		return p.unlock(key, lock, fence)
+	}, true, nil
+}
+
+// unlock releases the lock stored under the given key if it still holds the
+// given token.
+func (p *Provider) unlock(key, lock, token string) (err error) {
+	defer p.recoverPanic(&err, "unlock", key)
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	released, err := p.runScript(ctx, unlockScript, []string{lock}, token).Int()
+	if err != nil {
+		return err
+	}
+
+	if released == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+)
+
+// maintenanceKey marks a context whose commands belong to an admin operation,
+// so they are sent through the maintenance client if there is one.
+type maintenanceKey struct{}
+
+// WithMaintenanceClient sends the commands of the AdminClient's scans, cleanups,
+// exports and diffs through the given client instead of the one that serves
+// requests, so a long maintenance run can't use up the connections that
+// requests need. The client is never closed by the Provider.
+func WithMaintenanceClient(client redis.Cmdable) func(o *options) {
+	return func(o *options) {
+		o.maintenanceClient = client
+	}
+}
+
+// WithMaintenancePoolSize is WithMaintenanceClient with a client that the
+// Provider creates itself from the options of its own client, with a pool of
+// the given size. The client is closed by Shutdown. It is ignored if
+// WithMaintenanceClient is used as well.
+func WithMaintenancePoolSize(size int) func(o *options) {
+	return func(o *options) {
+		o.maintenancePoolSize = size
+	}
+}
+
+// newMaintenanceClient creates the client for WithMaintenancePoolSize from a
+// copy of the options the Provider connects with.
+func newMaintenanceClient(config *options) *redis.Client {
+	source := config.clientConfig
+	if source == nil {
+		source = config.client.Options()
+	}
+
+	copied := *source
+	copied.PoolSize = config.maintenancePoolSize
+	client := redis.NewClient(&copied)
+	for _, hook := range config.clientHooks {
+		client.AddHook(hook)
+	}
+
+	return client
+}
+
+// maintenanceContext marks ctx so p.cmd uses the maintenance client. It's
+// marked even if this Provider has none, since Diff uses it for the other
+// Provider's commands as well.
+func (p *Provider) maintenanceContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, maintenanceKey{}, true)
+}
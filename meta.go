@@ -0,0 +1,183 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// metaKey returns the hash that metadata is stored in. It uses the same fields
+// as the ratelimit hash, so every path that deletes a ratelimit deletes its
+// metadata with it.
+func (p *Provider) metaKey() string {
+	return p.space.Load().meta
+}
+
+// entryKeys returns the given keys followed by the metadata hash and, when it's
+// enabled, the reset index, which is the order that the scripts that delete
+// ratelimits expect them in.
+func (p *Provider) entryKeys(keys ...string) []string {
+	keys = append(keys, p.metaKey())
+	if p.resetIndex {
+		keys = append(keys, p.indexKey())
+	}
+
+	return keys
+}
+
+// PutWithMeta stores the given ratelimit like Put, together with metadata that
+// lives and dies with it: Reset and every other way a ratelimit is deleted
+// deletes its metadata too. Empty metadata deletes what was stored before.
+// Both the ratelimit and the metadata are checked against WithMaxValueSize on
+// their own.
+func (p *Provider) PutWithMeta(key string, rl *types.Ratelimit, meta map[string]string) (err error) {
+	defer p.recoverPanic(&err, "put_with_meta", key)
+
+	if rl, err = p.checkRemaining(key, rl); err != nil {
+		return err
+	}
+
+	data, err := p.encode(rl)
+	if err != nil {
+		return err
+	}
+
+	var encodedMeta []byte
+	if len(meta) > 0 {
+		if encodedMeta, err = json.Marshal(meta); err != nil {
+			return err
+		}
+
+		if encodedMeta, err = p.compress(encodedMeta); err != nil {
+			return err
+		}
+	}
+
+	for _, value := range [][]byte{data, encodedMeta} {
+		if p.maxValueSize > 0 && len(value) > p.maxValueSize {
+			return fmt.Errorf("%w: %d bytes (max %d)", ErrValueTooLarge, len(value), p.maxValueSize)
+		}
+	}
+
+	if p.dryRun {
+		return nil
+	}
+
+	key = p.storageKey(key)
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	hash := p.hashKey()
+	if err := p.reserveTenantKey(ctx, hash, key); err != nil {
+		return err
+	}
+
+	_, err = p.cmd(ctx).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, hash, key, string(data))
+		if encodedMeta == nil {
+			pipe.HDel(ctx, p.metaKey(), key)
+		} else {
+			pipe.HSet(ctx, p.metaKey(), key, string(encodedMeta))
+		}
+
+		if p.resetIndex {
+			if rl.ResetTime.IsZero() {
+				pipe.ZRem(ctx, p.indexKey(), key)
+			} else {
+				pipe.ZAdd(ctx, p.indexKey(), &redis.Z{Score: float64(rl.ResetTime.UnixMilli()), Member: key})
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	p.verifyWrite(ctx, hash, key, data)
+	p.mirror(ctx, key, data)
+	p.recordChange(hash, key, string(data), false)
+	if err := p.trackCreated(ctx, key, rl.ResetTime); err != nil {
+		return err
+	}
+	if err := p.expireFields(ctx, hash, rl.ResetTime, key); err != nil {
+		return err
+	}
+
+	if encodedMeta != nil {
+		if err := p.expireFields(ctx, p.metaKey(), rl.ResetTime, key); err != nil {
+			return err
+		}
+	}
+
+	if err := p.expireWindow(ctx, hash); err != nil {
+		return err
+	}
+
+	// The ratelimit was written without going through the deduplication, so
+	// make sure that the next Put isn't skipped because of an older one.
+	if p.dedup != nil {
+		p.dedup.forget(key)
+	}
+
+	return replication.wait(ctx)
+}
+
+// GetMeta returns the metadata that was stored with PutWithMeta for the given
+// key, or nil if there is none.
+func (p *Provider) GetMeta(key string) (meta map[string]string, err error) {
+	defer p.recoverPanic(&err, "get_meta", key)
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	data, err := p.cmd(ctx).HGet(ctx, p.metaKey(), p.storageKey(key)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	decompressed, err := p.decompress([]byte(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(decompressed, &meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
@@ -0,0 +1,137 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// MetricsHook has the callbacks of WithMetricsHook. Each of them is optional,
+// and they're called on the goroutine of the operation, so they should be
+// quick.
+type MetricsHook struct {
+	// Read is called after every Get, GetDetailed and Peek with the operation
+	// ("get" or "peek"), where the ratelimit was read from, or an empty
+	// ReadSource if there was none, how long the operation took and its error.
+	Read func(op string, source ReadSource, d time.Duration, err error)
+
+	// PartialBatch is called when some, but not all, of the keys of a batch
+	// operation failed, with the operation ("consume_many_keys", "get_many" or
+	// "prewarm"), how many keys failed and how many the batch had. It's called
+	// with RequireComplete too, although nothing is returned then. Sharded
+	// calls it once for the whole batch, with the hook of its first shard.
+	PartialBatch func(op string, failed, total int)
+
+	// LocalCache is called after every read that WithLocalCache answered or
+	// had to read Redis for, with the stats of the local cache after it.
+	LocalCache func(stats LocalCacheStats)
+
+	// AsyncFlush is called after every flush of WithAsyncWrites that wrote
+	// anything, with the stats of the queue after it.
+	AsyncFlush func(stats AsyncStats)
+}
+
+// WithMetricsHook hands what the Provider measures to the callbacks of hook,
+// like for exporting them to Prometheus.
+func WithMetricsHook(hook MetricsHook) func(o *options) {
+	return func(o *options) {
+		o.metrics = hook
+	}
+}
+
+// Tracer starts the spans of WithTracer. It's small enough to adapt any
+// tracing library to, like OpenTelemetry's trace.Tracer.
+type Tracer interface {
+	// Start starts a span for the given operation as a child of whatever span
+	// ctx has, returning a context that has the new one.
+	Start(ctx context.Context, op string) (context.Context, Span)
+}
+
+// Span is a span that a Tracer started.
+type Span interface {
+	SetAttribute(key, value string)
+	End()
+}
+
+// WithTracer makes Get, GetDetailed and Peek each run in a span of the given
+// Tracer, named "chi-ratelimit-redis.get" or "chi-ratelimit-redis.peek", whose
+// parent is the context of WithCallContext. The spans have a
+// "ratelimit.source" attribute with the ReadSource that answered, which is
+// empty if there was no ratelimit, and an "error" attribute if they failed.
+// The context with the span is what the Redis commands are sent with, so
+// client hooks see it.
+func WithTracer(t Tracer) func(o *options) {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// reportPartial calls PartialBatch if some, but not all, of the keys of a batch
+// failed.
+func (p *Provider) reportPartial(op string, call *callOptions, failed, total int) {
+	if p.metrics.PartialBatch == nil || failed == 0 || failed == total || (call != nil && call.partialReported) {
+		return
+	}
+
+	p.metrics.PartialBatch(op, failed, total)
+}
+
+// observeRead starts observing a read for WithMetricsHook and WithTracer,
+// returning the options that the read should use and what it has to call once
+// it's done.
+func (p *Provider) observeRead(op string, call *callOptions) (*callOptions, func(source ReadSource, err error)) {
+	if p.metrics.Read == nil && p.tracer == nil {
+		return call, func(ReadSource, error) {}
+	}
+
+	start := time.Now()
+	var span Span
+	if p.tracer != nil {
+		var traced callOptions
+		if call != nil {
+			traced = *call
+		}
+
+		if traced.parent == nil {
+			traced.parent = context.Background()
+		}
+
+		traced.parent, span = p.tracer.Start(traced.parent, "chi-ratelimit-redis."+op)
+		call = &traced
+	}
+
+	return call, func(source ReadSource, err error) {
+		if span != nil {
+			span.SetAttribute("ratelimit.source", string(source))
+			if err != nil {
+				span.SetAttribute("error", err.Error())
+			}
+
+			span.End()
+		}
+
+		if p.metrics.Read != nil {
+			p.metrics.Read(op, source, time.Since(start), err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+)
+
+// MirrorVersion is the version of the layout that WithMirrorFormat writes,
+// which only changes if a field is removed or changes its meaning.
+const MirrorVersion = 1
+
+// MirrorStats counts the writes of WithMirrorFormat.
+type MirrorStats struct {
+	// Written is how many ratelimits were mirrored.
+	Written uint64
+
+	// Failed is how many mirror writes failed, so the mirror of their keys
+	// is behind until their next write.
+	Failed uint64
+}
+
+// WithMirrorFormat also writes every ratelimit that the Provider writes as a
+// plain hash under the given prefix followed by the key as it's stored
+// ("<prefix><key>"), for consumers outside of Go that read Redis directly and
+// shouldn't depend on the codec, compression or wire format. The fields, all
+// decimal integers, are:
+//
+//	version        MirrorVersion
+//	limit          the ratelimit's limit
+//	remaining      its remaining requests
+//	reset_at_ms    when its window resets, in Unix milliseconds
+//	updated_at_ms  when it was written, in Unix milliseconds
+//
+// New fields can be added within a version. A mirror expires when its window
+// resets and is deleted together with its ratelimit. On Redis Cluster, the
+// prefix should contain the keyspace's hash tag, like "{chi_ratelimit}:mirror:".
+//
+// Mirroring is best-effort: a failed mirror write never fails the write itself
+// and only shows up in MirrorStats and the error handler. Plain Puts send it in
+// the same pipeline as the write; after scripted writes, like the one of
+// Consume, it's one more pipelined command. Turning the option off again stops
+// the writes but leaves the mirrors that exist alone.
+func WithMirrorFormat(prefix string) func(o *options) {
+	return func(o *options) {
+		o.mirrorPrefix = prefix
+	}
+}
+
+// MirrorStats returns what WithMirrorFormat wrote so far.
+func (p *Provider) MirrorStats() MirrorStats {
+	return MirrorStats{
+		Written: p.mirrorWritten.Load(),
+		Failed:  p.mirrorFailed.Load(),
+	}
+}
+
+// queueMirror queues the mirror write of the given ratelimit, returning the
+// commands whose errors decide whether it worked.
+func (p *Provider) queueMirror(ctx context.Context, pipe redis.Pipeliner, key string, rl *types.Ratelimit) []redis.Cmder {
+	mirror := p.mirrorPrefix + key
+	cmds := []redis.Cmder{pipe.HSet(ctx, mirror,
+		"version", strconv.Itoa(MirrorVersion),
+		"limit", strconv.FormatInt(int64(rl.Limit), 10),
+		"remaining", strconv.FormatInt(int64(rl.Remaining), 10),
+		"reset_at_ms", strconv.FormatInt(rl.ResetTime.UnixMilli(), 10),
+		"updated_at_ms", strconv.FormatInt(p.now().UnixMilli(), 10),
+	)}
+
+	if !rl.ResetTime.IsZero() {
+		cmds = append(cmds, pipe.PExpireAt(ctx, mirror, rl.ResetTime))
+	}
+
+	return cmds
+}
+
+// countMirror counts the result of a mirror write.
+func (p *Provider) countMirror(cmds []redis.Cmder) {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil {
+			p.mirrorFailed.Add(1)
+			p.reportError("mirror", err)
+			return
+		}
+	}
+
+	p.mirrorWritten.Add(1)
+}
+
+// mirrored decodes the ratelimit to mirror, counting a failure if it can't.
+func (p *Provider) mirrored(data []byte) *types.Ratelimit {
+	rl, err := p.decode(string(data))
+	if err != nil {
+		p.mirrorFailed.Add(1)
+		p.reportError("mirror", err)
+		return nil
+	}
+
+	return rl
+}
+
+// mirror writes the mirror of the encoded ratelimit under the given storage
+// key on its own, if WithMirrorFormat is used.
+func (p *Provider) mirror(ctx context.Context, key string, data []byte) {
+	if p.mirrorPrefix == "" {
+		return
+	}
+
+	rl := p.mirrored(data)
+	if rl == nil {
+		return
+	}
+
+	var cmds []redis.Cmder
+	_, _ = p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmds = p.queueMirror(ctx, pipe, key, rl)
+		return nil
+	})
+
+	p.countMirror(cmds)
+}
+
+// putField is the HSET of write, pipelined with the mirror write if
+// WithMirrorFormat is used. It returns how many fields were added.
+func (p *Provider) putField(ctx context.Context, hash, key string, data []byte) (int64, error) {
+	var rl *types.Ratelimit
+	if p.mirrorPrefix != "" {
+		rl = p.mirrored(data)
+	}
+
+	if rl == nil {
+		return p.cmd(ctx).HSet(ctx, hash, key, string(data)).Result()
+	}
+
+	var (
+		put  *redis.IntCmd
+		cmds []redis.Cmder
+	)
+
+	// Only the HSET's own error counts, so the mirror can't fail the write.
+	_, _ = p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		put = pipe.HSet(ctx, hash, key, string(data))
+		cmds = p.queueMirror(ctx, pipe, key, rl)
+		return nil
+	})
+
+	added, err := put.Result()
+	if err != nil {
+		return 0, err
+	}
+
+	p.countMirror(cmds)
+	return added, nil
+}
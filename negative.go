@@ -0,0 +1,110 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// clampLogInterval is how often a clamped Put is logged at most.
+const clampLogInterval = time.Minute
+
+// NegativePolicy is what Put does with a ratelimit that has a negative amount of
+// requests remaining.
+type NegativePolicy int
+
+const (
+	// NegativeClamp stores zero remaining requests instead, and logs a warning
+	// through WithLogger at most once a minute. The warning has a hash of the
+	// key instead of the key, which is often an IP address or account ID.
+	NegativeClamp NegativePolicy = iota
+
+	// NegativeReject fails the Put with an error that wraps ErrInconsistent.
+	NegativeReject
+)
+
+// WithNegativeRemaining sets what Put does with a ratelimit that has a negative
+// amount of requests remaining, which is what racing writers that each
+// decremented their own copy end up with. It's NegativeClamp by default.
+// Whatever is already stored is clamped to zero when it's read either way.
+func WithNegativeRemaining(policy NegativePolicy) func(o *options) {
+	return func(o *options) {
+		o.negativePolicy = policy
+	}
+}
+
+// ClampedReads returns how many ratelimits with negative remaining requests
+// were read and clamped to zero since the Provider was created.
+func (p *Provider) ClampedReads() uint64 {
+	return p.clampedReads.Load()
+}
+
+// checkRemaining applies the NegativePolicy to a ratelimit that is about to be
+// written, returning the one that should be written instead.
+func (p *Provider) checkRemaining(key string, rl *types.Ratelimit) (*types.Ratelimit, error) {
+	if rl == nil || rl.Remaining >= 0 {
+		return rl, nil
+	}
+
+	if p.negativePolicy == NegativeReject {
+		return nil, fmt.Errorf("%w: negative remaining %d", ErrInconsistent, rl.Remaining)
+	}
+
+	p.logClamp(key, rl.Remaining)
+
+	clamped := *rl
+	clamped.Remaining = 0
+	return &clamped, nil
+}
+
+// logClamp logs a clamped Put at most once per clampLogInterval, with the first
+// bytes of the SHA-256 of the key to tell keys apart.
+func (p *Provider) logClamp(key string, remaining int32) {
+	if p.logf == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := p.lastClampLog.Load()
+	if now-last < int64(clampLogInterval) || !p.lastClampLog.CompareAndSwap(last, now) {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	p.logf("chi-ratelimit-redis: clamped negative remaining %d to 0 for the key with SHA-256 %s...; further clamps are logged at most once every %v",
+		remaining, hex.EncodeToString(sum[:8]), clampLogInterval)
+}
+
+// clampRead clamps a ratelimit that was read with negative remaining requests
+// to zero.
+func (p *Provider) clampRead(rl *types.Ratelimit) *types.Ratelimit {
+	if rl != nil && rl.Remaining < 0 {
+		rl.Remaining = 0
+		p.clampedReads.Add(1)
+	}
+
+	return rl
+}
@@ -0,0 +1,69 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegativeClamp(t *testing.T) {
+	var logs []string
+	p, _ := newTestProvider(t, WithLogger(func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}))
+
+	for i := 0; i < 3; i++ {
+		rl := types.NewRatelimit(5, false, time.Now().Add(time.Minute))
+		rl.Remaining = -2
+		if err := p.Put("10.0.0.1", rl); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	stored, err := p.Get("10.0.0.1")
+	if err != nil || stored.Remaining != 0 {
+		t.Fatalf("Get = %+v, %v", stored, err)
+	}
+
+	if len(logs) != 1 {
+		t.Fatalf("logged %d clamps, want 1: %q", len(logs), logs)
+	}
+
+	if strings.Contains(logs[0], "10.0.0.1") {
+		t.Fatalf("the log has the raw key: %s", logs[0])
+	}
+}
+
+func TestNegativeReject(t *testing.T) {
+	p, _ := newTestProvider(t, WithNegativeRemaining(NegativeReject))
+
+	rl := types.NewRatelimit(5, false, time.Now().Add(time.Minute))
+	rl.Remaining = -1
+	if err := p.Put("k", rl); !errors.Is(err, ErrInconsistent) {
+		t.Fatalf("Put = %v, want ErrInconsistent", err)
+	}
+}
@@ -0,0 +1,114 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"time"
+)
+
+// WithNoScripting is for Redis-compatible servers and proxies that can't run
+// Lua scripts, like Envoy's Redis filter. New fails if another option turns on
+// a feature that needs scripts, naming an alternative where there is one; the
+// features are classified with the same requirements as
+// WithRestrictedCommands.
+//
+// Get, Put and Reset work as usual. Consume with FixedWindow, and the
+// chi-ratelimit adapter, read the ratelimit and write it back instead of
+// running a transaction script, so concurrent requests for the same key can
+// overwrite each other and count as one. Txn, ConsumeManyKeys and anything
+// else that runs a script fail with ErrScriptingDisabled. Reset still uses
+// MULTI.
+func WithNoScripting() func(o *options) {
+	return func(o *options) {
+		o.noScripting = true
+	}
+}
+
+// checkNoScripting returns an error that lists every configured feature that
+// needs scripts.
+func checkNoScripting(config *options) error {
+	var needed []string
+	for _, req := range config.requirements() {
+		for _, command := range req.commands {
+			if command != "EVALSHA" {
+				continue
+			}
+
+			if req.alternative != "" {
+				needed = append(needed, fmt.Sprintf("%s (%s)", req.feature, req.alternative))
+			} else {
+				needed = append(needed, req.feature)
+			}
+		}
+	}
+
+	if len(needed) > 0 {
+		return fmt.Errorf("%w: with WithNoScripting, these can't be used: %s", ErrScriptingDisabled, strings.Join(needed, ", "))
+	}
+
+	return nil
+}
+
+// scriptingDisabled returns the failed command that runScript returns for the
+// given script under WithNoScripting.
+func scriptingDisabled(s *script) *redis.Cmd {
+	cmd := &redis.Cmd{}
+	cmd.SetErr(fmt.Errorf("%w: script %q can't run", ErrScriptingDisabled, s.name))
+	return cmd
+}
+
+// consumeUnscripted is consumeFixed without txnScript: it reads the ratelimit
+// and writes it back, with the last write winning.
+func (p *Provider) consumeUnscripted(key string, limit int32, window time.Duration, call *callOptions) (counted fixedCount, err error) {
+	storageKey := p.storageKey(key)
+	current, err := p.fetch(storageKey, call)
+	if err != nil {
+		return fixedCount{}, err
+	}
+
+	counted.old = current
+	current, counted.fresh = p.countedWindow(key, current, limit, window, p.now())
+	counted.before = current.Remaining
+	if counted.rl, err = p.checkRemaining(key, current.Copy()); err != nil {
+		return fixedCount{}, err
+	}
+
+	data, err := p.encode(counted.rl)
+	if err != nil {
+		return fixedCount{}, err
+	}
+
+	if err := p.write(storageKey, data, counted.rl.ResetTime, call); err != nil {
+		return fixedCount{}, err
+	}
+
+	if p.firstSeenTracking && !p.dryRun {
+		if counted.firstSeen, err = p.markSeen(storageKey); err != nil {
+			return fixedCount{}, err
+		}
+	}
+
+	return counted, nil
+}
@@ -0,0 +1,90 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// graceScript admits a request from a key's grace requests if it has any left,
+// and makes them expire at the given time with the first one. It returns how
+// many are left afterwards, or -1 if there were none.
+//
+// KEYS[1] = grace counter
+// ARGV[1] = grace requests per window, ARGV[2] = expiry in Unix milliseconds
+var graceScript = registerScript("grace", `
+local used = tonumber(redis.call('GET', KEYS[1]) or '0')
+if used >= tonumber(ARGV[1]) then
+	return -1
+end
+
+used = redis.call('INCR', KEYS[1])
+if used == 1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+
+return tonumber(ARGV[1]) - used
+`)
+
+// WithGrace gives every key n more requests per window after Consume,
+// ConsumeSliding or ConsumeApprox would have rejected it. They're allowed with
+// OverLimit set in their Decision, so the application can degrade instead of
+// rejecting them, like serving a cached response. The grace requests of a key
+// ("{<prefix>}:grace:<key>") are counted in one atomic step and run out when
+// the window that was exhausted resets, or when the key is reset. Requests that
+// are admitted with them don't count towards WithPenaltyBackoff and
+// WithAbuseScore; the ones after them are rejected as usual.
+func WithGrace(n int) func(o *options) {
+	return func(o *options) {
+		o.graceRequests = int64(n)
+	}
+}
+
+func (p *Provider) graceKey(key string) string {
+	return p.companionKey("grace", key)
+}
+
+// consumeGrace admits the rejected request of the given storage key with a
+// grace request of WithGrace if it has one left, and returns true if it did.
+func (p *Provider) consumeGrace(ctx context.Context, key string, decision *Decision) (bool, error) {
+	if p.graceRequests <= 0 {
+		return false, nil
+	}
+
+	defer p.trackLatency(time.Now())
+
+	// PEXPIREAT in the past would delete the counter right away.
+	resetAfter := decision.ResetAfter
+	if resetAfter < time.Millisecond {
+		resetAfter = time.Millisecond
+	}
+
+	expireAt := p.now().Add(resetAfter)
+	left, err := p.runScript(ctx, graceScript, []string{p.graceKey(key)}, p.graceRequests, expireAt.UnixMilli()).Int64()
+	if err != nil || left < 0 {
+		return false, err
+	}
+
+	decision.Allowed, decision.OverLimit, decision.RetryAfter = true, true, 0
+	return true, nil
+}
@@ -0,0 +1,114 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrace(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p, server := newTestProvider(t, WithGrace(2), WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	consume := func(allowed, over bool) {
+		t.Helper()
+
+		decision, err := p.Consume("k", 2, time.Minute)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+
+		if decision.Allowed != allowed || decision.OverLimit != over {
+			t.Fatalf("Consume = allowed %t, over limit %t; want %t, %t", decision.Allowed, decision.OverLimit, allowed, over)
+		}
+	}
+
+	// The budget, then the grace requests, then rejection.
+	consume(true, false)
+	consume(true, false)
+	consume(true, true)
+	consume(true, true)
+	consume(false, false)
+
+	// Reset forgets the grace requests together with the ratelimit.
+	if _, err := p.Reset("k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if server.Exists(p.graceKey(p.storageKey("k"))) {
+		t.Fatal("Reset left the grace counter behind")
+	}
+
+	consume(true, false)
+	consume(true, false)
+	consume(true, true)
+
+	// The next window has its own grace requests.
+	now = now.Add(time.Minute + time.Second)
+	server.SetTime(now)
+	server.FastForward(time.Minute + time.Second)
+
+	consume(true, false)
+	consume(true, false)
+	consume(true, true)
+	consume(true, true)
+	consume(false, false)
+}
+
+func TestGraceSliding(t *testing.T) {
+	p, _ := newTestProvider(t, WithGrace(1))
+
+	var over int
+	for i := 0; i < 4; i++ {
+		decision, err := p.ConsumeSliding("k", 2, time.Minute)
+		if err != nil {
+			t.Fatalf("ConsumeSliding: %v", err)
+		}
+
+		if decision.OverLimit {
+			over++
+		}
+
+		if i == 3 && decision.Allowed {
+			t.Fatal("ConsumeSliding allowed a request after the grace requests")
+		}
+	}
+
+	if over != 1 {
+		t.Fatalf("%d requests were over the limit, want 1", over)
+	}
+}
+
+func TestGraceDecisionJSON(t *testing.T) {
+	data, err := json.Marshal(Decision{Allowed: true, OverLimit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"over_limit":true`) {
+		t.Fatalf("Decision encodes to %s", data)
+	}
+}
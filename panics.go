@@ -0,0 +1,112 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is the error that a Provider method returns instead of letting a
+// panic escape into the caller. It unwraps to ErrInternal.
+type PanicError struct {
+	// Value is the value that was passed to panic.
+	Value interface{}
+
+	// Stack is the stack trace of the goroutine when it panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrInternal, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	return ErrInternal
+}
+
+// WithErrorHandler sets a function that is called with errors that the Provider
+// handled internally, like a recovered panic, which would otherwise only be
+// visible to the caller of a single operation.
+func WithErrorHandler(handler func(err error)) func(o *options) {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// recoverPanic turns a panic in the calling method into a *PanicError that is
+// stored in err, and classifies the error otherwise, see classifyError. Either
+// way, the error is wrapped in an *OpError for the given operation and key,
+// unless it already is one. It must be deferred directly.
+func (p *Provider) recoverPanic(err *error, op, key string) {
+	value := recover()
+	if value != nil {
+		*err = &PanicError{Value: value, Stack: debug.Stack()}
+	}
+
+	if *err == nil {
+		return
+	}
+
+	*err = p.wrapError(op, key, *err)
+	if value != nil {
+		p.reportError(op, *err)
+	}
+}
+
+// wrapError classifies err and wraps it in an *OpError for the given operation
+// and key, unless it already is one.
+func (p *Provider) wrapError(op, key string, err error) error {
+	if opErr, ok := err.(*OpError); ok {
+		return opErr
+	}
+
+	return &OpError{Op: op, Key: key, Err: p.classifyError(err), verbose: p.verboseErrors}
+}
+
+// classifyError marks NOPERM replies with ErrNoPermission, and pool timeouts
+// with ErrClientSaturated.
+func (p *Provider) classifyError(err error) error {
+	switch {
+	case errors.Is(err, ErrNoPermission), errors.Is(err, ErrClientSaturated):
+		return err
+
+	case hasErrorPrefix(err, "NOPERM"):
+		return &permissionError{err: err}
+
+	case isPoolTimeout(err):
+		p.logSaturation()
+		return &saturatedError{err: err}
+
+	default:
+		return err
+	}
+}
+
+// reportError passes err to the handler of WithErrorHandler, wrapped for the
+// given operation.
+func (p *Provider) reportError(op string, err error) {
+	if p.errorHandler != nil {
+		p.errorHandler(p.wrapError(op, "", err))
+	}
+}
@@ -0,0 +1,101 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// penaltyScript counts a rejected request in a key's violations and makes them
+// expire at the given time, which is the end of the window after the current
+// one. It returns the new count.
+//
+// KEYS[1] = violation counter
+// ARGV[1] = expiry in Unix milliseconds
+var penaltyScript = registerScript("penalty", `
+local violations = redis.call('INCR', KEYS[1])
+redis.call('PEXPIREAT', KEYS[1], ARGV[1])
+return violations
+`)
+
+type penaltyBackoff struct {
+	base    time.Duration
+	factor  float64
+	ceiling time.Duration
+}
+
+// WithPenaltyBackoff makes keys that keep sending requests after Consume
+// rejected them wait longer and longer: the RetryAfter of their nth rejected
+// request in a row is at least base×factor^(n-1), up to ceiling, even if the
+// window resets sooner. The count ("{<prefix>}:penalty:<key>") goes on across
+// windows as long as each of them has rejected requests, and starts over once
+// a whole window went by without any. It only changes RetryAfter: requests
+// are still admitted as soon as the window resets, and nothing is counted for
+// keys that are never rejected.
+func WithPenaltyBackoff(base time.Duration, factor float64, ceiling time.Duration) func(o *options) {
+	return func(o *options) {
+		o.penaltyBackoff = &penaltyBackoff{base: base, factor: factor, ceiling: ceiling}
+	}
+}
+
+func (b *penaltyBackoff) valid() bool {
+	return b.base > 0 && b.factor >= 1 && !math.IsInf(b.factor, 0) && b.ceiling >= b.base
+}
+
+// wait returns how long the given amount of rejected requests in a row wait at
+// least.
+func (b *penaltyBackoff) wait(violations int64) time.Duration {
+	wait := float64(b.base) * math.Pow(b.factor, float64(violations-1))
+	if wait >= float64(b.ceiling) {
+		return b.ceiling
+	}
+
+	return time.Duration(wait)
+}
+
+func (p *Provider) penaltyKey(key string) string {
+	return p.companionKey("penalty", key)
+}
+
+// penalize counts a rejected request of the given storage key with
+// WithPenaltyBackoff and raises the decision's RetryAfter to its backoff.
+func (p *Provider) penalize(ctx context.Context, key string, window time.Duration, decision *Decision) error {
+	if p.penaltyBackoff == nil {
+		return nil
+	}
+
+	defer p.trackLatency(time.Now())
+
+	expireAt := p.now().Add(decision.ResetAfter + window)
+	violations, err := p.runScript(ctx, penaltyScript, []string{p.penaltyKey(key)}, expireAt.UnixMilli()).Int64()
+	if err != nil {
+		return err
+	}
+
+	if wait := p.penaltyBackoff.wait(violations); wait > decision.RetryAfter {
+		decision.RetryAfter = wait
+	}
+
+	return nil
+}
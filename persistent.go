@@ -0,0 +1,93 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// persistentExpireScript sets the field TTLs of WithFieldTTL, except for the
+// persistent fields, whose TTL is removed instead.
+//
+// KEYS[1] = hash, KEYS[2] = persistent set
+// ARGV[1] = when the fields expire in Unix milliseconds, ARGV[2...] = fields
+var persistentExpireScript = registerScript("persistent_expire", `
+for i = 2, #ARGV do
+	if redis.call('SISMEMBER', KEYS[2], ARGV[i]) == 1 then
+		redis.call('HPERSIST', KEYS[1], 'FIELDS', 1, ARGV[i])
+	else
+		redis.call('HPEXPIREAT', KEYS[1], ARGV[1], 'FIELDS', 1, ARGV[i])
+	end
+end
+
+return 1
+`)
+
+// WithPersistentEntries lets writes mark ratelimits as persistent with the
+// Persistent call option, like monthly quotas that have to outlive any TTL. The
+// marked keys are kept in a set next to the ratelimits
+// ("{<prefix>}:persistent"), and WithFieldTTL never lets them expire. They stay
+// persistent through later writes until they're reset. WithWindowedKeys
+// expires whole hashes, so it can't spare them.
+func WithPersistentEntries() func(o *options) {
+	return func(o *options) {
+		o.persistentEntries = true
+	}
+}
+
+// Persistent marks the ratelimit written by PutWithOptions, or by the write of
+// GetWithOptions, as persistent. It needs WithPersistentEntries.
+func Persistent() CallOption {
+	return func(c *callOptions) {
+		c.persistent = true
+	}
+}
+
+func (p *Provider) persistentKey() string {
+	return p.space.Load().persistent
+}
+
+// markPersistent adds the given storage key to the persistent set if the call
+// asked for it.
+func (p *Provider) markPersistent(ctx context.Context, key string, call *callOptions) error {
+	if call == nil || !call.persistent {
+		return nil
+	}
+
+	if !p.persistentEntries {
+		return ErrPersistentDisabled
+	}
+
+	return p.cmd(ctx).SAdd(ctx, p.persistentKey(), key).Err()
+}
+
+// expirePersistentFields is expireFields while WithPersistentEntries is on.
+func (p *Provider) expirePersistentFields(ctx context.Context, hash string, at time.Time, fields ...string) error {
+	args := make([]interface{}, 0, len(fields)+1)
+	args = append(args, at.UnixMilli())
+	for _, field := range fields {
+		args = append(args, field)
+	}
+
+	return p.runScript(ctx, persistentExpireScript, []string{hash, p.persistentKey()}, args...).Err()
+}
@@ -0,0 +1,127 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPolicyName is the name that policies without one get in headers.
+const defaultPolicyName = "default"
+
+// Policy is a quota policy as it's advertised in the RateLimit-Policy header
+// from the IETF RateLimit header fields draft.
+type Policy struct {
+	// Name identifies the policy in the header. It's "default" when empty.
+	Name string
+
+	// Limit is how many requests the policy allows per window.
+	Limit int64
+
+	// Window is how long a window is. It's written in whole seconds, rounded
+	// up.
+	Window time.Duration
+
+	// PartitionKey, if it's not empty, is the partition that the policy
+	// applies to, like an API key.
+	PartitionKey string
+}
+
+// FormatPolicyHeader returns the value of a RateLimit-Policy header that lists
+// the given policies, like `"default";q=100;w=60`.
+func FormatPolicyHeader(policies []Policy) string {
+	var builder strings.Builder
+	for i, policy := range policies {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+
+		writePolicyName(&builder, policy.Name)
+		builder.WriteString(";q=")
+		builder.WriteString(strconv.FormatInt(policy.Limit, 10))
+		builder.WriteString(";w=")
+		builder.WriteString(strconv.FormatInt(ceilSeconds(policy.Window), 10))
+		writePartitionKey(&builder, policy.PartitionKey)
+	}
+
+	return builder.String()
+}
+
+// FormatRateLimitHeader returns the value of a RateLimit header for the given
+// decision, like `"default";r=50;t=30`, named after its PolicyName. The
+// partition key is left out when it's empty.
+func FormatRateLimitHeader(decision Decision, partitionKey string) string {
+	remaining := decision.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var builder strings.Builder
+	writePolicyName(&builder, decision.PolicyName)
+	builder.WriteString(";r=")
+	builder.WriteString(strconv.FormatInt(remaining, 10))
+	builder.WriteString(";t=")
+	builder.WriteString(strconv.FormatInt(ceilSeconds(decision.ResetAfter), 10))
+	writePartitionKey(&builder, partitionKey)
+
+	return builder.String()
+}
+
+// writePolicyName writes the name as a structured field string. Those can only
+// hold printable ASCII, so anything else is replaced with a question mark.
+func writePolicyName(builder *strings.Builder, name string) {
+	if name == "" {
+		name = defaultPolicyName
+	}
+
+	builder.WriteByte('"')
+	for _, c := range name {
+		switch {
+		case c == '"' || c == '\\':
+			builder.WriteByte('\\')
+			builder.WriteRune(c)
+
+		case c < 0x20 || c > 0x7e:
+			builder.WriteByte('?')
+
+		default:
+			builder.WriteRune(c)
+		}
+	}
+
+	builder.WriteByte('"')
+}
+
+// writePartitionKey writes the pk parameter as a structured field byte
+// sequence, which can hold any key since it's base64.
+func writePartitionKey(builder *strings.Builder, key string) {
+	if key == "" {
+		return
+	}
+
+	builder.WriteString(";pk=:")
+	builder.WriteString(base64.StdEncoding.EncodeToString([]byte(key)))
+	builder.WriteByte(':')
+}
@@ -0,0 +1,283 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"time"
+)
+
+// poolPrefix is what the hash field of a shared pool starts with, so a pool
+// can't collide with a key of the same name.
+const poolPrefix = "__pool__:"
+
+// pairTxnScript commits the transactions of a pool and one of its members
+// together: both fields are replaced only if neither changed since they were
+// read. It returns 1 if it committed and 0 otherwise.
+//
+// KEYS[1] = hash, KEYS[2] = metadata hash, KEYS[3] = reset index (optional)
+// ARGV[1] = pool field, ARGV[2] = '1' if the pool existed, ARGV[3] = read pool
+// value, ARGV[4] = new pool value, ARGV[5] = new pool reset time in Unix
+// milliseconds, and ARGV[6] to ARGV[10] the same for the member
+var pairTxnScript = registerScript("pair_txn", `
+for i = 1, 6, 5 do
+	local current = redis.call('HGET', KEYS[1], ARGV[i])
+	if ARGV[i + 1] == '1' then
+		if current ~= ARGV[i + 2] then
+			return 0
+		end
+	elseif current then
+		return 0
+	end
+end
+
+for i = 1, 6, 5 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 3])
+	if KEYS[3] and ARGV[i + 4] ~= '' then
+		redis.call('ZADD', KEYS[3], ARGV[i + 4], ARGV[i])
+	end
+end
+
+return 1
+`)
+
+// sharedPool is what WithSharedPool configures.
+type sharedPool struct {
+	resolve     func(key string) (string, bool)
+	memberLimit int32
+}
+
+// PoolOption configures WithSharedPool.
+type PoolOption func(o *sharedPool)
+
+// WithMemberLimit also counts every request against the member's own
+// ratelimit, which allows limit requests per window, so one member can't use
+// up the whole pool. A request is only allowed if both the pool and the member
+// have requests left, and the limit given to Consume is the pool's. It only
+// works with FixedWindow.
+func WithMemberLimit(limit int64) PoolOption {
+	return func(o *sharedPool) {
+		o.memberLimit = saturateInt32(limit)
+	}
+}
+
+// WithSharedPool lets keys draw from a shared pool, like several API keys of
+// one customer: when resolve maps a key to a pool, Consume, Inspect, Get, Put
+// and Peek use the pool's ratelimit instead of the key's. The pool is stored
+// in the same hash as every other ratelimit, so it's counted in the same
+// atomic step. Resetting a member leaves the pool alone; ResetPool resets the
+// pool itself. Decision.FirstEver is never set for pooled keys.
+func WithSharedPool(resolve func(key string) (poolKey string, ok bool), opts ...PoolOption) func(o *options) {
+	return func(o *options) {
+		o.pool = &sharedPool{resolve: resolve}
+		for _, opt := range opts {
+			opt(o.pool)
+		}
+	}
+}
+
+// poolField returns the hash field that the given pool is stored under.
+func poolField(pool string) string {
+	return poolPrefix + pool
+}
+
+// poolOf returns the hash field of the pool that the given key belongs to.
+func (p *Provider) poolOf(key string) (string, bool) {
+	if p.pool == nil {
+		return "", false
+	}
+
+	pool, ok := p.pool.resolve(key)
+	if !ok {
+		return "", false
+	}
+
+	return poolField(pool), true
+}
+
+// pooledKey returns the key of the pool that the given key belongs to, or the
+// key itself if it doesn't belong to one.
+func (p *Provider) pooledKey(key string) string {
+	if pool, ok := p.poolOf(key); ok {
+		return pool
+	}
+
+	return key
+}
+
+// ResetPool resets the ratelimit of the given pool like Reset, returning false
+// if there was none. Its members' own ratelimits stay as they are.
+func (p *Provider) ResetPool(poolKey string) (ok bool, err error) {
+	defer p.recoverPanic(&err, "reset_pool", poolKey)
+
+	key := p.storageKey(poolField(poolKey))
+	if ok, err = p.resetStored(key, nil); err != nil {
+		return ok, err
+	}
+
+	reset, err := p.resetAlgorithm(key, nil)
+	if ok || reset {
+		p.logReset(key, p.now())
+	}
+
+	return ok || reset, err
+}
+
+// consumeMember is Consume for a key in a pool with WithMemberLimit.
+func (p *Provider) consumeMember(pool, member string, params AlgorithmParams, call *callOptions) (Decision, error) {
+	counted, err := p.consumePooled(pool, member, saturateInt32(params.Limit), params.Window, call)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	now := p.now()
+	decision := newFixedDecision(counted, now)
+	p.logDecision(member, pairTxnScript.Hash(), params.Window, decision, now)
+	return decision, nil
+}
+
+// consumePooled counts a request in both the pool and the member's ratelimit
+// under the given storage keys, for WithMemberLimit. The returned count is the
+// one of whichever had fewer requests left.
+func (p *Provider) consumePooled(pool, member string, limit int32, window time.Duration, call *callOptions) (counted fixedCount, err error) {
+	for attempt := 0; attempt < p.txnAttempts; attempt++ {
+		poolTx, err := p.readTxn(pool, call)
+		if err != nil {
+			return fixedCount{}, err
+		}
+
+		memberTx, err := p.readTxn(member, call)
+		if err != nil {
+			return fixedCount{}, err
+		}
+
+		now := p.now()
+		pooled, err := p.countTxn(poolTx, limit, window, now)
+		if err != nil {
+			return fixedCount{}, err
+		}
+
+		own, err := p.countTxn(memberTx, p.pool.memberLimit, window, now)
+		if err != nil {
+			return fixedCount{}, err
+		}
+
+		// A rejected request isn't taken from the one that still had some
+		// left.
+		allowed := pooled.before > 0 && own.before > 0
+		for _, c := range []*fixedCount{&pooled, &own} {
+			if allowed {
+				c.rl = c.rl.Copy()
+			}
+		}
+
+		if err := poolTx.Put(pooled.rl); err != nil {
+			return fixedCount{}, err
+		}
+
+		if err := memberTx.Put(own.rl); err != nil {
+			return fixedCount{}, err
+		}
+
+		counted = pooled
+		if own.before < pooled.before {
+			counted = own
+		}
+
+		if p.dryRun {
+			return counted, nil
+		}
+
+		committed, err := p.commitPair(poolTx, memberTx)
+		if err != nil {
+			return fixedCount{}, err
+		}
+
+		if committed {
+			return counted, nil
+		}
+	}
+
+	return fixedCount{}, fmt.Errorf("%w: gave up after %d attempts", ErrTxnConflict, p.txnAttempts)
+}
+
+// readTxn reads the ratelimit under the given storage key into a transaction.
+// call can be nil.
+func (p *Provider) readTxn(key string, call *callOptions) (*Txn, error) {
+	data, source, expiry, err := p.fetchExpiry(key, call)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Txn{provider: p, key: key, data: data, exists: source != "", expiry: expiry, call: call}, nil
+}
+
+// countTxn returns the window that a request would be counted in for the
+// transaction's ratelimit, before the request is taken from it.
+func (p *Provider) countTxn(tx *Txn, limit int32, window time.Duration, now time.Time) (fixedCount, error) {
+	current, err := tx.Get()
+	if err != nil {
+		return fixedCount{}, err
+	}
+
+	counted := fixedCount{old: current}
+	counted.rl, counted.fresh = p.countedWindow(tx.key, current, limit, window, now)
+	counted.before = counted.rl.Remaining
+	return counted, nil
+}
+
+// commitPair runs pairTxnScript for the given transactions, returning false if
+// either key was changed in the meantime.
+func (p *Provider) commitPair(pool, member *Txn) (bool, error) {
+	ctx, cancel := p.writeContextFor(pool.call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	hash := p.hashKey()
+	args := make([]interface{}, 0, 10)
+	for _, tx := range []*Txn{pool, member} {
+		existed := "0"
+		if tx.exists {
+			existed = "1"
+		} else if err := p.reserveTenantKey(ctx, hash, tx.key); err != nil {
+			return false, err
+		}
+
+		args = append(args, tx.key, existed, tx.data, tx.next, indexScore(tx.resetAt))
+	}
+
+	committed, err := p.runScript(ctx, pairTxnScript, p.entryKeys(hash), args...).Int()
+	if err != nil || committed == 0 {
+		return false, err
+	}
+
+	for _, tx := range []*Txn{pool, member} {
+		if err := p.afterCommit(ctx, hash, tx.key, tx); err != nil {
+			return false, err
+		}
+	}
+
+	return true, replication.wait(ctx)
+}
@@ -0,0 +1,205 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// prefetchHandoff is how long the result of a Prefetch is kept for the next
+// Get of its key, unless WithMaxStaleness is shorter.
+const prefetchHandoff = time.Second
+
+// prefetchFlight is a single lookup that every Prefetch of the same key joins
+// while it runs.
+type prefetchFlight struct {
+	done   chan struct{}
+	rl     *types.Ratelimit
+	err    error
+	readAt time.Time
+}
+
+// Prefetch is a lookup started by PrefetchAsync.
+type Prefetch struct {
+	provider *Provider
+	key      string
+	flight   *prefetchFlight
+}
+
+// PrefetchAsync starts reading the ratelimit of the given key in the
+// background, so a later middleware can pick it up with Wait instead of waiting
+// for a round trip of its own. Like Peek, it doesn't count a request. While the
+// lookup runs, and for a second after it's done, every other PrefetchAsync for
+// the same key joins it instead of starting another one, as long as the key
+// isn't evicted from the table that WithKeyStateLimit bounds. The first Get of
+// the key in that time takes the result too, so a middleware that only calls
+// Get, like chi-ratelimit's, doesn't read it again; later ones read it anew.
+//
+// The lookup itself isn't tied to ctx, since other requests might be waiting
+// for it too; it's only bounded by the read timeout, so nothing leaks if Wait
+// is never called. If ctx is already done, the lookup isn't started at all.
+func (p *Provider) PrefetchAsync(ctx context.Context, key string) *Prefetch {
+	storageKey := p.storageKey(p.pooledKey(key))
+	if err := ctx.Err(); err != nil {
+		return &Prefetch{provider: p, key: storageKey, flight: finishedFlight(nil, err)}
+	}
+
+	var flight *prefetchFlight
+	p.states.update(storageKey, true, func(s *keyState) {
+		if s.flight != nil && p.joinable(s.flight) {
+			flight = s.flight
+			return
+		}
+
+		flight = &prefetchFlight{done: make(chan struct{})}
+		started := p.goBackground(func() {
+			defer p.finishPrefetch(storageKey, flight)
+			defer p.recoverPanic(&flight.err, "prefetch", storageKey)
+
+			flight.rl, flight.err = p.fetch(storageKey, nil)
+			flight.readAt = p.now()
+		})
+
+		if !started {
+			flight = finishedFlight(nil, redis.ErrClosed)
+			return
+		}
+
+		s.flight = flight
+	})
+
+	return &Prefetch{provider: p, key: storageKey, flight: flight}
+}
+
+func finishedFlight(rl *types.Ratelimit, err error) *prefetchFlight {
+	flight := &prefetchFlight{done: make(chan struct{}), rl: rl, err: err}
+	close(flight.done)
+
+	return flight
+}
+
+// finishPrefetch lets the waiters of flight go. A failed lookup makes the next
+// PrefetchAsync for the key start a new one right away.
+func (p *Provider) finishPrefetch(key string, flight *prefetchFlight) {
+	p.states.update(key, false, func(s *keyState) {
+		if s.flight == flight && flight.err != nil {
+			s.flight = nil
+		}
+	})
+
+	close(flight.done)
+}
+
+// joinable returns whether flight is still running, or done and recent enough
+// to hand its result out.
+func (p *Provider) joinable(flight *prefetchFlight) bool {
+	select {
+	case <-flight.done:
+	default:
+		return true
+	}
+
+	handoff := prefetchHandoff
+	if p.maxStaleness > 0 && p.maxStaleness < handoff {
+		handoff = p.maxStaleness
+	}
+
+	return flight.err == nil && elapsed(p.now(), flight.readAt) <= handoff
+}
+
+// takePrefetch waits for the Prefetch of the given storage key, if there is one
+// that Get can take, and returns a copy of its result. Nobody else can take it
+// afterwards, since another Get would count the same ratelimit again.
+func (p *Provider) takePrefetch(key string) (rl *types.Ratelimit, ok bool) {
+	var flight *prefetchFlight
+	p.states.update(key, false, func(s *keyState) {
+		if s.flight != nil && p.joinable(s.flight) {
+			flight = s.flight
+		}
+
+		s.flight = nil
+	})
+
+	if flight == nil {
+		return nil, false
+	}
+
+	<-flight.done
+	if flight.err != nil {
+		return nil, false
+	}
+
+	if flight.rl == nil {
+		return nil, true
+	}
+
+	copied := *flight.rl
+	return &copied, true
+}
+
+// Wait returns the result of the lookup, or ctx's error if ctx is done before
+// the lookup is. Every call returns its own copy of the ratelimit. With
+// WithMaxStaleness, a result that's older than allowed is read again.
+func (f *Prefetch) Wait(ctx context.Context) (rl *types.Ratelimit, err error) {
+	defer f.provider.recoverPanic(&err, "prefetch", f.key)
+
+	select {
+	case <-f.flight.done:
+		if f.flight.err == nil && f.stale() {
+			return f.provider.fetch(f.key, nil)
+		}
+
+		if f.flight.err != nil || f.flight.rl == nil {
+			return nil, f.flight.err
+		}
+
+		copied := *f.flight.rl
+		return &copied, nil
+
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Age returns how long ago the lookup read the ratelimit, or zero if it isn't
+// done or failed.
+func (f *Prefetch) Age() time.Duration {
+	select {
+	case <-f.flight.done:
+	default:
+		return 0
+	}
+
+	if f.flight.readAt.IsZero() {
+		return 0
+	}
+
+	return elapsed(f.provider.now(), f.flight.readAt)
+}
+
+func (f *Prefetch) stale() bool {
+	limit := f.provider.maxStaleness
+	return limit > 0 && f.Age() > limit
+}
@@ -0,0 +1,148 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"go.uber.org/goleak"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetchSingleRoundTrip(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a", "b")
+
+	before := server.CommandCount()
+	if _, err := p.Get("a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	plain := server.CommandCount() - before
+
+	// Wait picks up the lookup without another round trip.
+	before = server.CommandCount()
+	rl, err := p.PrefetchAsync(context.Background(), "b").Wait(context.Background())
+	if err != nil || rl == nil || rl.Remaining != 10 {
+		t.Fatalf("Wait = %+v, %v", rl, err)
+	}
+
+	if commands := server.CommandCount() - before; commands != 1 {
+		t.Fatalf("PrefetchAsync and Wait ran %d commands, want 1", commands)
+	}
+
+	// So does the first Get after it, which only has to write.
+	before = server.CommandCount()
+	prefetch := p.PrefetchAsync(context.Background(), "a")
+	<-prefetch.flight.done
+	if _, err := p.Get("a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if commands := server.CommandCount() - before; commands != plain {
+		t.Fatalf("PrefetchAsync and Get ran %d commands, want %d like Get alone", commands, plain)
+	}
+
+	// Only the first Get takes it, since the next one has to count on top.
+	before = server.CommandCount()
+	if _, err := p.Get("a"); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if commands := server.CommandCount() - before; commands != plain {
+		t.Fatalf("the second Get ran %d commands, want %d", commands, plain)
+	}
+}
+
+func TestPrefetchDeduplicates(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a")
+
+	before := server.CommandCount()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if rl, err := p.PrefetchAsync(context.Background(), "a").Wait(context.Background()); err != nil || rl == nil {
+				t.Errorf("Wait = %+v, %v", rl, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	if commands := server.CommandCount() - before; commands != 1 {
+		t.Fatalf("20 prefetches ran %d commands, want 1", commands)
+	}
+}
+
+func TestPrefetchPooledKey(t *testing.T) {
+	p, _ := newTestProvider(t, WithSharedPool(func(string) (string, bool) { return "pool", true }))
+	if err := p.Put("member", &types.Ratelimit{Limit: 10, Remaining: 4, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rl, err := p.PrefetchAsync(context.Background(), "other").Wait(context.Background())
+	if err != nil || rl == nil || rl.Remaining != 4 {
+		t.Fatalf("Wait for another member = %+v, %v; want the pool's ratelimit", rl, err)
+	}
+}
+
+func TestPrefetchCancellation(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := server.CommandCount()
+	if _, err := p.PrefetchAsync(ctx, "a").Wait(context.Background()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait of a prefetch with a done context = %v, want context.Canceled", err)
+	}
+
+	if commands := server.CommandCount() - before; commands != 0 {
+		t.Fatalf("a prefetch with a done context ran %d commands", commands)
+	}
+
+	if _, err := p.PrefetchAsync(context.Background(), "a").Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait with a done context = %v, want context.Canceled", err)
+	}
+}
+
+func TestPrefetchWithoutWait(t *testing.T) {
+	p, _ := newTestProvider(t)
+	putAll(t, p, "a")
+
+	ignore := goleak.IgnoreCurrent()
+	prefetch := p.PrefetchAsync(context.Background(), "a")
+	select {
+	case <-prefetch.flight.done:
+	case <-time.After(time.Second):
+		t.Fatal("the lookup didn't finish")
+	}
+
+	goleak.VerifyNone(t, ignore)
+}
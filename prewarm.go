@@ -0,0 +1,66 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "context"
+
+// Prewarm reads the ratelimits of the given keys and primes the local cache of
+// WithLocalCache with them, like right after a deploy, so the first request of
+// every hot key doesn't wait for Redis. Keys without a ratelimit, or whose
+// window is over, are skipped. It returns how many keys were warmed.
+//
+// The keys are read like with GetMany, with one HMGET per 500 of them, each
+// with the read timeout of its own, and ctx is checked between chunks. If only
+// some of the chunks failed, the keys of the others are still warmed, and the
+// error is a *BatchError with the keys that weren't; with RequireComplete, it
+// returns 0 if any key failed, although the keys that were read stay warmed.
+// Without WithLocalCache it returns ErrLocalCacheDisabled.
+func (p *Provider) Prewarm(ctx context.Context, keys []string, opts ...CallOption) (warmed int, err error) {
+	defer p.recoverPanic(&err, "prewarm", "")
+
+	if p.localCacheTTL <= 0 {
+		return 0, ErrLocalCacheDisabled
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	call := newCallOptions(opts)
+	now := p.now()
+	failed := p.readMany(ctx, keys, call, func(_ int, field, data string) {
+		rl, err := p.decode(data)
+		if err != nil || (!rl.ResetTime.IsZero() && !rl.ResetTime.After(now)) {
+			return
+		}
+
+		p.cacheStore(field, data)
+		warmed++
+	})
+
+	p.reportPartial("prewarm", call, failed.Len(), len(keys))
+	if call.completeRequired() && failed.Len() > 0 {
+		return 0, failed.err()
+	}
+
+	return warmed, failed.err()
+}
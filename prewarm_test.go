@@ -0,0 +1,96 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrewarm(t *testing.T) {
+	p, server := newTestProvider(t, WithLocalCache(time.Minute))
+	writer := newTestProviderOn(t, server)
+
+	keys := make([]string, 1200)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+		putAll(t, writer, keys[i])
+	}
+
+	// Neither missing keys nor windows that are over are warmed.
+	if err := writer.Put("over", &types.Ratelimit{Limit: 10, Remaining: 3, ResetTime: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	before := server.CommandCount()
+	warmed, err := p.Prewarm(context.Background(), append(keys, "missing", "over"))
+	if err != nil || warmed != len(keys) {
+		t.Fatalf("Prewarm = %d, %v; want %d", warmed, err, len(keys))
+	}
+
+	if commands := server.CommandCount() - before; commands != 3 {
+		t.Fatalf("Prewarm ran %d commands, want 3 chunks", commands)
+	}
+
+	before = server.CommandCount()
+	for _, key := range keys {
+		if rl, err := p.Peek(key); err != nil || rl == nil || rl.Remaining != 10 {
+			t.Fatalf("Peek(%q) = %+v, %v", key, rl, err)
+		}
+	}
+
+	if commands := server.CommandCount() - before; commands != 0 {
+		t.Fatalf("Peek of warmed keys ran %d commands, want 0", commands)
+	}
+
+	if _, source, err := p.GetDetailed(keys[0]); err != nil || source != ReadLocalCache {
+		t.Fatalf("GetDetailed of a warmed key = %q, %v; want %q", source, err, ReadLocalCache)
+	}
+}
+
+func TestPrewarmCancelled(t *testing.T) {
+	p, server := newTestProvider(t, WithLocalCache(time.Minute))
+	putAll(t, p, "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := server.CommandCount()
+	if warmed, err := p.Prewarm(ctx, []string{"a"}); warmed != 0 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Prewarm with a done context = %d, %v", warmed, err)
+	}
+
+	if commands := server.CommandCount() - before; commands != 0 {
+		t.Fatalf("Prewarm with a done context ran %d commands", commands)
+	}
+}
+
+func TestPrewarmWithoutLocalCache(t *testing.T) {
+	p, _ := newTestProvider(t)
+	if _, err := p.Prewarm(context.Background(), []string{"a"}); !errors.Is(err, ErrLocalCacheDisabled) {
+		t.Fatalf("Prewarm without WithLocalCache = %v, want ErrLocalCacheDisabled", err)
+	}
+}
@@ -0,0 +1,100 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/go-redis/redis/v8"
+	"math"
+	"strconv"
+	"time"
+)
+
+// WithRateEstimation keeps an exponentially weighted estimate of every key's
+// request rate ("{<prefix>}:rate:<key>"), where a request counts half as much
+// after every halfLife, so about the last three half-lives make up most of it.
+// It's updated in the same script that Consume counts the request in, with the
+// server's time, so every instance sees the same estimate and there is no
+// extra round trip. Read it with EstimatedRate. Only FixedWindow updates it,
+// and it needs scripting.
+func WithRateEstimation(halfLife time.Duration) func(o *options) {
+	return func(o *options) {
+		o.rateHalfLife = halfLife
+	}
+}
+
+func (p *Provider) rateKey(key string) string {
+	return p.companionKey("rate", key)
+}
+
+// EstimatedRate returns the estimated request rate of the given key in
+// requests per second, decayed up to the server's current time, which is zero
+// for keys that had no requests recently. It needs WithRateEstimation.
+func (p *Provider) EstimatedRate(key string) (rate float64, err error) {
+	defer p.recoverPanic(&err, "estimated_rate", key)
+
+	if p.rateHalfLife <= 0 {
+		return 0, ErrRateEstimationDisabled
+	}
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	var (
+		now    *redis.TimeCmd
+		stored *redis.SliceCmd
+	)
+
+	_, err = p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		now = pipe.Time(ctx)
+		stored = pipe.HMGet(ctx, p.rateKey(p.storageKey(key)), "rate", "at")
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := stored.Val()[0].(string)
+	at, atOK := stored.Val()[1].(string)
+	if !ok || !atOK {
+		return 0, nil
+	}
+
+	if rate, err = strconv.ParseFloat(value, 64); err != nil {
+		return 0, err
+	}
+
+	updatedAt, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Val().UnixMilli() - updatedAt
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	// The script keeps the rate in requests per millisecond.
+	tau := float64(p.rateHalfLife.Milliseconds()) / math.Ln2
+	return rate * math.Exp(-float64(elapsed)/tau) * 1000, nil
+}
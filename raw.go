@@ -0,0 +1,91 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// WithUnsafeRaw makes PutRaw store any bytes it's given, instead of checking
+// that they decode to a ratelimit first.
+func WithUnsafeRaw() func(o *options) {
+	return func(o *options) {
+		o.unsafeRaw = true
+	}
+}
+
+// GetRaw returns the bytes stored for the given key exactly as they are in
+// Redis, without decoding them, or nil if there is nothing stored. Unlike Get,
+// it doesn't count as a request.
+func (p *Provider) GetRaw(key string) (data []byte, err error) {
+	defer p.recoverPanic(&err, "get_raw", key)
+
+	raw, ok, err := p.fetchRaw(p.storageKey(key), nil)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return []byte(raw), nil
+}
+
+// PutRaw stores the given bytes for the given key exactly as they are, without
+// encoding them. Everything else that Put does still applies, like key hashing
+// and WithMaxValueSize. Unless WithUnsafeRaw is used, the bytes have to decode
+// to a ratelimit so a normal Get can read them back.
+func (p *Provider) PutRaw(key string, data []byte) (err error) {
+	defer p.recoverPanic(&err, "put_raw", key)
+
+	// The reset time is only needed for the reset index, so a value that can't be
+	// decoded with WithUnsafeRaw is simply left out of it.
+	var resetAt time.Time
+	if rl, err := p.decode(string(data)); err == nil {
+		resetAt = rl.ResetTime
+	} else if !p.unsafeRaw {
+		return fmt.Errorf("raw value doesn't decode: %w", err)
+	}
+
+	return p.write(p.storageKey(key), data, resetAt, nil)
+}
+
+// EntrySize returns how many bytes are stored for the given key, as GetRaw
+// would return them, without fetching them, or 0 if there is nothing stored.
+func (p *Provider) EntrySize(key string) (size int, err error) {
+	defer p.recoverPanic(&err, "entry_size", key)
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	// go-redis has no helper for HSTRLEN, and Cmdable has no Do, but a
+	// pipeline of one command has.
+	var cmd *redis.Cmd
+	if _, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmd = pipe.Do(ctx, "HSTRLEN", p.hashKey(), p.storageKey(key))
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	return cmd.Int()
+}
@@ -0,0 +1,99 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+)
+
+// ReadSource is a place that Get and Peek can find a ratelimit in.
+type ReadSource string
+
+const (
+	// ReadPrimary is the hash under the configured prefix.
+	ReadPrimary ReadSource = "primary"
+
+	// ReadFallbackPrefix is the hash under the old prefix of
+	// WithFallbackPrefix, until its deadline.
+	ReadFallbackPrefix ReadSource = "fallback_prefix"
+)
+
+// defaultReadPath is the order that reads use without WithReadPath.
+var defaultReadPath = []ReadSource{ReadPrimary, ReadFallbackPrefix}
+
+// WithReadPath sets the order in which reads look through the sources they
+// have, where the first one that has the key answers. By default it's
+// ReadPrimary, then ReadFallbackPrefix. Every source in the list has to be
+// enabled, ReadPrimary has to be one of them, and none can be listed twice, or
+// New fails. Sources that aren't listed aren't read.
+//
+// Every source is still read in the same pipeline, so the order only decides
+// which one wins. When ReadFallbackPrefix comes first and both prefixes have a
+// ratelimit for the key, the old one replaces the new one as it's moved.
+func WithReadPath(order []ReadSource) func(o *options) {
+	return func(o *options) {
+		o.readPath = append([]ReadSource{}, order...)
+	}
+}
+
+// checkReadPath validates the order of WithReadPath.
+func checkReadPath(config *options) error {
+	seen := map[ReadSource]bool{}
+	for _, source := range config.readPath {
+		switch source {
+		case ReadPrimary:
+		case ReadLocalCache, ReadNegativeCache, ReadAsyncQueue:
+			return fmt.Errorf("WithReadPath lists %q, which is always read first", source)
+
+		case ReadFallbackPrefix:
+			if config.fallbackPrefix == "" {
+				return fmt.Errorf("WithReadPath lists %q, which needs WithFallbackPrefix", source)
+			}
+
+		default:
+			return fmt.Errorf("WithReadPath lists the unknown read source %q", source)
+		}
+
+		if seen[source] {
+			return fmt.Errorf("WithReadPath lists %q twice", source)
+		}
+
+		seen[source] = true
+	}
+
+	if !seen[ReadPrimary] {
+		return fmt.Errorf("WithReadPath has to list %q", ReadPrimary)
+	}
+
+	return nil
+}
+
+// GetDetailed is Get, also returning the source that the ratelimit was read
+// from: ReadPrimary or ReadFallbackPrefix for Redis, or ReadLocalCache with
+// WithLocalCache. If there is no ratelimit, the source is ReadNegativeCache if
+// WithNegativeCache knew that, and empty otherwise.
+func (p *Provider) GetDetailed(key string, opts ...CallOption) (rl *types.Ratelimit, source ReadSource, err error) {
+	defer p.recoverPanic(&err, "get", key)
+
+	return p.get(key, newCallOptions(opts))
+}
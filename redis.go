@@ -24,25 +24,288 @@
 package redis
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-redis/redis/v8"
 	"github.com/noelware/chi-ratelimit/providers"
 	"github.com/noelware/chi-ratelimit/types"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Provider is the main providers.Provider object to implement when using
 // this library.
 type Provider struct {
-	keyPrefix string
-	client    *redis.Client
+	space               atomic.Pointer[keyspace]
+	maxKeyLength        int
+	maxValueSize        int
+	tombstoneTTL        time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	errorHandler        func(err error)
+	wireFormat          *WireFormat
+	dryRun              bool
+	unsafeRaw           bool
+	resetIndex          bool
+	dedup               *putDedup
+	pinnedScripts       bool
+	now                 func() time.Time
+	repairPolicy        RepairPolicy
+	repairHorizon       time.Duration
+	latency             *latencyTracker
+	logf                func(format string, args ...interface{})
+	client              *redis.Client
+	owned               *sharedClient
+	closeOnce           sync.Once
+	instanceID          string
+	startedAt           time.Time
+	stop                chan struct{}
+	detectsStateLoss    bool
+	txnAttempts         int
+	scanBatchSize       int
+	scanThrottle        time.Duration
+	scanProgress        func(ScanProgress)
+	forceRename         bool
+	approxWidth         int
+	approxDepth         int
+	sampleEvery         int
+	sampleThreshold     float64
+	sample              func(n int) int
+	writeReplicas       int
+	writeConcernTimeout time.Duration
+	snapshotLimit       int64
+	negativePolicy      NegativePolicy
+	compression         CompressionCodec
+	compressionMinSize  int
+	keyWindow           time.Duration
+	abuseHalfLife       time.Duration
+	autoBanThreshold    float64
+	autoBanFor          time.Duration
+	catalog             *limitCatalog
+	fieldTTL            bool
+	coldStartRamp       time.Duration
+	coldStartFloor      float64
+	expiryGrace         time.Duration
+	captures            captures
+	states              *keyStates
+	maintenance         redis.Cmdable
+	ownedMaintenance    *redis.Client
+	decisions           *decisionLog
+	cardinality         *cardinalityEstimate
+	verifyRate          float64
+	verifyRandom        func() float64
+	tenants             *tenantBudget
+	fallback            *fallbackPrefix
+	algorithm           Algorithm
+	algorithmScripts    map[string]*script
+	windowCreatedAt     bool
+	persistentEntries   bool
+	calendar            CalendarUnit
+	calendarLoc         *time.Location
+	lenientDecoding     bool
+	noScripting         bool
+	maxStaleness        time.Duration
+	evictions           *evictionDetector
+	limitChangePolicy   LimitChangePolicy
+	firstSeenTracking   bool
+	verboseErrors       bool
+	pool                *sharedPool
+	rateHalfLife        time.Duration
+	features            []string
+	companions          []companion
+	schemaInfo          bool
+	mirrorPrefix        string
+	burstBucket         *burstBucket
+	readPath            []ReadSource
+	credentials         CredentialsFunc
+	penaltyBackoff      *penaltyBackoff
+	graceRequests       int64
+	roundTripEvery      int
+	roundTripFn         func(op string, roundTrips int)
+	changes             *changeNotifications
+	layout              *layoutRollout
+	statNoise           float64
+	statNoiseSeed       int64
+	async               *asyncQueue
+	health              *ClientHealth
+	derivedReset        bool
+	hash                func(string) uint64
+	tracer              Tracer
+	metrics             MetricsHook
+	negativeCacheTTL    time.Duration
+	localCacheTTL       time.Duration
+	rolloverFn          func(key string, old, new *types.Ratelimit)
+	threshold           *thresholdCallback
+	resetJitter         time.Duration
+	clientHooks         []redis.Hook
+	background          sync.WaitGroup
+	backgroundMu        sync.Mutex
+	stopped             bool
+
+	// clampedReads counts the reads that had negative remaining requests.
+	clampedReads atomic.Uint64
+
+	// cardinalityReporter is the running CardinalityReporter of
+	// StartCardinalityReporter, if any.
+	cardinalityReporter atomic.Pointer[CardinalityReporter]
+
+	// replicator is the running Replicator of StartReplicator, if any.
+	replicator atomic.Pointer[Replicator]
+
+	// rampStart is when the marker key was created, in Unix milliseconds by the
+	// server's clock, and clockOffset is how far ahead of p.now the server's
+	// clock was. Both are only used by WithColdStartRamp.
+	rampStart   atomic.Int64
+	clockOffset atomic.Int64
+
+	// lastSaturationLog is when a saturated pool was last logged, in Unix
+	// nanoseconds.
+	lastSaturationLog atomic.Int64
+
+	// lastClampLog is when a clamped Put was last logged, in Unix nanoseconds.
+	lastClampLog atomic.Int64
+
+	// capturing is set while CaptureKey has captures running.
+	capturing atomic.Bool
+
+	// schemaRecorded is set once WithSchemaInfo wrote the SchemaInfo.
+	schemaRecorded atomic.Bool
+
+	// slotSeq numbers the slots that AcquireSlot takes.
+	slotSeq atomic.Uint64
+
+	// mirrorWritten and mirrorFailed count the writes of WithMirrorFormat.
+	mirrorWritten atomic.Uint64
+	mirrorFailed  atomic.Uint64
+
+	// noUnlink is set once the server turned out not to support UNLINK.
+	noUnlink atomic.Bool
 }
 
+var _ providers.Provider = (*Provider)(nil)
+
 type options struct {
-	keyPrefix string
-	client    *redis.Client
+	keyPrefix           string
+	maxKeyLength        int
+	maxValueSize        int
+	tombstoneTTL        time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	errorHandler        func(err error)
+	wireFormat          *WireFormat
+	dryRun              bool
+	unsafeRaw           bool
+	resetIndex          bool
+	dedupWindow         time.Duration
+	pinnedScripts       map[string]string
+	now                 func() time.Time
+	repairPolicy        RepairPolicy
+	repairHorizon       time.Duration
+	degradedThreshold   time.Duration
+	degradedCallback    func(LatencySnapshot)
+	logf                func(format string, args ...interface{})
+	clientConfig        *redis.Options
+	noClientReuse       bool
+	db                  *int
+	stateLossInterval   time.Duration
+	stateLossCallback   func(StateLoss)
+	txnAttempts         int
+	scanBatchSize       int
+	scanThrottle        time.Duration
+	scanProgress        func(ScanProgress)
+	forceRename         bool
+	approxWidth         int
+	approxDepth         int
+	sampleEvery         int
+	sampleThreshold     float64
+	writeReplicas       int
+	writeConcernTimeout time.Duration
+	snapshotLimit       int64
+	negativePolicy      NegativePolicy
+	compression         CompressionCodec
+	compressionMinSize  int
+	keyWindow           time.Duration
+	abuseHalfLife       time.Duration
+	autoBanThreshold    float64
+	autoBanFor          time.Duration
+	catalogKey          string
+	catalogTier         func(key string) string
+	catalogRefresh      time.Duration
+	fieldTTL            bool
+	fieldTTLFallback    bool
+	coldStartRamp       time.Duration
+	coldStartFloor      float64
+	expiryGrace         time.Duration
+	allowedCommands     []string
+	maintenanceClient   redis.Cmdable
+	maintenancePoolSize int
+	decisionSink        func(DecisionRecord)
+	decisionSampleRate  float64
+	clientHooks         []redis.Hook
+	cardinalityEstimate bool
+	keyStateEntries     int
+	verifyRate          float64
+	tenantFn            func(key string) string
+	tenantMaxKeys       int64
+	fallbackPrefix      string
+	fallbackUntil       time.Time
+	algorithm           Algorithm
+	windowCreatedAt     bool
+	persistentEntries   bool
+	calendar            CalendarUnit
+	calendarLoc         *time.Location
+	lenientDecoding     bool
+	noScripting         bool
+	maxStaleness        time.Duration
+	evictionInterval    time.Duration
+	evictionCallback    func(EvictionEvent)
+	evictionBudget      float64
+	limitChangePolicy   LimitChangePolicy
+	firstSeenTracking   bool
+	autoLegacyMigration bool
+	autoLegacyOptions   []LegacyOption
+	clockSkewTolerance  time.Duration
+	verboseErrors       bool
+	pool                *sharedPool
+	rateHalfLife        time.Duration
+	schemaInfo          bool
+	schemaWarning       bool
+	adaptiveTimeout     *adaptiveTimeout
+	mirrorPrefix        string
+	burstBucket         *burstBucket
+	readPath            []ReadSource
+	credentials         CredentialsFunc
+	penaltyBackoff      *penaltyBackoff
+	graceRequests       int64
+	roundTripEvery      int
+	roundTripFn         func(op string, roundTrips int)
+	changeChannel       string
+	changeMaxValue      int
+	layoutRollout       *layoutRollout
+	layoutNoFallback    bool
+	asyncPressure       func(stats AsyncStats, backedUp bool)
+	asyncPressureAt     float64
+	localCacheBytes     int64
+	statNoise           float64
+	statNoiseSeed       int64
+	asyncCapacity       int
+	asyncInterval       time.Duration
+	sharedHealth        *ClientHealth
+	breakerFailures     int
+	breakerCooldown     time.Duration
+	derivedReset        bool
+	hash                func(string) uint64
+	tracer              Tracer
+	metrics             MetricsHook
+	negativeCacheTTL    time.Duration
+	localCacheTTL       time.Duration
+	rolloverFn          func(key string, old, new *types.Ratelimit)
+	threshold           *thresholdCallback
+	resetJitter         time.Duration
+	client              *redis.Client
 }
 
 // WithKeyPrefix appends a new key prefix to use when constructing
@@ -53,70 +316,588 @@ func WithKeyPrefix(prefix string) func(o *options) {
 	}
 }
 
-// WithClient appends a pre-existing Redis client that is connected
-// when constructing a Provider.
-func WithClient(client *redis.Client) func(o *options) {
+// WithMaxKeyLength sets the maximum length of a key that is stored in Redis. Keys
+// longer than n are stored as "<truncated key>#<sha256 of the full key>", which
+// is exactly n bytes long. n has to leave room for the 65 bytes of the suffix,
+// so New fails for anything between 0 and 65. Since this happens before every
+// operation, Get, Put and Reset always agree on where a key lives, but
+// anything listing the stored keys will see the shortened keys.
+func WithMaxKeyLength(n int) func(o *options) {
 	return func(o *options) {
-		o.client = client
+		o.maxKeyLength = n
 	}
 }
 
-// WithConfig creates and connects a new Redis client and appends it
-// to the Provider.
-func WithConfig(config *redis.Options) (func(o *options), error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
-	defer cancel()
+// WithMaxValueSize rejects any Put whose encoded value is larger than the given
+// amount of bytes with ErrValueTooLarge, before anything is sent to Redis. Zero,
+// the default, means there is no limit.
+func WithMaxValueSize(bytes int) func(o *options) {
+	return func(o *options) {
+		o.maxValueSize = bytes
+	}
+}
+
+// WithDryRun makes Put (and the write-back that Get does after decrementing) do
+// everything except writing to Redis, so the Provider can be rolled out in an
+// observe-only mode where it reads real state but never consumes any budget.
+// Reset still deletes ratelimits, since it's only called explicitly.
+func WithDryRun() func(o *options) {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
 
-	client := redis.NewClient(config)
-	if err := client.Ping(ctx).Err(); err != nil {
-		// TODO: find a better solution for this
-		// no-op operation
-		return func(o *options) {}, nil
+// WithClock sets the function that the Provider uses to get the current time,
+// which is time.Now by default.
+func WithClock(now func() time.Time) func(o *options) {
+	return func(o *options) {
+		o.now = now
 	}
+}
 
+// WithLogger sets a printf-style function, like log.Printf, that the Provider
+// logs warnings with.
+func WithLogger(logf func(format string, args ...interface{})) func(o *options) {
+	return func(o *options) {
+		o.logf = logf
+	}
+}
+
+// WithClient appends a pre-existing Redis client that is connected
+// when constructing a Provider.
+func WithClient(client *redis.Client) func(o *options) {
 	return func(o *options) {
 		o.client = client
-	}, nil
+	}
+}
+
+// WithDB selects the logical Redis database that the Provider uses. With
+// WithConfig or WithURL it overrides the database in the options, and with
+// WithClient, New fails if the client is connected to a different database.
+// Keyspace notifications and SCAN only ever see one database, so tooling built
+// on them has to be pointed at the same one.
+func WithDB(index int) func(o *options) {
+	return func(o *options) {
+		o.db = &index
+	}
 }
 
 // New creates a new Provider object with the following options that was
 // passed down.
-func New(opts ...func(o *options)) (providers.Provider, error) {
+//
+// New used to return a providers.Provider. It returns the *Provider now, so
+// its other methods can be reached without a type assertion; it can still be
+// passed wherever a providers.Provider is expected, but code that stored New in
+// a variable of the old function type has to change.
+func New(opts ...func(o *options)) (*Provider, error) {
+	p, err := newProvider(opts...)
+	if err != nil {
+		if _, ok := err.(*OpError); !ok {
+			err = &OpError{Op: "new", Err: err}
+		}
+
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func newProvider(opts ...func(o *options)) (*Provider, error) {
 	config := &options{
-		keyPrefix: "chi_ratelimit",
-		client:    nil,
+		keyPrefix:       "chi_ratelimit",
+		now:             time.Now,
+		txnAttempts:     defaultTxnAttempts,
+		snapshotLimit:   defaultSnapshotLimit,
+		keyStateEntries: defaultKeyStateEntries,
+		client:          nil,
 	}
 
 	for _, override := range opts {
 		override(config)
 	}
 
-	if config.client == nil {
+	if config.sharedHealth != nil && config.clientConfig == nil && config.client == nil {
+		config.client = config.sharedHealth.client
+	}
+
+	if config.client == nil && config.clientConfig == nil {
 		return nil, errors.New("missing redis client to use")
 	}
 
-	return &Provider{
-		keyPrefix: config.keyPrefix,
-		client:    config.client,
-	}, nil
+	if _, fixed := config.algorithm.(fixedWindow); config.pool != nil && config.pool.memberLimit > 0 && config.algorithm != nil && !fixed {
+		return nil, errors.New("WithMemberLimit only works with FixedWindow")
+	}
+
+	if config.adaptiveTimeout != nil && !config.adaptiveTimeout.valid() {
+		return nil, errors.New("WithAdaptiveTimeout needs 0 < floor <= ceiling, a percentile in (0, 1] and a positive multiplier")
+	}
+
+	if config.burstBucket != nil && !config.burstBucket.valid() {
+		return nil, errors.New("WithBurstBucket needs a positive burst and sustained rate")
+	}
+
+	if config.penaltyBackoff != nil && !config.penaltyBackoff.valid() {
+		return nil, errors.New("WithPenaltyBackoff needs a positive base, a factor of at least 1 and base <= ceiling")
+	}
+
+	if config.roundTripFn != nil && config.roundTripEvery < 1 {
+		return nil, errors.New("WithRoundTripSampling needs to sample at least 1 in 1 requests")
+	}
+
+	if config.tombstoneTTL > 0 && config.tombstoneTTL < time.Millisecond {
+		return nil, errors.New("WithTombstones needs a TTL of at least a millisecond")
+	}
+
+	if config.threshold != nil && !config.threshold.valid() {
+		return nil, errors.New("WithThresholdCallback needs a fraction in (0, 1] and a callback")
+	}
+
+	if config.maxKeyLength < 0 || (config.maxKeyLength > 0 && config.maxKeyLength < maxKeyDigestLength) {
+		return nil, errors.New("WithMaxKeyLength needs at least 65 bytes, for the SHA-256 of the key")
+	}
+
+	if config.derivedReset && !config.fieldTTL {
+		return nil, errors.New("WithDerivedReset needs WithFieldTTL, whose TTLs the reset times are derived from")
+	}
+
+	if config.asyncCapacity < 0 || (config.asyncCapacity > 0 && config.asyncInterval <= 0) {
+		return nil, errors.New("WithAsyncWrites needs a positive capacity and interval")
+	}
+
+	if config.asyncPressure != nil && (config.asyncCapacity <= 0 || !(config.asyncPressureAt > 0 && config.asyncPressureAt <= 1)) {
+		return nil, errors.New("WithAsyncBackpressureCallback needs WithAsyncWrites and a fraction in (0, 1]")
+	}
+
+	if config.localCacheBytes < 0 || (config.localCacheBytes > 0 && config.localCacheTTL <= 0) {
+		return nil, errors.New("WithLocalCacheBytes needs a positive budget and WithLocalCache")
+	}
+
+	if config.changeChannel != "" && config.changeMaxValue < 0 {
+		return nil, errors.New("WithChangeNotifications needs a maxValueBytes of at least 0")
+	}
+
+	if err := checkLayoutRollout(config); err != nil {
+		return nil, err
+	}
+
+	if config.statNoise < 0 || math.IsNaN(config.statNoise) || math.IsInf(config.statNoise, 0) {
+		return nil, errors.New("WithStatNoise needs a positive epsilon")
+	}
+
+	if err := checkHealth(config); err != nil {
+		return nil, err
+	}
+
+	if config.resetJitter < 0 {
+		return nil, errors.New("WithResetJitter needs a non-negative jitter")
+	}
+
+	if config.graceRequests < 0 {
+		return nil, errors.New("WithGrace needs a non-negative amount of requests")
+	}
+
+	if config.degradedCallback != nil && config.degradedThreshold <= 0 {
+		return nil, errors.New("WithDegradationCallback needs a positive threshold")
+	}
+
+	if config.readPath == nil {
+		config.readPath = defaultReadPath
+	} else if err := checkReadPath(config); err != nil {
+		return nil, err
+	}
+
+	if config.clockSkewTolerance > 0 {
+		config.now = (&skewClock{now: config.now, tolerance: config.clockSkewTolerance}).read
+	}
+
+	if config.pinnedScripts != nil {
+		if err := checkPinnedScripts(config.pinnedScripts, config.algorithm); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.allowedCommands != nil {
+		if err := checkRestrictedCommands(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.noScripting {
+		if err := checkNoScripting(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.maxStaleness > 0 {
+		if err := checkMaxStaleness(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.db != nil {
+		if config.client != nil {
+			if db := config.client.Options().DB; db != *config.db {
+				return nil, fmt.Errorf("redis client uses database %d, but WithDB asked for %d", db, *config.db)
+			}
+		} else {
+			withDB := *config.clientConfig
+			withDB.DB = *config.db
+			config.clientConfig = &withDB
+		}
+	}
+
+	if config.credentials != nil {
+		if config.client != nil {
+			return nil, errors.New("WithCredentialsProvider only works with a client that the Provider creates itself")
+		}
+
+		config.clientConfig = withCredentials(config.clientConfig, config.credentials)
+	}
+
+	var owned *sharedClient
+	if config.client == nil {
+		reuse := !config.noClientReuse && len(config.clientHooks) == 0 && config.credentials == nil
+		shared, err := acquireClient(config.clientConfig, reuse, config.clientHooks)
+		if err != nil {
+			return nil, err
+		}
+
+		owned = shared
+		config.client = shared.client
+	}
+
+	if trackConstruction(config.now()) && config.logf != nil {
+		config.logf("chi-ratelimit-redis: %d providers were created in the last %v; a Provider should be created once and reused", constructionWarnCount, constructionWarnWindow)
+	}
+
+	hash := config.hash
+	if hash == nil {
+		hash = fnv1a
+	}
+
+	states := newKeyStates(config.keyStateEntries, hash)
+	states.maxCacheBytes = config.localCacheBytes
+	var dedup *putDedup
+	if config.dedupWindow > 0 && config.keyWindow <= 0 {
+		dedup = newPutDedup(config.dedupWindow, config.now, states)
+	}
+
+	p := &Provider{
+		maxKeyLength:  config.maxKeyLength,
+		maxValueSize:  config.maxValueSize,
+		tombstoneTTL:  config.tombstoneTTL,
+		readTimeout:   config.readTimeout,
+		writeTimeout:  config.writeTimeout,
+		errorHandler:  config.errorHandler,
+		wireFormat:    config.wireFormat,
+		dryRun:        config.dryRun,
+		unsafeRaw:     config.unsafeRaw,
+		resetIndex:    config.resetIndex,
+		dedup:         dedup,
+		states:        states,
+		pinnedScripts: config.pinnedScripts != nil,
+		now:           config.now,
+		repairPolicy:  config.repairPolicy,
+		repairHorizon: config.repairHorizon,
+		latency: &latencyTracker{
+			threshold: config.degradedThreshold,
+			callback:  config.degradedCallback,
+			adaptive:  config.adaptiveTimeout,
+		},
+		logf:       config.logf,
+		client:     config.client,
+		owned:      owned,
+		instanceID: newInstanceID(),
+		startedAt:  config.now(),
+		stop:       make(chan struct{}),
+
+		txnAttempts:         config.txnAttempts,
+		scanBatchSize:       config.scanBatchSize,
+		scanThrottle:        config.scanThrottle,
+		scanProgress:        config.scanProgress,
+		forceRename:         config.forceRename,
+		approxWidth:         config.approxWidth,
+		approxDepth:         config.approxDepth,
+		sampleEvery:         config.sampleEvery,
+		sampleThreshold:     config.sampleThreshold,
+		sample:              rand.Intn,
+		verifyRate:          config.verifyRate,
+		verifyRandom:        rand.Float64,
+		windowCreatedAt:     config.windowCreatedAt,
+		persistentEntries:   config.persistentEntries,
+		calendar:            config.calendar,
+		calendarLoc:         config.calendarLoc,
+		lenientDecoding:     config.lenientDecoding,
+		noScripting:         config.noScripting,
+		maxStaleness:        config.maxStaleness,
+		limitChangePolicy:   config.limitChangePolicy,
+		firstSeenTracking:   config.firstSeenTracking,
+		verboseErrors:       config.verboseErrors,
+		pool:                config.pool,
+		rateHalfLife:        config.rateHalfLife,
+		mirrorPrefix:        config.mirrorPrefix,
+		burstBucket:         config.burstBucket,
+		readPath:            config.readPath,
+		credentials:         config.credentials,
+		penaltyBackoff:      config.penaltyBackoff,
+		graceRequests:       config.graceRequests,
+		roundTripEvery:      config.roundTripEvery,
+		roundTripFn:         config.roundTripFn,
+		statNoise:           config.statNoise,
+		statNoiseSeed:       config.statNoiseSeed,
+		derivedReset:        config.derivedReset,
+		hash:                hash,
+		tracer:              config.tracer,
+		metrics:             config.metrics,
+		negativeCacheTTL:    config.negativeCacheTTL,
+		localCacheTTL:       config.localCacheTTL,
+		rolloverFn:          config.rolloverFn,
+		threshold:           config.threshold,
+		resetJitter:         config.resetJitter,
+		clientHooks:         config.clientHooks,
+		schemaInfo:          config.schemaInfo,
+		writeReplicas:       config.writeReplicas,
+		writeConcernTimeout: config.writeConcernTimeout,
+		snapshotLimit:       config.snapshotLimit,
+		negativePolicy:      config.negativePolicy,
+		compression:         config.compression,
+		compressionMinSize:  config.compressionMinSize,
+		keyWindow:           config.keyWindow,
+		coldStartRamp:       config.coldStartRamp,
+		coldStartFloor:      config.coldStartFloor,
+		expiryGrace:         config.expiryGrace,
+		maintenance:         config.maintenanceClient,
+		abuseHalfLife:       config.abuseHalfLife,
+		autoBanThreshold:    config.autoBanThreshold,
+		autoBanFor:          config.autoBanFor,
+		detectsStateLoss:    config.stateLossInterval > 0 && config.stateLossCallback != nil,
+	}
+
+	if config.layoutRollout != nil {
+		p.layout = &layoutRollout{fraction: config.layoutRollout.fraction, fallback: !config.layoutNoFallback}
+	}
+
+	p.companions = p.registerCompanions()
+
+	if config.catalogKey != "" && config.catalogTier != nil {
+		p.catalog = &limitCatalog{key: config.catalogKey, tier: config.catalogTier, refresh: config.catalogRefresh}
+	}
+
+	if p.maintenance == nil && config.maintenancePoolSize > 0 {
+		p.ownedMaintenance = newMaintenanceClient(config)
+		p.maintenance = p.ownedMaintenance
+	}
+
+	// FixedWindow's state is the hash itself, so only others need to be
+	// remembered.
+	if _, fixed := config.algorithm.(fixedWindow); config.algorithm != nil && !fixed {
+		p.algorithm = config.algorithm
+		p.algorithmScripts = algorithmScripts(config.algorithm)
+	}
+
+	if config.fallbackPrefix != "" {
+		p.fallback = &fallbackPrefix{hash: config.fallbackPrefix, until: config.fallbackUntil}
+	}
+
+	if config.tenantFn != nil && config.tenantMaxKeys > 0 {
+		p.tenants = &tenantBudget{tenant: config.tenantFn, maxKeys: config.tenantMaxKeys}
+		p.goBackground(p.reconcileTenants)
+	}
+
+	if config.cardinalityEstimate {
+		p.cardinality = &cardinalityEstimate{}
+	}
+
+	if config.decisionSink != nil {
+		p.decisions = newDecisionLog(config.decisionSink, config.decisionSampleRate)
+		p.goBackground(p.emitDecisions)
+	}
+
+	if config.asyncCapacity > 0 {
+		p.async = newAsyncQueue(config.asyncCapacity, config.asyncInterval)
+		p.async.pressureAt, p.async.pressure = config.asyncPressureAt, config.asyncPressure
+		p.goBackground(p.runFlusher)
+	}
+
+	if config.changeChannel != "" {
+		p.changes = &changeNotifications{channel: config.changeChannel, maxValue: config.changeMaxValue}
+		p.goBackground(p.runChanges)
+	}
+
+	p.watchHealth(config)
+	p.space.Store(newKeyspace(config.keyPrefix))
+	p.latency.spawn = p.goBackground
+	if p.latency.callback != nil {
+		p.goBackground(func() {
+			p.latency.watch(p.stop)
+		})
+	}
+	if config.fieldTTL || config.writeReplicas > 0 {
+		if err := p.checkCapabilities(config); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+
+	// Without field TTLs, like after WithFieldTTLFallback, there is nothing
+	// to derive the reset times from.
+	p.derivedReset = p.derivedReset && p.fieldTTL
+
+	if config.schemaInfo {
+		if err := p.checkSchema(config.schemaWarning); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+
+	if p.detectsStateLoss || p.coldStartRamp > 0 {
+		ctx, cancel := p.writeContext()
+		defer cancel()
+
+		if err := p.writeMarker(ctx); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+
+	if p.detectsStateLoss {
+		p.goBackground(func() {
+			p.detectStateLoss(config.stateLossInterval, config.stateLossCallback)
+		})
+	}
+
+	if config.evictionInterval > 0 && config.evictionCallback != nil {
+		ctx, cancel := p.writeContext()
+		defer cancel()
+
+		if err := p.writeCanaries(ctx); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+
+		p.evictions = &evictionDetector{fn: config.evictionCallback, budget: config.evictionBudget}
+		p.goBackground(func() {
+			p.detectEvictions(config.evictionInterval)
+		})
+	}
+
+	if config.autoLegacyMigration {
+		p.goBackground(func() {
+			p.autoMigrateLegacy(config.autoLegacyOptions)
+		})
+	}
+
+	p.features = p.enabledFeatures(config)
+	return p, nil
 }
 
 func (p *Provider) Reset(key string) (bool, error) {
+	return p.ResetWithOptions(key)
+}
+
+// ResetWithOptions is Reset with options that only apply to this call. With
+// WithAlgorithm, the algorithm's state for the key is reset as well.
+func (p *Provider) ResetWithOptions(key string, opts ...CallOption) (ok bool, err error) {
+	defer p.recoverPanic(&err, "reset", key)
+
+	key = p.storageKey(key)
+	call := newCallOptions(opts)
+	queued, err := p.queueWrite(call, &asyncOp{op: "reset", key: key, target: key, deleted: true, apply: func(call *callOptions) error {
+		_, err := p.reset(key, call)
+		return err
+	}})
+
+	if queued || err != nil {
+		return queued, err
+	}
+
+	err = p.async.exclusive(key, func() error {
+		ok, err = p.reset(key, call)
+		return err
+	})
+
+	return ok, err
+}
+
+// reset is ResetWithOptions for the given storage key, without the queue of
+// WithAsyncWrites.
+func (p *Provider) reset(key string, call *callOptions) (bool, error) {
+	ok, err := p.resetStored(key, call)
+	if err != nil {
+		return ok, err
+	}
+
+	reset, err := p.resetAlgorithm(key, call)
+	if ok || reset {
+		p.logReset(key, p.now())
+		p.publishChange("reset", key, "", call)
+	}
+
+	return ok || reset, err
+}
+
+// resetStored deletes the ratelimit stored under the given storage key.
+func (p *Provider) resetStored(key string, call *callOptions) (ok bool, err error) {
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	// Whatever happens, the next Put for this key must reach Redis.
+	defer p.forgetLocal(key)
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	// The previous window only matters until it expires, so it's deleted from
+	// without checking whether that did anything.
+	if previous := p.previousHashKey(); previous != "" {
+		if err := p.cmd(ctx).HDel(ctx, previous, key).Err(); err != nil {
+			return false, err
+		}
+	}
+
+	if p.tombstoneTTL > 0 {
+		if ok, err = p.resetToTombstone(ctx, key, call); err != nil || !ok {
+			return ok, err
+		}
+
+		if err := p.deleteCompanions(ctx, key); err != nil {
+			return true, err
+		}
+
+		p.trackCardinality(-1)
+		p.recordChange(p.hashKey(), key, "", true)
+		if err := p.releaseTenantKeys(ctx, key); err != nil {
+			return true, err
+		}
+
+		return true, replication.wait(ctx)
+	}
+
 	// Check if it exists
-	ok, err := p.client.HExists(context.TODO(), p.keyPrefix, key).Result()
+	ok, err = p.cmd(ctx).HExists(ctx, p.hashKey(), key).Result()
 	if err != nil {
 		return false, err
 	}
 
+	if !ok && p.layout != nil {
+		exists, err := p.cmd(ctx).Exists(ctx, p.entryKey(key)).Result()
+		if err != nil {
+			return false, err
+		}
+
+		ok = exists == 1
+	}
+
 	if !ok {
 		return false, nil
 	}
 
 	// Delete it from Redis
-	if err := p.client.HDel(context.TODO(), p.keyPrefix, key).Err(); err != nil {
+	if _, err := p.deleteFields(ctx, key); err != nil {
 		return false, err
 	} else {
-		return true, nil
+		return true, replication.wait(ctx)
 	}
 }
 
@@ -125,38 +906,293 @@ func (*Provider) Name() string {
 }
 
 func (p *Provider) Put(key string, value *types.Ratelimit) error {
-	data, err := json.Marshal(value)
+	return p.PutWithOptions(key, value)
+}
+
+// PutWithOptions is Put with options that only apply to this call.
+func (p *Provider) PutWithOptions(key string, value *types.Ratelimit, opts ...CallOption) (err error) {
+	defer p.recoverPanic(&err, "put", key)
+
+	return p.reportLag("put", key, p.put(p.pooledKey(key), value, newCallOptions(opts), "put"))
+}
+
+// put is PutWithOptions without resolving the key's pool. It's published as
+// changeOp for WithChangeNotifications, unless that's empty. call can be nil.
+func (p *Provider) put(key string, value *types.Ratelimit, call *callOptions, changeOp string) error {
+	value, err := p.checkRemaining(key, value)
 	if err != nil {
 		return err
 	}
 
-	if err := p.client.HMSet(context.TODO(), p.keyPrefix, key, string(data)).Err(); err != nil {
+	data, err := p.encode(value)
+	if err != nil {
 		return err
-	} else {
+	}
+
+	if err := p.checkValueSize(data); err != nil {
+		return err
+	}
+
+	key = p.storageKey(key)
+	apply := func(call *callOptions) error {
+		if err := p.write(key, data, value.ResetTime, call); err != nil {
+			return err
+		}
+
+		if changeOp != "" {
+			p.publishChange(changeOp, key, string(data), call)
+		}
+
 		return nil
 	}
+
+	if queued, err := p.queueWrite(call, &asyncOp{op: "put", key: key, target: key, data: string(data), apply: apply}); queued || err != nil {
+		return err
+	}
+
+	return p.async.exclusive(key, func() error { return apply(call) })
+}
+
+// write stores already encoded data under the given storage key. The reset time
+// is only used for the reset index, and can be zero if it isn't known. call can
+// be nil.
+func (p *Provider) write(key string, data []byte, resetAt time.Time, call *callOptions) error {
+	if err := p.checkValueSize(data); err != nil {
+		return err
+	}
+
+	if p.dryRun || (p.dedup != nil && p.dedup.seen(key, data)) {
+		return nil
+	}
+
+	ctx, cancel := p.writeContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	hash := p.hashKey()
+	if err := p.reserveTenantKey(ctx, hash, key); err != nil {
+		return err
+	}
+
+	if err := p.markPersistent(ctx, key, call); err != nil {
+		return err
+	}
+
+	if p.onEntryLayout(key) {
+		if err := p.putEntry(ctx, hash, key, data, resetAt); err != nil {
+			return err
+		}
+	} else if p.resetIndex {
+		keys := []string{hash, p.indexKey()}
+		if err := p.runScript(ctx, indexedPutScript, keys, key, string(data), indexScore(resetAt)).Err(); err != nil {
+			return err
+		}
+
+		p.mirror(ctx, key, data)
+	} else {
+		added, err := p.putField(ctx, hash, key, data)
+		if err != nil {
+			return err
+		}
+
+		p.trackCardinality(added)
+		if p.layout != nil {
+			if err := p.cmd(ctx).Del(ctx, p.entryKey(key)).Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.verifyWrite(ctx, hash, key, data)
+	p.recordSchema(ctx)
+	p.recordChange(hash, key, string(data), false)
+	if err := p.trackCreated(ctx, key, resetAt); err != nil {
+		return err
+	}
+
+	if err := p.expireFields(ctx, hash, resetAt, key); err != nil {
+		return err
+	}
+
+	if err := p.expireWindow(ctx, hash); err != nil {
+		return err
+	}
+
+	if p.dedup != nil {
+		p.dedup.record(key, data)
+	}
+
+	return replication.wait(ctx)
 }
 
 func (p *Provider) Get(key string) (*types.Ratelimit, error) {
+	return p.GetWithOptions(key)
+}
+
+// GetWithOptions is Get with options that only apply to this call, including
+// the write that counts the request.
+func (p *Provider) GetWithOptions(key string, opts ...CallOption) (rl *types.Ratelimit, err error) {
+	defer p.recoverPanic(&err, "get", key)
+
+	rl, _, err = p.get(key, newCallOptions(opts))
+	return rl, err
+}
+
+// get is GetWithOptions, returning where the ratelimit was read from. call can
+// be nil.
+func (p *Provider) get(key string, call *callOptions) (rl *types.Ratelimit, source ReadSource, err error) {
+	call, counted := p.sampleRoundTrips(call)
+	defer p.reportRoundTrips("get", counted)
+
+	call, observed := p.observeRead("get", call)
+	defer func() { observed(source, err) }()
+
+	key = p.pooledKey(key)
+	if prefetched, ok := p.takePrefetch(p.storageKey(key)); ok && p.async.pending(p.storageKey(key)) == nil {
+		rl = prefetched
+		if rl != nil {
+			source = ReadPrimary
+		}
+	} else {
+		rl, source, err = p.fetchCached(p.storageKey(key), call)
+	}
+
+	if err != nil || rl == nil {
+		return nil, source, err
+	}
+
+	if p.inExpiryGrace(rl, p.now()) {
+		rl.Remaining = 0
+		return rl, source, nil
+	}
+
 	// Update the database with the new copy
-	data, err := p.client.HGet(context.TODO(), p.keyPrefix, key).Result()
+	remaining, persist := p.sampledRemaining(rl.Remaining, rl.Limit)
+	copied := rl.Copy()
+	if !persist {
+		return copied, source, nil
+	}
+
+	copied.Remaining = remaining
+	if err := p.reportLag("get", key, p.put(key, copied, call, "")); err != nil {
+		return nil, "", err
+	}
+
+	return copied, source, nil
+}
+
+// Peek returns the ratelimit stored for the given key, or nil if there is none.
+// Unlike Get, it doesn't count as a request.
+func (p *Provider) Peek(key string) (rl *types.Ratelimit, err error) {
+	defer p.recoverPanic(&err, "peek", key)
+
+	call, observed := p.observeRead("peek", nil)
+	rl, source, err := p.fetchCached(p.storageKey(p.pooledKey(key)), call)
+	observed(source, err)
+	return rl, err
+}
+
+// fetch reads and decodes the ratelimit stored under the given storage key
+// without changing it, returning nil if it doesn't exist. call can be nil.
+func (p *Provider) fetch(key string, call *callOptions) (*types.Ratelimit, error) {
+	rl, _, err := p.fetchDetailed(key, call)
+	return rl, err
+}
+
+// fetchDetailed is fetch, returning where the ratelimit was found.
+func (p *Provider) fetchDetailed(key string, call *callOptions) (*types.Ratelimit, ReadSource, error) {
+	data, source, expiry, err := p.fetchExpiry(key, call)
+	if err != nil || source == "" {
+		return nil, "", err
+	}
+
+	rl, err := p.decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return p.clampRead(withExpiry(rl, expiry)), source, nil
+}
+
+// fetchRaw reads the data stored under the given storage key. The returned bool
+// is false if it doesn't exist. call can be nil.
+func (p *Provider) fetchRaw(key string, call *callOptions) (string, bool, error) {
+	data, source, err := p.fetchSource(key, call)
+	return data, source != "", err
+}
+
+// fetchSource is fetchRaw, returning where the data was found or an empty
+// ReadSource if it doesn't exist.
+func (p *Provider) fetchSource(key string, call *callOptions) (string, ReadSource, error) {
+	ctx, cancel := p.readContextFor(call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	if p.fallback != nil && p.now().Before(p.fallback.until) {
+		return p.fetchWithFallback(ctx, p.hashKey(), key)
+	}
+
+	if p.layout != nil {
+		return p.fetchLayout(ctx, key)
+	}
+
+	data, err := p.cmd(ctx).HGet(ctx, p.hashKey(), key).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			return nil, nil
+			return "", "", nil
 		} else {
-			return nil, err
+			return "", "", err
 		}
 	}
 
-	var rl *types.Ratelimit
-	if err := json.Unmarshal([]byte(data), &rl); err != nil {
+	return data, ReadPrimary, nil
+}
+
+// checkValueSize fails with ErrValueTooLarge for data over the limit of
+// WithMaxValueSize.
+func (p *Provider) checkValueSize(data []byte) error {
+	if p.maxValueSize > 0 && len(data) > p.maxValueSize {
+		return fmt.Errorf("%w: %d bytes (max %d)", ErrValueTooLarge, len(data), p.maxValueSize)
+	}
+
+	return nil
+}
+
+func (p *Provider) encode(rl *types.Ratelimit) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if p.wireFormat != nil {
+		data, err = p.wireFormat.encode(rl)
+	} else {
+		data, err = encodeJSON(rl)
+	}
+
+	if err != nil {
 		return nil, err
 	}
 
-	copied := rl.Copy()
-	if err := p.Put(key, copied); err != nil {
+	return p.compress(data)
+}
+
+func (p *Provider) decode(stored string) (*types.Ratelimit, error) {
+	data, err := p.decompress([]byte(stored))
+	if err != nil {
 		return nil, err
 	}
 
-	return copied, nil
+	if p.wireFormat != nil {
+		return p.wireFormat.decode(data)
+	}
+
+	if p.lenientDecoding {
+		return decodeLenient(data)
+	}
+
+	return decodeJSON(data)
 }
@@ -0,0 +1,142 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+// newTestProvider returns a Provider on a new miniredis server, which is closed
+// together with the Provider when the test ends.
+func newTestProvider(t testing.TB, opts ...func(o *options)) (*Provider, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	return newTestProviderOn(t, server, opts...), server
+}
+
+// newTestProviderOn is newTestProvider on the given server, for tests that have
+// to set it up before New runs.
+func newTestProviderOn(t testing.TB, server *miniredis.Miniredis, opts ...func(o *options)) *Provider {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	p, err := New(append([]func(o *options){WithClient(client)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+	return p
+}
+
+// putAll stores a ratelimit for each of the given keys.
+func putAll(t *testing.T, p *Provider, keys ...string) {
+	t.Helper()
+
+	for _, key := range keys {
+		rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+		if err := p.Put(key, rl); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	p, _ := newTestProvider(t)
+	rl := types.NewRatelimit(1<<30, false, time.Now().Add(time.Hour))
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// miniredis runs in this process, so its allocations are counted as well;
+	// these are about twice of what both take together. Consume isn't checked,
+	// since miniredis sets up a whole Lua state for every script it runs.
+	for name, test := range map[string]struct {
+		max float64
+		fn  func() error
+	}{
+		"Get": {150, func() error { _, err := p.Get("k"); return err }},
+		"Put": {75, func() error { return p.Put("k", rl) }},
+	} {
+		var err error
+		allocs := testing.AllocsPerRun(200, func() {
+			if callErr := test.fn(); callErr != nil {
+				err = callErr
+			}
+		})
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if allocs > test.max {
+			t.Errorf("%s allocates %.0f times, want at most %.0f", name, allocs, test.max)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	p, _ := newTestProvider(b)
+	if err := p.Put("k", types.NewRatelimit(1<<30, false, time.Now().Add(time.Hour))); err != nil {
+		b.Fatalf("Put: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Get("k"); err != nil {
+			b.Fatalf("Get: %v", err)
+		}
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	p, _ := newTestProvider(b)
+	rl := types.NewRatelimit(100, false, time.Now().Add(time.Hour))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Put("k", rl); err != nil {
+			b.Fatalf("Put: %v", err)
+		}
+	}
+}
+
+func BenchmarkConsume(b *testing.B) {
+	p, _ := newTestProvider(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Consume("k", 1<<30, time.Hour); err != nil {
+			b.Fatalf("Consume: %v", err)
+		}
+	}
+}
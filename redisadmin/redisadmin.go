@@ -0,0 +1,332 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redisadmin has HTTP handlers to inspect and reset the ratelimits of a
+// redis.Provider, like for internal admin endpoints.
+package redisadmin
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit/types"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPageSize is how many entries a listing has by default.
+const defaultPageSize = 100
+
+// routePrefix is the path segment that every route starts with.
+const routePrefix = "/ratelimits"
+
+// Handler serves the admin routes:
+//
+//	GET    /ratelimits?cursor=&count=  lists the stored ratelimits, a page at a time
+//	GET    /ratelimits/{key}           returns a ratelimit without counting a request
+//	DELETE /ratelimits/{key}           resets a ratelimit
+//	POST   /ratelimits/{key}/ban       bans a key, with {"duration": "1h"} as body
+//
+// Routes are matched from the "/ratelimits" segment on, so the Handler can be
+// mounted under any prefix, like with chi's Router.Mount. Keys have to be path
+// escaped. Responses are JSON; errors from Redis are never shown to clients,
+// only passed to the function set with WithErrorHandler.
+type Handler struct {
+	provider     *redis.Provider
+	authorize    func(http.Handler) http.Handler
+	errorHandler func(r *http.Request, err error)
+	handler      http.Handler
+}
+
+// Option configures a Handler.
+type Option func(h *Handler)
+
+// WithAuthorization wraps every route in the given middleware, which should
+// reject requests that aren't allowed to use the admin routes. There is none by
+// default, so the routes have to be protected some other way otherwise.
+func WithAuthorization(middleware func(http.Handler) http.Handler) Option {
+	return func(h *Handler) {
+		h.authorize = middleware
+	}
+}
+
+// WithErrorHandler sets a function that is called with the errors that the
+// routes turned into a 500 response.
+func WithErrorHandler(fn func(r *http.Request, err error)) Option {
+	return func(h *Handler) {
+		h.errorHandler = fn
+	}
+}
+
+// New returns a Handler for the given Provider.
+func New(provider *redis.Provider, opts ...Option) *Handler {
+	h := &Handler{provider: provider}
+	for _, override := range opts {
+		override(h)
+	}
+
+	h.handler = http.HandlerFunc(h.route)
+	if h.authorize != nil {
+		h.handler = h.authorize(h.handler)
+	}
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, r)
+}
+
+// route dispatches a request to the route that it's for.
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	index := strings.Index(path, routePrefix)
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	rest := path[index+len(routePrefix):]
+	if rest == "" || rest == "/" {
+		h.allowMethods(w, r, h.list, http.MethodGet)
+		return
+	}
+
+	rest = strings.TrimPrefix(rest, "/")
+	escaped, action, _ := strings.Cut(rest, "/")
+
+	key, err := url.PathUnescape(escaped)
+	if err != nil || key == "" {
+		writeError(w, http.StatusBadRequest, "malformed key")
+		return
+	}
+
+	switch action {
+	case "":
+		h.allowMethods(w, r, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				h.reset(w, r, key)
+			} else {
+				h.get(w, r, key)
+			}
+		}, http.MethodGet, http.MethodDelete)
+
+	case "ban":
+		h.allowMethods(w, r, func(w http.ResponseWriter, r *http.Request) {
+			h.ban(w, r, key)
+		}, http.MethodPost)
+
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) allowMethods(w http.ResponseWriter, r *http.Request, fn http.HandlerFunc, methods ...string) {
+	for _, method := range methods {
+		if r.Method == method {
+			fn(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// ratelimitResponse is how a ratelimit is returned.
+type ratelimitResponse struct {
+	Key       string    `json:"key"`
+	Limit     int32     `json:"limit"`
+	Remaining int32     `json:"remaining"`
+	Global    bool      `json:"global"`
+	ResetAt   time.Time `json:"reset_at"`
+	ResetInMS int64     `json:"reset_in_ms"`
+}
+
+func newRatelimitResponse(key string, rl *types.Ratelimit, now time.Time) ratelimitResponse {
+	resetIn := rl.ResetTime.Sub(now)
+	if resetIn < 0 {
+		resetIn = 0
+	}
+
+	return ratelimitResponse{
+		Key:       key,
+		Limit:     rl.Limit,
+		Remaining: rl.Remaining,
+		Global:    rl.Global,
+		ResetAt:   rl.ResetTime,
+		ResetInMS: resetIn.Milliseconds(),
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, key string) {
+	rl, err := h.provider.Peek(key)
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	if rl == nil {
+		writeError(w, http.StatusNotFound, "no ratelimit is stored for the key")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newRatelimitResponse(key, rl, time.Now()))
+}
+
+func (h *Handler) reset(w http.ResponseWriter, r *http.Request, key string) {
+	// A window that a request started while this one was on its way isn't
+	// what was meant to be reset.
+	ok, err := h.provider.ResetIfOlderThan(key, time.Now())
+	if errors.Is(err, redis.ErrCreatedAtDisabled) {
+		ok, err = h.provider.Reset(key)
+	}
+
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "no ratelimit is stored for the key")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "reset": true})
+}
+
+// listResponse is a page of GET /ratelimits.
+type listResponse struct {
+	Entries []listEntry `json:"entries"`
+
+	// NextCursor is the cursor of the next page, or empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type listEntry struct {
+	ratelimitResponse
+
+	// Undecodable is true if the stored value couldn't be decoded, in which
+	// case only the key is set.
+	Undecodable bool `json:"undecodable,omitempty"`
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var cursor uint64
+	if raw := query.Get("cursor"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "malformed cursor")
+			return
+		}
+
+		cursor = parsed
+	}
+
+	count := int64(defaultPageSize)
+	if raw := query.Get("count"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "malformed count")
+			return
+		}
+
+		count = parsed
+	}
+
+	entries, next, err := h.provider.Admin().List(r.Context(), cursor, count)
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	now := time.Now()
+	response := listResponse{Entries: make([]listEntry, 0, len(entries))}
+	for _, entry := range entries {
+		if entry.Ratelimit == nil {
+			response.Entries = append(response.Entries, listEntry{ratelimitResponse: ratelimitResponse{Key: entry.Key}, Undecodable: true})
+			continue
+		}
+
+		response.Entries = append(response.Entries, listEntry{ratelimitResponse: newRatelimitResponse(entry.Key, entry.Ratelimit, now)})
+	}
+
+	if next != 0 {
+		response.NextCursor = strconv.FormatUint(next, 10)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// banRequest is the body of POST /ratelimits/{key}/ban.
+type banRequest struct {
+	// Duration is how long the ban lasts, like "1h".
+	Duration string `json:"duration"`
+}
+
+func (h *Handler) ban(w http.ResponseWriter, r *http.Request, key string) {
+	var request banRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed body")
+		return
+	}
+
+	duration, err := time.ParseDuration(request.Duration)
+	if err != nil || duration <= 0 {
+		writeError(w, http.StatusBadRequest, "malformed duration")
+		return
+	}
+
+	if err := h.provider.Ban(key, duration); err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "banned_for_ms": duration.Milliseconds()})
+}
+
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	if h.errorHandler != nil {
+		h.errorHandler(r, err)
+	}
+
+	message := "internal error"
+	if errors.Is(err, redis.ErrNoPermission) {
+		message = "the redis user isn't allowed to do this"
+	}
+
+	writeError(w, http.StatusInternalServerError, message)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,93 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Command redisbench runs redisbench.Run against a Redis server:
+//
+//	go run ./redisbench/cmd/redisbench -addr localhost:6379 -duration 30s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit-redis/redisbench"
+	"os"
+	"os/signal"
+	"sort"
+)
+
+func main() {
+	var (
+		addr   = flag.String("addr", "localhost:6379", "address of the Redis server")
+		prefix = flag.String("prefix", redisbench.SafetyPrefix, "key prefix to benchmark under; has to start with "+redisbench.SafetyPrefix)
+		cfg    redisbench.BenchConfig
+		zipf   bool
+	)
+
+	flag.IntVar(&cfg.Keys, "keys", 10000, "how many distinct keys to use")
+	flag.BoolVar(&zipf, "zipf", false, "pick keys with a Zipf distribution instead of uniformly")
+	flag.Float64Var(&cfg.ReadRatio, "reads", 0, "fraction of operations that only read")
+	flag.IntVar(&cfg.Concurrency, "concurrency", 16, "how many goroutines generate load")
+	flag.DurationVar(&cfg.Duration, "duration", 0, "how long to run for (10s by default)")
+	flag.Int64Var(&cfg.Limit, "limit", 100, "limit of every key")
+	flag.DurationVar(&cfg.Window, "window", 0, "window of every key (1m by default)")
+	flag.Parse()
+
+	if zipf {
+		cfg.Distribution = redisbench.Zipf
+	}
+
+	client := goredis.NewClient(&goredis.Options{Addr: *addr})
+	defer client.Close()
+
+	provider, err := redis.New(redis.WithClient(client), redis.WithKeyPrefix(*prefix))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cfg.Redis = client
+	report, err := redisbench.Run(ctx, provider, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%d operations (%d errors) in %s: %.0f/s\n", report.Operations, report.Errors, report.Duration, report.Throughput)
+	fmt.Printf("reads:  p50 %s  p90 %s  p99 %s  max %s\n", report.Reads.P50, report.Reads.P90, report.Reads.P99, report.Reads.Max)
+	fmt.Printf("writes: p50 %s  p90 %s  p99 %s  max %s\n", report.Writes.P50, report.Writes.P90, report.Writes.P99, report.Writes.Max)
+	fmt.Printf("memory: %+d bytes\n", report.MemoryDelta)
+
+	commands := make([]string, 0, len(report.Commands))
+	for command := range report.Commands {
+		commands = append(commands, command)
+	}
+
+	sort.Strings(commands)
+	for _, command := range commands {
+		fmt.Printf("  %-16s %d\n", command, report.Commands[command])
+	}
+}
@@ -0,0 +1,349 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redisbench generates load against a redis.Provider and a live Redis
+// server, to measure what a configuration sustains before it's rolled out. The
+// Provider's key prefix has to start with SafetyPrefix, and everything under it
+// is deleted afterwards.
+package redisbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SafetyPrefix is what the key prefix of a benchmarked Provider has to start
+// with, so Run can never write to or clean up real ratelimits.
+const SafetyPrefix = "redisbench"
+
+// ErrUnsafePrefix is returned by Run when the Provider's key prefix doesn't
+// start with SafetyPrefix.
+var ErrUnsafePrefix = errors.New("key prefix doesn't start with " + SafetyPrefix)
+
+// Distribution is how the generated load is spread over the keys.
+type Distribution int
+
+const (
+	// Uniform picks every key equally often.
+	Uniform Distribution = iota
+
+	// Zipf picks a few keys most of the time, like real traffic with a handful
+	// of busy clients.
+	Zipf
+)
+
+// BenchConfig configures Run. Zero values are replaced by the defaults noted on
+// each field.
+type BenchConfig struct {
+	// Keys is how many distinct keys the load is spread over. It's 10000 by
+	// default.
+	Keys int
+
+	// Distribution is how the load is spread over the keys.
+	Distribution Distribution
+
+	// ReadRatio is the fraction of operations that only read with Peek; the
+	// rest count a request with Consume.
+	ReadRatio float64
+
+	// Concurrency is how many goroutines generate load. It's 16 by default.
+	Concurrency int
+
+	// Duration is how long the load is generated for. It's 10 seconds by
+	// default.
+	Duration time.Duration
+
+	// Limit and Window are what Consume is called with. They're 100 and a
+	// minute by default.
+	Limit  int64
+	Window time.Duration
+
+	// Redis is a client for the same server the Provider uses, for the
+	// server-side metrics and the cleanup. Without it, the report has no
+	// server-side metrics and only the ratelimit hash is cleaned up.
+	Redis goredis.Cmdable
+}
+
+// Latency holds latency percentiles of the operations.
+type Latency struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+// BenchReport is the result of Run.
+type BenchReport struct {
+	// Operations is how many operations ran, and Errors how many of them
+	// failed.
+	Operations int64
+	Errors     int64
+
+	// Duration is how long the load ran for.
+	Duration time.Duration
+
+	// Throughput is how many operations ran per second.
+	Throughput float64
+
+	// Reads and Writes are the latencies of Peek and Consume, measured
+	// client-side.
+	Reads  Latency
+	Writes Latency
+
+	// Commands is how many times Redis ran each command while the load ran,
+	// from INFO commandstats. It's nil without BenchConfig.Redis, and includes
+	// commands of other clients of the same server.
+	Commands map[string]int64
+
+	// MemoryDelta is how much used_memory grew while the load ran, before the
+	// cleanup.
+	MemoryDelta int64
+}
+
+// worker is what a single goroutine measured.
+type worker struct {
+	reads  []time.Duration
+	writes []time.Duration
+	errors int64
+}
+
+// Run generates load against p as configured by cfg until cfg.Duration is over
+// or ctx is done, then deletes everything under p's key prefix.
+func Run(ctx context.Context, p *redis.Provider, cfg BenchConfig) (*BenchReport, error) {
+	if !strings.HasPrefix(p.KeyPrefix(), SafetyPrefix) {
+		return nil, fmt.Errorf("%w: %q", ErrUnsafePrefix, p.KeyPrefix())
+	}
+
+	cfg = withDefaults(cfg)
+	before, err := serverStats(ctx, cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		workers = make([]*worker, cfg.Concurrency)
+		started = time.Now()
+	)
+
+	for i := range workers {
+		workers[i] = &worker{}
+		wg.Add(1)
+		go func(w *worker, seed int64) {
+			defer wg.Done()
+			w.run(runCtx, p, cfg, rand.New(rand.NewSource(seed)))
+		}(workers[i], started.UnixNano()+int64(i))
+	}
+
+	wg.Wait()
+	report := &BenchReport{Duration: time.Since(started)}
+
+	after, err := serverStats(ctx, cfg.Redis)
+	if err != nil {
+		return nil, err
+	}
+
+	var reads, writes []time.Duration
+	for _, w := range workers {
+		reads = append(reads, w.reads...)
+		writes = append(writes, w.writes...)
+		report.Errors += w.errors
+	}
+
+	report.Operations = int64(len(reads)+len(writes)) + report.Errors
+	report.Throughput = float64(report.Operations) / report.Duration.Seconds()
+	report.Reads = percentiles(reads)
+	report.Writes = percentiles(writes)
+	if before != nil {
+		report.Commands = map[string]int64{}
+		for command, calls := range after.commands {
+			if delta := calls - before.commands[command]; delta > 0 {
+				report.Commands[command] = delta
+			}
+		}
+
+		report.MemoryDelta = after.memory - before.memory
+	}
+
+	return report, cleanup(ctx, p, cfg.Redis)
+}
+
+func withDefaults(cfg BenchConfig) BenchConfig {
+	if cfg.Keys <= 0 {
+		cfg.Keys = 10000
+	}
+
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 16
+	}
+
+	if cfg.Duration <= 0 {
+		cfg.Duration = 10 * time.Second
+	}
+
+	if cfg.Limit <= 0 {
+		cfg.Limit = 100
+	}
+
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+
+	return cfg
+}
+
+func (w *worker) run(ctx context.Context, p *redis.Provider, cfg BenchConfig, random *rand.Rand) {
+	next := func() int { return random.Intn(cfg.Keys) }
+	if cfg.Distribution == Zipf && cfg.Keys > 1 {
+		zipf := rand.NewZipf(random, 1.1, 1, uint64(cfg.Keys-1))
+		next = func() int { return int(zipf.Uint64()) }
+	}
+
+	for ctx.Err() == nil {
+		key := "key-" + strconv.Itoa(next())
+		read := random.Float64() < cfg.ReadRatio
+
+		var err error
+		start := time.Now()
+		if read {
+			_, err = p.Peek(key)
+		} else {
+			_, err = p.Consume(key, cfg.Limit, cfg.Window)
+		}
+
+		took := time.Since(start)
+		switch {
+		case err != nil:
+			w.errors++
+		case read:
+			w.reads = append(w.reads, took)
+		default:
+			w.writes = append(w.writes, took)
+		}
+	}
+}
+
+func percentiles(latencies []time.Duration) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+
+	return Latency{P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: latencies[len(latencies)-1]}
+}
+
+// stats is what serverStats read from INFO.
+type stats struct {
+	commands map[string]int64
+	memory   int64
+}
+
+// serverStats reads the command counts and memory use of the server, or
+// returns nil without a client.
+func serverStats(ctx context.Context, client goredis.Cmdable) (*stats, error) {
+	if client == nil {
+		return nil, nil
+	}
+
+	info, err := client.Info(ctx, "commandstats", "memory").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &stats{commands: map[string]int64{}}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if value := strings.TrimPrefix(line, "used_memory:"); value != line {
+			result.memory, _ = strconv.ParseInt(value, 10, 64)
+			continue
+		}
+
+		// cmdstat_hget:calls=10,usec=25,usec_per_call=2.50,...
+		if !strings.HasPrefix(line, "cmdstat_") {
+			continue
+		}
+
+		name, fields, _ := strings.Cut(strings.TrimPrefix(line, "cmdstat_"), ":")
+
+		for _, field := range strings.Split(fields, ",") {
+			if calls := strings.TrimPrefix(field, "calls="); calls != field {
+				result.commands[name], _ = strconv.ParseInt(calls, 10, 64)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// cleanup deletes everything the benchmark wrote. Run already checked that
+// the prefix is only used for benchmarks.
+func cleanup(ctx context.Context, p *redis.Provider, client goredis.Cmdable) error {
+	if _, err := p.Admin().ResetAll(ctx, nil); err != nil {
+		return err
+	}
+
+	if client == nil {
+		return nil
+	}
+
+	// Everything besides the hash is named after the prefix in a hash tag,
+	// unless the prefix already has one.
+	prefix := redis.EscapeGlob(p.KeyPrefix())
+	for _, pattern := range []string{"{" + prefix + "}:*", prefix + ":*"} {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, pattern, 500).Result()
+			if err != nil {
+				return err
+			}
+
+			if len(keys) > 0 {
+				if err := client.Unlink(ctx, keys...).Err(); err != nil {
+					return err
+				}
+			}
+
+			if next == 0 {
+				break
+			}
+
+			cursor = next
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redisbench
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"testing"
+	"time"
+)
+
+func newTestProvider(t *testing.T, prefix string) (*redis.Provider, *goredis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	p, err := redis.New(redis.WithClient(client), redis.WithKeyPrefix(prefix))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = p.Close() })
+	return p, client, server
+}
+
+func TestRun(t *testing.T) {
+	for _, distribution := range []Distribution{Uniform, Zipf} {
+		p, _, server := newTestProvider(t, SafetyPrefix+"-smoke")
+		report, err := Run(context.Background(), p, BenchConfig{
+			Keys:         50,
+			Distribution: distribution,
+			ReadRatio:    0.5,
+			Concurrency:  4,
+			Duration:     100 * time.Millisecond,
+			Limit:        5,
+		})
+
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+
+		if report.Operations == 0 || report.Errors != 0 || report.Throughput <= 0 {
+			t.Fatalf("report = %+v, want operations without errors", report)
+		}
+
+		if report.Writes.P50 <= 0 || report.Writes.P50 > report.Writes.P99 || report.Writes.P99 > report.Writes.Max {
+			t.Fatalf("write latencies = %+v", report.Writes)
+		}
+
+		if report.Commands != nil {
+			t.Fatalf("Commands = %v without a Redis client", report.Commands)
+		}
+
+		if keys := server.Keys(); len(keys) != 0 {
+			t.Fatalf("keys left after Run: %v", keys)
+		}
+	}
+}
+
+func TestRunUnsafePrefix(t *testing.T) {
+	p, _, server := newTestProvider(t, "ratelimits")
+	if _, err := p.Consume("a", 10, time.Minute); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	_, err := Run(context.Background(), p, BenchConfig{Duration: time.Millisecond})
+	if !errors.Is(err, ErrUnsafePrefix) {
+		t.Fatalf("Run = %v, want ErrUnsafePrefix", err)
+	}
+
+	if keys := server.Keys(); len(keys) == 0 {
+		t.Fatal("Run cleaned up a prefix that isn't for benchmarks")
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	p, client, server := newTestProvider(t, SafetyPrefix+"-cleanup")
+	ctx := context.Background()
+
+	if _, err := p.Consume("a", 10, time.Minute); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	for _, key := range []string{"{redisbench-cleanup}:meta", "redisbench-cleanup:seen", "other"} {
+		if err := server.Set(key, "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cleanup(ctx, p, client); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+
+	if keys := server.Keys(); len(keys) != 1 || keys[0] != "other" {
+		t.Fatalf("keys after cleanup = %v, want only the one outside the prefix", keys)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	got := percentiles(latencies)
+	want := Latency{P50: 50 * time.Millisecond, P90: 90 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if got != want {
+		t.Fatalf("percentiles = %+v, want %+v", got, want)
+	}
+
+	if got := percentiles(nil); got != (Latency{}) {
+		t.Fatalf("percentiles of nothing = %+v", got)
+	}
+}
@@ -0,0 +1,149 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redistest
+
+import (
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+// ConformanceProvider is what RunConformance checks: the providers.Provider
+// surface, with Peek and Reset, that both a redis.Provider and a Recorder have.
+type ConformanceProvider interface {
+	providers.Provider
+	Peek(key string) (*types.Ratelimit, error)
+	Reset(key string) (bool, error)
+}
+
+// RunConformance checks that the Providers that newProvider returns behave
+// like a redis.Provider with the default options, each one in a subtest of its
+// own on an empty store. The Recorder is checked with it, so its semantics stay
+// the same as the real Provider's; other stand-ins can be checked with it too.
+func RunConformance(t *testing.T, newProvider func(t *testing.T) ConformanceProvider) {
+	resetAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	ratelimit := func(limit, remaining int32) *types.Ratelimit {
+		return &types.Ratelimit{Limit: limit, Remaining: remaining, ResetTime: resetAt}
+	}
+
+	check := func(t *testing.T, what string, got *types.Ratelimit, err error, want *types.Ratelimit) {
+		t.Helper()
+
+		switch {
+		case err != nil:
+			t.Fatalf("%s: %v", what, err)
+		case want == nil && got != nil:
+			t.Fatalf("%s = %+v, want nil", what, got)
+		case want != nil && (got == nil || got.Limit != want.Limit || got.Remaining != want.Remaining || !got.ResetTime.Equal(want.ResetTime)):
+			t.Fatalf("%s = %+v, want %+v", what, got, want)
+		}
+	}
+
+	t.Run("Name", func(t *testing.T) {
+		if name := newProvider(t).Name(); name == "" {
+			t.Fatal("Name is empty")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		p := newProvider(t)
+		rl, err := p.Get("missing")
+		check(t, "Get", rl, err, nil)
+
+		rl, err = p.Peek("missing")
+		check(t, "Peek", rl, err, nil)
+
+		if ok, err := p.Reset("missing"); ok || err != nil {
+			t.Fatalf("Reset = %t, %v; want false", ok, err)
+		}
+	})
+
+	t.Run("PutPeek", func(t *testing.T) {
+		p := newProvider(t)
+		if err := p.Put("k", ratelimit(10, 7)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		rl, err := p.Peek("k")
+		check(t, "Peek", rl, err, ratelimit(10, 7))
+
+		rl, err = p.Peek("k")
+		check(t, "Peek again", rl, err, ratelimit(10, 7))
+	})
+
+	t.Run("GetCounts", func(t *testing.T) {
+		p := newProvider(t)
+		if err := p.Put("k", ratelimit(10, 2)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		for _, remaining := range []int32{1, 0, 0} {
+			rl, err := p.Get("k")
+			check(t, "Get", rl, err, ratelimit(10, remaining))
+		}
+
+		rl, err := p.Peek("k")
+		check(t, "Peek after Get", rl, err, ratelimit(10, 0))
+	})
+
+	t.Run("PutClampsNegative", func(t *testing.T) {
+		p := newProvider(t)
+		if err := p.Put("k", ratelimit(10, -3)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		rl, err := p.Peek("k")
+		check(t, "Peek", rl, err, ratelimit(10, 0))
+	})
+
+	t.Run("PutCopies", func(t *testing.T) {
+		p := newProvider(t)
+		value := ratelimit(10, 5)
+		if err := p.Put("k", value); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		value.Remaining = 1
+		rl, err := p.Peek("k")
+		check(t, "Peek", rl, err, ratelimit(10, 5))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		p := newProvider(t)
+		for _, key := range []string{"a", "b"} {
+			if err := p.Put(key, ratelimit(10, 5)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+
+		if ok, err := p.Reset("a"); !ok || err != nil {
+			t.Fatalf("Reset = %t, %v; want true", ok, err)
+		}
+
+		rl, err := p.Peek("a")
+		check(t, "Peek after Reset", rl, err, nil)
+
+		rl, err = p.Peek("b")
+		check(t, "Peek of another key", rl, err, ratelimit(10, 5))
+	})
+}
@@ -0,0 +1,316 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redistest
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HarnessStep is a single step of a Harness workload: the virtual clock moves
+// forward by Advance, then every request of Requests is made at once, each
+// from its own goroutine.
+type HarnessStep struct {
+	Advance  time.Duration
+	Requests []HarnessRequest
+}
+
+// HarnessRequest is a request that one of the Harness's instances counts.
+type HarnessRequest struct {
+	// Instance is the index of the Provider that counts the request.
+	Instance int
+
+	// Key is the key the request is counted for.
+	Key string
+}
+
+// HarnessConfig configures RunHarness. Zero values are replaced by the defaults
+// noted on each field.
+type HarnessConfig struct {
+	// Instances is how many Providers share the miniredis server, like app
+	// instances sharing one Redis. It's 3 by default.
+	Instances int
+
+	// New creates each instance on the shared server's client, with the
+	// virtual clock. By default, it's redis.New with WithClient and WithClock,
+	// which counts requests with FixedWindow; the invariants only hold for
+	// algorithms with fixed windows.
+	New func(client *goredis.Client, now func() time.Time) (*redis.Provider, error)
+
+	// Limit and Window are what every request is counted with. They're 10 and
+	// a minute by default.
+	Limit  int64
+	Window time.Duration
+
+	// Epsilon is how far apart the reset times that different instances see
+	// for the same window can be. It's a millisecond by default, since the
+	// stored reset times are in milliseconds.
+	Epsilon time.Duration
+
+	// Script is the workload to run. Without it, a random one is generated
+	// from Seed.
+	Script []HarnessStep
+
+	// Seed seeds the random workload. The same seed always generates the same
+	// workload; with 0, a seed is picked from the time, and reported in
+	// HarnessReport.Seed so a failing run can be replayed.
+	Seed int64
+
+	// Steps, Keys and Burst shape the random workload: Steps steps, each with
+	// up to Burst requests for up to Keys keys. They're 200, 4 and 5 by
+	// default. Every step moves the clock by up to a tenth of Window.
+	Steps int
+	Keys  int
+	Burst int
+}
+
+// HarnessReport is what RunHarness saw.
+type HarnessReport struct {
+	// Seed is the seed the random workload was generated from, or 0 for a
+	// Script.
+	Seed int64
+
+	// Requests, Admitted and Errors are how many requests were made, how many
+	// of them were allowed and how many failed.
+	Requests int
+	Admitted int
+	Errors   int
+
+	// Windows is how many windows the keys went through.
+	Windows int
+
+	// Violations describes every time an invariant didn't hold. It's empty if
+	// the instances behaved like a single one.
+	Violations []string
+}
+
+// harnessWindow is what RunHarness knows about the current window of a key.
+type harnessWindow struct {
+	resetAt   time.Time
+	admitted  int64
+	remaining int64
+}
+
+// RunHarness runs a workload against cfg.Instances Providers sharing one
+// miniredis server and a virtual clock, and checks that together they behave
+// like a single Provider would:
+//
+//   - no window of a key admits more than the limit, across all instances
+//   - within a window, a key's remaining requests never go up
+//   - every instance sees the same reset time for a window, within Epsilon
+//
+// Requests of the same step are made concurrently, so windows that are
+// created twice or counts that get lost show up as violations. Errors are
+// counted but not violations. The error is only for a harness that couldn't
+// be set up.
+func RunHarness(cfg HarnessConfig) (*HarnessReport, error) {
+	cfg = harnessDefaults(cfg)
+	report := &HarnessReport{}
+	script := cfg.Script
+	if script == nil {
+		report.Seed = cfg.Seed
+		if report.Seed == 0 {
+			report.Seed = time.Now().UnixNano()
+		}
+
+		script = RandomWorkload(report.Seed, cfg)
+	}
+
+	server, err := miniredis.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	defer server.Close()
+
+	var (
+		mu  sync.Mutex
+		now = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	)
+
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+
+	server.SetTime(now)
+	instances := make([]*redis.Provider, cfg.Instances)
+	for i := range instances {
+		client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+		defer client.Close()
+
+		if instances[i], err = cfg.New(client, clock); err != nil {
+			return nil, fmt.Errorf("instance %d: %w", i, err)
+		}
+
+		defer instances[i].Close()
+	}
+
+	windows := map[string]*harnessWindow{}
+	for step, s := range script {
+		mu.Lock()
+		now = now.Add(s.Advance)
+		at := now
+		mu.Unlock()
+
+		server.SetTime(at)
+		server.FastForward(s.Advance)
+
+		decisions := make([]redis.Decision, len(s.Requests))
+		errs := make([]error, len(s.Requests))
+		var wg sync.WaitGroup
+		for i, req := range s.Requests {
+			if req.Instance < 0 || req.Instance >= len(instances) {
+				return nil, fmt.Errorf("step %d: there is no instance %d", step, req.Instance)
+			}
+
+			wg.Add(1)
+			go func(i int, req HarnessRequest) {
+				defer wg.Done()
+				decisions[i], errs[i] = instances[req.Instance].Consume(req.Key, cfg.Limit, cfg.Window)
+			}(i, req)
+		}
+
+		wg.Wait()
+		report.Requests += len(s.Requests)
+
+		// Requests in the same step are checked against the state before the
+		// step, since they ran at the same time.
+		before := map[string]harnessWindow{}
+		for key, w := range windows {
+			before[key] = *w
+		}
+
+		for i, req := range s.Requests {
+			if errs[i] != nil {
+				report.Errors++
+				continue
+			}
+
+			d := decisions[i]
+			if d.Allowed {
+				report.Admitted++
+			}
+
+			w := windows[req.Key]
+			if w == nil || !w.resetAt.After(at) {
+				w = &harnessWindow{resetAt: d.ResetAt, remaining: d.Remaining}
+				windows[req.Key] = w
+				before[req.Key] = harnessWindow{resetAt: d.ResetAt, remaining: cfg.Limit}
+				report.Windows++
+			}
+
+			violated := func(format string, args ...interface{}) {
+				prefix := fmt.Sprintf("step %d, instance %d, key %q: ", step, req.Instance, req.Key)
+				report.Violations = append(report.Violations, prefix+fmt.Sprintf(format, args...))
+			}
+
+			if drift := d.ResetAt.Sub(w.resetAt); drift > cfg.Epsilon || drift < -cfg.Epsilon {
+				violated("reset at %v, but the window resets at %v", d.ResetAt, w.resetAt)
+			}
+
+			if previous := before[req.Key]; d.Remaining > previous.remaining {
+				violated("%d remaining, up from %d", d.Remaining, previous.remaining)
+			}
+
+			if d.Remaining < w.remaining {
+				w.remaining = d.Remaining
+			}
+
+			if d.Allowed {
+				w.admitted++
+				if w.admitted == cfg.Limit+1 {
+					violated("more than %d requests admitted in the window that resets at %v", cfg.Limit, w.resetAt)
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// RandomWorkload returns the random workload that RunHarness runs for the given
+// seed and config.
+func RandomWorkload(seed int64, cfg HarnessConfig) []HarnessStep {
+	cfg = harnessDefaults(cfg)
+	random := rand.New(rand.NewSource(seed))
+	maxAdvance := int64(cfg.Window / 10)
+
+	script := make([]HarnessStep, cfg.Steps)
+	for i := range script {
+		script[i].Advance = time.Duration(random.Int63n(maxAdvance + 1))
+		script[i].Requests = make([]HarnessRequest, 1+random.Intn(cfg.Burst))
+		for j := range script[i].Requests {
+			script[i].Requests[j] = HarnessRequest{
+				Instance: random.Intn(cfg.Instances),
+				Key:      "key-" + strconv.Itoa(random.Intn(cfg.Keys)),
+			}
+		}
+	}
+
+	return script
+}
+
+func harnessDefaults(cfg HarnessConfig) HarnessConfig {
+	if cfg.Instances <= 0 {
+		cfg.Instances = 3
+	}
+
+	if cfg.New == nil {
+		cfg.New = func(client *goredis.Client, now func() time.Time) (*redis.Provider, error) {
+			return redis.New(redis.WithClient(client), redis.WithClock(now))
+		}
+	}
+
+	if cfg.Limit <= 0 {
+		cfg.Limit = 10
+	}
+
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+
+	if cfg.Epsilon <= 0 {
+		cfg.Epsilon = time.Millisecond
+	}
+
+	if cfg.Steps <= 0 {
+		cfg.Steps = 200
+	}
+
+	if cfg.Keys <= 0 {
+		cfg.Keys = 4
+	}
+
+	if cfg.Burst <= 0 {
+		cfg.Burst = 5
+	}
+
+	return cfg
+}
@@ -0,0 +1,108 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redistest
+
+import (
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHarnessFixedSeed(t *testing.T) {
+	report, err := RunHarness(HarnessConfig{Seed: 1})
+	if err != nil {
+		t.Fatalf("RunHarness: %v", err)
+	}
+
+	if len(report.Violations) > 0 || report.Errors > 0 {
+		t.Fatalf("RunHarness with seed 1 saw %d errors and violations:\n%s", report.Errors, strings.Join(report.Violations, "\n"))
+	}
+
+	if report.Admitted == 0 || report.Admitted == report.Requests || report.Windows <= 4 {
+		t.Fatalf("RunHarness with seed 1 = %+v, want some requests rejected over several windows", report)
+	}
+
+	// The same seed runs the same workload.
+	again, err := RunHarness(HarnessConfig{Seed: 1})
+	if err != nil || again.Requests != report.Requests || again.Admitted != report.Admitted || again.Windows != report.Windows {
+		t.Fatalf("RunHarness with seed 1 again = %+v, %v, want %+v", again, err, report)
+	}
+}
+
+func TestHarnessRandomized(t *testing.T) {
+	report, err := RunHarness(HarnessConfig{Steps: 50})
+	if err != nil {
+		t.Fatalf("RunHarness: %v", err)
+	}
+
+	if len(report.Violations) > 0 || report.Errors > 0 {
+		t.Fatalf("RunHarness with seed %d saw %d errors and violations:\n%s", report.Seed, report.Errors, strings.Join(report.Violations, "\n"))
+	}
+}
+
+func TestHarnessScript(t *testing.T) {
+	burst := make([]HarnessRequest, 12)
+	for i := range burst {
+		burst[i] = HarnessRequest{Instance: i % 3, Key: "k"}
+	}
+
+	script := []HarnessStep{
+		{Requests: burst},
+		{Advance: time.Minute, Requests: burst[:2]},
+	}
+
+	report, err := RunHarness(HarnessConfig{Script: script})
+	if err != nil {
+		t.Fatalf("RunHarness: %v", err)
+	}
+
+	if len(report.Violations) > 0 || report.Admitted != 12 || report.Windows != 2 {
+		t.Fatalf("RunHarness = %+v, want 10 and then 2 admitted requests in 2 windows", report)
+	}
+}
+
+func TestHarnessCatchesSplitState(t *testing.T) {
+	// Instances that don't share their ratelimits admit a limit each.
+	var instances atomic.Int64
+	split := func(client *goredis.Client, now func() time.Time) (*redis.Provider, error) {
+		prefix := "instance-" + strconv.FormatInt(instances.Add(1), 10)
+		return redis.New(redis.WithClient(client), redis.WithClock(now), redis.WithKeyPrefix(prefix))
+	}
+
+	burst := make([]HarnessRequest, 30)
+	for i := range burst {
+		burst[i] = HarnessRequest{Instance: i % 3, Key: "k"}
+	}
+
+	report, err := RunHarness(HarnessConfig{New: split, Script: []HarnessStep{{Requests: burst}}})
+	if err != nil {
+		t.Fatalf("RunHarness: %v", err)
+	}
+
+	if len(report.Violations) == 0 {
+		t.Fatalf("RunHarness with split state = %+v, want violations", report)
+	}
+}
@@ -0,0 +1,183 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redistest
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit-redis"
+	"github.com/noelware/chi-ratelimit/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("Recorder", func(t *testing.T) {
+		RunConformance(t, func(*testing.T) ConformanceProvider {
+			return NewRecorder()
+		})
+	})
+
+	t.Run("Provider", func(t *testing.T) {
+		RunConformance(t, func(t *testing.T) ConformanceProvider {
+			server := miniredis.RunT(t)
+			client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+			t.Cleanup(func() { _ = client.Close() })
+
+			provider, err := redis.New(redis.WithClient(client))
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			return provider
+		})
+	})
+}
+
+func TestRecorderName(t *testing.T) {
+	provider, err := redis.New(redis.WithClient(goredis.NewClient(&goredis.Options{Addr: miniredis.RunT(t).Addr()})))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	t.Cleanup(func() { _ = provider.Close() })
+	if got, want := NewRecorder().Name(), provider.Name(); got != want {
+		t.Fatalf("Name = %q, want %q like the Provider's", got, want)
+	}
+}
+
+func TestRecorderCalls(t *testing.T) {
+	r := NewRecorder()
+	before := time.Now()
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 2, ResetTime: before.Add(time.Minute)}
+	if err := r.Put("a", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	_, _ = r.Get("a")
+	_, _ = r.Get("b")
+	_, _ = r.Peek("a")
+	_, _ = r.Reset("b")
+
+	if calls := r.Calls("", ""); len(calls) != 5 {
+		t.Fatalf("Calls = %+v, want 5", calls)
+	}
+
+	gets := r.Calls("Get", "")
+	if len(gets) != 2 || gets[0].Key != "a" || gets[0].Value.Remaining != 1 || gets[1].Key != "b" || gets[1].Value != nil {
+		t.Fatalf("Calls of Get = %+v", gets)
+	}
+
+	puts := r.Calls("Put", "a")
+	if len(puts) != 1 || puts[0].Value.Remaining != 2 || puts[0].At.Before(before) {
+		t.Fatalf("Calls of Put for a = %+v", puts)
+	}
+
+	// The recorded value is a copy, not what Put was given.
+	rl.Remaining = 9
+	if puts := r.Calls("Put", "a"); puts[0].Value.Remaining != 2 {
+		t.Fatalf("the recorded Put changed to %+v", puts[0].Value)
+	}
+
+	if calls := r.Calls("", "b"); len(calls) != 2 || calls[0].Method != "Get" || calls[1].Method != "Reset" {
+		t.Fatalf("Calls for b = %+v", calls)
+	}
+}
+
+func TestRecorderLastValue(t *testing.T) {
+	r := NewRecorder()
+	if rl := r.LastValue("a"); rl != nil {
+		t.Fatalf("LastValue of a missing key = %+v", rl)
+	}
+
+	_ = r.Put("a", &types.Ratelimit{Limit: 10, Remaining: 5})
+	_, _ = r.Get("a")
+	if rl := r.LastValue("a"); rl == nil || rl.Remaining != 4 {
+		t.Fatalf("LastValue after Get = %+v, want 4 remaining", rl)
+	}
+
+	_, _ = r.Reset("a")
+	if rl := r.LastValue("a"); rl != nil {
+		t.Fatalf("LastValue after Reset = %+v", rl)
+	}
+}
+
+func TestRecorderFailWith(t *testing.T) {
+	r := NewRecorder()
+	_ = r.Put("a", &types.Ratelimit{Limit: 10, Remaining: 5})
+
+	failure := errors.New("down")
+	for _, method := range []string{"Get", "Peek", "Put", "Reset"} {
+		r.FailWith(method, failure)
+	}
+
+	if _, err := r.Get("a"); !errors.Is(err, failure) {
+		t.Fatalf("Get = %v", err)
+	}
+
+	if _, err := r.Peek("a"); !errors.Is(err, failure) {
+		t.Fatalf("Peek = %v", err)
+	}
+
+	if err := r.Put("a", &types.Ratelimit{Limit: 1}); !errors.Is(err, failure) {
+		t.Fatalf("Put = %v", err)
+	}
+
+	if _, err := r.Reset("a"); !errors.Is(err, failure) {
+		t.Fatalf("Reset = %v", err)
+	}
+
+	// Failed calls are recorded with their error, and leave the store alone.
+	if calls := r.Calls("Get", "a"); len(calls) != 1 || !errors.Is(calls[0].Err, failure) {
+		t.Fatalf("Calls of Get = %+v", calls)
+	}
+
+	for _, method := range []string{"Get", "Peek", "Put", "Reset"} {
+		r.FailWith(method, nil)
+	}
+
+	if rl, err := r.Peek("a"); err != nil || rl == nil || rl.Remaining != 5 {
+		t.Fatalf("Peek once the failures are gone = %+v, %v", rl, err)
+	}
+}
+
+func TestRecorderConcurrent(t *testing.T) {
+	r := NewRecorder()
+	_ = r.Put("a", &types.Ratelimit{Limit: 100, Remaining: 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Get("a")
+		}()
+	}
+
+	wg.Wait()
+	if rl := r.LastValue("a"); rl.Remaining != 50 || len(r.Calls("Get", "a")) != 50 {
+		t.Fatalf("50 concurrent Gets left %+v with %d calls", rl, len(r.Calls("Get", "a")))
+	}
+}
@@ -0,0 +1,222 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package redistest has a Recorder, which stands in for a redis.Provider in
+// the tests of code that uses one through chi-ratelimit, without Redis or
+// miniredis. It keeps ratelimits in a map and records every call to it.
+// RunConformance checks that it behaves like the real Provider.
+//
+// RunHarness is for the Provider's own behavior with many instances instead:
+// it runs a workload against several Providers that share one miniredis
+// server and checks that they count like a single one.
+package redistest
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"sync"
+	"time"
+)
+
+// Call is a single call that a Recorder recorded.
+type Call struct {
+	// Method is the name of the method that was called, like "Get".
+	Method string
+
+	// Key is the key that the method was called with.
+	Key string
+
+	// Value is the ratelimit that was given to Put or returned by Get or Peek,
+	// or nil if there was none.
+	Value *types.Ratelimit
+
+	// Err is the error that the call returned, if any.
+	Err error
+
+	// At is when the call happened.
+	At time.Time
+}
+
+// Recorder is a providers.Provider that keeps ratelimits in a map, the way a
+// redis.Provider with the default options keeps them in Redis: Get takes a
+// request off the stored ratelimit and returns it, Put clamps negative
+// remaining requests to zero, and Reset deletes the ratelimit. Every call is
+// recorded, and FailWith makes a method fail. It's safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	data   map[string]types.Ratelimit
+	calls  []Call
+	errors map[string]error
+}
+
+var _ providers.Provider = (*Recorder)(nil)
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		data:   map[string]types.Ratelimit{},
+		errors: map[string]error{},
+	}
+}
+
+// Name returns the name of a redis.Provider, so code that checks it sees the
+// same.
+func (*Recorder) Name() string {
+	return "redis provider"
+}
+
+// Get returns the ratelimit stored for the given key with one request taken
+// off, which is stored as well, or nil if there is none.
+func (r *Recorder) Get(key string) (*types.Ratelimit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.errors["Get"]; err != nil {
+		return nil, r.record("Get", key, nil, err)
+	}
+
+	stored, ok := r.data[key]
+	if !ok {
+		return nil, r.record("Get", key, nil, nil)
+	}
+
+	if stored.Remaining > 0 {
+		stored.Remaining--
+	}
+
+	r.data[key] = stored
+	return copyOf(stored), r.record("Get", key, copyOf(stored), nil)
+}
+
+// Peek returns the ratelimit stored for the given key, or nil if there is none.
+// Unlike Get, it doesn't count as a request.
+func (r *Recorder) Peek(key string) (*types.Ratelimit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.errors["Peek"]; err != nil {
+		return nil, r.record("Peek", key, nil, err)
+	}
+
+	stored, ok := r.data[key]
+	if !ok {
+		return nil, r.record("Peek", key, nil, nil)
+	}
+
+	return copyOf(stored), r.record("Peek", key, copyOf(stored), nil)
+}
+
+// Put stores a copy of the given ratelimit for the key.
+func (r *Recorder) Put(key string, value *types.Ratelimit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var recorded *types.Ratelimit
+	if value != nil {
+		recorded = copyOf(*value)
+	}
+
+	if err := r.errors["Put"]; err != nil {
+		return r.record("Put", key, recorded, err)
+	}
+
+	if value == nil {
+		return r.record("Put", key, nil, errors.New("can't put a nil ratelimit"))
+	}
+
+	stored := *value
+	if stored.Remaining < 0 {
+		stored.Remaining = 0
+	}
+
+	r.data[key] = stored
+	return r.record("Put", key, recorded, nil)
+}
+
+// Reset deletes the ratelimit of the given key, returning false if there was
+// none.
+func (r *Recorder) Reset(key string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.errors["Reset"]; err != nil {
+		return false, r.record("Reset", key, nil, err)
+	}
+
+	_, ok := r.data[key]
+	delete(r.data, key)
+	return ok, r.record("Reset", key, nil, nil)
+}
+
+// FailWith makes every call of the given method ("Get", "Peek", "Put" or
+// "Reset") fail with err, without touching what is stored, until it's called
+// again with a nil error.
+func (r *Recorder) FailWith(method string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		delete(r.errors, method)
+		return
+	}
+
+	r.errors[method] = err
+}
+
+// Calls returns the recorded calls of the given method with the given key,
+// oldest first. An empty method or key matches every one.
+func (r *Recorder) Calls(method, key string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []Call
+	for _, call := range r.calls {
+		if (method == "" || call.Method == method) && (key == "" || call.Key == key) {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// LastValue returns the ratelimit that was stored last for the given key, by a
+// Put or a Get, or nil if there is none or it was reset.
+func (r *Recorder) LastValue(key string) *types.Ratelimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.data[key]; ok {
+		return copyOf(stored)
+	}
+
+	return nil
+}
+
+// record records a call and returns its error.
+func (r *Recorder) record(method, key string, value *types.Ratelimit, err error) error {
+	r.calls = append(r.calls, Call{Method: method, Key: key, Value: value, Err: err, At: time.Now()})
+	return err
+}
+
+func copyOf(rl types.Ratelimit) *types.Ratelimit {
+	return &rl
+}
@@ -0,0 +1,110 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// renamePrefixScript renames the hash and everything that shares its fields
+// from one prefix to another in one step. It returns -1 without changing
+// anything if the new hash already exists and ARGV[1] isn't '1'.
+//
+// KEYS = pairs of old and new keys, the hash first
+// ARGV[1] = '1' to overwrite whatever is stored under the new prefix
+var renamePrefixScript = registerScript("rename_prefix", `
+if ARGV[1] ~= '1' and redis.call('EXISTS', KEYS[2]) == 1 then
+	return -1
+end
+
+local renamed = 0
+for i = 1, #KEYS, 2 do
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		redis.call('RENAME', KEYS[i], KEYS[i + 1])
+		renamed = renamed + 1
+	else
+		redis.call('DEL', KEYS[i + 1])
+	end
+end
+
+return renamed
+`)
+
+// WithForceRename makes RenamePrefix overwrite what is already stored under the
+// new prefix instead of failing with ErrPrefixExists.
+func WithForceRename() func(o *options) {
+	return func(o *options) {
+		o.forceRename = true
+	}
+}
+
+// RenamePrefix moves every ratelimit, its metadata, the reset index, the
+// state loss marker and the schema entry from the current key prefix to the given one in one step,
+// and makes the Provider use the new prefix from then on. Windows carry on
+// where they were. It fails with ErrPrefixExists if something is already
+// stored under the new prefix, unless WithForceRename was given.
+//
+// Tombstones and links stay under the old prefix until they expire. In a
+// cluster, both prefixes have to hash to the same slot, e.g. by sharing a hash
+// tag, since the keys are renamed by a single script. Windowed keys can't be
+// renamed.
+func (a *AdminClient) RenamePrefix(ctx context.Context, newPrefix string) (err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "rename_prefix", "")
+	ctx = p.maintenanceContext(ctx)
+
+	if p.keyWindow > 0 {
+		return errors.New("RenamePrefix doesn't support WithWindowedKeys")
+	}
+
+	from, to := p.space.Load(), newKeyspace(newPrefix)
+	if from.prefix == to.prefix {
+		return nil
+	}
+
+	keys := []string{
+		from.prefix, to.prefix,
+		from.meta, to.meta,
+		from.resetIndex, to.resetIndex,
+		from.marker, to.marker,
+		from.schema, to.schema,
+	}
+
+	force := "0"
+	if p.forceRename {
+		force = "1"
+	}
+
+	renamed, err := p.runScript(ctx, renamePrefixScript, keys, force).Int()
+	if err != nil {
+		return err
+	}
+
+	if renamed < 0 {
+		return fmt.Errorf("%w: %q", ErrPrefixExists, newPrefix)
+	}
+
+	p.space.Store(to)
+	return nil
+}
@@ -0,0 +1,208 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// RepairPolicy is what RepairInconsistent does with an inconsistent ratelimit.
+type RepairPolicy int
+
+const (
+	// RepairClamp clamps the inconsistent values back into range.
+	RepairClamp RepairPolicy = iota
+
+	// RepairDelete deletes the inconsistent ratelimit.
+	RepairDelete
+)
+
+// repairScript replaces (or deletes, when ARGV[3] is empty) a field only if it
+// still holds the value that was inspected, so a repair never overwrites a
+// Put that happened in the meantime.
+//
+// KEYS[1] = hash, KEYS[2] = metadata hash, KEYS[3] = reset index (optional)
+// ARGV[1] = field, ARGV[2] = inspected value, ARGV[3] = new value,
+// ARGV[4] = new reset time in Unix milliseconds
+var repairScript = registerScript("repair", `
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+
+if ARGV[3] == '' then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	if KEYS[3] then
+		redis.call('ZREM', KEYS[3], ARGV[1])
+	end
+else
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+	if KEYS[3] and ARGV[4] ~= '' then
+		redis.call('ZADD', KEYS[3], ARGV[4], ARGV[1])
+	end
+end
+
+return 1
+`)
+
+// RepairReport is the result of AdminClient.RepairInconsistent.
+type RepairReport struct {
+	// Scanned is how many ratelimits were looked at.
+	Scanned int64
+
+	// Clamped lists the keys whose values were clamped.
+	Clamped []string
+
+	// Deleted lists the keys that were deleted.
+	Deleted []string
+
+	// Undecodable lists the keys whose values couldn't be decoded at all.
+	// They're left alone.
+	Undecodable []string
+}
+
+// WithRepairPolicy sets what RepairInconsistent does with the inconsistent
+// ratelimits it finds. It's RepairClamp by default.
+func WithRepairPolicy(policy RepairPolicy) func(o *options) {
+	return func(o *options) {
+		o.repairPolicy = policy
+	}
+}
+
+// WithRepairHorizon makes RepairInconsistent also treat ratelimits that reset
+// more than the given duration in the future as inconsistent. Clamping moves
+// their reset time to the horizon. Zero, the default, disables this check.
+func WithRepairHorizon(horizon time.Duration) func(o *options) {
+	return func(o *options) {
+		o.repairHorizon = horizon
+	}
+}
+
+// Validate returns an error that wraps ErrInconsistent if the given ratelimit
+// has negative values or more requests remaining than its limit.
+func Validate(rl *types.Ratelimit) error {
+	switch {
+	case rl == nil:
+		return ErrEmptyValue
+
+	case rl.Limit < 0:
+		return fmt.Errorf("%w: negative limit %d", ErrInconsistent, rl.Limit)
+
+	case rl.Remaining < 0:
+		return fmt.Errorf("%w: negative remaining %d", ErrInconsistent, rl.Remaining)
+
+	case rl.Remaining > rl.Limit:
+		return fmt.Errorf("%w: remaining %d is over the limit of %d", ErrInconsistent, rl.Remaining, rl.Limit)
+
+	default:
+		return nil
+	}
+}
+
+// RepairInconsistent scans every ratelimit under the configured prefix and
+// clamps or deletes (see WithRepairPolicy) the ones that fail Validate or reset
+// past the horizon set with WithRepairHorizon. The scan happens in batches and
+// stops between them when ctx is cancelled, returning what was repaired so far.
+func (a *AdminClient) RepairInconsistent(ctx context.Context) (report *RepairReport, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "repair_inconsistent", "")
+	ctx = p.maintenanceContext(ctx)
+
+	report = &RepairReport{}
+	err = p.scan(ctx, "RepairInconsistent", "", 100, func(fields, values []string) error {
+		for i := range fields {
+			report.Scanned++
+			if err := p.repair(ctx, report, fields[i], values[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+func (p *Provider) repair(ctx context.Context, report *RepairReport, key, data string) error {
+	rl, err := p.decode(data)
+	if err != nil {
+		report.Undecodable = append(report.Undecodable, key)
+		return nil
+	}
+
+	horizon := time.Time{}
+	if p.repairHorizon > 0 {
+		horizon = p.now().Add(p.repairHorizon)
+	}
+
+	tooFar := !horizon.IsZero() && rl.ResetTime.After(horizon)
+	if Validate(rl) == nil && !tooFar {
+		return nil
+	}
+
+	replacement, resetAt := "", rl.ResetTime
+	if p.repairPolicy == RepairClamp {
+		clamped := *rl
+		if clamped.Limit < 0 {
+			clamped.Limit = 0
+		}
+
+		if clamped.Remaining < 0 {
+			clamped.Remaining = 0
+		}
+
+		if clamped.Remaining > clamped.Limit {
+			clamped.Remaining = clamped.Limit
+		}
+
+		if tooFar {
+			clamped.ResetTime = horizon
+		}
+
+		encoded, err := p.encode(&clamped)
+		if err != nil {
+			return err
+		}
+
+		replacement, resetAt = string(encoded), clamped.ResetTime
+	}
+
+	keys := p.entryKeys(p.hashKey())
+
+	changed, err := p.runScript(ctx, repairScript, keys, key, data, replacement, indexScore(resetAt)).Int()
+	if err != nil || changed == 0 {
+		return err
+	}
+
+	p.forgetLocal(key)
+
+	if replacement == "" {
+		report.Deleted = append(report.Deleted, key)
+	} else {
+		report.Clamped = append(report.Clamped, key)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplayOption configures Replay.
+type ReplayOption func(o *replayOptions)
+
+type replayOptions struct {
+	limit func(record DecisionRecord) int64
+}
+
+// WithReplayLimit makes Replay consume with the limit that fn returns for a
+// record instead of the recorded one, to check how a different limit would
+// have decided.
+func WithReplayLimit(fn func(record DecisionRecord) int64) ReplayOption {
+	return func(o *replayOptions) {
+		o.limit = fn
+	}
+}
+
+// ReplayDivergence is a record that Replay decided differently.
+type ReplayDivergence struct {
+	// Index is the position of the record in what was given to Replay.
+	Index    int
+	Record   DecisionRecord
+	Decision Decision
+}
+
+// ReplayKeyStats is how a single key fared in Replay.
+type ReplayKeyStats struct {
+	Replayed int
+	Diverged int
+
+	// FirstDivergence is the index of the key's first divergent record, or -1
+	// if there is none.
+	FirstDivergence int
+}
+
+// ReplayReport is the result of Replay.
+type ReplayReport struct {
+	// Replayed is how many records were replayed.
+	Replayed int
+
+	// Divergences lists every consumed request that was decided differently
+	// than recorded, in order.
+	Divergences []ReplayDivergence
+
+	// Keys has the stats of every key that was replayed, by stored key.
+	Keys map[string]ReplayKeyStats
+}
+
+// OK returns true if every record was decided the same as it was recorded.
+func (r *ReplayReport) OK() bool {
+	return len(r.Divergences) == 0
+}
+
+// Replay issues the Consumes and Resets of records (as emitted by
+// WithDecisionLog) against p again, in order, with p's clock set to the time of
+// each record, so scripts are sent the same times they originally were. Every
+// consumed request is compared with what was recorded, and the ones that came
+// out differently are reported. Consumes use ConsumeSliding or ConsumeApprox
+// if the recorded script is theirs, and Consume with p's Algorithm otherwise.
+//
+// It's meant for reproducing an incident against a fresh Redis, like
+// miniredis: records only replay faithfully if all of a key's requests were
+// logged, with a sample rate of 1 or ForceLog, and p shouldn't be used for
+// anything else while Replay runs. Errors stop the replay and are returned
+// with the report so far.
+func Replay(ctx context.Context, p *Provider, records []DecisionRecord, opts ...ReplayOption) (*ReplayReport, error) {
+	config := &replayOptions{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	clock := p.now
+	defer func() { p.now = clock }()
+
+	report := &ReplayReport{Keys: map[string]ReplayKeyStats{}}
+	for i, record := range records {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		at := record.Time
+		p.now = func() time.Time { return at }
+
+		stats, ok := report.Keys[record.Key]
+		if !ok {
+			stats.FirstDivergence = -1
+		}
+
+		diverged, decision, err := p.replayRecord(record, config)
+		if err != nil {
+			return report, fmt.Errorf("replaying record %d: %w", i, err)
+		}
+
+		report.Replayed++
+		stats.Replayed++
+		if diverged {
+			report.Divergences = append(report.Divergences, ReplayDivergence{Index: i, Record: record, Decision: decision})
+			stats.Diverged++
+			if stats.FirstDivergence < 0 {
+				stats.FirstDivergence = i
+			}
+		}
+
+		report.Keys[record.Key] = stats
+	}
+
+	return report, nil
+}
+
+// replayRecord issues a single record again, returning whether it was decided
+// differently.
+func (p *Provider) replayRecord(record DecisionRecord, config *replayOptions) (bool, Decision, error) {
+	if record.Reset {
+		_, err := p.Reset(record.Key)
+		return false, Decision{}, err
+	}
+
+	limit := record.Limit
+	if config.limit != nil {
+		limit = config.limit(record)
+	}
+
+	var (
+		decision Decision
+		err      error
+	)
+
+	switch record.Script {
+	case slidingConsumeScript.Hash():
+		decision, err = p.ConsumeSliding(record.Key, limit, record.Window)
+	case approxConsumeScript.Hash():
+		decision, err = p.ConsumeApprox(record.Key, limit, record.Window)
+	default:
+		decision, err = p.Consume(record.Key, limit, record.Window)
+	}
+
+	if err != nil {
+		return false, Decision{}, err
+	}
+
+	same := decision.Allowed == record.Allowed &&
+		decision.Limit == record.Limit &&
+		decision.Remaining == record.RemainingAfter &&
+		decision.ResetAt.Equal(record.ResetAt)
+
+	return !same, decision, nil
+}
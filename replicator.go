@@ -0,0 +1,254 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicatorMaxPending is how many changed keys a Replicator holds at most
+// before it drops new ones until the next flush.
+const replicatorMaxPending = 100000
+
+// replicatedField is where a change happened.
+type replicatedField struct {
+	hash string
+	key  string
+}
+
+// replicatedChange is the latest change of a field that wasn't applied yet.
+type replicatedChange struct {
+	data    string
+	deleted bool
+	at      time.Time
+}
+
+// Replicator copies the ratelimits that its Provider writes and resets to
+// another Redis, see StartReplicator.
+type Replicator struct {
+	provider *Provider
+	target   redis.Cmdable
+	lag      time.Duration
+	done     chan struct{}
+
+	mu      sync.Mutex
+	pending map[replicatedField]replicatedChange
+
+	applied atomic.Uint64
+	dropped atomic.Uint64
+	lastLag atomic.Int64
+}
+
+// ReplicatorStats is a snapshot of how a Replicator is doing.
+type ReplicatorStats struct {
+	// Pending is how many changed keys are waiting to be applied.
+	Pending int
+
+	// Applied is how many changes were applied to the target so far.
+	Applied uint64
+
+	// Dropped is how many changes were lost because too many were pending or
+	// the target couldn't be written to.
+	Dropped uint64
+
+	// Lag is how long the oldest change of the last flush waited before it was
+	// applied.
+	Lag time.Duration
+}
+
+// StartReplicator starts copying every ratelimit that this Provider writes or
+// resets to the same hash on target, like a standby Redis in another region,
+// until ctx is done or the Provider is shut down. Changes are collected in
+// memory and applied in one pipeline every lag, where only the latest change of
+// each key is sent, so the target is at most about lag behind. Whatever is
+// written last wins on the target.
+//
+// Replication is best-effort: only this Provider's own writes are seen,
+// changes that can't be applied are dropped and passed to the error handler,
+// and field and window TTLs aren't copied, which is harmless since a copied
+// ratelimit whose window is over is treated like a missing one. Changes made
+// by scripts that delete many keys at once, like ResetCascade, aren't copied
+// either. Only one Replicator runs per Provider; calling it again while one
+// runs returns the running one. lag, which is also how often changes are
+// flushed, has to be positive.
+func (p *Provider) StartReplicator(ctx context.Context, target redis.Cmdable, lag time.Duration) (*Replicator, error) {
+	if target == nil {
+		return nil, errors.New("StartReplicator needs a target")
+	}
+
+	if lag <= 0 {
+		return nil, fmt.Errorf("StartReplicator needs a positive lag, not %v", lag)
+	}
+
+	r := &Replicator{
+		provider: p,
+		target:   target,
+		lag:      lag,
+		done:     make(chan struct{}),
+		pending:  map[replicatedField]replicatedChange{},
+	}
+
+	if !p.replicator.CompareAndSwap(nil, r) {
+		return p.replicator.Load(), nil
+	}
+
+	if !p.goBackground(func() { r.run(ctx) }) {
+		p.replicator.CompareAndSwap(r, nil)
+		close(r.done)
+	}
+
+	return r, nil
+}
+
+// Done is closed once the Replicator stopped and applied what it could.
+func (r *Replicator) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stats returns a snapshot of how the Replicator is doing.
+func (r *Replicator) Stats() ReplicatorStats {
+	r.mu.Lock()
+	pending := len(r.pending)
+	r.mu.Unlock()
+
+	return ReplicatorStats{
+		Pending: pending,
+		Applied: r.applied.Load(),
+		Dropped: r.dropped.Load(),
+		Lag:     time.Duration(r.lastLag.Load()),
+	}
+}
+
+// recordChange hands a change to the local caches and the running Replicator,
+// if there is one. data is ignored for deletes.
+func (p *Provider) recordChange(hash, key, data string, deleted bool) {
+	p.cacheChange(hash, key, data, deleted)
+
+	r := p.replicator.Load()
+	if r == nil {
+		return
+	}
+
+	field := replicatedField{hash: hash, key: key}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, ok := r.pending[field]
+	if !ok && len(r.pending) >= replicatorMaxPending {
+		r.dropped.Add(1)
+		return
+	}
+
+	change := replicatedChange{data: data, deleted: deleted, at: p.now()}
+	if ok {
+		// The first change is what the lag is measured from.
+		change.at = previous.at
+	}
+
+	r.pending[field] = change
+}
+
+func (r *Replicator) run(ctx context.Context) {
+	defer close(r.done)
+	defer r.provider.replicator.CompareAndSwap(r, nil)
+
+	ticker := time.NewTicker(r.lag)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.finish()
+			return
+
+		case <-r.provider.stop:
+			r.finish()
+			return
+
+		case <-ticker.C:
+			r.flushWithin(r.lag)
+		}
+	}
+}
+
+// finish applies what's still pending before the Replicator stops.
+func (r *Replicator) finish() {
+	timeout := r.provider.writeTimeout
+	if timeout <= 0 {
+		timeout = r.lag
+	}
+
+	r.flushWithin(timeout)
+}
+
+func (r *Replicator) flushWithin(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := r.flush(ctx); err != nil {
+		r.provider.reportError("replicate", err)
+	}
+}
+
+// flush applies every pending change to the target in one pipeline.
+func (r *Replicator) flush(ctx context.Context) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = map[replicatedField]replicatedChange{}
+	r.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var oldest time.Time
+	_, err := r.target.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for field, change := range pending {
+			if oldest.IsZero() || change.at.Before(oldest) {
+				oldest = change.at
+			}
+
+			if change.deleted {
+				pipe.HDel(ctx, field.hash, field.key)
+			} else {
+				pipe.HSet(ctx, field.hash, field.key, change.data)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		r.dropped.Add(uint64(len(pending)))
+		return err
+	}
+
+	r.applied.Add(uint64(len(pending)))
+	r.lastLag.Store(int64(elapsed(r.provider.now(), oldest)))
+	return nil
+}
@@ -0,0 +1,46 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"testing"
+	"time"
+)
+
+func TestStartReplicatorValidation(t *testing.T) {
+	p, _ := newTestProvider(t)
+	target := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer target.Close()
+
+	for _, lag := range []time.Duration{0, -time.Second} {
+		if r, err := p.StartReplicator(context.Background(), target, lag); r != nil || err == nil {
+			t.Fatalf("StartReplicator with a lag of %v = %v, %v", lag, r, err)
+		}
+	}
+
+	if r, err := p.StartReplicator(context.Background(), nil, time.Second); r != nil || err == nil {
+		t.Fatalf("StartReplicator without a target = %v, %v", r, err)
+	}
+}
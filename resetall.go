@@ -0,0 +1,108 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "context"
+
+// resetAllBatchSize is how many fields ResetAll deletes at once when it can't
+// use UNLINK, unless WithScanBatchSize says otherwise.
+const resetAllBatchSize = 500
+
+// ResetAll deletes every ratelimit under the configured prefix and returns how
+// many were deleted. Tombstones aren't kept for these.
+//
+// The hash is deleted with UNLINK so Redis frees it in the background instead
+// of blocking on a huge DEL. On servers without UNLINK, the fields are deleted
+// in batches with HSCAN and HDEL instead, calling progress (if it's not nil)
+// with the running total after each batch. If ctx is cancelled midway, the
+// batches that were already deleted stay deleted and the rest stay as they
+// were.
+func (a *AdminClient) ResetAll(ctx context.Context, progress func(deleted int64)) (deleted int64, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "reset_all", "")
+	ctx = p.maintenanceContext(ctx)
+	defer p.clearLocal()
+
+	if !p.noUnlink.Load() {
+		deleted, err = p.unlinkAll(ctx)
+		if err == nil {
+			if progress != nil {
+				progress(deleted)
+			}
+
+			return deleted, nil
+		}
+
+		if !hasErrorPrefix(err, "ERR unknown command") {
+			return 0, err
+		}
+
+		p.noUnlink.Store(true)
+	}
+
+	err = p.scan(ctx, "ResetAll", "", resetAllBatchSize, func(fields, _ []string) error {
+		count, err := p.deleteFields(ctx, fields...)
+		if err != nil {
+			return err
+		}
+
+		deleted += count
+		if progress != nil {
+			progress(deleted)
+		}
+
+		return nil
+	})
+
+	return deleted, err
+}
+
+// unlinkAll unlinks the whole hash and the companions of its ratelimits. The
+// count comes from HLEN right before, so it can be off by whatever was written
+// in between.
+func (p *Provider) unlinkAll(ctx context.Context) (int64, error) {
+	length, err := p.cmd(ctx).HLen(ctx, p.hashKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	keys := []string{p.hashKey()}
+	for _, c := range p.companions {
+		if c.typ != companionKeyed {
+			keys = append(keys, c.key())
+		}
+	}
+
+	if p.tenants != nil {
+		keys = append(keys, p.tenantsKey())
+	}
+
+	if p.firstSeenTracking {
+		keys = append(keys, p.seenKey())
+	}
+
+	if err := p.cmd(ctx).Unlink(ctx, keys...).Err(); err != nil {
+		return 0, err
+	}
+
+	return length, p.unlinkCompanionKeys(ctx)
+}
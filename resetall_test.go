@@ -0,0 +1,75 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestResetAll(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a", "b")
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	if err := p.PutWithMeta("c", rl, map[string]string{"tier": "free"}); err != nil {
+		t.Fatalf("PutWithMeta: %v", err)
+	}
+
+	var progress []int64
+	deleted, err := p.Admin().ResetAll(context.Background(), func(deleted int64) { progress = append(progress, deleted) })
+	if err != nil || deleted != 3 {
+		t.Fatalf("ResetAll = %d, %v", deleted, err)
+	}
+
+	if len(progress) != 1 || progress[0] != 3 {
+		t.Fatalf("ResetAll reported progress %v", progress)
+	}
+
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Fatalf("ResetAll left %v behind", keys)
+	}
+}
+
+func TestResetAllWithoutUnlink(t *testing.T) {
+	p, server := newTestProvider(t)
+	putAll(t, p, "a", "b", "c", "d", "e")
+
+	// Servers without UNLINK get their fields deleted in batches, which
+	// miniredis always returns all at once.
+	p.noUnlink.Store(true)
+
+	var progress []int64
+	deleted, err := p.Admin().ResetAll(context.Background(), func(deleted int64) { progress = append(progress, deleted) })
+	if err != nil || deleted != 5 {
+		t.Fatalf("ResetAll = %d, %v", deleted, err)
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1] != 5 {
+		t.Fatalf("ResetAll reported progress %v", progress)
+	}
+
+	if server.Exists(p.hashKey()) {
+		t.Fatal("ResetAll left fields behind")
+	}
+}
@@ -0,0 +1,149 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+// ResetReason says why a ratelimit was reset. Custom reasons have to be
+// registered with RegisterResetReason before they're used.
+type ResetReason string
+
+const (
+	// ReasonUnspecified is what a plain Reset records.
+	ReasonUnspecified ResetReason = "unspecified"
+
+	// ReasonManual is a reset that someone asked for, like support staff.
+	ReasonManual ResetReason = "manual"
+
+	// ReasonAbuse is a reset decided by automated abuse handling.
+	ReasonAbuse ResetReason = "abuse"
+
+	// ReasonMigration is a reset done while moving data around.
+	ReasonMigration ResetReason = "migration"
+)
+
+var (
+	resetReasonsMu sync.RWMutex
+	resetReasons   = map[ResetReason]struct{}{
+		ReasonUnspecified: {},
+		ReasonManual:      {},
+		ReasonAbuse:       {},
+		ReasonMigration:   {},
+	}
+)
+
+// RegisterResetReason makes a custom reason usable with ResetWithReason and
+// returns it. Registering a reason twice does nothing.
+func RegisterResetReason(name string) ResetReason {
+	resetReasonsMu.Lock()
+	defer resetReasonsMu.Unlock()
+
+	reason := ResetReason(name)
+	resetReasons[reason] = struct{}{}
+
+	return reason
+}
+
+func isResetReason(reason ResetReason) bool {
+	resetReasonsMu.RLock()
+	defer resetReasonsMu.RUnlock()
+
+	_, ok := resetReasons[reason]
+	return ok
+}
+
+// resetRecord is what is stored next to a tombstone.
+type resetRecord struct {
+	Reason ResetReason `json:"reason"`
+	Note   string      `json:"note,omitempty"`
+}
+
+// withResetReason is the CallOption that ResetWithReason passes on.
+func withResetReason(reason ResetReason, note string) CallOption {
+	return func(c *callOptions) {
+		c.reason, c.note = reason, note
+	}
+}
+
+// ResetWithReason is Reset that records why the ratelimit was reset, which is
+// kept together with its tombstone when WithTombstones is used (see
+// GetResetReason). The reason has to be one of the predefined ones or
+// registered with RegisterResetReason.
+func (p *Provider) ResetWithReason(key string, reason ResetReason, note string, opts ...CallOption) (ok bool, err error) {
+	defer p.recoverPanic(&err, "reset", key)
+
+	if !isResetReason(reason) {
+		return false, fmt.Errorf("%w: %q", ErrUnknownResetReason, reason)
+	}
+
+	return p.ResetWithOptions(key, append(opts, withResetReason(reason, note))...)
+}
+
+// resetRecordFor returns the encoded resetRecord for a reset made with the
+// given options, which can be nil.
+func resetRecordFor(call *callOptions) (string, error) {
+	record := resetRecord{Reason: ReasonUnspecified}
+	if call != nil && call.reason != "" {
+		record.Reason, record.Note = call.reason, call.note
+	}
+
+	data, err := json.Marshal(record)
+	return string(data), err
+}
+
+func (p *Provider) resetReasonKey(key string) string {
+	return p.companionKey("reset_reason", key)
+}
+
+// GetResetReason returns why the given key was last reset and the note that
+// came with it, for as long as its tombstone is kept. It returns ErrNotFound
+// if there is no tombstone, or if it was written before reasons were kept.
+func (p *Provider) GetResetReason(key string) (reason ResetReason, note string, err error) {
+	defer p.recoverPanic(&err, "get_reset_reason", key)
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	data, err := p.cmd(ctx).Get(ctx, p.resetReasonKey(p.storageKey(key))).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", "", fmt.Errorf("%w: no reset reason", ErrNotFound)
+		}
+
+		return "", "", err
+	}
+
+	var record resetRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return "", "", err
+	}
+
+	return record.Reason, record.Note, nil
+}
@@ -0,0 +1,240 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// permissionError is a NOPERM reply from Redis that also matches
+// ErrNoPermission.
+type permissionError struct {
+	err error
+}
+
+func (e *permissionError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNoPermission, e.err)
+}
+
+func (e *permissionError) Unwrap() error {
+	return e.err
+}
+
+func (e *permissionError) Is(target error) bool {
+	return target == ErrNoPermission
+}
+
+// requirement is a feature and the commands that it sends to Redis, including
+// the ones that its scripts call. alternative is suggested instead of the
+// feature under WithNoScripting, if there is one.
+type requirement struct {
+	feature     string
+	commands    []string
+	alternative string
+}
+
+// WithRestrictedCommands makes New fail unless every feature that the other
+// options enable only needs commands out of allowed, for Redis users whose ACL
+// only grants a few commands. The check covers Get, Put, Reset and whatever the
+// options turn on; methods that are only called explicitly, like the ones on
+// AdminClient, aren't covered and fail with ErrNoPermission instead. Commands
+// are matched by name, case-insensitively, and ACL categories aren't resolved.
+func WithRestrictedCommands(allowed []string) func(o *options) {
+	return func(o *options) {
+		o.allowedCommands = allowed
+	}
+}
+
+// checkRestrictedCommands returns an error that lists every command that the
+// configured features need but which isn't allowed.
+func checkRestrictedCommands(config *options) error {
+	allowed := make(map[string]struct{}, len(config.allowedCommands))
+	for _, command := range config.allowedCommands {
+		allowed[strings.ToUpper(command)] = struct{}{}
+	}
+
+	var missing []string
+	for _, req := range config.requirements() {
+		for _, command := range req.commands {
+			if _, ok := allowed[command]; !ok {
+				missing = append(missing, fmt.Sprintf("%s needs %s", req.feature, command))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("commands aren't allowed by WithRestrictedCommands: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// requirements returns what the features that these options enable need.
+func (o *options) requirements() []requirement {
+	// Scripts fall back to EVAL when Redis doesn't know them yet, unless they
+	// are pinned.
+	script := func(commands ...string) []string {
+		if o.pinnedScripts != nil {
+			return append([]string{"EVALSHA"}, commands...)
+		}
+
+		return append([]string{"EVALSHA", "EVAL"}, commands...)
+	}
+
+	reqs := []requirement{{feature: "Get", commands: []string{"HGET"}}}
+	if o.resetIndex {
+		reqs = append(reqs, requirement{feature: "Put with WithResetIndex", commands: script("HSET", "ZADD", "ZREM"), alternative: "AdminClient.List lists every key without it"})
+	} else {
+		reqs = append(reqs, requirement{feature: "Put", commands: []string{"HSET"}})
+	}
+
+	switch {
+	case o.tombstoneTTL > 0:
+		reqs = append(reqs, requirement{feature: "Reset with WithTombstones", commands: script("HGET", "SET", "HDEL")})
+	case o.resetIndex:
+		reqs = append(reqs, requirement{feature: "Reset with WithResetIndex", commands: []string{"HEXISTS", "MULTI", "HDEL", "ZREM", "EXEC"}})
+	default:
+		reqs = append(reqs, requirement{feature: "Reset", commands: []string{"HEXISTS", "MULTI", "HDEL", "EXEC"}})
+	}
+
+	db := 0
+	if o.db != nil {
+		db = *o.db
+	} else if o.client != nil {
+		db = o.client.Options().DB
+	} else if o.clientConfig != nil {
+		db = o.clientConfig.DB
+	}
+
+	if db != 0 {
+		reqs = append(reqs, requirement{feature: "a database other than 0", commands: []string{"SELECT"}})
+	}
+
+	if o.keyWindow > 0 {
+		reqs = append(reqs, requirement{feature: "WithWindowedKeys", commands: []string{"PEXPIREAT", "HDEL"}})
+	}
+
+	if o.layoutRollout != nil {
+		reqs = append(reqs, requirement{feature: "WithLayoutRollout", commands: script("GET", "SET", "DEL", "HGET", "HSET", "HDEL", "HEXISTS", "EXISTS")})
+	}
+
+	if o.writeReplicas > 0 {
+		reqs = append(reqs, requirement{feature: "WithWriteConcern", commands: []string{"WAIT"}})
+	}
+
+	if o.stateLossInterval > 0 && o.stateLossCallback != nil {
+		reqs = append(reqs, requirement{feature: "WithStateLossDetection", commands: script("TIME", "HSETNX", "HGET", "EXISTS", "HLEN")})
+	}
+
+	if o.evictionInterval > 0 && o.evictionCallback != nil {
+		reqs = append(reqs, requirement{feature: "WithEvictionDetection", commands: []string{"MSET", "EXISTS", "INFO"}})
+	}
+
+	if o.coldStartRamp > 0 {
+		reqs = append(reqs, requirement{feature: "WithColdStartRamp", commands: script("TIME", "HSETNX", "HGET")})
+	}
+
+	if o.approxWidth > 0 && o.approxDepth > 0 {
+		reqs = append(reqs, requirement{feature: "WithApproximateMode", commands: script("BITFIELD", "PEXPIREAT"), alternative: "use FixedWindow"})
+	}
+
+	if o.abuseHalfLife > 0 {
+		reqs = append(reqs, requirement{feature: "WithAbuseScore", commands: script("TIME", "HMGET", "HSET", "PEXPIRE", "SET")})
+	}
+
+	if o.penaltyBackoff != nil {
+		reqs = append(reqs, requirement{feature: "WithPenaltyBackoff", commands: script("INCR", "PEXPIREAT")})
+	}
+
+	if o.graceRequests > 0 {
+		reqs = append(reqs, requirement{feature: "WithGrace", commands: script("GET", "INCR", "PEXPIREAT")})
+	}
+
+	if o.threshold != nil {
+		reqs = append(reqs, requirement{feature: "WithThresholdCallback", commands: script("GET", "SET")})
+	}
+
+	if o.fieldTTL {
+		reqs = append(reqs, requirement{feature: "WithFieldTTL", commands: []string{"HPTTL", "HPEXPIREAT"}})
+	}
+
+	if o.catalogKey != "" && o.catalogTier != nil {
+		reqs = append(reqs, requirement{feature: "WithLimitCatalog", commands: []string{"HGETALL"}})
+	}
+
+	if o.persistentEntries {
+		req := requirement{feature: "WithPersistentEntries", commands: []string{"SADD", "SREM"}}
+		if o.fieldTTL {
+			req.commands = script("SADD", "SREM", "SISMEMBER", "HPERSIST", "HPEXPIREAT")
+			req.alternative = "works without WithFieldTTL"
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	if o.autoLegacyMigration {
+		reqs = append(reqs, requirement{feature: "WithAutoLegacyMigration", commands: script("HSCAN", "HGET", "HSET", "HDEL")})
+	}
+
+	if o.pool != nil && o.pool.memberLimit > 0 {
+		reqs = append(reqs, requirement{feature: "WithSharedPool with WithMemberLimit", commands: script("HGET", "HSET", "ZADD")})
+	}
+
+	if o.schemaInfo {
+		reqs = append(reqs, requirement{feature: "WithSchemaInfo", commands: []string{"HGETALL", "MULTI", "HSET", "HSETNX", "EXEC"}})
+	}
+
+	if o.rateHalfLife > 0 {
+		reqs = append(reqs, requirement{feature: "WithRateEstimation", commands: script("TIME", "HMGET", "HSET", "PEXPIRE")})
+	}
+
+	if o.burstBucket != nil {
+		reqs = append(reqs, requirement{feature: "WithBurstBucket", commands: script("HMGET", "HSET", "PEXPIRE")})
+	}
+
+	if o.mirrorPrefix != "" {
+		reqs = append(reqs, requirement{feature: "WithMirrorFormat", commands: []string{"HSET", "PEXPIREAT", "DEL"}})
+	}
+
+	if o.firstSeenTracking {
+		reqs = append(reqs, requirement{feature: "WithFirstSeenTracking", commands: []string{"HSETNX"}})
+	}
+
+	if _, fixed := o.algorithm.(fixedWindow); o.algorithm != nil && !fixed && len(o.algorithm.Scripts()) > 0 {
+		reqs = append(reqs, requirement{feature: "WithAlgorithm(" + o.algorithm.Name() + ")", commands: script(), alternative: "use FixedWindow"})
+	}
+
+	if o.windowCreatedAt {
+		reqs = append(reqs, requirement{feature: "WithWindowCreatedAt", commands: script("HGET", "HSET", "HEXISTS", "HDEL")})
+	}
+
+	if o.fallbackPrefix != "" {
+		reqs = append(reqs, requirement{feature: "WithFallbackPrefix", commands: script("HGET", "HSET", "HDEL")})
+	}
+
+	if o.tenantFn != nil && o.tenantMaxKeys > 0 {
+		reqs = append(reqs, requirement{feature: "WithTenantKeyBudget", commands: script("HEXISTS", "HGET", "HINCRBY", "HSCAN", "MULTI", "DEL", "HSET", "EXEC")})
+	}
+
+	return reqs
+}
@@ -0,0 +1,60 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// RetryAfter returns how long a client has to wait before it may send another
+// request, which is zero if it still has requests remaining or the window has
+// already been reset.
+func RetryAfter(rl *types.Ratelimit) time.Duration {
+	return retryAfter(rl, time.Now())
+}
+
+// RetryAfterFor is RetryAfter for the ratelimit stored under the given key,
+// using the Provider's clock. Unlike Get, it doesn't count as a request. The
+// returned bool is false if there is no ratelimit for the key.
+func (p *Provider) RetryAfterFor(key string) (after time.Duration, found bool, err error) {
+	defer p.recoverPanic(&err, "retry_after", key)
+
+	rl, err := p.fetch(p.storageKey(key), nil)
+	if err != nil || rl == nil {
+		return 0, false, err
+	}
+
+	return retryAfter(rl, p.now()), true, nil
+}
+
+func retryAfter(rl *types.Ratelimit, now time.Time) time.Duration {
+	if rl == nil || rl.Remaining > 0 {
+		return 0
+	}
+
+	if after := rl.ResetTime.Sub(now); after > 0 {
+		return after
+	}
+
+	return 0
+}
@@ -0,0 +1,59 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import "github.com/noelware/chi-ratelimit/types"
+
+// WithRolloverCallback calls fn every time Consume or the adapter of
+// NewConsumeAdapter starts a new window for a key, because there was none or
+// the last one was over or reset. old is the ratelimit of the window before,
+// like for how much of its budget was used, and new the one of the new window
+// after the request that started it. old is nil when it isn't known: when the
+// last window already expired from Redis, or for an Algorithm other than
+// FixedWindow, which don't keep ratelimits. fn runs on a goroutine of its own,
+// so it can't slow down Consume; Shutdown waits for it.
+//
+// Only the instance whose request started the window calls fn, so every
+// rollover is seen at most once per instance. It isn't exactly once across
+// instances: with WithNoScripting, where the last write wins, two instances
+// can both start the same window.
+func WithRolloverCallback(fn func(key string, old, new *types.Ratelimit)) func(o *options) {
+	return func(o *options) {
+		o.rolloverFn = fn
+	}
+}
+
+// rollover calls the callback of WithRolloverCallback, if there is one.
+func (p *Provider) rollover(key string, old, new *types.Ratelimit) {
+	if p.rolloverFn != nil {
+		p.goBackground(func() { p.rolloverFn(key, old, new) })
+	}
+}
+
+// decisionRatelimit returns the ratelimit that the given Decision is about.
+func decisionRatelimit(decision Decision) *types.Ratelimit {
+	return &types.Ratelimit{
+		Limit:     saturateInt32(decision.Limit),
+		Remaining: saturateInt32(decision.Remaining),
+		ResetTime: decision.ResetAt,
+	}
+}
@@ -0,0 +1,104 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rollover is a call of a WithRolloverCallback callback.
+type rollover struct {
+	key      string
+	old, new *types.Ratelimit
+}
+
+func TestRolloverCallback(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	var (
+		mu    sync.Mutex
+		calls []rollover
+	)
+
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }), WithRolloverCallback(func(key string, old, new *types.Ratelimit) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, rollover{key: key, old: old, new: new})
+	}))
+
+	server.SetTime(now)
+	consume := func(n int) []rollover {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := p.Consume("k", 3, time.Minute); err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+		}
+
+		p.background.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		seen := calls
+		calls = nil
+		return seen
+	}
+
+	// The first window has no window before it.
+	seen := consume(4)
+	if len(seen) != 1 || seen[0].key != "k" || seen[0].old != nil || seen[0].new.Remaining != 2 || !seen[0].new.ResetTime.Equal(start.Add(time.Minute)) {
+		t.Fatalf("rollovers in the first window = %+v, want one without an old window", seen)
+	}
+
+	// Once it's over, the next request rolls it over.
+	now = start.Add(time.Minute)
+	server.SetTime(now)
+	seen = consume(2)
+	if len(seen) != 1 || seen[0].old == nil || seen[0].old.Remaining != 0 || !seen[0].old.ResetTime.Equal(start.Add(time.Minute)) {
+		t.Fatalf("rollovers in the second window = %+v, want one from the used up first window", seen)
+	}
+
+	if rl := seen[0].new; rl.Limit != 3 || rl.Remaining != 2 || !rl.ResetTime.Equal(now.Add(time.Minute)) {
+		t.Fatalf("the second window = %+v", rl)
+	}
+
+	// After a Reset, the old window is gone.
+	if _, err := p.Reset("k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	if seen = consume(1); len(seen) != 1 || seen[0].old != nil {
+		t.Fatalf("rollovers after a Reset = %+v, want one without an old window", seen)
+	}
+
+	// The adapter starts windows too.
+	if _, err := NewConsumeAdapter(p, 3, time.Minute).Get("adapted"); err != nil {
+		t.Fatalf("adapter Get: %v", err)
+	}
+
+	if seen = consume(0); len(seen) != 1 || seen[0].key != "adapted" || seen[0].new.Remaining != 2 {
+		t.Fatalf("rollovers of the adapter = %+v", seen)
+	}
+}
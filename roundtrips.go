@@ -0,0 +1,123 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"sync/atomic"
+)
+
+// roundTrips counts the round trips of a single sampled operation, which sends
+// its commands through conn.
+type roundTrips struct {
+	conn  *redis.Conn
+	count atomic.Int32
+}
+
+type roundTripKey struct{}
+
+// WithRoundTripSampling counts the round trips to Redis that 1 in n requests
+// to Get, GetWithOptions, GetDetailed and Consume take, picked at random, and
+// passes the count to fn together with the operation ("get" or "consume"),
+// like to feed a histogram that checks a budget of one round trip per request.
+// A pipeline or transaction counts as one round trip, and so does a script
+// unless it has to be loaded first. The Decision of a counted Consume has the
+// count in RoundTrips. fn is called on the goroutine of the request, so it
+// should be quick.
+//
+// Only what the operation sends itself is counted, not background work that it
+// might have started, like reloading the catalog of WithLimitCatalog. A counted
+// operation sends its commands through a single connection of the client that
+// has the counting hook, so the client itself isn't changed and can be shared.
+func WithRoundTripSampling(n int, fn func(op string, roundTrips int)) func(o *options) {
+	return func(o *options) {
+		o.roundTripEvery = n
+		o.roundTripFn = fn
+	}
+}
+
+// sampleRoundTrips returns the given call's options with a round trip counter
+// if this call is sampled by WithRoundTripSampling, and nil otherwise.
+func (p *Provider) sampleRoundTrips(call *callOptions) (*callOptions, *roundTrips) {
+	if p.roundTripFn == nil || p.sample(p.roundTripEvery) != 0 {
+		return call, nil
+	}
+
+	counted := &callOptions{}
+	if call != nil {
+		copied := *call
+		counted = &copied
+	}
+
+	counted.roundTrips = &roundTrips{conn: p.conn(context.Background())}
+	counted.roundTrips.conn.AddHook(roundTripHook{counted: counted.roundTrips})
+	return counted, counted.roundTrips
+}
+
+// reportRoundTrips passes what the counter counted to the function of
+// WithRoundTripSampling, and returns it, giving its connection back to the
+// client. A nil counter reports nothing.
+func (p *Provider) reportRoundTrips(op string, counted *roundTrips) int {
+	if counted == nil {
+		return 0
+	}
+
+	_ = counted.conn.Close()
+	n := int(counted.count.Load())
+	p.roundTripFn(op, n)
+	return n
+}
+
+// roundTripCall returns the options of a call that counts its round trips with
+// the counter of the given context, or nil if it has none.
+func roundTripCall(ctx context.Context) *callOptions {
+	if counted, ok := ctx.Value(roundTripKey{}).(*roundTrips); ok {
+		return &callOptions{roundTrips: counted}
+	}
+
+	return nil
+}
+
+// roundTripHook is the redis.Hook that counts every round trip of the
+// connection it's added to.
+type roundTripHook struct {
+	counted *roundTrips
+}
+
+func (h roundTripHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	h.counted.count.Add(1)
+	return ctx, nil
+}
+
+func (h roundTripHook) AfterProcess(context.Context, redis.Cmder) error {
+	return nil
+}
+
+func (h roundTripHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	h.counted.count.Add(1)
+	return ctx, nil
+}
+
+func (h roundTripHook) AfterProcessPipeline(context.Context, []redis.Cmder) error {
+	return nil
+}
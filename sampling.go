@@ -0,0 +1,58 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+// WithSampledWrites makes Get only write 1 in n requests back to Redis, taking
+// n off the remaining requests when it does, while more than threshold (a
+// fraction between 0 and 1) of the limit remains. Below that, every request is
+// written again, so the limit itself is still enforced exactly.
+//
+// This trades accuracy for fewer writes on busy keys: above the threshold, the
+// stored count is only right on average, and concurrent processes can each
+// skip their writes for a while, so a key can get up to n requests per process
+// more than it would otherwise before it drops below the threshold.
+func WithSampledWrites(n int, threshold float64) func(o *options) {
+	return func(o *options) {
+		o.sampleEvery = n
+		o.sampleThreshold = threshold
+	}
+}
+
+// sampledRemaining returns what Get should store as the remaining requests of a
+// ratelimit that had the given amount left before this request, and false if
+// this request shouldn't be written at all.
+func (p *Provider) sampledRemaining(remaining, limit int32) (int32, bool) {
+	next := remaining - 1
+	if p.sampleEvery > 1 && limit > 0 && float64(remaining)/float64(limit) > p.sampleThreshold {
+		if p.sample(p.sampleEvery) != 0 {
+			return next, false
+		}
+
+		next = saturateInt32(int64(remaining) - int64(p.sampleEvery))
+	}
+
+	if next < 0 {
+		next = 0
+	}
+
+	return next, true
+}
@@ -0,0 +1,78 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// poolTimeoutMessage is the error that go-redis returns when no connection
+	// became free in time, which isn't exported.
+	poolTimeoutMessage = "redis: connection pool timeout"
+
+	// saturationLogInterval is how often a saturated pool is logged at most.
+	saturationLogInterval = time.Minute
+)
+
+// saturatedError is an error that came from the client running out of
+// connections, which also matches ErrClientSaturated.
+type saturatedError struct {
+	err error
+}
+
+func (e *saturatedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrClientSaturated, e.err)
+}
+
+func (e *saturatedError) Unwrap() error {
+	return e.err
+}
+
+func (e *saturatedError) Is(target error) bool {
+	return target == ErrClientSaturated
+}
+
+// isPoolTimeout returns true if err is, or wraps, the pool timeout of go-redis.
+func isPoolTimeout(err error) bool {
+	return strings.Contains(err.Error(), poolTimeoutMessage)
+}
+
+// logSaturation suggests a bigger pool, along with the current pool stats, at
+// most once per saturationLogInterval.
+func (p *Provider) logSaturation() {
+	if p.logf == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := p.lastSaturationLog.Load()
+	if now-last < int64(saturationLogInterval) || !p.lastSaturationLog.CompareAndSwap(last, now) {
+		return
+	}
+
+	stats := p.client.PoolStats()
+	p.logf("chi-ratelimit-redis: timed out waiting for a free connection (pool size %d, %d in use, %d idle, %d timeouts so far); the pool is likely too small for the load, consider raising PoolSize or PoolTimeout",
+		p.client.Options().PoolSize, stats.TotalConns-stats.IdleConns, stats.IdleConns, stats.Timeouts)
+}
@@ -0,0 +1,129 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// ScanProgress is what the callback set with WithScanProgress is called with
+// after every batch of a scan over the hash.
+type ScanProgress struct {
+	// Operation is the name of the method that is scanning, like "ResetAll".
+	Operation string
+
+	// Visited is how many ratelimits were looked at so far.
+	Visited int64
+
+	// Elapsed is how long the scan has been running.
+	Elapsed time.Duration
+}
+
+// WithScanBatchSize sets how many ratelimits every batch of a scan over the
+// hash asks Redis for, like in ResetByPart, ResetAll and RepairInconsistent.
+// Each of them has its own default.
+func WithScanBatchSize(size int) func(o *options) {
+	return func(o *options) {
+		o.scanBatchSize = size
+	}
+}
+
+// WithScanThrottle makes scans over the hash wait for the given duration between
+// batches, to spread their load on Redis out.
+func WithScanThrottle(throttle time.Duration) func(o *options) {
+	return func(o *options) {
+		o.scanThrottle = throttle
+	}
+}
+
+// WithScanProgress calls fn after every batch of a scan over the hash.
+func WithScanProgress(fn func(ScanProgress)) func(o *options) {
+	return func(o *options) {
+		o.scanProgress = fn
+	}
+}
+
+// scan goes through every field of the hash that matches the given pattern in
+// batches, calling fn with the fields and values of each. ctx is checked between
+// batches, so a cancelled scan always stops after a whole batch was handled.
+func (p *Provider) scan(ctx context.Context, operation, match string, batchSize int, fn func(fields, values []string) error) error {
+	if p.scanBatchSize > 0 {
+		batchSize = p.scanBatchSize
+	}
+
+	// The cursor only means something for the hash it came from, so a scan
+	// that runs into the next key window or a swapped key space keeps going
+	// through the hash it started on.
+	var (
+		hash    = p.hashKey()
+		cursor  uint64
+		visited int64
+		start   = time.Now()
+	)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// HSCAN returns field/value pairs, so split them up.
+		items, next, err := p.cmd(ctx).HScan(ctx, hash, cursor, match, int64(batchSize)).Result()
+		if err != nil {
+			return err
+		}
+
+		fields := make([]string, 0, len(items)/2)
+		values := make([]string, 0, len(items)/2)
+		for i := 0; i+1 < len(items); i += 2 {
+			fields = append(fields, items[i])
+			values = append(values, items[i+1])
+		}
+
+		if len(fields) > 0 {
+			if err := fn(fields, values); err != nil {
+				return err
+			}
+		}
+
+		visited += int64(len(fields))
+		if p.scanProgress != nil {
+			p.scanProgress(ScanProgress{Operation: operation, Visited: visited, Elapsed: time.Since(start)})
+		}
+
+		if next == 0 {
+			return nil
+		}
+
+		cursor = next
+		if p.scanThrottle > 0 {
+			timer := time.NewTimer(p.scanThrottle)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+
+			case <-timer.C:
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// schemaVersion is the version of how ratelimits are stored, which goes
+	// up whenever a release stores them in a way that older releases can't
+	// read.
+	schemaVersion = 1
+
+	// schemaLayout is the only layout there is: every ratelimit is a field of
+	// one hash.
+	schemaLayout = "hash"
+
+	// modulePath is what this library's version is looked up by in the build
+	// info.
+	modulePath = "github.com/noelware/chi-ratelimit-redis"
+)
+
+// SchemaInfo describes how the ratelimits under a key prefix are stored. With
+// WithSchemaInfo, it's kept in a hash next to them ("{<prefix>}:__schema__").
+type SchemaInfo struct {
+	// Layout is how ratelimits are laid out in Redis, which is "hash".
+	Layout string
+
+	// Codec is how values are encoded: "json", or "wire_format(...)" with the
+	// field names of WithWireFormat.
+	Codec string
+
+	// Compression is the codec of WithCompression, "gzip", "snappy" or
+	// "codec-<id>" for others, or empty without compression.
+	Compression string
+
+	// SchemaVersion is the version of how ratelimits are stored.
+	SchemaVersion int
+
+	// LibraryVersion is the version of this library that last changed the
+	// entry, or "(devel)" if it isn't known.
+	LibraryVersion string
+
+	// CreatedAt is when the entry was first written.
+	CreatedAt time.Time
+}
+
+// WithSchemaInfo writes a SchemaInfo of the Provider's configuration on its
+// first successful write, and again whenever the configuration changes between
+// deploys, so tools can tell how a prefix is stored. New reads the one that
+// is already there and fails with ErrSchemaConflict if the Provider couldn't
+// read the stored ratelimits, like after deploying another codec against old
+// data, unless WithSchemaConflictWarning is used too. Prefixes without an
+// entry, like ones written before this option, are fine.
+func WithSchemaInfo() func(o *options) {
+	return func(o *options) {
+		o.schemaInfo = true
+	}
+}
+
+// WithSchemaConflictWarning makes New log a warning instead of failing when the
+// stored SchemaInfo conflicts with the configuration.
+func WithSchemaConflictWarning() func(o *options) {
+	return func(o *options) {
+		o.schemaWarning = true
+	}
+}
+
+func (p *Provider) schemaKey() string {
+	return p.space.Load().schema
+}
+
+// ReadSchema returns the SchemaInfo stored for the Provider's key prefix, or
+// nil if there is none.
+func (a *AdminClient) ReadSchema(ctx context.Context) (schema *SchemaInfo, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "read_schema", "")
+	ctx = p.maintenanceContext(ctx)
+
+	return p.readSchema(ctx)
+}
+
+func (p *Provider) readSchema(ctx context.Context) (*SchemaInfo, error) {
+	fields, err := p.cmd(ctx).HGetAll(ctx, p.schemaKey()).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+
+	schema := &SchemaInfo{
+		Layout:         fields["layout"],
+		Codec:          fields["codec"],
+		Compression:    fields["compression"],
+		LibraryVersion: fields["library_version"],
+	}
+
+	if version, err := strconv.Atoi(fields["schema_version"]); err == nil {
+		schema.SchemaVersion = version
+	}
+
+	if createdAt, err := strconv.ParseInt(fields["created_at"], 10, 64); err == nil {
+		schema.CreatedAt = time.UnixMilli(createdAt)
+	}
+
+	return schema, nil
+}
+
+// currentSchema returns the SchemaInfo of the Provider's configuration, without
+// a creation time.
+func (p *Provider) currentSchema() *SchemaInfo {
+	schema := &SchemaInfo{
+		Layout:         schemaLayout,
+		Codec:          "json",
+		Compression:    compressionName(p.compression),
+		SchemaVersion:  schemaVersion,
+		LibraryVersion: libraryVersion(),
+	}
+
+	if f := p.wireFormat; f != nil {
+		fields := []string{f.LimitField, f.RemainingField, f.ResetField, f.GlobalField}
+		if f.ResetAsUnixMillis {
+			fields = append(fields, "unix_ms")
+		}
+
+		schema.Codec = "wire_format(" + strings.Join(fields, ",") + ")"
+	}
+
+	return schema
+}
+
+// compressionName returns the name of a codec in SchemaInfo.Compression.
+func compressionName(codec CompressionCodec) string {
+	switch {
+	case codec == nil:
+		return ""
+	case codec.ID() == GzipCompression.ID():
+		return "gzip"
+	case codec.ID() == SnappyCompression.ID():
+		return "snappy"
+	default:
+		return "codec-" + strconv.Itoa(int(codec.ID()))
+	}
+}
+
+// libraryVersion returns the version of this library in the build info.
+func libraryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+
+	return "(devel)"
+}
+
+// schemaConflicts returns what in the stored SchemaInfo the Provider can't
+// read.
+func (p *Provider) schemaConflicts(stored *SchemaInfo) []string {
+	current := p.currentSchema()
+
+	var conflicts []string
+	if stored.Layout != current.Layout {
+		conflicts = append(conflicts, fmt.Sprintf("layout is %q, not %q", stored.Layout, current.Layout))
+	}
+
+	if stored.SchemaVersion > current.SchemaVersion {
+		conflicts = append(conflicts, fmt.Sprintf("schema version %d is newer than %d", stored.SchemaVersion, current.SchemaVersion))
+	}
+
+	if stored.Codec != current.Codec {
+		conflicts = append(conflicts, fmt.Sprintf("codec is %q, not %q", stored.Codec, current.Codec))
+	}
+
+	// Built-in codecs can always be read, whatever is configured.
+	switch stored.Compression {
+	case "", "gzip", "snappy", current.Compression:
+	default:
+		conflicts = append(conflicts, fmt.Sprintf("compression is %q, not %q", stored.Compression, current.Compression))
+	}
+
+	return conflicts
+}
+
+// checkSchema makes sure that the stored SchemaInfo doesn't conflict with the
+// Provider's configuration.
+func (p *Provider) checkSchema(warn bool) error {
+	ctx, cancel := p.readContext()
+	defer cancel()
+
+	stored, err := p.readSchema(ctx)
+	if err != nil || stored == nil {
+		return err
+	}
+
+	conflicts := p.schemaConflicts(stored)
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	if !warn {
+		return fmt.Errorf("%w: %s", ErrSchemaConflict, strings.Join(conflicts, "; "))
+	}
+
+	if p.logf != nil {
+		p.logf("chi-ratelimit-redis: the stored schema conflicts with the configuration: %s", strings.Join(conflicts, "; "))
+	}
+
+	return nil
+}
+
+// recordSchema writes the SchemaInfo after the first successful write, if it
+// changed. Failures are reported and retried on the next write.
+func (p *Provider) recordSchema(ctx context.Context) {
+	if !p.schemaInfo || p.schemaRecorded.Load() {
+		return
+	}
+
+	if err := p.writeSchema(ctx); err != nil {
+		p.reportError("record_schema", err)
+		return
+	}
+
+	p.schemaRecorded.Store(true)
+}
+
+func (p *Provider) writeSchema(ctx context.Context) error {
+	stored, err := p.readSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := p.currentSchema()
+	if stored != nil {
+		current.CreatedAt = stored.CreatedAt
+		if *stored == *current {
+			return nil
+		}
+	}
+
+	key := p.schemaKey()
+	_, err = p.cmd(ctx).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"layout", current.Layout,
+			"codec", current.Codec,
+			"compression", current.Compression,
+			"schema_version", current.SchemaVersion,
+			"library_version", current.LibraryVersion,
+		)
+
+		pipe.HSetNX(ctx, key, "created_at", p.now().UnixMilli())
+		return nil
+	})
+
+	return err
+}
@@ -0,0 +1,346 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// scopePutScript writes the ratelimit of a single scope, making the scope hash
+// live at least as long as the window.
+//
+// KEYS[1] = scope hash
+// ARGV[1] = scope, ARGV[2] = value, ARGV[3] = milliseconds until the window
+// resets, or 0 if it isn't known
+var scopePutScript = registerScript("scope_put", `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+local ttl = tonumber(ARGV[3])
+if ttl > 0 and redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+
+return 1
+`)
+
+// scopeCommitScript writes the ratelimit of a single scope like scopePutScript,
+// but only if the scope still is in the state that was read, so two requests
+// can never both count against the same remaining request.
+//
+// It returns 0 if it didn't write and 1 otherwise.
+//
+// KEYS[1] = scope hash
+// ARGV[1] = scope, ARGV[2] = '1' if the scope existed, ARGV[3] = read value,
+// ARGV[4] = new value, ARGV[5] = milliseconds until the window resets, or 0 if
+// it isn't known
+var scopeCommitScript = registerScript("scope_commit", `
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if ARGV[2] == '1' then
+	if current ~= ARGV[3] then
+		return 0
+	end
+elseif current then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[4])
+local ttl = tonumber(ARGV[5])
+if ttl > 0 and redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+
+return 1
+`)
+
+// LimiterScope stores ratelimits under a name, like an HTTP method, next to the
+// other scopes of the same key, so one key can have a different budget per
+// scope and ResetAllScopes can still reset them together. It is a
+// providers.Provider, so it can be given to the chi-ratelimit middleware.
+type LimiterScope struct {
+	provider *Provider
+	name     string
+}
+
+var _ providers.Provider = (*LimiterScope)(nil)
+
+// Scope returns the scope with the given name. Every scope of a key lives in a
+// single hash ("{<prefix>}:scopes:<key>"), with one field per scope, which
+// expires once the last of their windows is over.
+func (p *Provider) Scope(name string) *LimiterScope {
+	return &LimiterScope{provider: p, name: name}
+}
+
+func (p *Provider) scopeKey(key string) string {
+	return p.companionKey("scopes", key)
+}
+
+func (s *LimiterScope) Name() string {
+	return s.provider.Name() + " (scope " + s.name + ")"
+}
+
+// Get is Provider.Get for this scope: it returns the scope's ratelimit for the
+// given key, and stores it again with one request less. Like Txn, the request
+// is only counted if nothing else wrote to the scope in between, and Get tries
+// again otherwise, so concurrent Gets never lose a request.
+func (s *LimiterScope) Get(key string) (rl *types.Ratelimit, err error) {
+	defer s.provider.recoverPanic(&err, "get", key)
+
+	return s.update(key, func(current *types.Ratelimit) *types.Ratelimit {
+		if current == nil {
+			return nil
+		}
+
+		return current.Copy()
+	})
+}
+
+// Consume is Provider.Consume with the FixedWindow algorithm for this scope: it
+// counts a request in the scope's ratelimit for the given key in one atomic
+// step, starting a new window of the given limit and length when there is none
+// or the last one is over. WithLimitCatalog, WithColdStartRamp and the
+// LimitChangePolicy apply like they do to FixedWindow.
+func (s *LimiterScope) Consume(key string, limit int64, window time.Duration) (decision Decision, err error) {
+	p := s.provider
+	defer p.recoverPanic(&err, "consume", key)
+
+	var counted fixedCount
+	_, err = s.update(key, func(current *types.Ratelimit) *types.Ratelimit {
+		current, counted.fresh = p.countedWindow(key, current, saturateInt32(limit), window, p.now())
+		counted.before = current.Remaining
+		counted.rl = current.Copy()
+		return counted.rl
+	})
+
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision = newFixedDecision(counted, p.now())
+	decision.Window = window
+	return decision, nil
+}
+
+// update replaces the scope's ratelimit for the given key with what fn returns
+// for the current one, or nil if there is none, and returns what was stored.
+// Like Txn, fn runs again if another write got in between, up to the amount of
+// attempts of WithTxnAttempts. If fn returns nil, nothing is written.
+func (s *LimiterScope) update(key string, fn func(current *types.Ratelimit) *types.Ratelimit) (*types.Ratelimit, error) {
+	p := s.provider
+	for attempt := 0; attempt < p.txnAttempts; attempt++ {
+		data, exists, err := s.fetchRaw(key)
+		if err != nil {
+			return nil, err
+		}
+
+		var current *types.Ratelimit
+		if exists {
+			if current, err = p.decode(data); err != nil {
+				return nil, err
+			}
+
+			current = p.clampRead(current)
+		}
+
+		next := fn(current)
+		if next == nil {
+			return nil, nil
+		}
+
+		committed, err := s.commit(key, exists, data, next)
+		if err != nil {
+			return nil, err
+		}
+
+		if committed {
+			return next, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: gave up after %d attempts", ErrTxnConflict, p.txnAttempts)
+}
+
+// commit runs scopeCommitScript, returning false if the scope was changed since
+// it was read.
+func (s *LimiterScope) commit(key string, existed bool, read string, rl *types.Ratelimit) (bool, error) {
+	p := s.provider
+	rl, data, err := s.encode(key, rl)
+	if err != nil || p.dryRun {
+		return err == nil, err
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	flag := "0"
+	if existed {
+		flag = "1"
+	}
+
+	keys := []string{p.scopeKey(p.storageKey(key))}
+	committed, err := p.runScript(ctx, scopeCommitScript, keys, s.name, flag, read, data, s.ttl(rl)).Int()
+	if err != nil || committed == 0 {
+		return false, err
+	}
+
+	return true, replication.wait(ctx)
+}
+
+// Peek returns the scope's ratelimit for the given key without counting a
+// request, or nil if there is none.
+func (s *LimiterScope) Peek(key string) (rl *types.Ratelimit, err error) {
+	defer s.provider.recoverPanic(&err, "peek", key)
+
+	data, exists, err := s.fetchRaw(key)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	if rl, err = s.provider.decode(data); err != nil {
+		return nil, err
+	}
+
+	return s.provider.clampRead(rl), nil
+}
+
+// fetchRaw reads the data stored for the scope of the given key. The returned
+// bool is false if it doesn't exist.
+func (s *LimiterScope) fetchRaw(key string) (string, bool, error) {
+	p := s.provider
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	data, err := p.cmd(ctx).HGet(ctx, p.scopeKey(p.storageKey(key)), s.name).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	return data, true, nil
+}
+
+// encode checks and encodes a ratelimit of the scope like Put does.
+func (s *LimiterScope) encode(key string, rl *types.Ratelimit) (*types.Ratelimit, string, error) {
+	p := s.provider
+	rl, err := p.checkRemaining(key, rl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := p.encode(rl)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if p.maxValueSize > 0 && len(data) > p.maxValueSize {
+		return nil, "", fmt.Errorf("%w: %d bytes (max %d)", ErrValueTooLarge, len(data), p.maxValueSize)
+	}
+
+	return rl, string(data), nil
+}
+
+// ttl returns how many milliseconds there are until the given ratelimit resets,
+// or 0 if it isn't known.
+func (s *LimiterScope) ttl(rl *types.Ratelimit) int64 {
+	if rl.ResetTime.IsZero() {
+		return 0
+	}
+
+	return rl.ResetTime.Sub(s.provider.now()).Milliseconds()
+}
+
+// Put stores the scope's ratelimit for the given key.
+func (s *LimiterScope) Put(key string, rl *types.Ratelimit) (err error) {
+	p := s.provider
+	defer p.recoverPanic(&err, "put", key)
+
+	rl, data, err := s.encode(key, rl)
+	if err != nil || p.dryRun {
+		return err
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	keys := []string{p.scopeKey(p.storageKey(key))}
+	if err := p.runScript(ctx, scopePutScript, keys, s.name, data, s.ttl(rl)).Err(); err != nil {
+		return err
+	}
+
+	return replication.wait(ctx)
+}
+
+// Reset deletes the scope's ratelimit for the given key, leaving the other
+// scopes alone.
+func (s *LimiterScope) Reset(key string) (ok bool, err error) {
+	p := s.provider
+	defer p.recoverPanic(&err, "reset", key)
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	deleted, err := p.cmd(ctx).HDel(ctx, p.scopeKey(p.storageKey(key)), s.name).Result()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+
+	return true, replication.wait(ctx)
+}
+
+// ResetAllScopes deletes the ratelimits of every scope of the given key in one
+// step. The key's ratelimit outside of any scope isn't affected.
+func (p *Provider) ResetAllScopes(key string) (ok bool, err error) {
+	defer p.recoverPanic(&err, "reset_all_scopes", key)
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	deleted, err := p.cmd(ctx).Del(ctx, p.scopeKey(p.storageKey(key))).Result()
+	if err != nil || deleted == 0 {
+		return false, err
+	}
+
+	return true, replication.wait(ctx)
+}
@@ -0,0 +1,123 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScopeConsume(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	scope := p.Scope("POST")
+	for i, remaining := range []int64{1, 0} {
+		decision, err := scope.Consume("k", 2, time.Minute)
+		if err != nil || !decision.Allowed || decision.Remaining != remaining || decision.FirstInWindow != (i == 0) {
+			t.Fatalf("Consume %d = %+v, %v", i, decision, err)
+		}
+	}
+
+	decision, err := scope.Consume("k", 2, time.Minute)
+	if err != nil || decision.Allowed || decision.RetryAfter != time.Minute {
+		t.Fatalf("Consume over the limit = %+v, %v", decision, err)
+	}
+
+	// The other scopes and the key itself have their own budgets.
+	if decision, err := p.Scope("GET").Consume("k", 2, time.Minute); err != nil || decision.Remaining != 1 {
+		t.Fatalf("Consume of another scope = %+v, %v", decision, err)
+	}
+
+	if rl, err := p.Peek("k"); err != nil || rl != nil {
+		t.Fatalf("Peek of the key = %+v, %v", rl, err)
+	}
+
+	// Once the window is over, a new one starts.
+	now = now.Add(time.Minute)
+	server.SetTime(now)
+	if decision, err := scope.Consume("k", 2, time.Minute); err != nil || !decision.Allowed || !decision.FirstInWindow || decision.Remaining != 1 {
+		t.Fatalf("Consume in the next window = %+v, %v", decision, err)
+	}
+}
+
+func TestScopeGetConcurrent(t *testing.T) {
+	p, _ := newTestProvider(t, WithTxnAttempts(1000))
+
+	scope := p.Scope("POST")
+	if rl, err := scope.Get("k"); err != nil || rl != nil {
+		t.Fatalf("Get without a ratelimit = %+v, %v", rl, err)
+	}
+
+	if err := scope.Put("k", &types.Ratelimit{Limit: 100, Remaining: 100, ResetTime: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Every Get counts, even when they race, since a Get whose ratelimit
+	// changed in the meantime tries again.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := scope.Get("k"); err != nil {
+				t.Errorf("Get: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+	if rl, err := scope.Peek("k"); err != nil || rl == nil || rl.Remaining != 50 {
+		t.Fatalf("Peek after 50 Gets = %+v, %v", rl, err)
+	}
+}
+
+func TestScopeCommitConflict(t *testing.T) {
+	p, _ := newTestProvider(t, WithTxnAttempts(2))
+
+	scope := p.Scope("POST")
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	if err := scope.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A write between reading and committing makes every attempt fail.
+	_, err := scope.update("k", func(current *types.Ratelimit) *types.Ratelimit {
+		if err := scope.Put("k", &types.Ratelimit{Limit: 10, Remaining: current.Remaining - 1, ResetTime: current.ResetTime}); err != nil {
+			t.Fatalf("Put in between: %v", err)
+		}
+
+		return current.Copy()
+	})
+
+	if !errors.Is(err, ErrTxnConflict) {
+		t.Fatalf("update with a write in between = %v, want ErrTxnConflict", err)
+	}
+
+	if current, err := scope.Peek("k"); err != nil || current.Remaining != 8 {
+		t.Fatalf("Peek after the conflicts = %+v, %v, want only the writes in between", current, err)
+	}
+}
@@ -0,0 +1,155 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+)
+
+// script is a Lua script that ships with this package.
+type script struct {
+	name   string
+	source string
+	*redis.Script
+}
+
+// scripts holds every script in this package, by name.
+var scripts = map[string]*script{}
+
+func registerScript(name, source string) *script {
+	s := &script{name: name, source: source, Script: redis.NewScript(source)}
+	scripts[name] = s
+
+	return s
+}
+
+// EmbeddedScripts returns the source of every Lua script that this package
+// uses, by name, so they can be reviewed and loaded into Redis out of band
+// (for example with SCRIPT LOAD) when WithPinnedScripts is used.
+func EmbeddedScripts() map[string]string {
+	sources := make(map[string]string, len(scripts))
+	for name, s := range scripts {
+		sources[name] = s.source
+	}
+
+	return sources
+}
+
+// WithPinnedScripts pins the SHA1 of every embedded script, by name. New fails
+// if any script is missing from shas or has a different SHA, and the Provider
+// only ever calls EVALSHA, so script bodies are never sent to Redis. Scripts
+// that haven't been loaded fail with ErrScriptNotLoaded. The scripts of an
+// Algorithm given to WithAlgorithm have to be pinned as well, by the names that
+// its Scripts returns.
+func WithPinnedScripts(shas map[string]string) func(o *options) {
+	return func(o *options) {
+		o.pinnedScripts = shas
+	}
+}
+
+// checkPinnedScripts makes sure that every embedded script, and every script of
+// the given Algorithm if it isn't nil, matches its pin.
+func checkPinnedScripts(shas map[string]string, algorithm Algorithm) error {
+	for name, s := range scripts {
+		if err := checkPin(shas, name, s); err != nil {
+			return err
+		}
+	}
+
+	if algorithm == nil {
+		return nil
+	}
+
+	for name, s := range algorithmScripts(algorithm) {
+		if err := checkPin(shas, name, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkPin(shas map[string]string, name string, s *script) error {
+	pinned, ok := shas[name]
+	if !ok {
+		return fmt.Errorf("script %q (%s) isn't pinned", name, s.Hash())
+	}
+
+	if !strings.EqualFold(pinned, s.Hash()) {
+		return fmt.Errorf("script %q has SHA %s, but it was pinned to %s", name, s.Hash(), pinned)
+	}
+
+	return nil
+}
+
+// runScript runs the given script, only falling back to sending its body when
+// scripts aren't pinned.
+func (p *Provider) runScript(ctx context.Context, s *script, keys []string, args ...interface{}) *redis.Cmd {
+	if p.noScripting {
+		return scriptingDisabled(s)
+	}
+
+	if !p.pinnedScripts {
+		return s.Run(ctx, p.cmd(ctx), keys, args...)
+	}
+
+	cmd := s.EvalSha(ctx, p.cmd(ctx), keys, args...)
+	if noScript(cmd) {
+		cmd.SetErr(scriptNotLoaded(s))
+	}
+
+	return cmd
+}
+
+// queueScript adds the given script to a pipeline. Since its result is only
+// known once the pipeline ran, commands that Redis didn't have the script for
+// have to be sent again after loadScript.
+func (p *Provider) queueScript(ctx context.Context, pipe redis.Pipeliner, s *script, keys []string, args ...interface{}) *redis.Cmd {
+	if p.noScripting {
+		return scriptingDisabled(s)
+	}
+
+	return s.EvalSha(ctx, pipe, keys, args...)
+}
+
+// loadScript loads the given script after queueScript found it missing, or
+// fails with ErrScriptNotLoaded when scripts are pinned.
+func (p *Provider) loadScript(ctx context.Context, s *script) error {
+	if p.pinnedScripts {
+		return scriptNotLoaded(s)
+	}
+
+	return s.Load(ctx, p.cmd(ctx)).Err()
+}
+
+// noScript returns true if cmd failed because Redis didn't know its script.
+func noScript(cmd *redis.Cmd) bool {
+	err := cmd.Err()
+	return err != nil && hasErrorPrefix(err, "NOSCRIPT")
+}
+
+func scriptNotLoaded(s *script) error {
+	return fmt.Errorf("%w: %q (%s) must be loaded with SCRIPT LOAD", ErrScriptNotLoaded, s.name, s.Hash())
+}
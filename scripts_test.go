@@ -0,0 +1,127 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"testing"
+	"time"
+)
+
+// customAlgorithm is SlidingWindow with a script of its own.
+type customAlgorithm struct {
+	Algorithm
+}
+
+func (customAlgorithm) Scripts() map[string]string {
+	return map[string]string{"custom": "return 1"}
+}
+
+func scriptPins(extra map[string]string) map[string]string {
+	pins := map[string]string{}
+	for name, source := range EmbeddedScripts() {
+		sum := sha1.Sum([]byte(source))
+		pins[name] = hex.EncodeToString(sum[:])
+	}
+
+	for name, sha := range extra {
+		pins[name] = sha
+	}
+
+	return pins
+}
+
+func TestPinnedScriptsCheckAlgorithm(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	_, err := New(WithClient(client), WithPinnedScripts(scriptPins(nil)), WithAlgorithm(customAlgorithm{SlidingWindow()}))
+	if err == nil || !strings.Contains(err.Error(), `"custom"`) {
+		t.Fatalf("New = %v, want an error about the unpinned algorithm script", err)
+	}
+
+	sum := sha1.Sum([]byte("return 1"))
+	pins := scriptPins(map[string]string{"custom": hex.EncodeToString(sum[:])})
+	newTestProvider(t, WithPinnedScripts(pins), WithAlgorithm(customAlgorithm{SlidingWindow()}))
+}
+
+func TestAlgorithmScriptsAreRegistered(t *testing.T) {
+	for name, s := range algorithmScripts(SlidingWindow()) {
+		if scripts[name] != s {
+			t.Fatalf("script %q of SlidingWindow isn't the registered one", name)
+		}
+	}
+}
+
+func TestPipelinedScriptsLoad(t *testing.T) {
+	p, server := newTestProvider(t)
+
+	reqs := []ConsumeRequest{{Key: "a", Limit: 2, Window: time.Minute}, {Key: "b", Limit: 2, Window: time.Minute}}
+	for i := 0; i < 2; i++ {
+		server.FlushAll()
+		if err := p.client.ScriptFlush(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := p.ConsumeManyKeys(reqs); err != nil {
+			t.Fatalf("ConsumeManyKeys: %v", err)
+		}
+	}
+
+	if err := p.client.ScriptFlush(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	global := p.GlobalLimiter("global", 10, time.Minute)
+	if _, _, err := p.ConsumeWithGlobal("a", 2, time.Minute, global); err != nil {
+		t.Fatalf("ConsumeWithGlobal: %v", err)
+	}
+}
+
+func TestPipelinedScriptsPinned(t *testing.T) {
+	p, _ := newTestProvider(t, WithPinnedScripts(scriptPins(nil)))
+
+	_, err := p.ConsumeManyKeys([]ConsumeRequest{{Key: "a", Limit: 2, Window: time.Minute}})
+	if !errors.Is(err, ErrScriptNotLoaded) {
+		t.Fatalf("ConsumeManyKeys = %v, want ErrScriptNotLoaded", err)
+	}
+
+	_, _, err = p.ConsumeWithGlobal("a", 2, time.Minute, p.GlobalLimiter("global", 10, time.Minute))
+	if !errors.Is(err, ErrScriptNotLoaded) {
+		t.Fatalf("ConsumeWithGlobal = %v, want ErrScriptNotLoaded", err)
+	}
+
+	if err := slidingConsumeScript.Load(context.Background(), p.client).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ConsumeManyKeys([]ConsumeRequest{{Key: "a", Limit: 2, Window: time.Minute}}); err != nil {
+		t.Fatalf("ConsumeManyKeys with the script loaded: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// selfTestKey is the key that SelfTest writes its ratelimit under.
+const selfTestKey = "__selftest__"
+
+// SelfTestError is returned by SelfTest, naming the step that failed.
+type SelfTestError struct {
+	// Step is "put", "get", "consume" or "reset".
+	Step string
+	Err  error
+
+	// Cleanup is the error from deleting the self-test ratelimit afterwards,
+	// if that failed too.
+	Cleanup error
+}
+
+func (e *SelfTestError) Error() string {
+	message := fmt.Sprintf("self-test failed at %s: %v", e.Step, e.Err)
+	if e.Cleanup != nil {
+		message += fmt.Sprintf(" (cleaning up also failed: %v)", e.Cleanup)
+	}
+
+	return message
+}
+
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// SelfTest goes through what a request does with a ratelimit, in contrast to
+// Verify, which only looks at what's stored: it puts a ratelimit under the
+// "__selftest__" key, reads it back, counts a request against it in a Txn and
+// resets it. Each step also fails if it took longer than the read or write
+// timeout. The ratelimit is deleted even if a step failed. With WithDryRun,
+// nothing is written, so only reading is tested.
+func (a *AdminClient) SelfTest(ctx context.Context) (err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "self_test", "")
+
+	if p.dryRun {
+		return p.selfTestStep(ctx, "get", p.readTimeout, func() error {
+			_, err := p.fetch(p.storageKey(selfTestKey), nil)
+			return err
+		})
+	}
+
+	want := types.NewRatelimit(10, false, p.now().Add(time.Minute).Truncate(time.Millisecond))
+	steps := []struct {
+		name    string
+		timeout time.Duration
+		run     func() error
+	}{
+		{"put", p.writeTimeout, func() error {
+			return p.Put(selfTestKey, want)
+		}},
+
+		{"get", p.readTimeout, func() error {
+			got, err := p.fetch(p.storageKey(selfTestKey), nil)
+			if err != nil {
+				return err
+			}
+
+			if got == nil || got.Limit != want.Limit || got.Remaining != want.Remaining || !got.ResetTime.Equal(want.ResetTime) {
+				return fmt.Errorf("read back %+v, but wrote %+v", got, want)
+			}
+
+			return nil
+		}},
+
+		{"consume", p.writeTimeout, func() error {
+			return p.Txn(selfTestKey, func(tx *Txn) error {
+				rl, err := tx.Get()
+				if err != nil {
+					return err
+				}
+
+				if rl == nil {
+					return ErrNotFound
+				}
+
+				return tx.Put(rl.Copy())
+			})
+		}},
+
+		{"reset", p.writeTimeout, func() error {
+			ok, err := p.Reset(selfTestKey)
+			if err == nil && !ok {
+				err = errors.New("the ratelimit was already gone")
+			}
+
+			return err
+		}},
+	}
+
+	for i, step := range steps {
+		if err := p.selfTestStep(ctx, step.name, step.timeout, step.run); err != nil {
+			// Whatever was written has to go, unless resetting is what failed.
+			if i < len(steps)-1 {
+				if _, cleanupErr := p.Reset(selfTestKey); cleanupErr != nil {
+					err.(*SelfTestError).Cleanup = cleanupErr
+				}
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// selfTestStep runs a single step of SelfTest.
+func (p *Provider) selfTestStep(ctx context.Context, name string, timeout time.Duration, run func() error) error {
+	if err := ctx.Err(); err != nil {
+		return &SelfTestError{Step: name, Err: err}
+	}
+
+	started := time.Now()
+	if err := run(); err != nil {
+		return &SelfTestError{Step: name, Err: err}
+	}
+
+	if took := time.Since(started); timeout > 0 && took > timeout {
+		return &SelfTestError{Step: name, Err: fmt.Errorf("took %v, longer than the timeout of %v", took, timeout)}
+	}
+
+	return nil
+}
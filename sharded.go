@@ -0,0 +1,514 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/providers"
+	"github.com/noelware/chi-ratelimit/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ShardConfig is one shard of NewSharded, made with Shard.
+type ShardConfig struct {
+	options []func(o *options)
+}
+
+// Shard returns a ShardConfig whose Provider is constructed with the given
+// options, like WithClient for its Redis deployment. They're applied after the
+// ones given with WithShardOptions.
+func Shard(opts ...func(o *options)) ShardConfig {
+	return ShardConfig{options: opts}
+}
+
+// ShardedOption configures NewSharded.
+type ShardedOption func(o *shardedOptions)
+
+type shardedOptions struct {
+	common []func(o *options)
+	policy FailurePolicy
+}
+
+// WithShardOptions applies the given options to every shard's Provider, before
+// the shard's own.
+func WithShardOptions(opts ...func(o *options)) ShardedOption {
+	return func(o *shardedOptions) {
+		o.common = append(o.common, opts...)
+	}
+}
+
+// WithShardFailurePolicy sets what Sharded.Consume decides when the key's
+// shard can't be reached. It's FailOpen by default.
+func WithShardFailurePolicy(policy FailurePolicy) ShardedOption {
+	return func(o *shardedOptions) {
+		o.policy = policy
+	}
+}
+
+// ShardError is the failure of one shard in a fan-out operation of Sharded.
+type ShardError struct {
+	// Shard is the index of the shard in what NewSharded was given.
+	Shard int
+
+	// Err is what the shard's Provider returned.
+	Err error
+}
+
+// PartialError is the cause of the OpError that the fan-out operations of
+// Sharded return when some of the shards failed. What the others returned is
+// still merged into the result.
+type PartialError struct {
+	// Shards is how many shards there are.
+	Shards int
+
+	// Failed lists the shards that failed, in order.
+	Failed []ShardError
+}
+
+func (e *PartialError) Error() string {
+	failures := make([]string, len(e.Failed))
+	for i, failure := range e.Failed {
+		// The operation is already in the text of the OpError around this.
+		cause := failure.Err
+		if opErr, ok := cause.(*OpError); ok {
+			cause = opErr.Err
+		}
+
+		failures[i] = "shard " + strconv.Itoa(failure.Shard) + ": " + cause.Error()
+	}
+
+	return fmt.Sprintf("%d of %d shards failed: %s", len(e.Failed), e.Shards, strings.Join(failures, "; "))
+}
+
+// Sharded splits one logical ratelimiter across several Redis deployments,
+// like while traffic moves from an old cluster to a new one: every key lives
+// on the shard that the picker chooses for it, with a Provider of its own. A
+// shard that is down only affects its own keys. It is a providers.Provider, so
+// it can be given to the chi-ratelimit middleware.
+//
+// Keys are never moved between shards, so the picker has to keep choosing the
+// same shard for a key; a key that is picked differently starts over on its
+// new shard.
+type Sharded struct {
+	shards []*Provider
+	pick   func(key string) int
+	policy FailurePolicy
+}
+
+var _ providers.Provider = (*Sharded)(nil)
+
+// NewSharded returns a Sharded with a Provider for each of the given shards,
+// which routes every key to the shard at the index that pick returns for it.
+// pick can't be nil; HashPick spreads keys evenly.
+func NewSharded(shards []ShardConfig, pick func(key string) int, opts ...ShardedOption) (*Sharded, error) {
+	config := &shardedOptions{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if len(shards) == 0 {
+		return nil, &OpError{Op: "new", Err: errors.New("no shards to use")}
+	}
+
+	if pick == nil {
+		return nil, &OpError{Op: "new", Err: errors.New("no picker to choose the shard of a key, like HashPick")}
+	}
+
+	s := &Sharded{pick: pick, policy: config.policy}
+	for i, shard := range shards {
+		provider, err := New(append(append([]func(o *options){}, config.common...), shard.options...)...)
+		if err != nil {
+			for _, created := range s.shards {
+				_ = created.Close()
+			}
+
+			var opErr *OpError
+			if errors.As(err, &opErr) {
+				err = opErr.Err
+			}
+
+			return nil, &OpError{Op: "new", Err: fmt.Errorf("shard %d: %w", i, err)}
+		}
+
+		s.shards = append(s.shards, provider)
+	}
+
+	return s, nil
+}
+
+// Shards returns the Provider of every shard, in the order NewSharded was
+// given them.
+func (s *Sharded) Shards() []*Provider {
+	return append([]*Provider(nil), s.shards...)
+}
+
+// Shard returns the Provider of the shard that the given key lives on.
+func (s *Sharded) Shard(key string) (*Provider, error) {
+	return s.shard("shard", key)
+}
+
+func (s *Sharded) shard(op, key string) (*Provider, error) {
+	i := s.pick(key)
+	if i < 0 || i >= len(s.shards) {
+		return nil, &OpError{Op: op, Key: key, Err: fmt.Errorf("%w: %d of %d", ErrNoShard, i, len(s.shards))}
+	}
+
+	return s.shards[i], nil
+}
+
+func (*Sharded) Name() string {
+	return "sharded redis provider"
+}
+
+// Get is Provider.Get on the key's shard.
+func (s *Sharded) Get(key string) (*types.Ratelimit, error) {
+	p, err := s.shard("get", key)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Get(key)
+}
+
+// Put is Provider.Put on the key's shard.
+func (s *Sharded) Put(key string, value *types.Ratelimit) error {
+	p, err := s.shard("put", key)
+	if err != nil {
+		return err
+	}
+
+	return p.Put(key, value)
+}
+
+// Reset is Provider.Reset on the key's shard.
+func (s *Sharded) Reset(key string) (bool, error) {
+	p, err := s.shard("reset", key)
+	if err != nil {
+		return false, err
+	}
+
+	return p.Reset(key)
+}
+
+// Peek is Provider.Peek on the key's shard.
+func (s *Sharded) Peek(key string) (*types.Ratelimit, error) {
+	p, err := s.shard("peek", key)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Peek(key)
+}
+
+// Consume is Provider.Consume on the key's shard. If it fails, the error is
+// returned together with the Decision that DecideOnError makes for it with the
+// policy of WithShardFailurePolicy.
+func (s *Sharded) Consume(key string, limit int64, window time.Duration) (Decision, error) {
+	p, err := s.shard("consume", key)
+	if err != nil {
+		return s.decideOnError(err, window), err
+	}
+
+	decision, err := p.Consume(key, limit, window)
+	if err != nil {
+		return s.decideOnError(err, window), err
+	}
+
+	return decision, nil
+}
+
+// decideOnError is the Decision of Consume for a request that failed.
+func (s *Sharded) decideOnError(err error, window time.Duration) Decision {
+	decision := DecideOnError(err, s.policy, nil)
+	decision.Window = window
+	return decision
+}
+
+// Inspect is Provider.Inspect on the key's shard.
+func (s *Sharded) Inspect(key string, limit int64, window time.Duration) (Decision, error) {
+	p, err := s.shard("inspect", key)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	return p.Inspect(key, limit, window)
+}
+
+// ConsumeManyKeys is Provider.ConsumeManyKeys with every key on its shard, one
+// pipeline per shard. Keys on a shard that is down are in the BatchError with
+// the shard's error, while the keys of the other shards are still counted and
+// returned, unless RequireComplete is given.
+func (s *Sharded) ConsumeManyKeys(reqs []ConsumeRequest, opts ...CallOption) ([]Decision, error) {
+	keys := make([]string, len(reqs))
+	for i, req := range reqs {
+		keys[i] = req.Key
+	}
+
+	decisions := make([]Decision, len(reqs))
+	ok, err := s.batch("consume_many_keys", keys, opts, func(p *Provider, indexes []int, opts []CallOption) (bool, error) {
+		batch := make([]ConsumeRequest, len(indexes))
+		for j, i := range indexes {
+			batch[j] = reqs[i]
+		}
+
+		got, err := p.ConsumeManyKeys(batch, opts...)
+		for j, decision := range got {
+			decisions[indexes[j]] = decision
+		}
+
+		return got != nil, err
+	})
+
+	if !ok {
+		return nil, err
+	}
+
+	return decisions, err
+}
+
+// GetMany is Provider.GetMany with every key on its shard. Keys on a shard that
+// is down are in the BatchError with the shard's error, while the ratelimits
+// of the other shards are still returned, unless RequireComplete is given.
+func (s *Sharded) GetMany(keys []string, opts ...CallOption) ([]*types.Ratelimit, error) {
+	rls := make([]*types.Ratelimit, len(keys))
+	ok, err := s.batch("get_many", keys, opts, func(p *Provider, indexes []int, opts []CallOption) (bool, error) {
+		batch := make([]string, len(indexes))
+		for j, i := range indexes {
+			batch[j] = keys[i]
+		}
+
+		got, err := p.GetMany(batch, opts...)
+		for j, rl := range got {
+			rls[indexes[j]] = rl
+		}
+
+		return got != nil, err
+	})
+
+	if !ok {
+		return nil, err
+	}
+
+	return rls, err
+}
+
+// Prewarm is Provider.Prewarm with every key on its shard, which warms the
+// local cache of that shard's Provider. Keys on a shard that is down are in the
+// BatchError with the shard's error, while the other shards are still warmed,
+// unless RequireComplete is given.
+func (s *Sharded) Prewarm(ctx context.Context, keys []string, opts ...CallOption) (int, error) {
+	warmed := 0
+	ok, err := s.batch("prewarm", keys, opts, func(p *Provider, indexes []int, opts []CallOption) (bool, error) {
+		batch := make([]string, len(indexes))
+		for j, i := range indexes {
+			batch[j] = keys[i]
+		}
+
+		count, err := p.Prewarm(ctx, batch, opts...)
+		warmed += count
+
+		var batchErr *BatchError
+		return err == nil || (errors.As(err, &batchErr) && batchErr.Len() < len(batch)), err
+	})
+
+	if !ok {
+		return 0, err
+	}
+
+	return warmed, err
+}
+
+// batch runs a batch operation on every shard with the indexes of the keys
+// that live on it, one shard after another. run returns whether that shard
+// returned any results. Failures of single keys and of whole shards are put
+// together in a single BatchError, in batch order. batch returns false if the
+// results shouldn't be returned: when no shard had any, or with
+// RequireComplete when any key failed.
+func (s *Sharded) batch(op string, keys []string, opts []CallOption, run func(p *Provider, indexes []int, opts []CallOption) (bool, error)) (bool, error) {
+	if len(keys) == 0 {
+		return false, nil
+	}
+
+	failed := BatchError{verbose: s.shards[0].verboseErrors}
+	byShard := make(map[int][]int)
+	for i, key := range keys {
+		shard := s.pick(key)
+		if shard < 0 || shard >= len(s.shards) {
+			failed.add(i, key, fmt.Errorf("%w: %d of %d", ErrNoShard, shard, len(s.shards)))
+			continue
+		}
+
+		byShard[shard] = append(byShard[shard], i)
+	}
+
+	// Completeness is decided across every shard, not by each on its own,
+	// and the batch is reported as a whole.
+	shardOpts := append(append([]CallOption{}, opts...), func(c *callOptions) {
+		c.requireComplete = false
+		c.partialReported = true
+	})
+
+	returned := false
+	for shard := range s.shards {
+		indexes := byShard[shard]
+		if len(indexes) == 0 {
+			continue
+		}
+
+		got, err := run(s.shards[shard], indexes, shardOpts)
+		returned = returned || got
+
+		var batchErr *BatchError
+		switch {
+		case err == nil:
+		case errors.As(err, &batchErr):
+			for _, keyErr := range batchErr.errs {
+				failed.add(indexes[keyErr.Index], keyErr.Key, keyErr.Err)
+			}
+
+		default:
+			// The operation is already in the text of the OpError around the
+			// BatchError.
+			var opErr *OpError
+			if errors.As(err, &opErr) {
+				err = opErr.Err
+			}
+
+			for _, i := range indexes {
+				failed.add(i, keys[i], err)
+			}
+		}
+	}
+
+	if failed.Len() == 0 {
+		return true, nil
+	}
+
+	// Entries were added shard by shard, but are listed in batch order.
+	sort.Slice(failed.errs, func(a, b int) bool {
+		return failed.errs[a].Index < failed.errs[b].Index
+	})
+
+	s.shards[0].reportPartial(op, nil, failed.Len(), len(keys))
+	err := &OpError{Op: op, Err: &failed, verbose: failed.verbose}
+	call := newCallOptions(opts)
+	if !returned || call.completeRequired() {
+		return false, err
+	}
+
+	return true, err
+}
+
+// fanOut runs fn for every shard, returning a PartialError with the shards it
+// failed for.
+func (s *Sharded) fanOut(op string, fn func(i int, p *Provider) (stop bool, err error)) error {
+	partial := &PartialError{Shards: len(s.shards)}
+	for i, p := range s.shards {
+		stop, err := fn(i, p)
+		if err != nil {
+			partial.Failed = append(partial.Failed, ShardError{Shard: i, Err: err})
+		}
+
+		if stop {
+			break
+		}
+	}
+
+	if len(partial.Failed) > 0 {
+		return &OpError{Op: op, Err: partial}
+	}
+
+	return nil
+}
+
+// Count returns how many ratelimits are stored across every shard. Shards that
+// fail are left out of the count, and listed in the returned PartialError.
+func (s *Sharded) Count(ctx context.Context) (count int64, err error) {
+	err = s.fanOut("count", func(_ int, p *Provider) (bool, error) {
+		entries, err := p.hashLen(ctx)
+		count += entries
+		return false, err
+	})
+
+	return count, err
+}
+
+// ResetAll is AdminClient.ResetAll on every shard, one after another, with
+// progress getting the total across them. Shards that fail don't stop the
+// others, and are listed in the returned PartialError.
+func (s *Sharded) ResetAll(ctx context.Context, progress func(deleted int64)) (deleted int64, err error) {
+	err = s.fanOut("reset_all", func(_ int, p *Provider) (bool, error) {
+		before := deleted
+		count, err := p.Admin().ResetAll(ctx, func(count int64) {
+			if progress != nil {
+				progress(before + count)
+			}
+		})
+
+		deleted = before + count
+		return false, err
+	})
+
+	return deleted, err
+}
+
+// Iterate is AdminClient.Iterate on every shard, one after another, until fn
+// returns false. Shards that fail don't stop the others, and are listed in the
+// returned PartialError.
+func (s *Sharded) Iterate(ctx context.Context, fn func(key string, rl *types.Ratelimit) bool) error {
+	return s.fanOut("iterate", func(_ int, p *Provider) (bool, error) {
+		stopped := false
+		err := p.Admin().Iterate(ctx, func(key string, rl *types.Ratelimit) bool {
+			stopped = !fn(key, rl)
+			return !stopped
+		})
+
+		return stopped, err
+	})
+}
+
+// Shutdown is Provider.Shutdown on every shard.
+func (s *Sharded) Shutdown(ctx context.Context) error {
+	return s.fanOut("shutdown", func(_ int, p *Provider) (bool, error) {
+		return false, p.Shutdown(ctx)
+	})
+}
+
+// Close is Provider.Close on every shard.
+func (s *Sharded) Close() error {
+	return s.fanOut("close", func(_ int, p *Provider) (bool, error) {
+		return false, p.Close()
+	})
+}
+
+// hashLen returns how many ratelimits the hash has.
+func (p *Provider) hashLen(ctx context.Context) (count int64, err error) {
+	defer p.recoverPanic(&err, "count", "")
+	ctx = p.maintenanceContext(ctx)
+
+	return p.cmd(ctx).HLen(ctx, p.hashKey()).Result()
+}
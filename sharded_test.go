@@ -0,0 +1,167 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type partialBatch struct {
+	op            string
+	failed, total int
+}
+
+// newTestSharded returns a Sharded with two shards on servers of their own,
+// which puts keys starting with "b" on the second one and the rest on the
+// first. Partial batches reported by the metrics hook are appended to reports.
+func newTestSharded(t *testing.T, reports *[]partialBatch, opts ...func(o *options)) (*Sharded, []*miniredis.Miniredis) {
+	t.Helper()
+
+	servers := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	var shards []ShardConfig
+	for _, server := range servers {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+		t.Cleanup(func() { _ = client.Close() })
+		shards = append(shards, Shard(WithClient(client)))
+	}
+
+	hook := func(op string, failed, total int) {
+		*reports = append(*reports, partialBatch{op, failed, total})
+	}
+
+	common := append([]func(o *options){WithMetricsHook(MetricsHook{PartialBatch: hook})}, opts...)
+	s, err := NewSharded(shards, func(key string) int {
+		if strings.HasPrefix(key, "b") {
+			return 1
+		}
+
+		return 0
+	}, WithShardOptions(common...))
+
+	if err != nil {
+		t.Fatalf("NewSharded: %v", err)
+	}
+
+	return s, servers
+}
+
+func TestNewShardedNilPick(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	s, err := NewSharded([]ShardConfig{Shard(WithClient(client))}, nil)
+	if s != nil || err == nil || !strings.Contains(err.Error(), "no picker") {
+		t.Fatalf("NewSharded = %v, %v; want an error about the picker", s, err)
+	}
+}
+
+func TestShardedGetManyShardDown(t *testing.T) {
+	var reports []partialBatch
+	s, servers := newTestSharded(t, &reports)
+	for _, p := range s.Shards() {
+		putAll(t, p, "a1", "a2", "b1")
+	}
+
+	servers[1].Close()
+
+	keys := []string{"a1", "b1", "a2", "a-missing"}
+	rls, err := s.GetMany(keys)
+	if rls == nil || rls[0] == nil || rls[1] != nil || rls[2] == nil || rls[3] != nil {
+		t.Fatalf("GetMany = %v, %v; want the ratelimits of the first shard", rls, err)
+	}
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || !reflect.DeepEqual(FailedKeys(err), []string{"b1"}) || batchErr.Errors()[0].Index != 1 {
+		t.Fatalf("GetMany = %v, want a BatchError with b1 at index 1", err)
+	}
+
+	if want := []partialBatch{{"get_many", 1, 4}}; !reflect.DeepEqual(reports, want) {
+		t.Fatalf("partial batches = %v, want %v", reports, want)
+	}
+
+	if rls, err := s.GetMany(keys, RequireComplete()); rls != nil || !errors.As(err, &batchErr) {
+		t.Fatalf("GetMany with RequireComplete = %v, %v; want nothing and the BatchError", rls, err)
+	}
+
+	// With every key on the shard that is down, there's nothing to return.
+	if rls, err := s.GetMany([]string{"b1", "b2"}); rls != nil || err == nil {
+		t.Fatalf("GetMany on the shard that is down = %v, %v", rls, err)
+	}
+}
+
+func TestShardedConsumeManyKeysShardDown(t *testing.T) {
+	var reports []partialBatch
+	s, servers := newTestSharded(t, &reports)
+	servers[1].Close()
+
+	reqs := []ConsumeRequest{{Key: "a", Limit: 2, Window: time.Minute}, {Key: "b", Limit: 2, Window: time.Minute}}
+	decisions, err := s.ConsumeManyKeys(reqs)
+	if len(decisions) != 2 || !decisions[0].Allowed || decisions[0].Remaining != 1 || decisions[1].Allowed {
+		t.Fatalf("ConsumeManyKeys = %+v, %v; want a counted, b left empty", decisions, err)
+	}
+
+	if !reflect.DeepEqual(FailedKeys(err), []string{"b"}) {
+		t.Fatalf("ConsumeManyKeys = %v, want b to have failed", err)
+	}
+
+	if decisions, err := s.ConsumeManyKeys(reqs, RequireComplete()); decisions != nil || err == nil {
+		t.Fatalf("ConsumeManyKeys with RequireComplete = %+v, %v", decisions, err)
+	}
+
+	if want := []partialBatch{{"consume_many_keys", 1, 2}, {"consume_many_keys", 1, 2}}; !reflect.DeepEqual(reports, want) {
+		t.Fatalf("partial batches = %v, want %v", reports, want)
+	}
+}
+
+func TestShardedPrewarmShardDown(t *testing.T) {
+	var reports []partialBatch
+	s, servers := newTestSharded(t, &reports, WithLocalCache(time.Minute))
+	writer := newTestProviderOn(t, servers[0])
+	putAll(t, writer, "a1", "a2")
+	servers[1].Close()
+
+	keys := []string{"a1", "b1", "a2"}
+	warmed, err := s.Prewarm(context.Background(), keys)
+	if warmed != 2 || !reflect.DeepEqual(FailedKeys(err), []string{"b1"}) {
+		t.Fatalf("Prewarm = %d, %v; want the first shard warmed and b1 failed", warmed, err)
+	}
+
+	before := servers[0].CommandCount()
+	if _, err := s.Peek("a1"); err != nil || servers[0].CommandCount() != before {
+		t.Fatalf("Peek of a warmed key = %v, with %d commands", err, servers[0].CommandCount()-before)
+	}
+
+	if warmed, err := s.Prewarm(context.Background(), keys, RequireComplete()); warmed != 0 || err == nil {
+		t.Fatalf("Prewarm with RequireComplete = %d, %v", warmed, err)
+	}
+
+	if want := []partialBatch{{"prewarm", 1, 3}, {"prewarm", 1, 3}}; !reflect.DeepEqual(reports, want) {
+		t.Fatalf("partial batches = %v, want %v", reports, want)
+	}
+}
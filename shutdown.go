@@ -0,0 +1,100 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultShutdownTimeout is how long Close waits for background work.
+const defaultShutdownTimeout = 5 * time.Second
+
+// goBackground runs fn on its own goroutine that Shutdown waits for. It returns
+// false without running fn once the Provider is shutting down.
+func (p *Provider) goBackground(fn func()) bool {
+	p.backgroundMu.Lock()
+	defer p.backgroundMu.Unlock()
+
+	if p.stopped {
+		return false
+	}
+
+	p.background.Add(1)
+	go func() {
+		defer p.background.Done()
+		fn()
+	}()
+
+	return true
+}
+
+// Shutdown stops the Provider's background work, like the state loss check,
+// and waits for it (including degradation callbacks that are still running)
+// until ctx is done. Then it releases the client that the Provider created with
+// WithConfig or WithURL, closing it once no other Provider uses it, even if
+// waiting timed out, and closes the one from WithMaintenancePoolSize. A client
+// that was given with WithClient is left open.
+// Only the first call does anything.
+func (p *Provider) Shutdown(ctx context.Context) (err error) {
+	defer p.recoverPanic(&err, "shutdown", "")
+
+	p.closeOnce.Do(func() {
+		p.backgroundMu.Lock()
+		p.stopped = true
+		close(p.stop)
+		p.backgroundMu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			p.background.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = fmt.Errorf("background work didn't finish: %w", ctx.Err())
+		}
+
+		if p.ownedMaintenance != nil {
+			if closeErr := p.ownedMaintenance.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+
+		if p.owned == nil {
+			return
+		}
+
+		if releaseErr := p.owned.release(); releaseErr != nil {
+			if err != nil {
+				err = fmt.Errorf("%w; closing the client also failed: %v", err, releaseErr)
+			} else {
+				err = releaseErr
+			}
+		}
+	})
+
+	return err
+}
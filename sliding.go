@@ -0,0 +1,216 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+)
+
+// slidingConsumeScript counts a request with the sliding window counter
+// approximation: the previous window's count is weighted by how much of it
+// still overlaps the sliding window, and added to the current window's count.
+// It returns whether the request was counted, both counts from before it, and
+// whether the key was first seen by it.
+//
+// KEYS[1] = counter hash, KEYS[2] = first-seen hash (optional)
+// ARGV[1] = limit, ARGV[2] = window in milliseconds,
+// ARGV[3] = now in Unix milliseconds, ARGV[4] = key in the first-seen hash
+var slidingConsumeScript = registerScript("sliding_consume", `
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local start = now - (now % window)
+
+local currentField = string.format('%d', start)
+local previousField = string.format('%d', start - window)
+local current = tonumber(redis.call('HGET', KEYS[1], currentField) or '0')
+local previous = tonumber(redis.call('HGET', KEYS[1], previousField) or '0')
+
+local weight = (window - (now - start)) / window
+if previous * weight + current + 1 > tonumber(ARGV[1]) then
+	return { 0, current, previous }
+end
+
+redis.call('HINCRBY', KEYS[1], currentField, 1)
+redis.call('HDEL', KEYS[1], string.format('%d', start - 2 * window))
+redis.call('PEXPIREAT', KEYS[1], start + 2 * window)
+
+local seen = 0
+if KEYS[2] then
+	seen = redis.call('HSETNX', KEYS[2], ARGV[4], ARGV[3])
+end
+
+return { 1, current, previous, seen }
+`)
+
+// windowStart returns when the fixed window of the given length that now is in
+// started, counting whole windows since the Unix epoch in milliseconds, like
+// the scripts do. time.Time.Truncate counts them since the zero Time instead,
+// which only agrees for windows that evenly divide the time in between, so a
+// window of 7 seconds or a week would start somewhere else.
+func windowStart(now time.Time, window time.Duration) time.Time {
+	millis, length := now.UnixMilli(), window.Milliseconds()
+	if length <= 0 {
+		return now
+	}
+
+	return time.UnixMilli(millis - millis%length).In(now.Location())
+}
+
+func (p *Provider) slidingKey(key string) string {
+	return p.companionKey("sliding", key)
+}
+
+// ConsumeSliding counts a request for the given key with a sliding window
+// counter, which only keeps the counts of the current and the previous fixed
+// window ("{<prefix>}:sliding:<key>") and estimates the sliding window from
+// them, assuming the previous window's requests were spread out evenly. Windows
+// come from the Provider's clock and are aligned to multiples of window since
+// the Unix epoch. Lua only counts exactly up to 2^53-1, so the script compares
+// larger limits, up to math.MaxInt64, as 2^53-1; the Decision still has the
+// exact limit and remaining requests. Rejected requests aren't
+// counted. If the abuse score of a rejected key can't be updated, the Decision
+// is returned with the error. It's Consume with the SlidingWindow algorithm.
+func (p *Provider) ConsumeSliding(key string, limit int64, window time.Duration) (Decision, error) {
+	return p.consumeWith(SlidingWindow(), key, AlgorithmParams{Limit: limit, Window: window})
+}
+
+// slidingWindow is the Algorithm of SlidingWindow.
+type slidingWindow struct{}
+
+// SlidingWindow is the Algorithm of ConsumeSliding.
+func SlidingWindow() Algorithm {
+	return slidingWindow{}
+}
+
+func (slidingWindow) Name() string {
+	return "sliding_window"
+}
+
+func (slidingWindow) Scripts() map[string]string {
+	return map[string]string{slidingConsumeScript.name: slidingConsumeScript.source}
+}
+
+func (slidingWindow) Consume(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	keys := []string{p.slidingKey(key)}
+	if p.firstSeenTracking {
+		keys = append(keys, p.seenKey())
+	}
+
+	result, err := p.runScript(ctx, slidingConsumeScript, keys, scriptLimit(params.Limit), params.Window.Milliseconds(), now.UnixMilli(), key).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := newSlidingDecision(result[0] == 1, result[1], result[2], params.Limit, params.Window, now)
+	decision.FirstEver = len(result) > 3 && result[3] == 1
+	p.logDecision(key, slidingConsumeScript.Hash(), params.Window, decision, now)
+	return decision, nil
+}
+
+func (slidingWindow) Peek(ctx context.Context, store AlgorithmStore, key string, params AlgorithmParams) (Decision, error) {
+	p := store.provider
+	defer p.trackLatency(time.Now())
+
+	now := p.now()
+	start := windowStart(now, params.Window)
+	counts, err := p.cmd(ctx).HMGet(ctx, p.slidingKey(key), strconv.FormatInt(start.UnixMilli(), 10), strconv.FormatInt(start.Add(-params.Window).UnixMilli(), 10)).Result()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var current, previous int64
+	if count, ok := counts[0].(string); ok {
+		current, _ = strconv.ParseInt(count, 10, 64)
+	}
+
+	if count, ok := counts[1].(string); ok {
+		previous, _ = strconv.ParseInt(count, 10, 64)
+	}
+
+	// The same check as the script, but nothing is counted.
+	decision := newSlidingDecision(false, current, previous, params.Limit, params.Window, now)
+	weight := float64(params.Window-now.Sub(start)) / float64(params.Window)
+	if float64(previous)*weight+float64(current)+1 <= float64(params.Limit) {
+		decision.Allowed, decision.RetryAfter = true, 0
+	}
+
+	return decision, nil
+}
+
+func (slidingWindow) Reset(ctx context.Context, store AlgorithmStore, key string) (bool, error) {
+	p := store.provider
+	defer p.trackLatency(time.Now())
+
+	deleted, err := p.cmd(ctx).Del(ctx, p.slidingKey(key)).Result()
+	return deleted > 0, err
+}
+
+// newSlidingDecision works out the Decision for a request that found the given
+// counts in the current and the previous window.
+func newSlidingDecision(allowed bool, current, previous, limit int64, window time.Duration, now time.Time) Decision {
+	start := windowStart(now, window)
+	elapsed := now.Sub(start)
+	weight := float64(window-elapsed) / float64(window)
+
+	if allowed {
+		current++
+	}
+
+	estimate := float64(previous)*weight + float64(current)
+	decision := Decision{
+		Allowed:    allowed,
+		Limit:      limit,
+		Remaining:  limit - int64(math.Ceil(estimate)),
+		Window:     window,
+		ResetAt:    start.Add(window),
+		ResetAfter: start.Add(window).Sub(now),
+	}
+
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+
+	if allowed {
+		decision.FirstInWindow = current == 1
+		return decision
+	}
+
+	// The previous window's weight shrinks over time, so wait until enough of
+	// it is gone for one more request, or for the window to end if the current
+	// window alone is already full.
+	decision.RetryAfter = decision.ResetAfter
+	if room := float64(limit - 1 - current); room >= 0 && previous > 0 {
+		until := time.Duration((1 - room/float64(previous)) * float64(window))
+		if until > elapsed && until-elapsed < decision.RetryAfter {
+			decision.RetryAfter = until - elapsed
+		}
+	}
+
+	return decision
+}
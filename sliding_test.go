@@ -0,0 +1,151 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsumeSliding(t *testing.T) {
+	// Windows are aligned to the minute, so this is the start of one.
+	start := time.Unix(1700000040, 0)
+	now := start
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+
+	at := func(offset time.Duration) {
+		now = start.Add(offset)
+		server.SetTime(now)
+	}
+
+	// allowed consumes until a request is rejected and returns how many were
+	// allowed.
+	allowed := func() int {
+		t.Helper()
+
+		for n := 0; ; n++ {
+			decision, err := p.ConsumeSliding("k", 10, time.Minute)
+			if err != nil {
+				t.Fatalf("ConsumeSliding: %v", err)
+			}
+
+			if !decision.Allowed {
+				if decision.RetryAfter <= 0 {
+					t.Fatalf("rejected Decision = %+v", decision)
+				}
+
+				return n
+			}
+
+			if n > 10 {
+				t.Fatal("ConsumeSliding allowed more than the limit")
+			}
+		}
+	}
+
+	at(0)
+	if n := allowed(); n != 10 {
+		t.Fatalf("%d requests were allowed in the first window, want 10", n)
+	}
+
+	// Right at the start of the next window, all of the previous one still
+	// counts.
+	at(time.Minute)
+	if n := allowed(); n != 0 {
+		t.Fatalf("%d requests were allowed at the start of the next window, want 0", n)
+	}
+
+	// Halfway through, half of it does: 5 of the previous window plus 5.
+	at(time.Minute + 30*time.Second)
+	if n := allowed(); n != 5 {
+		t.Fatalf("%d requests were allowed halfway through the next window, want 5", n)
+	}
+
+	// Two windows later, nothing counts anymore.
+	at(3 * time.Minute)
+	if n := allowed(); n != 10 {
+		t.Fatalf("%d requests were allowed two windows later, want 10", n)
+	}
+}
+
+func TestConsumeSlidingExpiry(t *testing.T) {
+	now := time.Unix(1700000040, 0)
+	p, server := newTestProvider(t, WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	first, err := p.ConsumeSliding("k", 2, time.Minute)
+	if err != nil {
+		t.Fatalf("ConsumeSliding: %v", err)
+	}
+
+	if !first.FirstInWindow || !first.ResetAt.Equal(now.Add(time.Minute)) || first.Remaining != 1 {
+		t.Fatalf("first Decision = %+v", first)
+	}
+
+	// The counters are kept until the current window can't count as the
+	// previous one anymore.
+	if ttl := server.TTL(p.slidingKey("k")); ttl != 2*time.Minute {
+		t.Fatalf("sliding counters have a TTL of %s, want 2m", ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if server.Exists(p.slidingKey("k")) {
+		t.Fatal("sliding counters outlived the next window")
+	}
+}
+
+func TestConsumeSlidingUnevenWindows(t *testing.T) {
+	// Both split the time since the zero Time differently from the time since
+	// the Unix epoch: weeks since 1970 start on Thursdays, since year 1 on
+	// Mondays.
+	for _, window := range []time.Duration{7 * time.Second, 7 * 24 * time.Hour} {
+		now := time.Unix(1700000000, 0).UTC()
+		p, server := newTestProvider(t, WithAlgorithm(SlidingWindow()), WithClock(func() time.Time { return now }))
+		server.SetTime(now)
+
+		start := time.UnixMilli(now.UnixMilli() - now.UnixMilli()%window.Milliseconds()).UTC()
+		for i := 0; i < 3; i++ {
+			decision, err := p.Consume("k", 3, window)
+			if err != nil {
+				t.Fatalf("Consume: %v", err)
+			}
+
+			if !decision.Allowed || !decision.ResetAt.Equal(start.Add(window)) || decision.Remaining != int64(2-i) {
+				t.Fatalf("%s window: Consume = %+v, want a reset at %s", window, decision, start.Add(window))
+			}
+		}
+
+		// Inspect has to read the same counters as the script.
+		decision, err := p.Inspect("k", 3, window)
+		if err != nil {
+			t.Fatalf("Inspect: %v", err)
+		}
+
+		if decision.Allowed || decision.Remaining != 0 || !decision.ResetAt.Equal(start.Add(window)) {
+			t.Fatalf("%s window: Inspect of a full key = %+v", window, decision)
+		}
+
+		if rejected, err := p.Consume("k", 3, window); err != nil || rejected.Allowed || rejected.RetryAfter != start.Add(window).Sub(now) {
+			t.Fatalf("%s window: Consume of a full key = %+v, %v", window, rejected, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// acquireSlotScript takes one of a key's concurrency slots if there is one
+// free. Slots are members of a sorted set scored by when they expire, so the
+// slots of a process that died without releasing them free up on their own.
+// It returns 1 if it took a slot and 0 otherwise.
+//
+// KEYS[1] = slot set
+// ARGV[1] = slot ID, ARGV[2] = most slots, ARGV[3] = now in Unix
+// milliseconds, ARGV[4] = slot TTL in milliseconds
+var acquireSlotScript = registerScript("acquire_slot", `
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+
+local deadline = now + tonumber(ARGV[4])
+redis.call('ZADD', KEYS[1], deadline, ARGV[1])
+
+-- The set only has to live as long as its last slot.
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[4]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+end
+
+return 1
+`)
+
+func (p *Provider) slotKey(key string) string {
+	return p.companionKey("slots", key)
+}
+
+// AcquireSlot takes one of at most maxSlots concurrency slots of the given key,
+// like for limiting how many expensive requests of a key run at the same time,
+// independent of its ratelimit. ok is false if every slot is taken. Call
+// release once the work is done; calling it more than once does nothing, and a
+// slot that is never released frees up after ttl, so it should be longer than
+// the work ever takes. Slots expire by the Provider's clock, so instances
+// should have theirs in sync.
+func (p *Provider) AcquireSlot(key string, maxSlots int, ttl time.Duration) (release func() error, ok bool, err error) {
+	defer p.recoverPanic(&err, "acquire_slot", key)
+
+	if ttl < time.Millisecond {
+		return nil, false, errors.New("slot TTL must be at least a millisecond")
+	}
+
+	ctx, cancel := p.writeContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	slots := p.slotKey(p.storageKey(key))
+	slot := p.instanceID + ":" + strconv.FormatUint(p.slotSeq.Add(1), 10)
+	acquired, err := p.runScript(ctx, acquireSlotScript, []string{slots}, slot, maxSlots, p.now().UnixMilli(), ttl.Milliseconds()).Int()
+	if err != nil || acquired == 0 {
+		return nil, false, err
+	}
+
+	var once sync.Once
+	release = func() (err error) {
+		defer p.recoverPanic(&err, "release_slot", key)
+
+		once.Do(func() {
+			ctx, cancel := p.writeContext()
+			defer cancel()
+			defer p.trackLatency(time.Now())
+
+			err = p.cmd(ctx).ZRem(ctx, slots, slot).Err()
+		})
+
+		return err
+	}
+
+	return release, true, nil
+}
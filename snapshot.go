@@ -0,0 +1,152 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"sort"
+	"time"
+)
+
+// defaultSnapshotLimit is up to how many entries Snapshot reads in one go.
+const defaultSnapshotLimit = 10000
+
+// Snapshot is a copy of every stored ratelimit, see AdminClient.Snapshot.
+type Snapshot struct {
+	// TakenAt is when the snapshot was started.
+	TakenAt time.Time
+
+	// Consistent is true if the whole hash was read in one step, so the
+	// snapshot is exactly what was stored at one point in time. Otherwise every
+	// key is in it once, but writes that happened while taking it might be
+	// missing.
+	Consistent bool
+
+	// Undecodable lists the keys whose values couldn't be decoded. They aren't
+	// part of the snapshot otherwise.
+	Undecodable []string
+
+	provider *Provider
+	entries  map[string]*types.Ratelimit
+}
+
+// WithSnapshotLimit sets up to how many entries Snapshot reads with a single
+// HGETALL. Larger hashes are scanned instead. It's 10000 by default.
+func WithSnapshotLimit(limit int64) func(o *options) {
+	return func(o *options) {
+		o.snapshotLimit = limit
+	}
+}
+
+// Keys returns every key in the snapshot, sorted, as they're stored.
+func (s *Snapshot) Keys() []string {
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the ratelimit of the given key in the snapshot, or nil if it
+// wasn't stored.
+func (s *Snapshot) Get(key string) *types.Ratelimit {
+	if rl, ok := s.entries[s.provider.storageKey(key)]; ok {
+		copied := *rl
+		return &copied
+	}
+
+	return nil
+}
+
+// Len returns how many ratelimits are in the snapshot.
+func (s *Snapshot) Len() int {
+	return len(s.entries)
+}
+
+// Snapshot reads every ratelimit under the configured prefix. Hashes of up to
+// WithSnapshotLimit entries are read with a single HGETALL, which makes the
+// snapshot consistent. Larger ones are scanned in batches, which can see a key
+// more than once; those duplicates are dropped, but the snapshot isn't
+// consistent anymore, which Snapshot.Consistent reports.
+func (a *AdminClient) Snapshot(ctx context.Context) (snapshot *Snapshot, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "snapshot", "")
+	ctx = p.maintenanceContext(ctx)
+
+	snapshot = &Snapshot{
+		TakenAt:  p.now(),
+		provider: p,
+		entries:  map[string]*types.Ratelimit{},
+	}
+
+	add := func(key, data string) {
+		rl, err := p.decode(data)
+		if err != nil {
+			snapshot.Undecodable = append(snapshot.Undecodable, key)
+			return
+		}
+
+		snapshot.entries[key] = rl
+	}
+
+	length, err := p.cmd(ctx).HLen(ctx, p.hashKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if length <= p.snapshotLimit {
+		all, err := p.cmd(ctx).HGetAll(ctx, p.hashKey()).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for key, data := range all {
+			add(key, data)
+		}
+
+		snapshot.Consistent = true
+		return snapshot, nil
+	}
+
+	seen := map[string]struct{}{}
+	err = p.scan(ctx, "Snapshot", "", 1000, func(fields, values []string) error {
+		for i, field := range fields {
+			if _, ok := seen[field]; ok {
+				continue
+			}
+
+			seen[field] = struct{}{}
+			add(field, values[i])
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
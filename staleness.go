@@ -0,0 +1,49 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"time"
+)
+
+// WithMaxStaleness bounds how old a ratelimit that's handed out can be, for a
+// guarantee like "remaining counts in headers are never more than 2 seconds
+// stale". New fails if another option can't honor it: WithSampledWrites can
+// leave the stored count behind for any amount of time, so it can't be
+// combined with this. A Prefetch that's older than d when Wait is called reads
+// the ratelimit again, and Prefetch.Age reports how old its result is.
+func WithMaxStaleness(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.maxStaleness = d
+	}
+}
+
+// checkMaxStaleness returns an error if an option is configured in a way that
+// the maximum staleness can't be guaranteed with.
+func checkMaxStaleness(config *options) error {
+	if config.sampleEvery > 1 {
+		return errors.New("WithMaxStaleness can't be guaranteed with WithSampledWrites, which doesn't bound how long the stored count lags behind")
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// stateLossMinEntries is how many entries the hash needs to have had before a
+// sudden drop in them counts as state loss, so tiny stores don't set it off.
+const stateLossMinEntries = 100
+
+// StateLoss describes why the Provider thinks that Redis lost its state, which
+// means that enforcement has effectively started over.
+type StateLoss struct {
+	// Reason is a human readable description of what was noticed.
+	Reason string
+
+	// Previous and Current are how many entries the hash had in the last and
+	// the current check.
+	Previous int64
+	Current  int64
+}
+
+// Marker is what is stored under the "{<prefix>}:__meta__" marker key.
+type Marker struct {
+	// Instance is the ID of the Provider that wrote the marker.
+	Instance string
+
+	// StartedAt is when that Provider was created.
+	StartedAt time.Time
+
+	// CreatedAt is when the marker was written, by the server's clock. It's
+	// zero for markers written by older versions.
+	CreatedAt time.Time
+}
+
+// markerScript writes the marker key, keeping the one that is already there,
+// and returns when it was created and the server's current time, both in Unix
+// milliseconds.
+//
+// KEYS[1] = marker key
+// ARGV[1] = instance ID, ARGV[2] = instance start in Unix milliseconds
+var markerScript = registerScript("marker", `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+redis.call('HSETNX', KEYS[1], 'instance', ARGV[1])
+redis.call('HSETNX', KEYS[1], 'started_at', ARGV[2])
+redis.call('HSETNX', KEYS[1], 'created_at', string.format('%d', now))
+
+return { tonumber(redis.call('HGET', KEYS[1], 'created_at')), now }
+`)
+
+// WithStateLossDetection writes a marker key when the Provider is created and
+// checks every interval whether it disappeared, or whether the amount of stored
+// ratelimits dropped by more than 90%, which is what a Redis restart without
+// persistence (or a FLUSHALL) looks like. fn is called when that happens, and
+// the marker is written again. The check stops when the Provider is closed.
+//
+// With WithKeyWindow, every window starts out with an empty hash, so the amount
+// is only compared between checks that saw the same window.
+func WithStateLossDetection(interval time.Duration, fn func(StateLoss)) func(o *options) {
+	return func(o *options) {
+		o.stateLossInterval = interval
+		o.stateLossCallback = fn
+	}
+}
+
+func (p *Provider) markerKey() string {
+	return p.space.Load().marker
+}
+
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// writeMarker writes the marker key, keeping the one that is already there.
+func (p *Provider) writeMarker(ctx context.Context) error {
+	keys := []string{p.markerKey()}
+	times, err := p.runScript(ctx, markerScript, keys, p.instanceID, p.startedAt.UnixMilli()).Int64Slice()
+	if err != nil {
+		return err
+	}
+
+	p.observeMarker(times[0], times[1])
+	return nil
+}
+
+// readMarker returns the marker, or nil if there is none.
+func (p *Provider) readMarker(ctx context.Context) (*Marker, error) {
+	fields, err := p.client.HGetAll(ctx, p.markerKey()).Result()
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+
+	marker := &Marker{Instance: fields["instance"]}
+	if startedAt, err := strconv.ParseInt(fields["started_at"], 10, 64); err == nil {
+		marker.StartedAt = time.UnixMilli(startedAt)
+	}
+
+	if createdAt, err := strconv.ParseInt(fields["created_at"], 10, 64); err == nil {
+		marker.CreatedAt = time.UnixMilli(createdAt)
+	}
+
+	return marker, nil
+}
+
+// detectStateLoss runs until the Provider is closed.
+func (p *Provider) detectStateLoss(interval time.Duration, fn func(StateLoss)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous, hash := int64(-1), ""
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			if current := p.hashKey(); current != hash {
+				previous, hash = -1, current
+			}
+
+			previous = p.checkStateLoss(interval, hash, previous, fn)
+		}
+	}
+}
+
+// checkStateLoss does a single state loss check and returns how many entries
+// the given hash has now.
+func (p *Provider) checkStateLoss(timeout time.Duration, hash string, previous int64, fn func(StateLoss)) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	exists, err := p.client.Exists(ctx, p.markerKey()).Result()
+	if err != nil {
+		p.reportError("detect_state_loss", err)
+		return previous
+	}
+
+	current, err := p.client.HLen(ctx, hash).Result()
+	if err != nil {
+		p.reportError("detect_state_loss", err)
+		return previous
+	}
+
+	loss := StateLoss{Previous: previous, Current: current}
+	switch {
+	case exists == 0:
+		loss.Reason = "the marker key " + p.markerKey() + " disappeared"
+
+	case previous >= stateLossMinEntries && current < previous/10:
+		loss.Reason = "the amount of stored ratelimits dropped from " + strconv.FormatInt(previous, 10) + " to " + strconv.FormatInt(current, 10)
+
+	default:
+		// Another instance might have noticed the loss and written the marker
+		// again already.
+		if p.coldStartRamp > 0 {
+			if err := p.writeMarker(ctx); err != nil {
+				p.reportError("detect_state_loss", err)
+			}
+		}
+
+		return current
+	}
+
+	fn(loss)
+	if err := p.writeMarker(ctx); err != nil {
+		p.reportError("detect_state_loss", err)
+	}
+
+	return current
+}
@@ -0,0 +1,187 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// tenantReconcileInterval is how often the tenant key counts of
+// WithTenantKeyBudget are recounted from the hash.
+const tenantReconcileInterval = 10 * time.Minute
+
+// tenantReserveScript counts a new ratelimit against its tenant's budget before
+// it's written. It returns 1 if the ratelimit already exists, 2 if it was
+// counted, and 0 if the tenant has no room left.
+//
+// KEYS[1] = hash, KEYS[2] = tenant counts
+// ARGV[1] = field, ARGV[2] = tenant, ARGV[3] = most ratelimits per tenant
+var tenantReserveScript = registerScript("tenant_reserve", `
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 1 then
+	return 1
+end
+
+if tonumber(redis.call('HGET', KEYS[2], ARGV[2]) or '0') >= tonumber(ARGV[3]) then
+	return 0
+end
+
+redis.call('HINCRBY', KEYS[2], ARGV[2], 1)
+return 2
+`)
+
+// tenantBudget holds what WithTenantKeyBudget needs.
+type tenantBudget struct {
+	tenant  func(key string) string
+	maxKeys int64
+}
+
+// WithTenantKeyBudget keeps a count of the ratelimits of every tenant in a hash
+// next to the ratelimits ("{<prefix>}:tenants"), where tenantFn tells which
+// tenant a key belongs to. It's given keys as they're stored, so after
+// WithMaxKeyLength cut a key down, the tenant has to still be in what's left.
+// Once a tenant has maxKeysPerTenant ratelimits, writes that would create
+// another one fail with ErrTenantKeyBudget, while its existing ratelimits keep
+// working as before.
+//
+// The counts are approximate: Reset and the AdminClient's deletes take keys off
+// them, but ratelimits that expire or are deleted by other means are only
+// taken off when the counts are recounted from the hash, which happens every
+// ten minutes and with AdminClient.ReconcileTenantKeys.
+func WithTenantKeyBudget(tenantFn func(key string) string, maxKeysPerTenant int64) func(o *options) {
+	return func(o *options) {
+		o.tenantFn = tenantFn
+		o.tenantMaxKeys = maxKeysPerTenant
+	}
+}
+
+func (p *Provider) tenantsKey() string {
+	return p.space.Load().tenants
+}
+
+// reserveTenantKey counts the given storage key against its tenant's budget if
+// it doesn't exist yet, failing with ErrTenantKeyBudget if there is no room.
+func (p *Provider) reserveTenantKey(ctx context.Context, hash, key string) error {
+	if p.tenants == nil {
+		return nil
+	}
+
+	tenant := p.tenants.tenant(key)
+	keys := []string{hash, p.tenantsKey()}
+	reserved, err := p.runScript(ctx, tenantReserveScript, keys, key, tenant, p.tenants.maxKeys).Int()
+	if err != nil {
+		return err
+	}
+
+	if reserved == 0 {
+		return fmt.Errorf("%w: the tenant already has %d", ErrTenantKeyBudget, p.tenants.maxKeys)
+	}
+
+	return nil
+}
+
+// releaseTenantKeys takes the given deleted storage keys off their tenants'
+// counts.
+func (p *Provider) releaseTenantKeys(ctx context.Context, keys ...string) error {
+	if p.tenants == nil || len(keys) == 0 {
+		return nil
+	}
+
+	released := map[string]int64{}
+	for _, key := range keys {
+		released[p.tenants.tenant(key)]--
+	}
+
+	_, err := p.cmd(ctx).Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for tenant, n := range released {
+			pipe.HIncrBy(ctx, p.tenantsKey(), tenant, n)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// ReconcileTenantKeys recounts the ratelimits of every tenant from the hash
+// and replaces the counts of WithTenantKeyBudget with the result, which
+// corrects them for ratelimits that expired. Writes that happen while it runs
+// can be off by one until the next time. It returns how many tenants have
+// ratelimits.
+func (a *AdminClient) ReconcileTenantKeys(ctx context.Context) (tenants int, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "reconcile_tenant_keys", "")
+	ctx = p.maintenanceContext(ctx)
+
+	if p.tenants == nil {
+		return 0, nil
+	}
+
+	counts := map[string]interface{}{}
+	err = p.scan(ctx, "ReconcileTenantKeys", "", 1000, func(fields, _ []string) error {
+		for _, field := range fields {
+			tenant := p.tenants.tenant(field)
+			n, _ := counts[tenant].(int64)
+			counts[tenant] = n + 1
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = p.cmd(ctx).TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, p.tenantsKey())
+		if len(counts) > 0 {
+			pipe.HSet(ctx, p.tenantsKey(), counts)
+		}
+
+		return nil
+	})
+
+	return len(counts), err
+}
+
+// reconcileTenants runs ReconcileTenantKeys until the Provider is closed.
+func (p *Provider) reconcileTenants() {
+	ticker := time.NewTicker(tenantReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), tenantReconcileInterval)
+			if _, err := p.Admin().ReconcileTenantKeys(ctx); err != nil {
+				p.reportError("reconcile_tenant_keys", err)
+			}
+
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"math"
+	"time"
+)
+
+// thresholdScript marks that the window of a key that ends at ARGV[1] was
+// notified about, returning 1 if it wasn't already and 0 otherwise, so only one
+// instance notifies about each window.
+//
+// KEYS[1] = notified key
+// ARGV[1] = end of the window in Unix milliseconds, ARGV[2] = milliseconds
+// until then
+var thresholdScript = registerScript("threshold", `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return 0
+end
+
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// thresholdCallback is the configuration of WithThresholdCallback.
+type thresholdCallback struct {
+	fraction float64
+	fn       func(key string, rl *types.Ratelimit)
+}
+
+// WithThresholdCallback calls fn once the requests that Consume allowed in a
+// key's window reach the given fraction of its limit, like 0.8 to warn
+// customers that used 80% of their quota. It's called at most once per key per
+// window, even across Provider instances: whether a window was notified about
+// is stored in Redis ("{<prefix>}:notified:<key>", until the window ends),
+// and only the instance that marks it calls fn. fn gets the ratelimit as it was
+// after the request that reached the threshold, and runs on a goroutine of its
+// own, so it can't slow down Consume; Shutdown waits for it. A Reset starts
+// over, so a key can be notified again in its next window.
+//
+// The request that reaches the threshold is the one that notifies, so if
+// marking the window fails, which is passed to the error handler, the window
+// isn't notified about at all. fraction has to be in (0, 1].
+func WithThresholdCallback(fraction float64, fn func(key string, rl *types.Ratelimit)) func(o *options) {
+	return func(o *options) {
+		o.threshold = &thresholdCallback{fraction: fraction, fn: fn}
+	}
+}
+
+func (t *thresholdCallback) valid() bool {
+	return t.fn != nil && t.fraction > 0 && t.fraction <= 1
+}
+
+func (p *Provider) notifiedKey(key string) string {
+	return p.companionKey("notified", key)
+}
+
+// checkThreshold calls the callback of WithThresholdCallback if the allowed
+// request of the given Decision reached the threshold and no instance notified
+// about its window yet. key is what Consume was called with, storageKey where
+// its state is.
+func (p *Provider) checkThreshold(ctx context.Context, key, storageKey string, decision Decision) {
+	if p.threshold == nil || !decision.Allowed || decision.Limit <= 0 {
+		return
+	}
+
+	// Only the request that reaches the threshold checks, so requests after
+	// it don't each cost a round trip.
+	threshold := int64(math.Ceil(p.threshold.fraction * float64(decision.Limit)))
+	if decision.Limit-decision.Remaining != threshold {
+		return
+	}
+
+	ttl := decision.ResetAt.Sub(p.now())
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+
+	keys := []string{p.notifiedKey(storageKey)}
+	marked, err := p.runScript(ctx, thresholdScript, keys, decision.ResetAt.UnixMilli(), ttl.Milliseconds()).Int()
+	if err != nil {
+		p.reportError("threshold", err)
+		return
+	}
+
+	if marked == 0 {
+		return
+	}
+
+	rl := decisionRatelimit(decision)
+	p.goBackground(func() { p.threshold.fn(key, rl) })
+}
@@ -0,0 +1,133 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+// notifications collects the calls of a WithThresholdCallback callback.
+type notifications struct {
+	mu    sync.Mutex
+	calls []types.Ratelimit
+}
+
+func (n *notifications) record(key string, rl *types.Ratelimit) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, *rl)
+}
+
+func (n *notifications) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.calls)
+}
+
+func TestThresholdCallback(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var clockMu sync.Mutex
+	clock := WithClock(func() time.Time {
+		clockMu.Lock()
+		defer clockMu.Unlock()
+		return now
+	})
+
+	notified := &notifications{}
+	opts := []func(o *options){clock, WithThresholdCallback(0.8, notified.record)}
+	first, server := newTestProvider(t, opts...)
+	server.SetTime(now)
+	second := newTestProviderOn(t, server, opts...)
+	instances := []*Provider{first, second}
+
+	// Both instances count concurrently, past the limit, and only one of them
+	// notifies.
+	consume := func(n int) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(p *Provider) {
+				defer wg.Done()
+				if _, err := p.Consume("k", 10, time.Minute); err != nil {
+					t.Errorf("Consume: %v", err)
+				}
+			}(instances[i%2])
+		}
+
+		wg.Wait()
+		for _, p := range instances {
+			p.background.Wait()
+		}
+	}
+
+	consume(15)
+	if notified.count() != 1 {
+		t.Fatalf("got %d notifications in the first window, want 1: %+v", notified.count(), notified.calls)
+	}
+
+	if rl := notified.calls[0]; rl.Limit != 10 || rl.Remaining != 2 || !rl.ResetTime.Equal(now.Add(time.Minute)) {
+		t.Fatalf("notified with %+v, want 2 of 10 remaining", rl)
+	}
+
+	// The next window is notified about again, once.
+	clockMu.Lock()
+	now = now.Add(time.Minute)
+	clockMu.Unlock()
+	server.SetTime(now)
+	server.FastForward(time.Minute)
+
+	consume(12)
+	if notified.count() != 2 {
+		t.Fatalf("got %d notifications after the second window, want 2", notified.count())
+	}
+
+	// So is the window after a Reset.
+	if _, err := first.Reset("k"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	consume(8)
+	if notified.count() != 3 {
+		t.Fatalf("got %d notifications after a Reset, want 3", notified.count())
+	}
+
+	// Requests below the threshold don't notify.
+	if _, err := second.Consume("other", 10, time.Minute); err != nil || notified.count() != 3 {
+		t.Fatalf("Consume below the threshold = %v and %d notifications", err, notified.count())
+	}
+}
+
+func TestThresholdCallbackValidation(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, fraction := range []float64{0, -0.5, 1.5} {
+		if _, err := New(WithClient(p.client), WithThresholdCallback(fraction, func(string, *types.Ratelimit) {})); err == nil {
+			t.Fatalf("New accepted a threshold of %v", fraction)
+		}
+	}
+
+	if _, err := New(WithClient(p.client), WithThresholdCallback(0.5, nil)); err == nil {
+		t.Fatal("New accepted a threshold without a callback")
+	}
+}
@@ -0,0 +1,71 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"time"
+)
+
+// WithOperationTimeout sets how long both reads and writes may take before
+// they're cancelled. It's the same as using WithReadTimeout and
+// WithWriteTimeout with the same duration.
+func WithOperationTimeout(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.readTimeout = d
+		o.writeTimeout = d
+	}
+}
+
+// WithReadTimeout sets how long reads (Get, GetTombstone) may take before
+// they're cancelled. Zero, the default, means there is no timeout.
+func WithReadTimeout(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.readTimeout = d
+	}
+}
+
+// WithWriteTimeout sets how long writes (Put, Reset) may take before they're
+// cancelled. Zero, the default, means there is no timeout.
+func WithWriteTimeout(d time.Duration) func(o *options) {
+	return func(o *options) {
+		o.writeTimeout = d
+	}
+}
+
+func (p *Provider) readContext() (context.Context, context.CancelFunc) {
+	return p.readContextFor(nil)
+}
+
+func (p *Provider) writeContext() (context.Context, context.CancelFunc) {
+	return p.writeContextFor(nil)
+}
+
+// readContextFor is readContext with the given call's options applied.
+func (p *Provider) readContextFor(call *callOptions) (context.Context, context.CancelFunc) {
+	return call.context(p.operationTimeout(p.readTimeout))
+}
+
+// writeContextFor is writeContext with the given call's options applied.
+func (p *Provider) writeContextFor(call *callOptions) (context.Context, context.CancelFunc) {
+	return call.context(p.operationTimeout(p.writeTimeout))
+}
@@ -0,0 +1,108 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// tombstoneScript moves a ratelimit out of the hash into its tombstone key in
+// one step, so there is never a moment where both or neither of them exist.
+//
+// KEYS[1] = hash, KEYS[2] = tombstone key, KEYS[3] = reset reason key,
+// KEYS[4] = metadata hash, KEYS[5] = reset index (optional)
+// ARGV[1] = field, ARGV[2] = tombstone TTL in milliseconds, ARGV[3] = reset
+// reason
+var tombstoneScript = registerScript("tombstone", `
+local value = redis.call('HGET', KEYS[1], ARGV[1])
+if not value then
+	return 0
+end
+
+redis.call('SET', KEYS[2], value, 'PX', ARGV[2])
+redis.call('SET', KEYS[3], ARGV[3], 'PX', ARGV[2])
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+if KEYS[5] then
+	redis.call('ZREM', KEYS[5], ARGV[1])
+end
+
+return 1
+`)
+
+// WithTombstones makes Reset keep the value it deletes under a tombstone key
+// ("{<prefix>}:tombstone:<key>") for the given amount of time, so it can be
+// looked up with GetTombstone. Redis expires keys in milliseconds, so New fails
+// for a TTL under a millisecond.
+func WithTombstones(ttl time.Duration) func(o *options) {
+	return func(o *options) {
+		o.tombstoneTTL = ttl
+	}
+}
+
+// GetTombstone returns the ratelimit that was last reset for the given key, or
+// nil if there is none or it has expired. Tombstones are only kept when the
+// Provider was constructed with WithTombstones.
+func (p *Provider) GetTombstone(key string) (rl *types.Ratelimit, err error) {
+	defer p.recoverPanic(&err, "get_tombstone", key)
+
+	ctx, cancel := p.readContext()
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	data, err := p.cmd(ctx).Get(ctx, p.tombstoneKey(p.storageKey(key))).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		} else {
+			return nil, err
+		}
+	}
+
+	return p.decode(data)
+}
+
+func (p *Provider) tombstoneKey(key string) string {
+	return p.companionKey("tombstone", key)
+}
+
+// resetToTombstone is Reset when tombstones are enabled. The key should already
+// be the storage key, and call can be nil.
+func (p *Provider) resetToTombstone(ctx context.Context, key string, call *callOptions) (bool, error) {
+	record, err := resetRecordFor(call)
+	if err != nil {
+		return false, err
+	}
+
+	keys := p.entryKeys(p.hashKey(), p.tombstoneKey(key), p.resetReasonKey(key))
+
+	moved, err := p.runScript(ctx, tombstoneScript, keys, key, p.tombstoneTTL.Milliseconds(), record).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return moved == 1, nil
+}
@@ -0,0 +1,63 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	p, server := newTestProvider(t, WithTombstones(time.Minute))
+
+	resetAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	if err := p.Put("k", types.NewRatelimit(5, false, resetAt)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if ok, err := p.Reset("k"); err != nil || !ok {
+		t.Fatalf("Reset = %t, %v", ok, err)
+	}
+
+	tombstone, err := p.GetTombstone("k")
+	if err != nil || tombstone == nil || tombstone.Limit != 5 || !tombstone.ResetTime.Equal(resetAt) {
+		t.Fatalf("GetTombstone = %+v, %v", tombstone, err)
+	}
+
+	server.FastForward(time.Minute + time.Second)
+	if tombstone, err := p.GetTombstone("k"); err != nil || tombstone != nil {
+		t.Fatalf("GetTombstone after the TTL = %+v, %v", tombstone, err)
+	}
+}
+
+func TestTombstonesSubMillisecond(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	if _, err := New(WithClient(client), WithTombstones(time.Microsecond)); err == nil {
+		t.Fatal("New accepted a tombstone TTL under a millisecond")
+	}
+}
@@ -0,0 +1,378 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"time"
+)
+
+// defaultTxnAttempts is how often Txn runs the function before it gives up.
+const defaultTxnAttempts = 10
+
+// txnScript commits a transaction: it replaces (or deletes, when ARGV[4] is
+// empty) a field only if the field still is in the state that the transaction
+// read, so two transactions on the same key can never both commit.
+//
+// It returns 0 if it didn't commit, 2 if it also was the first write of the
+// field to the first-seen hash, and 1 otherwise. With ARGV[8], a committed
+// write also updates the field's request rate estimate, decayed by the
+// server's time. With ARGV[9], a committed write also deletes the key's copy on
+// the per-key layout of WithLayoutRollout, or, if ARGV[9] is '1' because reads
+// fall back to it, doesn't commit while there is one.
+//
+// KEYS[1] = hash, KEYS[2] = metadata hash, KEYS[3] = reset index (optional),
+// then the first-seen hash if ARGV[6] is '1', the rate hash if ARGV[8] isn't
+// empty and the key on the per-key layout if ARGV[9] is given
+// ARGV[1] = field, ARGV[2] = '1' if the field existed, ARGV[3] = read value,
+// ARGV[4] = new value, ARGV[5] = new reset time in Unix milliseconds,
+// ARGV[6] = '1' to mark the field as seen, ARGV[7] = now in Unix milliseconds,
+// ARGV[8] = half-life of the rate estimate in milliseconds (optional),
+// ARGV[9] = '1' if reads fall back to the per-key layout, '0' if not (optional)
+var txnScript = registerScript("txn", `
+local entry
+if ARGV[9] then
+	entry = table.remove(KEYS)
+end
+
+local rate
+if ARGV[8] and ARGV[8] ~= '' then
+	rate = table.remove(KEYS)
+	if redis.replicate_commands then
+		redis.replicate_commands()
+	end
+end
+
+local seen
+if ARGV[6] == '1' then
+	seen = table.remove(KEYS)
+end
+
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+if ARGV[2] == '1' then
+	if current ~= ARGV[3] then
+		return 0
+	end
+elseif current then
+	return 0
+end
+
+if entry then
+	if ARGV[9] == '1' then
+		if redis.call('EXISTS', entry) == 1 then
+			return 0
+		end
+	else
+		redis.call('DEL', entry)
+	end
+end
+
+if ARGV[4] == '' then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	redis.call('HDEL', KEYS[2], ARGV[1])
+	if KEYS[3] then
+		redis.call('ZREM', KEYS[3], ARGV[1])
+	end
+else
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[4])
+	if KEYS[3] and ARGV[5] ~= '' then
+		redis.call('ZADD', KEYS[3], ARGV[5], ARGV[1])
+	end
+
+	if rate then
+		local time = redis.call('TIME')
+		local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+		local halfLife = tonumber(ARGV[8])
+
+		-- Every request adds 1/tau to the rate, which decays with e^(-t/tau),
+		-- so the estimate is in requests per millisecond.
+		local tau = halfLife / math.log(2)
+		local stored = redis.call('HMGET', rate, 'rate', 'at')
+		local estimate = 0
+		if stored[1] and stored[2] then
+			estimate = tonumber(stored[1]) * math.exp(-math.max(now - tonumber(stored[2]), 0) / tau)
+		end
+
+		estimate = estimate + 1 / tau
+		redis.call('HSET', rate, 'rate', string.format('%.17g', estimate), 'at', string.format('%d', now))
+
+		-- Once the estimate is below 1/1024 requests per half-life it's as
+		-- good as gone.
+		local halfLives = math.max(math.ceil(math.log(estimate * halfLife) / math.log(2)), 0) + 10
+		redis.call('PEXPIRE', rate, halfLives * halfLife)
+	end
+
+	if seen then
+		return 1 + redis.call('HSETNX', seen, ARGV[1], ARGV[7])
+	end
+end
+
+return 1
+`)
+
+// Txn is the view of a single ratelimit that the function given to
+// Provider.Txn works on. Nothing is written until the function returns.
+type Txn struct {
+	provider *Provider
+	key      string
+	data     string
+	exists   bool
+	dirty    bool
+	next     string
+	resetAt  time.Time
+
+	// expiry is when the field expires with WithDerivedReset, which is the
+	// reset time that Get reports, or zero.
+	expiry time.Time
+
+	// consume is set for the transactions of Consume, which mark their key
+	// as seen with WithFirstSeenTracking and count towards its estimated
+	// rate with WithRateEstimation. firstSeen is set once one of them
+	// committed the first write of its key.
+	consume   bool
+	firstSeen bool
+
+	// call is the options of the operation that the transaction is part of,
+	// or nil.
+	call *callOptions
+}
+
+// WithTxnAttempts sets how many times Txn runs the function when another write
+// to the same key got in between before it gives up with ErrTxnConflict. It's
+// 10 by default.
+func WithTxnAttempts(attempts int) func(o *options) {
+	return func(o *options) {
+		o.txnAttempts = attempts
+	}
+}
+
+// Get returns the ratelimit as the transaction sees it, including its own Put
+// or Delete, or nil if there is none. Unlike Provider.Get, it doesn't count
+// as a request.
+func (tx *Txn) Get() (*types.Ratelimit, error) {
+	data, exists := tx.data, tx.exists
+	if tx.dirty {
+		data, exists = tx.next, tx.next != ""
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	rl, err := tx.provider.decode(data)
+	if err != nil || tx.dirty {
+		return rl, err
+	}
+
+	return withExpiry(rl, tx.expiry), nil
+}
+
+// Put stores the given ratelimit when the transaction commits.
+func (tx *Txn) Put(rl *types.Ratelimit) error {
+	rl, err := tx.provider.checkRemaining(tx.key, rl)
+	if err != nil {
+		return err
+	}
+
+	data, err := tx.provider.encode(rl)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.provider.checkValueSize(data); err != nil {
+		return err
+	}
+
+	tx.dirty, tx.next, tx.resetAt = true, string(data), rl.ResetTime
+	return nil
+}
+
+// Delete deletes the ratelimit when the transaction commits. Unlike Reset, it
+// never leaves a tombstone behind.
+func (tx *Txn) Delete() {
+	tx.dirty, tx.next, tx.resetAt = true, "", time.Time{}
+}
+
+// Txn runs fn against the ratelimit stored for the given key and commits what
+// it did in one step, but only if nothing else wrote to the key since fn read
+// it. Otherwise fn runs again with the new state, up to the amount of attempts
+// set with WithTxnAttempts, after which an error that wraps ErrTxnConflict is
+// returned. If fn returns an error, nothing is written and the error is
+// returned in an *OpError, so errors.Is and errors.As still find it.
+//
+// Only the single key is covered, and fn shouldn't have side effects, since it
+// can run more than once.
+func (p *Provider) Txn(key string, fn func(tx *Txn) error) (err error) {
+	defer p.recoverPanic(&err, "txn", key)
+
+	_, err = p.runTxn(key, false, nil, fn)
+	return err
+}
+
+// runTxn is Txn, returning whether the commit was the first write of the key
+// to the first-seen hash if it's a transaction of Consume. call can be nil.
+func (p *Provider) runTxn(key string, consume bool, call *callOptions, fn func(tx *Txn) error) (bool, error) {
+	storageKey := p.storageKey(key)
+	for attempt := 0; attempt < p.txnAttempts; attempt++ {
+		data, source, expiry, err := p.fetchExpiry(storageKey, call)
+		if err != nil {
+			return false, err
+		}
+
+		tx := &Txn{provider: p, key: key, data: data, exists: source != "", expiry: expiry, consume: consume, call: call}
+		if err := fn(tx); err != nil {
+			return false, err
+		}
+
+		if !tx.dirty || p.dryRun {
+			return false, nil
+		}
+
+		committed, err := p.commit(storageKey, tx)
+		if err != nil {
+			return false, err
+		}
+
+		if committed {
+			return tx.firstSeen, nil
+		}
+	}
+
+	return false, fmt.Errorf("%w: gave up after %d attempts", ErrTxnConflict, p.txnAttempts)
+}
+
+// commit runs txnScript for the given transaction, returning false if the key
+// was changed in the meantime.
+func (p *Provider) commit(key string, tx *Txn) (bool, error) {
+	ctx, cancel := p.writeContextFor(tx.call)
+	defer cancel()
+	defer p.trackLatency(time.Now())
+
+	ctx, replication := p.replicate(ctx)
+	defer replication.close()
+
+	hash := p.hashKey()
+	keys := p.entryKeys(hash)
+
+	existed := "0"
+	if tx.exists {
+		existed = "1"
+	} else if tx.next != "" {
+		if err := p.reserveTenantKey(ctx, hash, key); err != nil {
+			return false, err
+		}
+	}
+
+	if p.onEntryLayout(key) {
+		committed, err := p.commitEntry(ctx, hash, key, existed, tx)
+		if err != nil || !committed {
+			return false, err
+		}
+
+		if err := p.afterCommit(ctx, hash, key, tx); err != nil {
+			return false, err
+		}
+
+		return true, replication.wait(ctx)
+	}
+
+	seen := ""
+	if tx.consume && p.firstSeenTracking {
+		keys, seen = append(keys, p.seenKey()), "1"
+	}
+
+	args := []interface{}{key, existed, tx.data, tx.next, indexScore(tx.resetAt), seen, p.now().UnixMilli()}
+	if tx.consume && p.rateHalfLife > 0 {
+		keys, args = append(keys, p.rateKey(key)), append(args, p.rateHalfLife.Milliseconds())
+	}
+
+	if p.layout != nil {
+		fallback := "0"
+		if p.layout.fallback {
+			fallback = "1"
+		}
+
+		// ARGV[8] has to be there for ARGV[9].
+		if len(args) == 7 {
+			args = append(args, "")
+		}
+
+		keys, args = append(keys, p.entryKey(key)), append(args, fallback)
+	}
+
+	committed, err := p.runScript(ctx, txnScript, keys, args...).Int()
+	if err != nil || committed == 0 {
+		return false, err
+	}
+
+	tx.firstSeen = committed == 2
+	if err := p.afterCommit(ctx, hash, key, tx); err != nil {
+		return false, err
+	}
+
+	return true, replication.wait(ctx)
+}
+
+// afterCommit does what has to follow a committed transaction on the given
+// storage key: the field TTL, the window's expiry and everything that keeps
+// track of writes.
+func (p *Provider) afterCommit(ctx context.Context, hash, key string, tx *Txn) error {
+	if tx.next != "" {
+		if p.verifyRate > 0 {
+			p.verifyWrite(ctx, hash, key, []byte(tx.next))
+		}
+
+		p.mirror(ctx, key, []byte(tx.next))
+		p.recordSchema(ctx)
+
+		if err := p.trackCreated(ctx, key, tx.resetAt); err != nil {
+			return err
+		}
+
+		if err := p.expireFields(ctx, hash, tx.resetAt, key); err != nil {
+			return err
+		}
+	}
+
+	if err := p.expireWindow(ctx, hash); err != nil {
+		return err
+	}
+
+	p.recordChange(hash, key, tx.next, tx.next == "")
+	if tx.exists && tx.next == "" {
+		if err := p.deleteCompanions(ctx, key); err != nil {
+			return err
+		}
+
+		if err := p.releaseTenantKeys(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	if p.dedup != nil {
+		p.dedup.forget(key)
+	}
+
+	return nil
+}
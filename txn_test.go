@@ -0,0 +1,69 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestTxnConflict(t *testing.T) {
+	p, _ := newTestProvider(t)
+	resetAt := time.Now().Add(time.Minute).UTC().Truncate(time.Millisecond)
+	if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: resetAt}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// The first attempt loses against a write that happens after it read the
+	// ratelimit, so it has to run again on top of that write.
+	var attempts int
+	err := p.Txn("k", func(tx *Txn) error {
+		attempts++
+		rl, err := tx.Get()
+		if err != nil {
+			return err
+		}
+
+		if attempts == 1 {
+			if err := p.Put("k", &types.Ratelimit{Limit: 10, Remaining: 5, ResetTime: resetAt}); err != nil {
+				return err
+			}
+		}
+
+		rl.Remaining--
+		return tx.Put(rl)
+	})
+
+	if err != nil {
+		t.Fatalf("Txn: %v", err)
+	}
+
+	if attempts != 2 {
+		t.Fatalf("Txn ran %d times, want 2", attempts)
+	}
+
+	rl, err := p.Peek("k")
+	if err != nil || rl == nil || rl.Remaining != 4 {
+		t.Fatalf("Peek = %+v, %v", rl, err)
+	}
+}
@@ -0,0 +1,164 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"github.com/noelware/chi-ratelimit/types"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// usageBatchSize is how many ratelimits every batch of Usage and TopOffenders
+// asks Redis for, unless WithScanBatchSize says otherwise.
+const usageBatchSize = 1000
+
+// KeyUsage is how many requests a key used in its current window, as Usage and
+// TopOffenders return it.
+type KeyUsage struct {
+	// Key is the key as it's stored.
+	Key string
+
+	// Used is how many requests of Limit were used, which is noisy with
+	// WithStatNoise.
+	Used int32
+
+	// Limit is the limit of the window.
+	Limit int32
+
+	// ResetTime is when the window ends.
+	ResetTime time.Time
+}
+
+// WithStatNoise adds Laplace noise with a scale of 1/epsilon to the Used of
+// what Usage and TopOffenders return, so reports made from them don't expose
+// exact counts. The noise of a key only changes with its window and the given
+// seed, so querying again doesn't average it away, and Used stays between zero
+// and the limit. It only applies to these reports: Get, Consume and everything
+// else that decides on a request still see the exact counts.
+func WithStatNoise(epsilon float64, seed int64) func(o *options) {
+	return func(o *options) {
+		o.statNoise = epsilon
+		o.statNoiseSeed = seed
+	}
+}
+
+// Usage returns how many requests every key with a ratelimit whose window
+// hasn't ended used in it, in no particular order. Ratelimits that can't be
+// decoded are skipped.
+func (a *AdminClient) Usage(ctx context.Context) (usage []KeyUsage, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "usage", "")
+
+	return a.usage(ctx, "Usage")
+}
+
+// TopOffenders returns the n keys that used the most requests of their current
+// window, most first and by key when they used as many. With WithStatNoise,
+// they're ranked by their noisy counts.
+func (a *AdminClient) TopOffenders(ctx context.Context, n int) (top []KeyUsage, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "top_offenders", "")
+
+	if n <= 0 {
+		return nil, errors.New("TopOffenders needs a positive n")
+	}
+
+	usage, err := a.usage(ctx, "TopOffenders")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Used != usage[j].Used {
+			return usage[i].Used > usage[j].Used
+		}
+
+		return usage[i].Key < usage[j].Key
+	})
+
+	if len(usage) > n {
+		usage = usage[:n]
+	}
+
+	return usage, nil
+}
+
+func (a *AdminClient) usage(ctx context.Context, operation string) (usage []KeyUsage, err error) {
+	p := a.provider
+	ctx = p.maintenanceContext(ctx)
+	now := p.now()
+
+	err = p.scan(ctx, operation, "", usageBatchSize, func(fields, values []string) error {
+		for i, field := range fields {
+			rl, err := p.decode(values[i])
+			if err != nil || !rl.ResetTime.After(now) {
+				continue
+			}
+
+			usage = append(usage, p.keyUsage(field, p.clampRead(rl)))
+		}
+
+		return nil
+	})
+
+	return usage, err
+}
+
+// keyUsage returns the usage of the given ratelimit, with the noise of
+// WithStatNoise.
+func (p *Provider) keyUsage(key string, rl *types.Ratelimit) KeyUsage {
+	usage := KeyUsage{Key: key, Used: rl.Limit - rl.Remaining, Limit: rl.Limit, ResetTime: rl.ResetTime}
+	if p.statNoise <= 0 {
+		return usage
+	}
+
+	used := float64(usage.Used) + laplaceNoise(1/p.statNoise, p.statNoiseSeed, key, rl.ResetTime)
+	switch {
+	case used < 0 || math.IsNaN(used):
+		usage.Used = 0
+	case used > float64(rl.Limit):
+		usage.Used = rl.Limit
+	default:
+		usage.Used = int32(math.Round(used))
+	}
+
+	return usage
+}
+
+// laplaceNoise returns Laplace noise with the given scale that only depends on
+// the seed, the key and its window, which is told apart by when it resets.
+func laplaceNoise(scale float64, seed int64, key string, resetAt time.Time) float64 {
+	hash := fnv1a(strconv.FormatInt(seed, 10) + "\x00" + key + "\x00" + strconv.FormatInt(resetAt.UnixMilli(), 10))
+
+	// A uniform value in (-0.5, 0.5) from the top 53 bits, turned into Laplace
+	// noise by the inverse of its distribution.
+	u := (float64(hash>>11)+0.5)/(1<<53) - 0.5
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+
+	return -scale * math.Log(1-2*u)
+}
@@ -0,0 +1,148 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"github.com/noelware/chi-ratelimit/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// putUsage stores ratelimits with a limit of 100 that used the given amount of
+// requests, all resetting at the given time.
+func putUsage(t *testing.T, p *Provider, resetAt time.Time, used map[string]int32) {
+	t.Helper()
+
+	for key, n := range used {
+		if err := p.Put(key, &types.Ratelimit{Limit: 100, Remaining: 100 - n, ResetTime: resetAt}); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+}
+
+// usedOf returns the Used of every key.
+func usedOf(usage []KeyUsage) map[string]int32 {
+	used := map[string]int32{}
+	for _, u := range usage {
+		used[u.Key] = u.Used
+	}
+
+	return used
+}
+
+func TestUsage(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	p, _ := newTestProvider(t, WithClock(func() time.Time { return now }))
+	putUsage(t, p, now.Add(time.Minute), map[string]int32{"a": 5, "b": 50, "c": 0})
+	putUsage(t, p, now.Add(-time.Second), map[string]int32{"ended": 90})
+
+	usage, err := p.Admin().Usage(context.Background())
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+
+	if want := map[string]int32{"a": 5, "b": 50, "c": 0}; !reflect.DeepEqual(usedOf(usage), want) {
+		t.Fatalf("Usage = %v, want %v", usedOf(usage), want)
+	}
+
+	top, err := p.Admin().TopOffenders(context.Background(), 2)
+	if err != nil || len(top) != 2 || top[0].Key != "b" || top[1].Key != "a" {
+		t.Fatalf("TopOffenders = %+v, %v, want b and a", top, err)
+	}
+
+	if _, err := p.Admin().TopOffenders(context.Background(), 0); err == nil {
+		t.Fatal("TopOffenders accepted n = 0")
+	}
+}
+
+func TestUsageStatNoise(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	p, _ := newTestProvider(t, WithClock(func() time.Time { return now }), WithStatNoise(0.5, 42))
+	putUsage(t, p, now.Add(time.Minute), map[string]int32{"a": 5, "b": 50, "c": 0, "d": 100})
+
+	want := map[string]int32{"a": 6, "b": 47, "c": 7, "d": 98}
+	for i := 0; i < 3; i++ {
+		usage, err := p.Admin().Usage(context.Background())
+		if err != nil {
+			t.Fatalf("Usage: %v", err)
+		}
+
+		if got := usedOf(usage); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Usage %d = %v, want %v", i, got, want)
+		}
+	}
+
+	// Another seed or window gets other noise.
+	other, _ := newTestProvider(t, WithClock(func() time.Time { return now }), WithStatNoise(0.5, 43))
+	putUsage(t, other, now.Add(time.Minute), map[string]int32{"a": 5, "b": 50, "c": 0, "d": 100})
+	usage, err := other.Admin().Usage(context.Background())
+	if err != nil || reflect.DeepEqual(usedOf(usage), want) {
+		t.Fatalf("Usage with another seed = %v, %v, want other noise than %v", usedOf(usage), err, want)
+	}
+
+	next := now.Add(2 * time.Minute)
+	if laplaceNoise(2, 42, "a", next) == laplaceNoise(2, 42, "a", now.Add(time.Minute)) {
+		t.Fatal("the next window of a key got the same noise")
+	}
+
+	// The stored ratelimits and what reads see stay exact.
+	if rl, err := p.Peek("b"); err != nil || rl.Remaining != 50 {
+		t.Fatalf("Peek = %+v, %v, want the exact Remaining", rl, err)
+	}
+}
+
+func TestStatNoiseLeavesConsume(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := WithClock(func() time.Time { return now })
+	exact, _ := newTestProvider(t, clock)
+	noisy, _ := newTestProvider(t, clock, WithStatNoise(0.1, 7))
+
+	for i := 0; i < 30; i++ {
+		key := []string{"a", "b", "c"}[i%3]
+		want, err := exact.Consume(key, 8, time.Minute)
+		if err != nil {
+			t.Fatalf("Consume: %v", err)
+		}
+
+		got, err := noisy.Consume(key, 8, time.Minute)
+		if err != nil {
+			t.Fatalf("Consume with WithStatNoise: %v", err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Consume %d = %+v with WithStatNoise, want %+v", i, got, want)
+		}
+
+		// Reading the noisy stats in between doesn't change anything either.
+		if _, err := noisy.Admin().Usage(context.Background()); err != nil {
+			t.Fatalf("Usage: %v", err)
+		}
+	}
+}
+
+func TestStatNoiseOptions(t *testing.T) {
+	if _, err := New(WithStatNoise(-1, 0)); err == nil {
+		t.Fatal("New accepted a negative epsilon")
+	}
+}
@@ -0,0 +1,200 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// verifySamples is how many entries Verify tries to decode.
+	verifySamples = 10
+
+	// verifyScanLimit is how many keys Verify looks at when it searches for
+	// other ratelimit-looking prefixes.
+	verifyScanLimit = 1000
+)
+
+// VerifyReport is the result of AdminClient.Verify.
+type VerifyReport struct {
+	// ServerVersion is the version that the Redis server reports.
+	ServerVersion string
+
+	// Server is the server that the Provider is connected to, see
+	// Capabilities.
+	Server ServerKind
+
+	// Entries is how many ratelimits exist under the configured prefix.
+	Entries int64
+
+	// Sampled is how many of those entries Verify tried to decode.
+	Sampled int
+
+	// Undecodable lists the sampled keys whose value couldn't be decoded.
+	Undecodable []string
+
+	// SimilarPrefixes lists other ratelimit-looking hashes and how many
+	// entries they have. It is only filled in when the configured prefix has
+	// no entries at all.
+	SimilarPrefixes map[string]int64
+
+	// Marker is what is stored under the marker key that
+	// WithStateLossDetection writes, or nil if there is none.
+	Marker *Marker
+
+	// Features is what Provider.Features returns.
+	Features []string
+
+	// Warnings describes anything that looks misconfigured.
+	Warnings []string
+}
+
+// OK returns true if Verify didn't find anything that looks misconfigured.
+func (r *VerifyReport) OK() bool {
+	return len(r.Warnings) == 0
+}
+
+// Verify checks that the connected server is reachable and that the data
+// under the configured prefix looks like what this Provider expects, so a
+// changed prefix doesn't silently start enforcement from a clean slate. It
+// never writes anything.
+func (a *AdminClient) Verify(ctx context.Context) (report *VerifyReport, err error) {
+	p := a.provider
+	defer p.recoverPanic(&err, "verify", "")
+	ctx = p.maintenanceContext(ctx)
+
+	if err := p.cmd(ctx).Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+
+	info, err := p.cmd(ctx).Info(ctx, "server").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	report = &VerifyReport{ServerVersion: infoField(info, "redis_version"), Features: p.Features()}
+	report.Server, _ = serverKind(info)
+	if report.Entries, err = p.cmd(ctx).HLen(ctx, p.hashKey()).Result(); err != nil {
+		return nil, err
+	}
+
+	if report.Marker, err = p.readMarker(ctx); err != nil {
+		return nil, err
+	}
+
+	if report.Marker == nil && p.detectsStateLoss {
+		report.Warnings = append(report.Warnings, "the marker key "+p.markerKey()+" is missing, so Redis might have lost its state")
+	}
+
+	if report.Entries > 0 {
+		items, _, err := p.cmd(ctx).HScan(ctx, p.hashKey(), 0, "", verifySamples).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i+1 < len(items) && report.Sampled < verifySamples; i += 2 {
+			report.Sampled++
+			if _, err := p.decode(items[i+1]); err != nil {
+				report.Undecodable = append(report.Undecodable, items[i])
+			}
+		}
+
+		if len(report.Undecodable) > 0 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%d of %d sampled entries under %q couldn't be decoded", len(report.Undecodable), report.Sampled, p.hashKey()))
+		}
+
+		return report, nil
+	}
+
+	if report.SimilarPrefixes, err = p.similarPrefixes(ctx); err != nil {
+		return nil, err
+	}
+
+	for key, entries := range report.SimilarPrefixes {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("no entries under %q, but %q has %d; is the key prefix misconfigured?", p.hashKey(), key, entries))
+	}
+
+	return report, nil
+}
+
+// similarPrefixes looks through a bounded amount of keys for other hashes that
+// look like they hold ratelimits.
+func (p *Provider) similarPrefixes(ctx context.Context) (map[string]int64, error) {
+	patterns := []string{"*ratelimit*"}
+	if !strings.Contains(p.hashKey(), "ratelimit") {
+		patterns = append(patterns, "*"+EscapeGlob(p.hashKey())+"*")
+	}
+
+	found := map[string]int64{}
+	for _, pattern := range patterns {
+		var (
+			cursor  uint64
+			visited int
+		)
+
+		for visited < verifyScanLimit {
+			keys, next, err := p.cmd(ctx).Scan(ctx, cursor, pattern, 100).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			visited += len(keys)
+			for _, key := range keys {
+				if key == p.hashKey() {
+					continue
+				}
+
+				// Keys that aren't hashes fail with WRONGTYPE, which just means
+				// they aren't something this Provider would've written.
+				entries, err := p.cmd(ctx).HLen(ctx, key).Result()
+				if err != nil && !hasErrorPrefix(err, "WRONGTYPE") {
+					return nil, err
+				}
+
+				if entries > 0 {
+					found[key] = entries
+				}
+			}
+
+			if next == 0 {
+				break
+			}
+
+			cursor = next
+		}
+	}
+
+	return found, nil
+}
+
+// infoField returns the value of a single field in the output of INFO.
+func infoField(info, field string) string {
+	for _, line := range strings.Split(info, "\n") {
+		if value := strings.TrimPrefix(line, field+":"); value != line {
+			return strings.TrimSpace(value)
+		}
+	}
+
+	return ""
+}
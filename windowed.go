@@ -0,0 +1,77 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// windowGrace is how long a window's hash is kept after the window is over, so
+// Reset can still clear the previous window.
+const windowGrace = time.Minute
+
+// WithWindowedKeys stores ratelimits in one hash per fixed window of the given
+// length ("{<prefix>}:<window start in Unix milliseconds>") instead of a single
+// hash. Every window starts from nothing, and each hash expires a minute after
+// its window is over, so no ratelimit outlives its window no matter what its
+// reset time says. Reset deletes the key from the current and the previous
+// window.
+//
+// Windows come from the Provider's clock. Everything else, like Get, the
+// admin scans and HLEN-based checks, only looks at the current window. Writes
+// take an extra round trip to set the expiry, and WithPutDeduplication is
+// ignored, since it can't tell windows apart.
+func WithWindowedKeys(window time.Duration) func(o *options) {
+	return func(o *options) {
+		o.keyWindow = window
+	}
+}
+
+// windowHash returns the hash of the window that the given time is in.
+func (p *Provider) windowHash(space *keyspace, at time.Time) string {
+	return space.tagged + ":" + strconv.FormatInt(at.Truncate(p.keyWindow).UnixMilli(), 10)
+}
+
+// previousHashKey returns the hash of the window before the current one, or an
+// empty string if keys aren't windowed.
+func (p *Provider) previousHashKey() string {
+	if p.keyWindow <= 0 {
+		return ""
+	}
+
+	return p.windowHash(p.space.Load(), p.now().Add(-p.keyWindow))
+}
+
+// expireWindow makes the given hash of the current window expire once the
+// window and the grace period are over. If the window changed since the hash
+// was picked, the hash lives for one more window, which is harmless. It does
+// nothing if keys aren't windowed.
+func (p *Provider) expireWindow(ctx context.Context, hash string) error {
+	if p.keyWindow <= 0 {
+		return nil
+	}
+
+	expireAt := p.now().Truncate(p.keyWindow).Add(p.keyWindow + windowGrace)
+	return p.cmd(ctx).PExpireAt(ctx, hash, expireAt).Err()
+}
@@ -0,0 +1,82 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestWindowedKeys(t *testing.T) {
+	start := time.Unix(1700000040, 0)
+	now := start
+	p, server := newTestProvider(t, WithWindowedKeys(time.Minute), WithClock(func() time.Time { return now }))
+	server.SetTime(now)
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 5, ResetTime: start.Add(time.Hour)}
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	first := p.hashKey()
+	if want := p.space.Load().tagged + ":1700000040000"; first != want {
+		t.Fatalf("the first window's hash is %q, want %q", first, want)
+	}
+
+	if ttl := server.TTL(first); ttl != time.Minute+windowGrace {
+		t.Fatalf("the first window's hash has a TTL of %s", ttl)
+	}
+
+	// The next window starts from nothing, even though the reset time is an
+	// hour away.
+	now = start.Add(time.Minute)
+	server.SetTime(now)
+	if stored, err := p.Peek("k"); err != nil || stored != nil {
+		t.Fatalf("Peek in the next window = %+v, %v", stored, err)
+	}
+
+	if p.hashKey() == first || p.previousHashKey() != first {
+		t.Fatalf("the next window's hashes are %q and %q", p.hashKey(), p.previousHashKey())
+	}
+
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put in the next window: %v", err)
+	}
+
+	// Reset clears the key from both windows.
+	if ok, err := p.Reset("k"); err != nil || !ok {
+		t.Fatalf("Reset = %t, %v", ok, err)
+	}
+
+	for _, hash := range []string{first, p.hashKey()} {
+		if server.Exists(hash) && server.HGet(hash, "k") != "" {
+			t.Fatalf("Reset left k in %q", hash)
+		}
+	}
+
+	// Each hash is gone once its window and the grace period are over.
+	server.FastForward(time.Minute + windowGrace)
+	if server.Exists(first) {
+		t.Fatal("the first window's hash outlived the grace period")
+	}
+}
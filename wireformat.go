@@ -0,0 +1,181 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+	"time"
+)
+
+// WireFormat pins the exact JSON that ratelimits are stored as, so other tools
+// reading the same hash don't break when the struct tags of types.Ratelimit
+// change. Fields are always written in the order limit, remaining, reset and
+// global, and a field with an empty name is left out.
+type WireFormat struct {
+	// LimitField is the name of the field that holds the limit.
+	LimitField string
+
+	// RemainingField is the name of the field that holds how many requests
+	// are remaining.
+	RemainingField string
+
+	// ResetField is the name of the field that holds the reset time.
+	ResetField string
+
+	// GlobalField is the name of the field that holds whether the ratelimit is
+	// global.
+	GlobalField string
+
+	// ResetAsUnixMillis stores the reset time as an integer of milliseconds
+	// since the Unix epoch instead of an RFC 3339 string.
+	ResetAsUnixMillis bool
+}
+
+// StableWireFormat is a WireFormat that is easy to read from other languages:
+//
+//	{"limit":10,"remaining":5,"reset_at":1665000000000,"global":false}
+var StableWireFormat = WireFormat{
+	LimitField:        "limit",
+	RemainingField:    "remaining",
+	ResetField:        "reset_at",
+	GlobalField:       "global",
+	ResetAsUnixMillis: true,
+}
+
+// WithWireFormat stores ratelimits with the given WireFormat instead of the
+// JSON encoding of types.Ratelimit.
+func WithWireFormat(spec WireFormat) func(o *options) {
+	return func(o *options) {
+		o.wireFormat = &spec
+	}
+}
+
+func (f *WireFormat) encode(rl *types.Ratelimit) ([]byte, error) {
+	if rl == nil {
+		return nil, ErrEmptyValue
+	}
+
+	var reset []byte
+	if f.ResetAsUnixMillis {
+		reset = strconv.AppendInt(nil, rl.ResetTime.UnixMilli(), 10)
+	} else {
+		data, err := rl.ResetTime.UTC().MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+
+		reset = data
+	}
+
+	buf := bytes.NewBufferString("{")
+	fields := []struct {
+		name  string
+		value []byte
+	}{
+		{f.LimitField, strconv.AppendInt(nil, int64(rl.Limit), 10)},
+		{f.RemainingField, strconv.AppendInt(nil, int64(rl.Remaining), 10)},
+		{f.ResetField, reset},
+		{f.GlobalField, strconv.AppendBool(nil, rl.Global)},
+	}
+
+	for _, field := range fields {
+		if field.name == "" {
+			continue
+		}
+
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+
+		name, err := json.Marshal(field.name)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(field.value)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (f *WireFormat) decode(data []byte) (*types.Ratelimit, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		return nil, ErrEmptyValue
+	}
+
+	rl := &types.Ratelimit{}
+	if err := decodeField(fields, f.LimitField, &rl.Limit); err != nil {
+		return nil, err
+	}
+
+	if err := decodeField(fields, f.RemainingField, &rl.Remaining); err != nil {
+		return nil, err
+	}
+
+	if f.ResetAsUnixMillis {
+		var millis int64
+		if err := decodeField(fields, f.ResetField, &millis); err != nil {
+			return nil, err
+		}
+
+		rl.ResetTime = time.UnixMilli(millis).UTC()
+	} else if err := decodeField(fields, f.ResetField, &rl.ResetTime); err != nil {
+		return nil, err
+	}
+
+	if f.GlobalField != "" {
+		if err := decodeField(fields, f.GlobalField, &rl.Global); err != nil {
+			return nil, err
+		}
+	}
+
+	return rl, nil
+}
+
+func decodeField(fields map[string]json.RawMessage, name string, into interface{}) error {
+	if name == "" {
+		return nil
+	}
+
+	raw, ok := fields[name]
+	if !ok {
+		return fmt.Errorf("missing %q field", name)
+	}
+
+	if err := json.Unmarshal(raw, into); err != nil {
+		return fmt.Errorf("invalid %q field: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"github.com/noelware/chi-ratelimit/types"
+	"testing"
+	"time"
+)
+
+func TestWireFormat(t *testing.T) {
+	rl := &types.Ratelimit{Limit: 10, Remaining: 5, ResetTime: time.UnixMilli(1665000000000).UTC()}
+	data, err := StableWireFormat.encode(rl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `{"limit":10,"remaining":5,"reset_at":1665000000000,"global":false}`; string(data) != want {
+		t.Fatalf("StableWireFormat = %s, want %s", data, want)
+	}
+
+	// Fields without a name are left out, and are zero when decoded.
+	short := WireFormat{LimitField: "l", RemainingField: "r", ResetField: "t"}
+	rl.Global = true
+	if data, err = short.encode(rl); err != nil || string(data) != `{"l":10,"r":5,"t":"2022-10-05T20:00:00Z"}` {
+		t.Fatalf("encode = %s, %v", data, err)
+	}
+
+	decoded, err := short.decode(data)
+	if err != nil || decoded.Global || decoded.Limit != 10 || !decoded.ResetTime.Equal(rl.ResetTime) {
+		t.Fatalf("decode = %+v, %v", decoded, err)
+	}
+
+	if _, err := StableWireFormat.decode([]byte(`{"limit":10,"reset_at":0,"global":false}`)); err == nil {
+		t.Fatal("StableWireFormat decoded a value without remaining")
+	}
+}
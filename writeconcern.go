@@ -0,0 +1,156 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+// replicationConnKey is the context key of the connection that a write has to
+// go through so that WAIT covers it.
+type replicationConnKey struct{}
+
+// skipWriteConcernKey is the context key that SkipWriteConcern sets.
+type skipWriteConcernKey struct{}
+
+// WithWriteConcern makes every write to a ratelimit (Put, Get, Reset,
+// PutWithMeta, Txn and ResetCascade) wait until at least the given amount of
+// replicas acknowledged it, or the timeout passed. If they didn't, the error
+// wraps ErrReplicationLag; the write itself still happened on the primary,
+// so callers that only care about it can check for that error and carry on.
+// Get and Put, whose errors make the chi-ratelimit middleware panic, pass it
+// to the handler of WithErrorHandler instead and succeed. New fails if the
+// server doesn't understand WAIT, like Dragonfly.
+//
+// A single call of one of the *WithOptions methods can skip the wait with
+// SkipWriteConcern.
+func WithWriteConcern(replicas int, timeout time.Duration) func(o *options) {
+	return func(o *options) {
+		o.writeReplicas = replicas
+		o.writeConcernTimeout = timeout
+	}
+}
+
+// replication is a write that WAIT is issued for. A nil replication is one
+// without a write concern, where every method is a no-op.
+type replication struct {
+	conn     *redis.Conn
+	replicas int
+	timeout  time.Duration
+}
+
+// replicate returns a context that makes every command of the write go through
+// a single connection, since WAIT only counts the writes of the connection that
+// it was sent on.
+func (p *Provider) replicate(ctx context.Context) (context.Context, *replication) {
+	if p.writeReplicas <= 0 || ctx.Value(skipWriteConcernKey{}) != nil {
+		return ctx, nil
+	}
+
+	conn := p.conn(ctx)
+	if counted, ok := ctx.Value(roundTripKey{}).(*roundTrips); ok {
+		conn.AddHook(roundTripHook{counted: counted})
+	}
+
+	return context.WithValue(ctx, replicationConnKey{}, conn), &replication{
+		conn:     conn,
+		replicas: p.writeReplicas,
+		timeout:  p.writeConcernTimeout,
+	}
+}
+
+// cmd returns what commands should be sent through for the given context.
+func (p *Provider) cmd(ctx context.Context) redis.Cmdable {
+	if conn, ok := ctx.Value(replicationConnKey{}).(*redis.Conn); ok {
+		return conn
+	}
+
+	if p.maintenance != nil && ctx.Value(maintenanceKey{}) != nil {
+		return p.maintenance
+	}
+
+	if counted, ok := ctx.Value(roundTripKey{}).(*roundTrips); ok {
+		return counted.conn
+	}
+
+	return p.client
+}
+
+// conn returns a single connection of the client. A redis.Conn doesn't run the
+// hooks of its client, so the ones of WithClientHooks and CaptureKey are added
+// to it.
+func (p *Provider) conn(ctx context.Context) *redis.Conn {
+	conn := p.client.Conn(ctx)
+	for _, hook := range p.clientHooks {
+		conn.AddHook(hook)
+	}
+
+	conn.AddHook(captureHook{provider: p})
+	if p.health != nil {
+		conn.AddHook(healthHook{health: p.health})
+	}
+
+	return conn
+}
+
+// reportLag passes err to the handler of WithErrorHandler and returns nil if it
+// only means that the write wasn't replicated in time, for Get and Put.
+func (p *Provider) reportLag(op, key string, err error) error {
+	if !errors.Is(err, ErrReplicationLag) {
+		return err
+	}
+
+	if p.errorHandler != nil {
+		p.errorHandler(p.wrapError(op, key, err))
+	}
+
+	return nil
+}
+
+// wait issues WAIT for everything that was written so far.
+func (r *replication) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+
+	acked, err := r.conn.Wait(ctx, r.replicas, r.timeout).Result()
+	if err != nil {
+		return err
+	}
+
+	if acked < int64(r.replicas) {
+		return fmt.Errorf("%w: %d of %d replicas acknowledged the write", ErrReplicationLag, acked, r.replicas)
+	}
+
+	return nil
+}
+
+// close gives the connection back to the pool.
+func (r *replication) close() {
+	if r != nil {
+		_ = r.conn.Close()
+	}
+}
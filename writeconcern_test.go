@@ -0,0 +1,166 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+	"github.com/noelware/chi-ratelimit/types"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replicas adds WAIT to a miniredis server, which doesn't have it, answering
+// with acked replicas. Only WAITs for at least one replica are counted, so the
+// probe of New isn't.
+type replicas struct {
+	acked atomic.Int64
+	waits atomic.Int64
+}
+
+func registerWait(t *testing.T, s *miniredis.Miniredis) *replicas {
+	t.Helper()
+
+	r := &replicas{}
+	err := s.Server().Register("WAIT", func(c *server.Peer, _ string, args []string) {
+		if n, _ := strconv.Atoi(args[0]); n > 0 {
+			r.waits.Add(1)
+		}
+
+		c.WriteInt(int(r.acked.Load()))
+	})
+
+	if err != nil {
+		t.Fatalf("registering WAIT: %v", err)
+	}
+
+	return r
+}
+
+func TestWriteConcern(t *testing.T) {
+	s := miniredis.RunT(t)
+	r := registerWait(t, s)
+
+	var handled []error
+	p := newTestProviderOn(t, s, WithWriteConcern(1, 10*time.Millisecond), WithErrorHandler(func(err error) {
+		handled = append(handled, err)
+	}))
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	r.acked.Store(1)
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	if r.waits.Load() != 1 || len(handled) != 0 {
+		t.Fatalf("Put sent %d WAITs and reported %v", r.waits.Load(), handled)
+	}
+
+	// Get and Put hand a write that wasn't replicated in time to the error
+	// handler, since the write itself happened.
+	r.acked.Store(0)
+	if err := p.Put("k", rl); err != nil {
+		t.Fatalf("Put without replicas: %v", err)
+	}
+
+	if len(handled) != 1 || !errors.Is(handled[0], ErrReplicationLag) {
+		t.Fatalf("Put without replicas reported %v", handled)
+	}
+
+	if stored, err := p.Peek("k"); err != nil || stored == nil {
+		t.Fatalf("Peek after Put without replicas = %+v, %v", stored, err)
+	}
+
+	// Everything else returns it.
+	if _, err := p.Reset("k"); !errors.Is(err, ErrReplicationLag) {
+		t.Fatalf("Reset without replicas = %v", err)
+	}
+
+	// SkipWriteConcern doesn't wait at all.
+	waits := r.waits.Load()
+	if err := p.PutWithOptions("k", rl, SkipWriteConcern()); err != nil {
+		t.Fatalf("PutWithOptions: %v", err)
+	}
+
+	if r.waits.Load() != waits || len(handled) != 1 {
+		t.Fatalf("PutWithOptions with SkipWriteConcern sent %d WAITs", r.waits.Load()-waits)
+	}
+}
+
+func TestWriteConcernUnsupported(t *testing.T) {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	if _, err := New(WithClient(client), WithWriteConcern(1, time.Second)); err == nil {
+		t.Fatal("New accepted WithWriteConcern on a server without WAIT")
+	}
+}
+
+func TestWriteConcernBansAndScopes(t *testing.T) {
+	s := miniredis.RunT(t)
+	r := registerWait(t, s)
+	p := newTestProviderOn(t, s, WithWriteConcern(1, 10*time.Millisecond))
+
+	rl := &types.Ratelimit{Limit: 10, Remaining: 10, ResetTime: time.Now().Add(time.Minute)}
+	scope := p.Scope("uploads")
+	writes := map[string]func() error{
+		"Ban":       func() error { return p.Ban("k", time.Minute) },
+		"scope Put": func() error { return scope.Put("k", rl) },
+		"scope Reset": func() error {
+			_, err := scope.Reset("k")
+			return err
+		},
+		"ResetAllScopes": func() error {
+			_, err := p.ResetAllScopes("k")
+			return err
+		},
+	}
+
+	for _, name := range []string{"Ban", "scope Put", "scope Reset", "scope Put", "ResetAllScopes"} {
+		r.acked.Store(1)
+		waits := r.waits.Load()
+		if err := writes[name](); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if r.waits.Load() != waits+1 {
+			t.Fatalf("%s sent %d WAITs, want 1", name, r.waits.Load()-waits)
+		}
+	}
+
+	// Without replicas, they return ErrReplicationLag after writing.
+	r.acked.Store(0)
+	for _, name := range []string{"Ban", "scope Put", "scope Reset", "scope Put", "ResetAllScopes"} {
+		if err := writes[name](); !errors.Is(err, ErrReplicationLag) {
+			t.Fatalf("%s without replicas = %v", name, err)
+		}
+	}
+
+	if banned, err := p.Banned("k"); err != nil || banned <= 0 {
+		t.Fatalf("Banned after Ban without replicas = %v, %v", banned, err)
+	}
+}
@@ -0,0 +1,86 @@
+// 🔬 chi-ratelimit-redis: Redis support for the chi-ratelimit library.
+// Copyright (c) 2022 Noelware
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+)
+
+// WriteMismatchError is what WithWriteVerification passes to the error handler
+// when a value read back right after it was written isn't the same.
+type WriteMismatchError struct {
+	// Key is the storage key that was written.
+	Key string
+
+	// Written is the encoded value that was sent to Redis.
+	Written []byte
+
+	// Read is what was read back, or nil if the key was missing.
+	Read []byte
+}
+
+func (e *WriteMismatchError) Error() string {
+	if e.Read == nil {
+		return fmt.Sprintf("%v: wrote %q, but it was missing afterwards", ErrWriteMismatch, e.Written)
+	}
+
+	return fmt.Sprintf("%v: wrote %q, but read back %q", ErrWriteMismatch, e.Written, e.Read)
+}
+
+func (e *WriteMismatchError) Is(target error) bool {
+	return target == ErrWriteMismatch
+}
+
+// WithWriteVerification reads back a sampleRate fraction (from 0 to 1) of the
+// ratelimits written by Put, PutWithMeta, Get and Txn (which the consume adapter uses) right
+// after writing them, and passes a *WriteMismatchError to the error handler
+// (see WithErrorHandler) if the stored value isn't byte-identical to what was
+// written. The write itself never fails because of it. A write by someone else
+// in between also shows up as a mismatch, so a few reports on busy keys are
+// expected; a proxy that drops writes shows up as many.
+func WithWriteVerification(sampleRate float64) func(o *options) {
+	return func(o *options) {
+		o.verifyRate = sampleRate
+	}
+}
+
+// verifyWrite reads back the value just written to the given field if the write
+// is sampled, reporting a mismatch or a failed read to the error handler.
+func (p *Provider) verifyWrite(ctx context.Context, hash, key string, data []byte) {
+	if p.verifyRate <= 0 || p.verifyRandom() >= p.verifyRate {
+		return
+	}
+
+	read, err := p.cmd(ctx).HGet(ctx, hash, key).Result()
+	switch {
+	case err == redis.Nil:
+		p.reportError("verify_write", p.wrapError("verify_write", key, &WriteMismatchError{Key: key, Written: data}))
+
+	case err != nil:
+		p.reportError("verify_write", p.wrapError("verify_write", key, err))
+
+	case read != string(data):
+		p.reportError("verify_write", p.wrapError("verify_write", key, &WriteMismatchError{Key: key, Written: data, Read: []byte(read)}))
+	}
+}